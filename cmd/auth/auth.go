@@ -4,16 +4,12 @@ import (
 	"bufio"
 	"fmt"
 	"net/http"
-	"net/http/cookiejar"
-	"net/url"
 	"os"
 	"strings"
 	"syscall"
 	"time"
 
-	"github.com/mdp/qrterminal"
 	"github.com/spf13/cobra"
-	"golang.org/x/net/publicsuffix"
 	"golang.org/x/term"
 
 	"tuya-ipc-terminal/pkg/storage"
@@ -38,6 +34,8 @@ func NewAuthCmd() *cobra.Command {
 	cmd.AddCommand(newRemoveCmd())
 	cmd.AddCommand(newRefreshCmd())
 	cmd.AddCommand(newTestCmd())
+	cmd.AddCommand(newEncryptCmd())
+	cmd.AddCommand(newDecryptCmd())
 	cmd.AddCommand(newShowRegionsCmd())
 	cmd.AddCommand(newShowCountryCodesCmd())
 
@@ -63,15 +61,28 @@ Authentication methods:
   --qr       Use QR code authentication (default)
   --password Use email/password authentication
 
+Without a terminal or with --non-interactive nothing is prompted: the password
+comes from $TUYA_IPC_PASSWORD, --password-file or --password-value, the QR code
+scan is awaited for --qr-timeout and existing users are re-authenticated.
+
+Exit codes:
+  2 Invalid arguments or no password
+  3 Account or password rejected
+  4 QR code not scanned in time
+  5 Tuya API error
+  6 Storage error
+  7 QR code scanned with another account
+
 Example:
   tuya-ipc-terminal auth add eu-central user@example.com
-  tuya-ipc-terminal auth add --password eu-central user@example.com`,
+  tuya-ipc-terminal auth add --password eu-central user@example.com
+  tuya-ipc-terminal auth add --qr-png /tmp/login.png eu-central user@example.com
+  echo "$PASSWORD" | tuya-ipc-terminal auth add --password-file - --country-code DE eu-central user@example.com`,
 		Args: cobra.ExactArgs(2),
 		RunE: runAddUser,
 	}
 
-	cmd.Flags().Bool("qr", false, "Use QR code authentication (default)")
-	cmd.Flags().Bool("password", false, "Use email/password authentication")
+	addLoginFlags(cmd)
 
 	return cmd
 }
@@ -90,13 +101,12 @@ func newRefreshCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "refresh [region] [email]",
 		Short: "Refresh user session",
-		Long:  "Refresh an existing user session by re-authenticating.",
+		Long:  "Refresh an existing user session by re-authenticating, takes the flags and exit codes of 'auth add'.",
 		Args:  cobra.ExactArgs(2),
 		RunE:  runRefreshUser,
 	}
 
-	cmd.Flags().Bool("qr", false, "Use QR code authentication (default)")
-	cmd.Flags().Bool("password", false, "Use email/password authentication")
+	addLoginFlags(cmd)
 
 	return cmd
 }
@@ -111,6 +121,29 @@ func newTestCmd() *cobra.Command {
 	}
 }
 
+func newEncryptCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "encrypt",
+		Short: "Encrypt stored sessions",
+		Long: `Encrypt the stored sessions in place with AES-GCM. The key is derived from
+the passphrase of --storage-key-file, --storage-passphrase or $TUYA_IPC_STORAGE_KEY,
+which every later command needs as well.
+
+Example:
+  TUYA_IPC_STORAGE_KEY=secret tuya-ipc-terminal auth encrypt`,
+		RunE: runEncryptUsers,
+	}
+}
+
+func newDecryptCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "decrypt",
+		Short: "Decrypt stored sessions",
+		Long:  "Decrypt the stored sessions in place, stored passwords and the passwords kept for RTSP users are removed.",
+		RunE:  runDecryptUsers,
+	}
+}
+
 func newShowRegionsCmd() *cobra.Command {
 	return &cobra.Command{
 		Use:   "show-regions",
@@ -148,16 +181,29 @@ func runListUsers(cmd *cobra.Command, args []string) error {
 	fmt.Printf("Found %d authenticated user(s):\n\n", len(users))
 
 	for i, user := range users {
+		var expires time.Time
+		if user.SessionData != nil {
+			expires = user.SessionData.Expires()
+		}
+
 		status := "✓ Valid"
-		if user.SessionData == nil {
+		switch {
+		case user.SessionData == nil:
 			status = "✗ Invalid"
-		} else if time.Since(user.LastRefresh) > 7*24*time.Hour {
+		case !expires.IsZero() && time.Now().After(expires):
+			status = "✗ Expired"
+		case !expires.IsZero() && time.Until(expires) < 24*time.Hour:
+			status = "⚠ Expires soon"
+		case expires.IsZero() && time.Since(user.LastRefresh) > 7*24*time.Hour:
 			status = "⚠ Old (>7 days)"
 		}
 
 		fmt.Printf("User %d: %s (%s)\n", i+1, user.Email, user.Region)
 		fmt.Printf("  Status: %s\n", status)
 		fmt.Printf("  Last refresh: %s\n", user.LastRefresh.Format("2006-01-02 15:04:05"))
+		if !expires.IsZero() {
+			fmt.Printf("  Expires: %s\n", expires.Local().Format("2006-01-02 15:04:05"))
+		}
 		if user.SessionData != nil {
 			fmt.Printf("  User ID: %s\n", user.SessionData.LoginResult.Uid)
 			fmt.Printf("  Nickname: %s\n", user.SessionData.LoginResult.Nickname)
@@ -172,14 +218,6 @@ func runAddUser(cmd *cobra.Command, args []string) error {
 	regionName := args[0]
 	email := args[1]
 
-	usePassword, _ := cmd.Flags().GetBool("password")
-	useQR, _ := cmd.Flags().GetBool("qr")
-
-	if !usePassword && !useQR {
-		authMethod := promptAuthMethod()
-		usePassword = (authMethod == "password")
-	}
-
 	var selectedRegion *tuya.Region
 	for _, region := range AvailableRegions {
 		if region.Name == regionName {
@@ -194,19 +232,26 @@ func runAddUser(cmd *cobra.Command, args []string) error {
 		for _, region := range AvailableRegions {
 			fmt.Printf("  %s - %s\n", region.Name, region.Description)
 		}
-		return fmt.Errorf("invalid region")
+		return exitError(ExitInvalidInput, "invalid region")
 	}
 
 	if !strings.Contains(email, "@") || !strings.Contains(email, ".") {
-		return fmt.Errorf("invalid email format: %s", email)
+		return exitError(ExitInvalidInput, "invalid email format: %s", email)
+	}
+
+	options, err := parseLoginOptions(cmd, *selectedRegion)
+	if err != nil {
+		return err
 	}
 
 	existingUser, err := storageManager.GetUser(selectedRegion.Name, email)
 	if err != nil {
-		return fmt.Errorf("failed to check existing user: %v", err)
+		return exitError(ExitStorageError, "failed to check existing user: %v", err)
 	}
 
-	if existingUser != nil {
+	if existingUser != nil && options.nonInteractive {
+		fmt.Printf("User %s in region %s already exists, re-authenticating.\n", email, selectedRegion.Name)
+	} else if existingUser != nil {
 		fmt.Printf("User %s in region %s already exists.\n", email, selectedRegion.Name)
 		fmt.Println("Do you want to re-authenticate? (y/N): ")
 
@@ -218,7 +263,7 @@ func runAddUser(cmd *cobra.Command, args []string) error {
 	}
 
 	authMethodStr := "QR code"
-	if usePassword {
+	if options.usePassword {
 		authMethodStr = "email/password"
 	}
 
@@ -227,18 +272,18 @@ func runAddUser(cmd *cobra.Command, args []string) error {
 		email, selectedRegion.Name, selectedRegion.Description, authMethodStr)
 
 	var sessionData *tuya.SessionData
-	if usePassword {
-		sessionData, err = performPasswordAuthentication(*selectedRegion, email)
+	if options.usePassword {
+		sessionData, err = performPasswordAuthentication(*selectedRegion, email, options)
 	} else {
-		sessionData, err = performQRAuthentication(*selectedRegion, email)
+		sessionData, err = performQRAuthentication(*selectedRegion, email, options)
 	}
 
 	if err != nil {
-		return fmt.Errorf("authentication failed: %v", err)
+		return exitError(loginExitCode(err), "authentication failed: %v", err)
 	}
 
-	if err := storageManager.SaveUser(selectedRegion.Name, email, sessionData); err != nil {
-		return fmt.Errorf("failed to save user session: %v", err)
+	if err := saveLogin(selectedRegion.Name, email, sessionData, options); err != nil {
+		return err
 	}
 
 	fmt.Printf("\n✓ Successfully added user %s (%s) in region %s\n",
@@ -281,14 +326,6 @@ func runRefreshUser(cmd *cobra.Command, args []string) error {
 	regionName := args[0]
 	email := args[1]
 
-	usePassword, _ := cmd.Flags().GetBool("password")
-	useQR, _ := cmd.Flags().GetBool("qr")
-
-	if !usePassword && !useQR {
-		authMethod := promptAuthMethod()
-		usePassword = (authMethod == "password")
-	}
-
 	var selectedRegion *tuya.Region
 	for _, region := range AvailableRegions {
 		if region.Name == regionName {
@@ -298,20 +335,25 @@ func runRefreshUser(cmd *cobra.Command, args []string) error {
 	}
 
 	if selectedRegion == nil {
-		return fmt.Errorf("invalid region: %s", regionName)
+		return exitError(ExitInvalidInput, "invalid region: %s", regionName)
+	}
+
+	options, err := parseLoginOptions(cmd, *selectedRegion)
+	if err != nil {
+		return err
 	}
 
 	existingUser, err := storageManager.GetUser(regionName, email)
 	if err != nil {
-		return fmt.Errorf("failed to check user: %v", err)
+		return exitError(ExitStorageError, "failed to check user: %v", err)
 	}
 
 	if existingUser == nil {
-		return fmt.Errorf("user %s in region %s not found", email, regionName)
+		return exitError(ExitInvalidInput, "user %s in region %s not found", email, regionName)
 	}
 
 	authMethodStr := "QR code"
-	if usePassword {
+	if options.usePassword {
 		authMethodStr = "email/password"
 	}
 
@@ -319,18 +361,18 @@ func runRefreshUser(cmd *cobra.Command, args []string) error {
 		email, regionName, authMethodStr)
 
 	var sessionData *tuya.SessionData
-	if usePassword {
-		sessionData, err = performPasswordAuthentication(*selectedRegion, email)
+	if options.usePassword {
+		sessionData, err = performPasswordAuthentication(*selectedRegion, email, options)
 	} else {
-		sessionData, err = performQRAuthentication(*selectedRegion, email)
+		sessionData, err = performQRAuthentication(*selectedRegion, email, options)
 	}
 
 	if err != nil {
-		return fmt.Errorf("authentication failed: %v", err)
+		return exitError(loginExitCode(err), "authentication failed: %v", err)
 	}
 
-	if err := storageManager.SaveUser(regionName, email, sessionData); err != nil {
-		return fmt.Errorf("failed to save user session: %v", err)
+	if err := saveLogin(regionName, email, sessionData, options); err != nil {
+		return err
 	}
 
 	fmt.Printf("✓ Successfully refreshed session for user %s (%s)\n",
@@ -381,6 +423,34 @@ func runTestUser(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+func runEncryptUsers(cmd *cobra.Command, args []string) error {
+	if !storageManager.Encrypted() {
+		return fmt.Errorf("no storage key, set --storage-key-file, --storage-passphrase or $TUYA_IPC_STORAGE_KEY")
+	}
+
+	count, err := storageManager.MigrateUsers(true)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt sessions: %v", err)
+	}
+
+	fmt.Printf("✓ Encrypted %d session(s)\n", count)
+	return nil
+}
+
+func runDecryptUsers(cmd *cobra.Command, args []string) error {
+	if !storageManager.Encrypted() {
+		return fmt.Errorf("no storage key, set --storage-key-file, --storage-passphrase or $TUYA_IPC_STORAGE_KEY")
+	}
+
+	count, err := storageManager.MigrateUsers(false)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt sessions: %v", err)
+	}
+
+	fmt.Printf("✓ Decrypted %d session(s), run later commands without the storage key\n", count)
+	return nil
+}
+
 func runShowRegions(cmd *cobra.Command, args []string) error {
 	fmt.Println(strings.Repeat("-", 70))
 	fmt.Printf("%-15s %-35s %s\n", "REGION", "ENDPOINT", "DESCRIPTION")
@@ -462,8 +532,7 @@ func runShowCountryCodes(cmd *cobra.Command, args []string) error {
 	}
 
 	fmt.Println("\nUsage in authentication:")
-	fmt.Println("  tuya-ipc-terminal auth add --password eu-central user@example.com")
-	fmt.Println("  (You'll be prompted to select your country code)")
+	fmt.Println("  tuya-ipc-terminal auth add --password --country-code 49 eu-central user@example.com")
 
 	return nil
 }
@@ -497,26 +566,30 @@ func promptPassword() (string, error) {
 	return string(password), nil
 }
 
-func performPasswordAuthentication(region tuya.Region, email string) (*tuya.SessionData, error) {
+func performPasswordAuthentication(region tuya.Region, email string, options *loginOptions) (*tuya.SessionData, error) {
 	serverHost := region.Host
 
-	password, err := promptPassword()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get password: %v", err)
+	if options.password == "" {
+		password, err := promptPassword()
+		if err != nil {
+			return nil, exitError(ExitInvalidInput, "failed to get password: %v", err)
+		}
+		options.password = password
 	}
+	password := options.password
 
 	httpClient := createHTTPClientWithSession(nil)
 
 	fmt.Println("\nAuthenticating with email/password...")
 
-	loginResult, err := tuya.PasswordLogin(httpClient, serverHost, email, password, region.Continent)
+	loginResult, err := tuya.PasswordLogin(httpClient, serverHost, email, password, options.countryCode)
 	if err != nil {
-		return nil, fmt.Errorf("password authentication failed: %v", err)
+		return nil, fmt.Errorf("password authentication failed: %w", err)
 	}
 
 	sessionData := &tuya.SessionData{
 		LoginResult:   loginResult,
-		Cookies:       extractCookies(httpClient, serverHost),
+		Cookies:       extractCookies(httpClient),
 		LastValidated: time.Now(),
 		ServerHost:    serverHost,
 		Region:        region.Name,
@@ -526,7 +599,7 @@ func performPasswordAuthentication(region tuya.Region, email string) (*tuya.Sess
 	return sessionData, nil
 }
 
-func performQRAuthentication(region tuya.Region, email string) (*tuya.SessionData, error) {
+func performQRAuthentication(region tuya.Region, email string, options *loginOptions) (*tuya.SessionData, error) {
 	serverHost := region.Host
 
 	httpClient := createHTTPClientWithSession(nil)
@@ -537,32 +610,34 @@ func performQRAuthentication(region tuya.Region, email string) (*tuya.SessionDat
 		return nil, fmt.Errorf("error generating QR code: %v", err)
 	}
 
-	qrterminal.Generate("tuyaSmart--qrLogin?token="+qrCodeToken, qrterminal.L, os.Stdout)
+	if err := showQRCode("tuyaSmart--qrLogin?token="+qrCodeToken, options); err != nil {
+		return nil, err
+	}
 	fmt.Printf("\nPlease scan the QR code with the Tuya Smart / Smart Life app.\n")
 	fmt.Printf("Make sure to use the account with email: %s\n", email)
-	fmt.Println("\nPress Enter after scanning to continue...")
-	fmt.Scanln()
 
-	fmt.Println("Polling for login status...")
-	loginResult, err := tuya.PollForLogin(httpClient, serverHost, qrCodeToken)
+	fmt.Printf("\nWaiting up to %s for the login...\n", options.qrTimeout)
+	loginResult, err := tuya.PollForLogin(httpClient, serverHost, qrCodeToken, options.qrTimeout)
 	if err != nil {
-		return nil, fmt.Errorf("error polling for login: %v", err)
+		return nil, fmt.Errorf("error polling for login: %w", err)
 	}
 
-	if loginResult.Email != email {
+	if loginResult.Email != email && options.nonInteractive {
+		return nil, exitError(ExitAccountMismatch, "logged in as %s instead of %s", loginResult.Email, email)
+	} else if loginResult.Email != email {
 		fmt.Println("Logged in with different email than expected!")
 		fmt.Printf("Expected: %s, Got: %s\n", email, loginResult.Email)
 		fmt.Println("Continue anyway? (y/N): ")
 		var response string
 		fmt.Scanln(&response)
 		if strings.ToLower(response) != "y" && strings.ToLower(response) != "yes" {
-			return nil, fmt.Errorf("email mismatch, authentication cancelled")
+			return nil, exitError(ExitAccountMismatch, "email mismatch, authentication cancelled")
 		}
 	}
 
 	sessionData := &tuya.SessionData{
 		LoginResult:   loginResult,
-		Cookies:       extractCookies(httpClient, serverHost),
+		Cookies:       extractCookies(httpClient),
 		LastValidated: time.Now(),
 		ServerHost:    serverHost,
 		Region:        region.Name,
@@ -573,56 +648,21 @@ func performQRAuthentication(region tuya.Region, email string) (*tuya.SessionDat
 }
 
 func createHTTPClientWithSession(session *tuya.SessionData) *http.Client {
-	jar, err := cookiejar.New(&cookiejar.Options{
-		PublicSuffixList: publicsuffix.List,
-	})
+	jar, err := tuya.NewCookieJar(session)
 	if err != nil {
 		return nil
 	}
 
-	if session != nil && len(session.Cookies) > 0 {
-		serverURL, _ := url.Parse(fmt.Sprintf("https://%s", session.ServerHost))
-
-		var httpCookies []*http.Cookie
-		for _, cookie := range session.Cookies {
-			httpCookies = append(httpCookies, &http.Cookie{
-				Name:     cookie.Name,
-				Value:    cookie.Value,
-				Domain:   cookie.Domain,
-				Path:     cookie.Path,
-				Expires:  cookie.Expires,
-				Secure:   cookie.Secure,
-				HttpOnly: cookie.HttpOnly,
-			})
-		}
-
-		jar.SetCookies(serverURL, httpCookies)
-	}
-
 	return &http.Client{
 		Timeout: 30 * time.Second,
 		Jar:     jar,
 	}
 }
 
-func extractCookies(client *http.Client, serverHost string) []*tuya.Cookie {
-	var cookies []*tuya.Cookie
-	if client.Jar != nil {
-		serverURL, _ := url.Parse(fmt.Sprintf("https://%s", serverHost))
-		httpCookies := client.Jar.Cookies(serverURL)
-
-		for _, httpCookie := range httpCookies {
-			cookies = append(cookies, &tuya.Cookie{
-				Name:     httpCookie.Name,
-				Value:    httpCookie.Value,
-				Domain:   httpCookie.Domain,
-				Path:     httpCookie.Path,
-				Expires:  httpCookie.Expires,
-				Secure:   httpCookie.Secure,
-				HttpOnly: httpCookie.HttpOnly,
-			})
-		}
+// extractCookies returns the cookies of the login with their expiry
+func extractCookies(client *http.Client) []*tuya.Cookie {
+	if jar, ok := client.Jar.(*tuya.CookieJar); ok {
+		return jar.SessionCookies()
 	}
-
-	return cookies
+	return nil
 }
@@ -0,0 +1,241 @@
+package auth
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mdp/qrterminal"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+	"rsc.io/qr"
+
+	"tuya-ipc-terminal/pkg/storage"
+	"tuya-ipc-terminal/pkg/tuya"
+)
+
+// Exit codes of failed logins, other errors exit with 1
+const (
+	ExitInvalidInput    = 2 // Invalid arguments, unknown user or no password
+	ExitLoginRejected   = 3 // The account or password was rejected
+	ExitLoginTimeout    = 4 // The QR code wasn't scanned in time
+	ExitAPIError        = 5 // The Tuya API failed or wasn't reachable
+	ExitStorageError    = 6 // The session couldn't be read or saved
+	ExitAccountMismatch = 7 // The QR code was scanned with another account
+)
+
+// Environment variable with the password of a password login
+const passwordEnv = "TUYA_IPC_PASSWORD"
+
+const qrCodeScale = 8
+
+// ExitError is a failure with its own exit code
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+func exitError(code int, format string, args ...any) error {
+	return &ExitError{Code: code, Err: fmt.Errorf(format, args...)}
+}
+
+// loginExitCode returns the exit code of a failed login
+func loginExitCode(err error) int {
+	var exitErr *ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		return exitErr.Code
+	case errors.Is(err, tuya.ErrLoginRejected):
+		return ExitLoginRejected
+	case errors.Is(err, tuya.ErrLoginTimeout):
+		return ExitLoginTimeout
+	default:
+		return ExitAPIError
+	}
+}
+
+type loginOptions struct {
+	usePassword    bool
+	password       string // Prompted if empty
+	countryCode    string
+	remember       bool // Store the password to log in again unattended
+	nonInteractive bool
+	qrTimeout      time.Duration
+	qrPNG          string
+	qrDataURL      bool
+}
+
+func addLoginFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("qr", false, "Use QR code authentication (default)")
+	cmd.Flags().Bool("password", false, "Use email/password authentication")
+	cmd.Flags().String("password-value", "", "Password, visible to other processes, prefer $"+passwordEnv+" or --password-file")
+	cmd.Flags().String("password-file", "", "Read the password from a file, - for stdin")
+	cmd.Flags().Bool("remember-password", false, "Keep the password in the encrypted session to log in again when it expires")
+	cmd.Flags().String("country-code", "", "Country of the password login, phone (49) or ISO code (DE), defaults to the region's")
+	cmd.Flags().Bool("non-interactive", false, "Never prompt, implied if stdin isn't a terminal")
+	cmd.Flags().Duration("qr-timeout", 2*time.Minute, "How long to wait for the QR code scan")
+	cmd.Flags().String("qr-png", "", "Write the QR code to a PNG file instead of the terminal")
+	cmd.Flags().Bool("qr-data-url", false, "Print the QR code as a PNG data URL instead of the terminal")
+}
+
+// parseLoginOptions reads the login flags, the method is prompted for if
+// neither is given in interactive mode
+func parseLoginOptions(cmd *cobra.Command, region tuya.Region) (*loginOptions, error) {
+	usePassword, _ := cmd.Flags().GetBool("password")
+	useQR, _ := cmd.Flags().GetBool("qr")
+	nonInteractive, _ := cmd.Flags().GetBool("non-interactive")
+	countryCode, _ := cmd.Flags().GetString("country-code")
+	remember, _ := cmd.Flags().GetBool("remember-password")
+	qrTimeout, _ := cmd.Flags().GetDuration("qr-timeout")
+	qrPNG, _ := cmd.Flags().GetString("qr-png")
+	qrDataURL, _ := cmd.Flags().GetBool("qr-data-url")
+
+	options := &loginOptions{
+		countryCode:    region.Continent,
+		remember:       remember,
+		nonInteractive: nonInteractive || !term.IsTerminal(int(os.Stdin.Fd())),
+		qrTimeout:      qrTimeout,
+		qrPNG:          qrPNG,
+		qrDataURL:      qrDataURL,
+	}
+
+	if qrTimeout <= 0 {
+		return nil, exitError(ExitInvalidInput, "invalid --qr-timeout: %s", qrTimeout)
+	}
+
+	if countryCode != "" {
+		code, err := lookupCountryCode(countryCode)
+		if err != nil {
+			return nil, err
+		}
+		options.countryCode = code
+	}
+
+	password, err := readPassword(cmd)
+	if err != nil {
+		return nil, err
+	}
+	options.password = password
+
+	// A given password selects the password login
+	if password != "" && !useQR {
+		usePassword = true
+	}
+
+	if !usePassword && !useQR && !options.nonInteractive {
+		usePassword = promptAuthMethod() == "password"
+	}
+	options.usePassword = usePassword
+
+	if remember && !usePassword {
+		return nil, exitError(ExitInvalidInput, "--remember-password needs the password login")
+	}
+	if remember && !storageManager.Encrypted() {
+		return nil, exitError(ExitInvalidInput, "--remember-password needs encrypted storage, set --storage-key-file, --storage-passphrase or $TUYA_IPC_STORAGE_KEY")
+	}
+
+	if usePassword && password == "" && options.nonInteractive {
+		return nil, exitError(ExitInvalidInput, "no password given, use $%s, --password-file or --password-value", passwordEnv)
+	}
+
+	return options, nil
+}
+
+// readPassword returns the password of the flags or the environment, empty
+// if there is none
+func readPassword(cmd *cobra.Command) (string, error) {
+	if password, _ := cmd.Flags().GetString("password-value"); password != "" {
+		return password, nil
+	}
+
+	if file, _ := cmd.Flags().GetString("password-file"); file != "" {
+		var data []byte
+		var err error
+		if file == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(file)
+		}
+		if err != nil {
+			return "", exitError(ExitInvalidInput, "failed to read password: %v", err)
+		}
+
+		password := strings.TrimRight(string(data), "\r\n")
+		if password == "" {
+			return "", exitError(ExitInvalidInput, "password file %s is empty", file)
+		}
+		return password, nil
+	}
+
+	return os.Getenv(passwordEnv), nil
+}
+
+// lookupCountryCode returns the phone code of a phone, ISO or ISO3 code
+func lookupCountryCode(value string) (string, error) {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "+")
+
+	for _, country := range CountryCodesData {
+		if country.C == value || strings.EqualFold(country.A, value) || strings.EqualFold(country.ISO3, value) {
+			return country.C, nil
+		}
+	}
+
+	return "", exitError(ExitInvalidInput, "unknown country code: %s, see 'tuya-ipc-terminal auth show-country-codes'", value)
+}
+
+// saveLogin saves the session and, if requested, the password
+func saveLogin(region, email string, sessionData *tuya.SessionData, options *loginOptions) error {
+	if err := storageManager.SaveUser(region, email, sessionData); err != nil {
+		return exitError(ExitStorageError, "failed to save user session: %v", err)
+	}
+
+	if options.usePassword && options.remember {
+		credentials := &storage.Credentials{Password: options.password, CountryCode: options.countryCode}
+		if err := storageManager.SetUserCredentials(region, email, credentials); err != nil {
+			return exitError(ExitStorageError, "failed to save password: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// showQRCode renders the QR code in the terminal or, if requested, as a PNG
+// file or data URL for other programs to display
+func showQRCode(content string, options *loginOptions) error {
+	if options.qrPNG == "" && !options.qrDataURL {
+		qrterminal.Generate(content, qrterminal.L, os.Stdout)
+		return nil
+	}
+
+	code, err := qr.Encode(content, qr.L)
+	if err != nil {
+		return fmt.Errorf("failed to encode QR code: %v", err)
+	}
+	code.Scale = qrCodeScale
+	png := code.PNG()
+
+	if options.qrPNG != "" {
+		if err := os.WriteFile(options.qrPNG, png, 0600); err != nil {
+			return exitError(ExitInvalidInput, "failed to write QR code: %v", err)
+		}
+		fmt.Printf("QR code written to %s\n", options.qrPNG)
+	}
+
+	if options.qrDataURL {
+		fmt.Println("data:image/png;base64," + base64.StdEncoding.EncodeToString(png))
+	}
+
+	return nil
+}
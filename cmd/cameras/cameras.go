@@ -1,17 +1,14 @@
 package cameras
 
 import (
-	"errors"
 	"fmt"
-	"net/http"
-	"net/http/cookiejar"
-	"net/url"
-	"strconv"
+	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
-	"golang.org/x/net/publicsuffix"
 
+	"tuya-ipc-terminal/pkg/discovery"
+	"tuya-ipc-terminal/pkg/ptz"
 	"tuya-ipc-terminal/pkg/storage"
 	"tuya-ipc-terminal/pkg/tuya"
 )
@@ -32,6 +29,9 @@ func NewCamerasCmd() *cobra.Command {
 	cmd.AddCommand(newListCmd())
 	cmd.AddCommand(newRefreshCmd())
 	cmd.AddCommand(newInfoCmd())
+	cmd.AddCommand(newAliasCmd())
+	cmd.AddCommand(newPlaybackCmd())
+	cmd.AddCommand(newPTZCmd())
 
 	return cmd
 }
@@ -51,12 +51,18 @@ func newListCmd() *cobra.Command {
 }
 
 func newRefreshCmd() *cobra.Command {
-	return &cobra.Command{
+	cmd := &cobra.Command{
 		Use:   "refresh",
 		Short: "Refresh camera discovery",
-		Long:  "Rediscover cameras from all authenticated users.",
-		RunE:  runRefreshCameras,
+		Long: `Rediscover cameras from all authenticated users.
+Known cameras keep their RTSP path, also when renamed in the app. New cameras get a path from the template.`,
+		RunE: runRefreshCameras,
 	}
+
+	cmd.Flags().String("path-template", "", "Paths of new cameras, placeholders {name}, {home}, {room} and {id} (default "+storage.DefaultPathTemplate+")")
+	cmd.Flags().Bool("reset-paths", false, "Give all cameras without alias a new path from the template")
+
+	return cmd
 }
 
 func newInfoCmd() *cobra.Command {
@@ -69,6 +75,58 @@ func newInfoCmd() *cobra.Command {
 	}
 }
 
+func newAliasCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "alias [camera-id] [path]",
+		Short: "Set the RTSP path of a camera",
+		Long: `Serve a camera at a path of your choice, refreshes keep it.
+Paths set in the config file take precedence.`,
+		Example: `  tuya-ipc-terminal cameras alias FrontDoor /front
+  tuya-ipc-terminal cameras alias FrontDoor --remove`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: runCameraAlias,
+	}
+
+	cmd.Flags().Bool("remove", false, "Remove the alias, the camera gets a path from the template")
+
+	return cmd
+}
+
+func newPlaybackCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "playback [camera-id]",
+		Short: "List SD-card recordings of a camera",
+		Long: `List the days with SD-card recordings of a month, or the recorded time ranges of a day.
+Recordings are played back by the RTSP server at rtsp://host:port/<camera>/playback?start=<time>.`,
+		Args: cobra.ExactArgs(1),
+		RunE: runCameraPlayback,
+	}
+
+	cmd.Flags().String("month", "", "Month to list recorded days of (YYYY-MM, default current month)")
+	cmd.Flags().String("day", "", "Day to list recorded time ranges of (YYYY-MM-DD)")
+	cmd.Flags().Int("port", 8554, "RTSP server port used in the printed URLs")
+
+	return cmd
+}
+
+func newPTZCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "ptz [camera-id] [action] [preset]",
+		Short: "Move a PTZ camera",
+		Long: `Pan, tilt and zoom a camera that supports PTZ, or go to and save presets.
+Actions: up, down, left, right, up-left, up-right, down-left, down-right, zoom-in, zoom-out, stop, preset N, save-preset N`,
+		Example: `  tuya-ipc-terminal cameras ptz FrontDoor left
+  tuya-ipc-terminal cameras ptz FrontDoor left --duration 0
+  tuya-ipc-terminal cameras ptz FrontDoor preset 2`,
+		Args: cobra.RangeArgs(2, 3),
+		RunE: runCameraPTZ,
+	}
+
+	cmd.Flags().Duration("duration", 500*time.Millisecond, "Stop a move or zoom after this long, 0 keeps moving until stop")
+
+	return cmd
+}
+
 func runListCameras(cmd *cobra.Command, args []string) error {
 	userFilter, _ := cmd.Flags().GetString("user")
 
@@ -103,6 +161,9 @@ func runListCameras(cmd *cobra.Command, args []string) error {
 		fmt.Printf("   Category: %s\n", cam.Category)
 		fmt.Printf("   Product ID: %s\n", cam.ProductID)
 		fmt.Printf("   RTSP Path: %s\n", cam.RTSPPath)
+		if cam.Room != "" {
+			fmt.Printf("   Room: %s / %s\n", cam.Home, cam.Room)
+		}
 		fmt.Println()
 	}
 
@@ -115,6 +176,13 @@ func runListCameras(cmd *cobra.Command, args []string) error {
 }
 
 func runRefreshCameras(cmd *cobra.Command, args []string) error {
+	if template, _ := cmd.Flags().GetString("path-template"); template != "" {
+		if err := storage.ValidatePathTemplate(template); err != nil {
+			return fmt.Errorf("invalid --path-template: %v", err)
+		}
+		storageManager.SetPathTemplate(template)
+	}
+
 	fmt.Println("Refreshing camera discovery...")
 
 	users, err := storageManager.ListUsers()
@@ -134,19 +202,14 @@ func runRefreshCameras(cmd *cobra.Command, args []string) error {
 	for _, user := range users {
 		fmt.Printf("Discovering cameras for %s (%s)...\n", user.Email, user.Region)
 
-		cameras, err := discoverCamerasForUser(&user)
+		count, err := discovery.RefreshUser(storageManager, &user)
 		if err != nil {
-			fmt.Printf("  ✗ Failed to discover cameras: %v\n", err)
+			fmt.Printf("  ✗ %v\n", err)
 			continue
 		}
 
-		if err := storageManager.UpdateCamerasForUser(user.UserKey, cameras); err != nil {
-			fmt.Printf("  ✗ Failed to save cameras: %v\n", err)
-			continue
-		}
-
-		fmt.Printf("  ✓ Found %d camera(s)\n", len(cameras))
-		totalCameras += len(cameras)
+		fmt.Printf("  ✓ Found %d camera(s)\n", count)
+		totalCameras += count
 		successfulUsers++
 	}
 
@@ -154,6 +217,35 @@ func runRefreshCameras(cmd *cobra.Command, args []string) error {
 	fmt.Printf("Successfully processed %d/%d users\n", successfulUsers, len(users))
 	fmt.Printf("Total cameras discovered: %d\n", totalCameras)
 
+	if resetPaths, _ := cmd.Flags().GetBool("reset-paths"); resetPaths {
+		count, err := storageManager.ResetCameraPaths()
+		if err != nil {
+			return fmt.Errorf("failed to reset paths: %v", err)
+		}
+		fmt.Printf("Changed the paths of %d camera(s)\n", count)
+	}
+
+	return nil
+}
+
+func runCameraAlias(cmd *cobra.Command, args []string) error {
+	remove, _ := cmd.Flags().GetBool("remove")
+	if remove == (len(args) == 2) {
+		return fmt.Errorf("give either a path or --remove")
+	}
+
+	alias := ""
+	if !remove {
+		alias = args[1]
+	}
+
+	camera, err := storageManager.SetCameraAlias(args[0], alias)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s is served at %s\n", camera.DeviceName, camera.RTSPPath)
+
 	return nil
 }
 
@@ -186,6 +278,15 @@ func runCameraInfo(cmd *cobra.Command, args []string) error {
 	fmt.Printf("Product ID: %s\n", targetCamera.ProductID)
 	fmt.Printf("User: %s\n", targetCamera.UserKey)
 	fmt.Printf("RTSP Path: %s\n", targetCamera.RTSPPath)
+	if targetCamera.Alias != "" {
+		fmt.Printf("Alias: %s\n", targetCamera.Alias)
+	}
+	if targetCamera.Home != "" {
+		fmt.Printf("Home: %s\n", targetCamera.Home)
+	}
+	if targetCamera.Room != "" {
+		fmt.Printf("Room: %s\n", targetCamera.Room)
+	}
 
 	fmt.Printf("Fetching additional information...\n")
 
@@ -195,7 +296,7 @@ func runCameraInfo(cmd *cobra.Command, args []string) error {
 		return nil
 	}
 
-	httpClient := createHTTPClientWithSession(user.SessionData)
+	httpClient := discovery.NewHTTPClient(user.SessionData)
 	if httpClient == nil {
 		fmt.Println("Could not create HTTP client")
 		return nil
@@ -218,123 +319,135 @@ func runCameraInfo(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
-func discoverCamerasForUser(user *storage.UserSession) ([]storage.CameraInfo, error) {
-	if user.SessionData == nil {
-		return nil, errors.New("user has no valid session data")
-	}
+func runCameraPlayback(cmd *cobra.Command, args []string) error {
+	monthFlag, _ := cmd.Flags().GetString("month")
+	dayFlag, _ := cmd.Flags().GetString("day")
+	port, _ := cmd.Flags().GetInt("port")
 
-	httpClient := createHTTPClientWithSession(user.SessionData)
-	if httpClient == nil {
-		return nil, errors.New("failed to create HTTP client")
+	targetCamera, err := findCamera(args[0])
+	if err != nil {
+		return err
 	}
 
-	// Test session validity first
-	_, err := tuya.GetAppInfo(httpClient, user.SessionData.ServerHost)
+	user, err := getUserFromKey(targetCamera.UserKey)
 	if err != nil {
-		return nil, fmt.Errorf("session is invalid: %v", err)
+		return fmt.Errorf("could not load user info: %v", err)
 	}
 
-	var devices []tuya.Device
+	httpClient := discovery.NewHTTPClient(user.SessionData)
+	if httpClient == nil {
+		return fmt.Errorf("could not create HTTP client")
+	}
 
-	// Get home list
-	homes, _ := tuya.GetHomeList(httpClient, user.SessionData.ServerHost)
-	if homes != nil && len(homes.Result) > 0 {
-		for _, home := range homes.Result {
-			// Get room list with devices
-			roomList, err := tuya.GetRoomList(httpClient, user.SessionData.ServerHost, strconv.Itoa(home.Gid))
-			if err != nil {
-				continue // Skip this home if we can't get rooms
-			}
+	if dayFlag != "" {
+		day, err := time.ParseInLocation("2006-01-02", dayFlag, time.Local)
+		if err != nil {
+			return fmt.Errorf("invalid day: %v", err)
+		}
 
-			// Extract cameras from rooms
-			for _, room := range roomList.Result {
-				for _, device := range room.DeviceList {
-					// Check if device is a camera (sp = smart camera, dghsxj = another camera type)
-					if (device.Category == "sp" || device.Category == "dghsxj") && !containsDevice(devices, device.DeviceId) {
-						devices = append(devices, device)
-					}
-				}
-			}
+		segments, err := tuya.GetRecordSegments(httpClient, user.SessionData.ServerHost, targetCamera.DeviceID, day)
+		if err != nil {
+			return fmt.Errorf("failed to get recordings: %v", err)
 		}
-	}
 
-	// Get shared home list
-	sharedHomes, _ := tuya.GetSharedHomeList(httpClient, user.SessionData.ServerHost)
-	if sharedHomes != nil && len(sharedHomes.Result.SecurityWebCShareInfoList) > 0 {
+		if len(segments.Result.Items) == 0 {
+			fmt.Printf("No recordings of %s on %s\n", targetCamera.DeviceName, dayFlag)
+			return nil
+		}
+
+		fmt.Printf("Recordings of %s on %s:\n", targetCamera.DeviceName, dayFlag)
+		for _, segment := range segments.Result.Items {
+			start := time.Unix(segment.StartTime, 0)
+			end := time.Unix(segment.EndTime, 0)
 
-		// Extract cameras from shared homes
-		for _, sharedHome := range sharedHomes.Result.SecurityWebCShareInfoList {
-			for _, device := range sharedHome.DeviceInfoList {
-				// Check if device is a camera (sp = smart camera, dghsxj = another camera type)
-				if (device.Category == "sp" || device.Category == "dghsxj") && !containsDevice(devices, device.DeviceId) {
-					devices = append(devices, device)
-				}
+			kind := "continuous"
+			if segment.Type != 0 {
+				kind = "event"
 			}
+
+			fmt.Printf("  %s - %s  %-10s  rtsp://localhost:%d%s/playback?start=%s\n",
+				start.Format("15:04:05"), end.Format("15:04:05"), kind,
+				port, targetCamera.RTSPPath, start.Format("2006-01-02T15:04:05"))
 		}
-	}
 
-	if len(devices) == 0 {
-		return []storage.CameraInfo{}, nil
+		return nil
 	}
 
-	var allCameras []storage.CameraInfo
-
-	for _, device := range devices {
-		webrtcConfig, err := tuya.GetWebRTCConfig(httpClient, user.SessionData.ServerHost, device.DeviceId)
+	month := time.Now()
+	if monthFlag != "" {
+		month, err = time.ParseInLocation("2006-01", monthFlag, time.Local)
 		if err != nil {
-			continue // Skip if we can't get WebRTC config
+			return fmt.Errorf("invalid month: %v", err)
 		}
+	}
 
-		rtspPath := storageManager.GenerateRTSPPath(device.DeviceName, device.DeviceId)
-
-		camera := storage.CameraInfo{
-			UserKey:    user.UserKey,
-			DeviceID:   device.DeviceId,
-			DeviceName: device.DeviceName,
-			Category:   device.Category,
-			RTSPPath:   rtspPath,
-			ProductID:  device.ProductId,
-			UUID:       device.Uuid,
-			Skill:      webrtcConfig.Result.Skill,
-		}
+	days, err := tuya.GetRecordDays(httpClient, user.SessionData.ServerHost, targetCamera.DeviceID, month)
+	if err != nil {
+		return fmt.Errorf("failed to get recorded days: %v", err)
+	}
 
-		allCameras = append(allCameras, camera)
+	if len(days.Result) == 0 {
+		fmt.Printf("No recordings of %s in %s\n", targetCamera.DeviceName, month.Format("2006-01"))
+		return nil
 	}
 
-	return allCameras, nil
+	fmt.Printf("Days with recordings of %s in %s:\n", targetCamera.DeviceName, month.Format("2006-01"))
+	for _, day := range days.Result {
+		fmt.Printf("  %s-%s\n", month.Format("2006-01"), day)
+	}
+	fmt.Printf("\nUse --day YYYY-MM-DD to list the recorded time ranges of a day\n")
+
+	return nil
 }
 
-func createHTTPClientWithSession(session *tuya.SessionData) *http.Client {
-	jar, err := cookiejar.New(&cookiejar.Options{
-		PublicSuffixList: publicsuffix.List,
-	})
+func runCameraPTZ(cmd *cobra.Command, args []string) error {
+	duration, _ := cmd.Flags().GetDuration("duration")
+
+	command, err := tuya.ParsePTZCommand(args[1:])
 	if err != nil {
-		return nil
+		return err
+	}
+
+	targetCamera, err := findCamera(args[0])
+	if err != nil {
+		return err
+	}
+
+	controller := ptz.NewController(storageManager)
+	defer controller.Close()
+
+	if err := controller.Send(targetCamera, command); err != nil {
+		return err
 	}
 
-	if session != nil && len(session.Cookies) > 0 {
-		serverURL, _ := url.Parse(fmt.Sprintf("https://%s", session.ServerHost))
-
-		var httpCookies []*http.Cookie
-		for _, cookie := range session.Cookies {
-			httpCookies = append(httpCookies, &http.Cookie{
-				Name:     cookie.Name,
-				Value:    cookie.Value,
-				Domain:   cookie.Domain,
-				Path:     cookie.Path,
-				Expires:  cookie.Expires,
-				Secure:   cookie.Secure,
-				HttpOnly: cookie.HttpOnly,
-			})
+	switch command.Action {
+	case tuya.PTZStop, tuya.PTZPreset, tuya.PTZSavePreset:
+	default:
+		if duration > 0 {
+			time.Sleep(duration)
+			if err := controller.Send(targetCamera, tuya.PTZCommand{Action: tuya.PTZStop}); err != nil {
+				return err
+			}
 		}
+	}
 
-		jar.SetCookies(serverURL, httpCookies)
+	fmt.Printf("Sent %s to %s\n", strings.Join(args[1:], " "), targetCamera.DeviceName)
+	return nil
+}
+
+func findCamera(cameraID string) (*storage.CameraInfo, error) {
+	cameras, err := storageManager.GetAllCameras()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cameras: %v", err)
 	}
 
-	return &http.Client{
-		Timeout: 30 * time.Second,
-		Jar:     jar,
+	for _, cam := range cameras {
+		if cam.DeviceID == cameraID || cam.DeviceName == cameraID || cam.RTSPPath == "/"+strings.TrimPrefix(cameraID, "/") {
+			return &cam, nil
+		}
 	}
+
+	return nil, fmt.Errorf("camera not found: %s", cameraID)
 }
 
 func getUserFromKey(userKey string) (*storage.UserSession, error) {
@@ -351,12 +464,3 @@ func getUserFromKey(userKey string) (*storage.UserSession, error) {
 
 	return nil, fmt.Errorf("user not found for key: %s", userKey)
 }
-
-func containsDevice(devices []tuya.Device, deviceID string) bool {
-	for _, device := range devices {
-		if device.DeviceId == deviceID {
-			return true
-		}
-	}
-	return false
-}
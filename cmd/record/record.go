@@ -0,0 +1,448 @@
+package record
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"tuya-ipc-terminal/cmd/rtsp"
+	"tuya-ipc-terminal/pkg/control"
+	"tuya-ipc-terminal/pkg/record"
+	"tuya-ipc-terminal/pkg/storage"
+)
+
+var storageManager *storage.StorageManager
+
+func SetStorageManager(sm *storage.StorageManager) {
+	storageManager = sm
+}
+
+func NewRecordCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "record",
+		Short: "Manage camera recordings",
+		Long: `Commands to record cameras continuously while the RTSP server is running.
+
+Recordings are fragmented MP4 segments in <dir>/<camera>/<hd|sd>/<date>/<time>.mp4
+and play in most players while they are still written.`,
+	}
+
+	cmd.AddCommand(newRecordStartCmd())
+	cmd.AddCommand(newRecordStopCmd())
+	cmd.AddCommand(newRecordListCmd())
+	cmd.AddCommand(newRecordPruneCmd())
+	cmd.AddCommand(newRecordClipCmd())
+
+	return cmd
+}
+
+func newRecordStartCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "start [camera]",
+		Short: "Start recording a camera",
+		Long: `Record a camera by RTSP path or device ID. The camera is recorded whenever the
+RTSP server runs, a running server starts recording immediately.
+
+Example:
+  tuya-ipc-terminal record start FrontDoor
+  tuya-ipc-terminal record start /Garden --sd`,
+		Args: cobra.ExactArgs(1),
+		RunE: runRecordStart,
+	}
+
+	cmd.Flags().Bool("sd", false, "Record the SD stream")
+	cmd.Flags().IntP("port", "p", 0, "Port of the running server (required if several are running)")
+
+	return cmd
+}
+
+func newRecordStopCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "stop [camera]",
+		Short: "Stop recording a camera",
+		Long:  "Stop recording a camera, existing recordings are kept.",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runRecordStop,
+	}
+
+	cmd.Flags().Bool("sd", false, "Stop recording the SD stream")
+	cmd.Flags().IntP("port", "p", 0, "Port of the running server (required if several are running)")
+
+	return cmd
+}
+
+func newRecordListCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "list [camera]",
+		Short: "List recorded cameras and segments",
+		Long:  "Display the recorded cameras with their state, or the segments of one camera.",
+		Args:  cobra.MaximumNArgs(1),
+		RunE:  runRecordList,
+	}
+
+	cmd.Flags().String("dir", "", "Recordings directory (default from the running server or <data dir>/recordings)")
+	cmd.Flags().Bool("sd", false, "List the segments of the SD stream")
+	cmd.Flags().IntP("port", "p", 0, "Port of the running server (required if several are running)")
+
+	return cmd
+}
+
+func newRecordClipCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "clip [camera]",
+		Short: "Record an event clip",
+		Long: `Write a clip of a camera with the pre-roll before and the post-roll after now.
+Triggering again while the clip runs extends it. Cameras started with
+--clip-camera have a pre-roll buffer, others start with the GOP cache.
+
+Example:
+  tuya-ipc-terminal record clip FrontDoor --reason doorbell`,
+		Args: cobra.ExactArgs(1),
+		RunE: runRecordClip,
+	}
+
+	cmd.Flags().Bool("sd", false, "Record the SD stream")
+	cmd.Flags().String("reason", "", "Reason passed to the clip hook (default manual)")
+	cmd.Flags().IntP("port", "p", 0, "Port of the running server (required if several are running)")
+
+	return cmd
+}
+
+func newRecordPruneCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Delete old recordings",
+		Long: `Delete recordings older than --max-age, then the oldest ones until the total
+size is below --max-size. Segments being written are kept.
+
+Example:
+  tuya-ipc-terminal record prune --max-age 72h
+  tuya-ipc-terminal record prune --max-size 50`,
+		RunE: runRecordPrune,
+	}
+
+	cmd.Flags().String("dir", "", "Recordings directory (default from the running server or <data dir>/recordings)")
+	cmd.Flags().Duration("max-age", 0, "Delete recordings older than this")
+	cmd.Flags().Float64("max-size", 0, "Total size of recordings in GiB")
+	cmd.Flags().IntP("port", "p", 0, "Port of the running server (required if several are running)")
+
+	return cmd
+}
+
+// findStoredCamera finds a camera by RTSP path (with or without "/") or device ID
+func findStoredCamera(cameraID string) (*storage.CameraInfo, error) {
+	cameras, err := storageManager.GetAllCameras()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cameras: %v", err)
+	}
+
+	for _, camera := range cameras {
+		if camera.DeviceID == cameraID || camera.RTSPPath == cameraID || camera.RTSPPath == "/"+cameraID {
+			return &camera, nil
+		}
+	}
+
+	return nil, fmt.Errorf("camera not found: %s", cameraID)
+}
+
+func recordResolution(cmd *cobra.Command) string {
+	if sd, _ := cmd.Flags().GetBool("sd"); sd {
+		return "sd"
+	}
+	return "hd"
+}
+
+// recordStatus returns the recorders of the running server, nil if it isn't running
+func recordStatus(port int) (*rtsp.RecordStatusResponse, error) {
+	socketPath, err := rtsp.FindControlSocket(port)
+	if errors.Is(err, control.ErrNotRunning) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var status rtsp.RecordStatusResponse
+	if err := control.Call(socketPath, "record-status", nil, &status); err != nil {
+		return nil, fmt.Errorf("failed to get recording status: %v", err)
+	}
+
+	return &status, nil
+}
+
+func runRecordStart(cmd *cobra.Command, args []string) error {
+	port, _ := cmd.Flags().GetInt("port")
+	resolution := recordResolution(cmd)
+
+	camera, err := findStoredCamera(args[0])
+	if err != nil {
+		return err
+	}
+
+	added, err := storageManager.AddRecordedCamera(camera.RTSPPath, resolution)
+	if err != nil {
+		return fmt.Errorf("failed to save recorded cameras: %v", err)
+	}
+
+	if added {
+		fmt.Printf("✓ Recording %s (%s)\n", camera.RTSPPath, resolution)
+	} else {
+		fmt.Printf("%s (%s) is already recorded\n", camera.RTSPPath, resolution)
+	}
+
+	socketPath, err := rtsp.FindControlSocket(port)
+	if errors.Is(err, control.ErrNotRunning) {
+		fmt.Println("RTSP server is not running, recording starts with 'rtsp start'")
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	call := rtsp.RecordArgs{Camera: camera.RTSPPath, Resolution: resolution}
+	if err := control.Call(socketPath, "record-start", call, nil); err != nil {
+		return fmt.Errorf("failed to start recording: %v", err)
+	}
+
+	return nil
+}
+
+func runRecordStop(cmd *cobra.Command, args []string) error {
+	port, _ := cmd.Flags().GetInt("port")
+	resolution := recordResolution(cmd)
+
+	// Removed cameras can still be recorded
+	cameraPath := "/" + strings.TrimPrefix(args[0], "/")
+	if camera, err := findStoredCamera(args[0]); err == nil {
+		cameraPath = camera.RTSPPath
+	}
+
+	removed, err := storageManager.RemoveRecordedCamera(cameraPath, resolution)
+	if err != nil {
+		return fmt.Errorf("failed to save recorded cameras: %v", err)
+	}
+
+	stopped := false
+	socketPath, err := rtsp.FindControlSocket(port)
+	if err == nil {
+		call := rtsp.RecordArgs{Camera: cameraPath, Resolution: resolution}
+		if err := control.Call(socketPath, "record-stop", call, &stopped); err != nil {
+			return fmt.Errorf("failed to stop recording: %v", err)
+		}
+	} else if !errors.Is(err, control.ErrNotRunning) {
+		return err
+	}
+
+	if !removed && !stopped {
+		fmt.Printf("%s (%s) is not recorded\n", cameraPath, resolution)
+		return nil
+	}
+
+	fmt.Printf("✓ Stopped recording %s (%s)\n", cameraPath, resolution)
+	return nil
+}
+
+func runRecordClip(cmd *cobra.Command, args []string) error {
+	port, _ := cmd.Flags().GetInt("port")
+	reason, _ := cmd.Flags().GetString("reason")
+
+	socketPath, err := rtsp.FindControlSocket(port)
+	if errors.Is(err, control.ErrNotRunning) {
+		return errors.New("RTSP server is not running")
+	} else if err != nil {
+		return err
+	}
+
+	var cameraPath string
+	call := rtsp.ClipArgs{Camera: args[0], Resolution: recordResolution(cmd), Reason: reason}
+	if err := control.Call(socketPath, "record-clip", call, &cameraPath); err != nil {
+		return fmt.Errorf("failed to trigger clip: %v", err)
+	}
+
+	fmt.Printf("✓ Clip of %s (%s) triggered\n", cameraPath, call.Resolution)
+	return nil
+}
+
+func runRecordList(cmd *cobra.Command, args []string) error {
+	port, _ := cmd.Flags().GetInt("port")
+	dir, _ := cmd.Flags().GetString("dir")
+
+	status, err := recordStatus(port)
+	if err != nil {
+		return err
+	}
+
+	if dir == "" {
+		dir = storageManager.GetRecordingsDir()
+		if status != nil {
+			dir = status.Dir
+		}
+	}
+
+	if len(args) == 1 {
+		return listCameraSegments(dir, args[0], recordResolution(cmd))
+	}
+
+	cameras, err := storageManager.GetRecordedCameras()
+	if err != nil {
+		return fmt.Errorf("failed to get recorded cameras: %v", err)
+	}
+
+	segments, err := record.ListSegments(dir)
+	if err != nil {
+		return err
+	}
+
+	if len(cameras) == 0 && len(segments) == 0 {
+		fmt.Println("No cameras are recorded.")
+		fmt.Println("Run 'tuya-ipc-terminal record start [camera]' to record one.")
+		return nil
+	}
+
+	running := make(map[string]record.Status)
+	if status != nil {
+		for _, recorder := range status.Recorders {
+			running[recorder.Path+"/"+recorder.Resolution] = recorder
+		}
+	}
+
+	fmt.Printf("Recordings in %s:\n", dir)
+	fmt.Println("==============")
+
+	for i, camera := range cameras {
+		state := "server not running"
+		if status != nil {
+			state = "not started"
+			if recorder, ok := running[camera.Path+"/"+camera.Resolution]; ok {
+				switch {
+				case recorder.Recording:
+					state = "recording"
+				case recorder.Error != "":
+					state = "waiting: " + recorder.Error
+				default:
+					state = "starting"
+				}
+			}
+		}
+
+		name := strings.TrimPrefix(camera.Path, "/")
+		count, size, last := summarizeSegments(segments, name, camera.Resolution)
+
+		fmt.Printf("%d. %s (%s) [%s]\n", i+1, camera.Path, camera.Resolution, state)
+		fmt.Printf("   Segments: %d, Size: %s", count, formatBytes(size))
+		if !last.IsZero() {
+			fmt.Printf(", Last: %s", last.Format("2006-01-02 15:04:05"))
+		}
+		fmt.Println()
+	}
+
+	var total int64
+	for _, segment := range segments {
+		total += segment.Size
+	}
+	fmt.Printf("\nTotal: %d segments, %s\n", len(segments), formatBytes(total))
+
+	return nil
+}
+
+func listCameraSegments(dir, cameraID, resolution string) error {
+	name := strings.TrimPrefix(cameraID, "/")
+	if camera, err := findStoredCamera(cameraID); err == nil {
+		name = strings.TrimPrefix(camera.RTSPPath, "/")
+	}
+
+	all, err := record.ListSegments(dir)
+	if err != nil {
+		return err
+	}
+
+	var segments []record.Segment
+	for _, segment := range all {
+		if segment.Camera == name && segment.Resolution == resolution {
+			segments = append(segments, segment)
+		}
+	}
+
+	if len(segments) == 0 {
+		fmt.Printf("No recordings of %s (%s).\n", name, resolution)
+		return nil
+	}
+
+	fmt.Printf("Recordings of %s (%s):\n", name, resolution)
+	for _, segment := range segments {
+		fmt.Printf("  %s  %8s  %s\n", segment.Start.Format("2006-01-02 15:04:05"), formatBytes(segment.Size), segment.File)
+	}
+
+	return nil
+}
+
+// summarizeSegments returns count, size and start of the last segment of a camera
+func summarizeSegments(segments []record.Segment, camera, resolution string) (int, int64, time.Time) {
+	var count int
+	var size int64
+	var last time.Time
+
+	for _, segment := range segments {
+		if segment.Camera != camera || segment.Resolution != resolution {
+			continue
+		}
+		count++
+		size += segment.Size
+		last = segment.Start
+	}
+
+	return count, size, last
+}
+
+func runRecordPrune(cmd *cobra.Command, args []string) error {
+	port, _ := cmd.Flags().GetInt("port")
+	dir, _ := cmd.Flags().GetString("dir")
+	maxAge, _ := cmd.Flags().GetDuration("max-age")
+	maxSize, _ := cmd.Flags().GetFloat64("max-size")
+
+	if maxAge <= 0 && maxSize <= 0 {
+		return errors.New("--max-age or --max-size is required")
+	}
+
+	status, err := recordStatus(port)
+	if err != nil {
+		return err
+	}
+
+	if dir == "" {
+		dir = storageManager.GetRecordingsDir()
+		if status != nil {
+			dir = status.Dir
+		}
+	}
+
+	keep := make(map[string]bool)
+	if status != nil {
+		for _, recorder := range status.Recorders {
+			if recorder.File != "" {
+				keep[recorder.File] = true
+			}
+		}
+	}
+
+	result, err := record.Prune(dir, maxAge, int64(maxSize*(1<<30)), keep)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("✓ Deleted %d segments (%s)\n", result.Files, formatBytes(result.Bytes))
+	return nil
+}
+
+func formatBytes(size int64) string {
+	switch {
+	case size >= 1<<30:
+		return fmt.Sprintf("%.1f GiB", float64(size)/(1<<30))
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", float64(size)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", size)
+}
@@ -1,17 +1,28 @@
 package cmd
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 
 	"tuya-ipc-terminal/cmd/auth"
 	"tuya-ipc-terminal/cmd/cameras"
+	"tuya-ipc-terminal/cmd/record"
 	"tuya-ipc-terminal/cmd/rtsp"
+	"tuya-ipc-terminal/pkg/config"
+	"tuya-ipc-terminal/pkg/core"
 	"tuya-ipc-terminal/pkg/storage"
 
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 )
 
+// Environment variable with the passphrase that encrypts sessions
+const storageKeyEnv = "TUYA_IPC_STORAGE_KEY"
+
 var (
 	storageManager *storage.StorageManager
 )
@@ -30,7 +41,8 @@ Examples:
   tuya-ipc-terminal auth list
   tuya-ipc-terminal auth add eu-central user@example.com
   tuya-ipc-terminal cameras refresh
-  tuya-ipc-terminal rtsp start --port 8554`,
+  tuya-ipc-terminal rtsp start --port 8554
+  tuya-ipc-terminal record start FrontDoor`,
 }
 
 func Execute(version string) error {
@@ -38,27 +50,135 @@ func Execute(version string) error {
 	return rootCmd.Execute()
 }
 
+// ExitCode returns the exit code of a failed command
+func ExitCode(err error) int {
+	var exitErr *auth.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.Code
+	}
+	return 1
+}
+
 func init() {
 	cobra.OnInitialize(initConfig)
 
+	rootCmd.PersistentFlags().String("config", "", "Config file (default $"+config.ConfigEnv+" or "+config.DefaultPath()+")")
+	rootCmd.PersistentFlags().String("data-dir", "", "Data directory (default $"+config.DataDirEnv+", ./.tuya-data if it exists or the user data directory)")
+	rootCmd.PersistentFlags().String("log-level", "", "Log level: trace, debug, info, warn or error (default trace)")
+	rootCmd.PersistentFlags().String("log-format", "", "Log format: console or json (default console)")
+	rootCmd.PersistentFlags().String("storage-key-file", "", "Encrypt sessions with the passphrase or key in this file")
+	rootCmd.PersistentFlags().Bool("storage-passphrase", false, "Prompt for the passphrase that encrypts sessions, read it from stdin if that isn't a terminal")
+
 	// Add subcommands
 	rootCmd.AddCommand(auth.NewAuthCmd())
 	rootCmd.AddCommand(cameras.NewCamerasCmd())
 	rootCmd.AddCommand(rtsp.NewRTSPCmd())
+	rootCmd.AddCommand(record.NewRecordCmd())
 }
 
 func initConfig() {
-	var err error
-	storageManager, err = storage.NewStorageManager()
+	configPath, _ := rootCmd.PersistentFlags().GetString("config")
+	cfg, err := config.Load(configPath)
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	logLevel, _ := rootCmd.PersistentFlags().GetString("log-level")
+	logFormat, _ := rootCmd.PersistentFlags().GetString("log-format")
+	if logLevel == "" {
+		logLevel = cfg.Log.Level
+	}
+	if logFormat == "" {
+		logFormat = cfg.Log.Format
+	}
+	if err := core.ConfigureLogger(logLevel, logFormat); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	dataDirFlag, _ := rootCmd.PersistentFlags().GetString("data-dir")
+	dataDir, err := cfg.ResolveDataDir(dataDirFlag)
+	if err != nil {
+		fmt.Printf("Failed to find the data directory: %v\n", err)
+		os.Exit(1)
+	}
+
+	storageManager, err = storage.NewStorageManager(dataDir)
 	if err != nil {
-		fmt.Println("Failed to initialize storage")
+		fmt.Printf("Failed to initialize storage in %s: %v\n", dataDir, err)
 		os.Exit(1)
 	}
+	storageManager.SetCameraOverrides(cfg.CameraOverrides())
+	storageManager.SetPathTemplate(cfg.PathTemplate)
+
+	secret, err := storageKey()
+	if err != nil {
+		fmt.Printf("Failed to read storage key: %v\n", err)
+		os.Exit(1)
+	}
+	if secret != nil {
+		if err := storageManager.EnableEncryption(secret); err != nil {
+			fmt.Printf("Failed to enable storage encryption: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if prompt, _ := rootCmd.PersistentFlags().GetBool("storage-passphrase"); prompt {
+		// A background server can't prompt, it gets the passphrase on stdin
+		rtsp.SetStoragePassphrase(secret)
+	}
 
 	// Make storage manager available to subcommands
 	auth.SetStorageManager(storageManager)
 	cameras.SetStorageManager(storageManager)
+	record.SetStorageManager(storageManager)
 	rtsp.SetStorageManager(storageManager)
+	rtsp.SetConfig(cfg)
+}
+
+// storageKey returns the secret of the key file, the prompt or the
+// environment, nil if sessions aren't encrypted
+func storageKey() ([]byte, error) {
+	if file, _ := rootCmd.PersistentFlags().GetString("storage-key-file"); file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.TrimRight(data, "\r\n"), nil
+	}
+
+	if prompt, _ := rootCmd.PersistentFlags().GetBool("storage-passphrase"); prompt {
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			return readPassphrase(os.Stdin)
+		}
+
+		fmt.Fprint(os.Stderr, "Storage passphrase: ")
+		passphrase, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		return passphrase, err
+	}
+
+	if value := os.Getenv(storageKeyEnv); value != "" {
+		return []byte(value), nil
+	}
+
+	return nil, nil
+}
+
+// readPassphrase reads the first line of a pipe
+func readPassphrase(file *os.File) ([]byte, error) {
+	line, err := bufio.NewReader(file).ReadBytes('\n')
+	line = bytes.TrimRight(line, "\r\n")
+	if len(line) == 0 {
+		if err == nil || errors.Is(err, io.EOF) {
+			err = errors.New("no passphrase on stdin")
+		}
+		return nil, err
+	}
+	return line, nil
 }
 
 func GetStorageManager() *storage.StorageManager {
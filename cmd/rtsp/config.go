@@ -0,0 +1,122 @@
+package rtsp
+
+import (
+	"strings"
+
+	"tuya-ipc-terminal/pkg/config"
+	"tuya-ipc-terminal/pkg/core"
+	"tuya-ipc-terminal/pkg/rtsp"
+)
+
+var appConfig = &config.Config{}
+
+func SetConfig(c *config.Config) {
+	appConfig = c
+}
+
+// startFlags maps the flags of 'rtsp start' to their config values
+func startFlags(c *config.Config) map[string]any {
+	return map[string]any{
+		"port":                   c.RTSP.Port,
+		"bind":                   c.RTSP.Bind,
+		"auth":                   c.RTSP.Auth,
+		"auth-basic":             c.RTSP.AuthBasic,
+		"describe-timeout":       c.RTSP.DescribeTimeout,
+		"gop-cache-size":         c.RTSP.GOPCacheSize,
+		"gop-cache-camera":       c.CameraGOPCacheSizes(),
+		"api-listen":             c.API.Listen,
+		"api-token":              c.API.Token,
+		"metrics-listen":         c.Metrics.Listen,
+		"http-listen":            c.HTTP.Listen,
+		"hls-low-latency":        c.HTTP.HLSLowLatency,
+		"webrtc-listen":          c.WebRTC.Listen,
+		"webrtc-candidate":       c.WebRTC.Candidates,
+		"webrtc-interface":       c.WebRTC.Interfaces,
+		"webrtc-ip":              c.WebRTC.IPs,
+		"webrtc-udp-ports":       c.WebRTC.UDPPorts,
+		"record-dir":             c.Record.Dir,
+		"record-segment":         c.Record.Segment,
+		"record-max-age":         c.Record.MaxAge,
+		"record-max-size":        c.Record.MaxSize,
+		"clip-dir":               c.Clips.Dir,
+		"clip-camera":            c.Clips.Cameras,
+		"clip-pre-roll":          c.Clips.PreRoll,
+		"clip-post-roll":         c.Clips.PostRoll,
+		"clip-max-duration":      c.Clips.MaxDuration,
+		"clip-hook":              c.Clips.Hook,
+		"clip-on-event":          c.Clips.OnEvent,
+		"webhook":                c.Events.Webhooks,
+		"session-check-interval": c.Session.CheckInterval,
+		"session-warn-before":    c.Session.WarnBefore,
+		"ha-broker":              c.HomeAssistant.Broker,
+		"ha-username":            c.HomeAssistant.Username,
+		"ha-password":            c.HomeAssistant.Password,
+		"ha-discovery-prefix":    c.HomeAssistant.DiscoveryPrefix,
+		"ha-topic-prefix":        c.HomeAssistant.TopicPrefix,
+		"ha-rtsp-host":           c.HomeAssistant.RTSPHost,
+		"ha-privacy":             c.HomeAssistant.Privacy,
+		"ha-snapshot-interval":   c.HomeAssistant.SnapshotInterval,
+		"ha-ffmpeg":              c.HomeAssistant.FFmpeg,
+		"onvif-listen":           c.ONVIF.Listen,
+	}
+}
+
+// parseCameraResolution splits a camera like FrontDoor/sd, without a
+// resolution the camera's default is used
+func parseCameraResolution(camera string) (string, string) {
+	cameraPath, resolution := rtsp.ParseCameraPath(camera)
+	if resolution != "" {
+		return cameraPath, resolution
+	}
+
+	if cameras, err := storageManager.GetAllCameras(); err == nil {
+		for _, info := range cameras {
+			if info.RTSPPath == "/"+strings.TrimPrefix(cameraPath, "/") && info.Resolution != "" {
+				return cameraPath, info.Resolution
+			}
+		}
+	}
+
+	return cameraPath, "hd"
+}
+
+// warnUnknownCameras logs camera settings of the config that match no camera
+func warnUnknownCameras() {
+	registry, err := storageManager.GetCameraRegistry()
+	if err != nil {
+		return
+	}
+
+	for name := range appConfig.Cameras {
+		found := false
+		for _, camera := range registry.Cameras {
+			if name == camera.DeviceID || name == camera.DeviceName || "/"+strings.TrimPrefix(name, "/") == camera.RTSPPath {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			core.Logger.Warn().Msgf("Config camera %q matches no camera, use a device ID, RTSP path or name", name)
+		}
+	}
+}
+
+// warnDuplicatePaths logs cameras that can't be reached because another one
+// has the same path, paths of the config may collide with stored ones
+func warnDuplicatePaths() {
+	cameras, err := storageManager.GetAllCameras()
+	if err != nil {
+		return
+	}
+
+	served := make(map[string]string)
+	for _, camera := range cameras {
+		if other, exists := served[camera.RTSPPath]; exists {
+			core.Logger.Warn().Msgf("%s has the path %s of %s and can't be reached, run 'cameras refresh' to give it another one",
+				camera.DeviceName, camera.RTSPPath, other)
+			continue
+		}
+		served[camera.RTSPPath] = camera.DeviceName
+	}
+}
@@ -0,0 +1,214 @@
+package rtsp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"tuya-ipc-terminal/pkg/control"
+	"tuya-ipc-terminal/pkg/record"
+	"tuya-ipc-terminal/pkg/rtsp"
+	"tuya-ipc-terminal/pkg/session"
+)
+
+// Set in the environment of the re-executed daemon process
+const daemonEnv = "TUYA_IPC_TERMINAL_DAEMON"
+
+const daemonStartTimeout = 15 * time.Second
+
+// statusResponse is the result of the "status" control command
+type statusResponse struct {
+	PID       int               `json:"pid"`
+	Version   string            `json:"version"`
+	StartedAt time.Time         `json:"startedAt"`
+	Stats     rtsp.ServerStats  `json:"stats"`
+	Streams   []rtsp.StreamInfo `json:"streams"`
+	Sessions  []session.Status  `json:"sessions"`
+}
+
+func controlSocketPath(port int) string {
+	return filepath.Join(storageManager.GetDataDir(), fmt.Sprintf("rtsp-%d.sock", port))
+}
+
+func pidFilePath(port int) string {
+	return filepath.Join(storageManager.GetDataDir(), fmt.Sprintf("rtsp-%d.pid", port))
+}
+
+func logFilePath(port int) string {
+	return filepath.Join(storageManager.GetDataDir(), fmt.Sprintf("rtsp-%d.log", port))
+}
+
+// FindControlSocket returns the control socket of a running server. Port 0
+// selects the only running server of this data directory.
+func FindControlSocket(port int) (string, error) {
+	if port != 0 {
+		path := controlSocketPath(port)
+		if err := control.Call(path, "ping", nil, nil); err != nil {
+			return "", err
+		}
+		return path, nil
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(storageManager.GetDataDir(), "rtsp-*.sock"))
+
+	var running []string
+	for _, path := range matches {
+		if control.Call(path, "ping", nil, nil) == nil {
+			running = append(running, path)
+		}
+	}
+
+	switch len(running) {
+	case 0:
+		return "", control.ErrNotRunning
+	case 1:
+		return running[0], nil
+	default:
+		return "", errors.New("multiple RTSP servers are running, select one with --port")
+	}
+}
+
+// spawnDaemon starts this command again detached from the terminal and waits
+// until the new process answers on its control socket
+func spawnDaemon(port int) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find executable: %v", err)
+	}
+
+	logPath := logFilePath(port)
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %v", err)
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), daemonEnv+"=1")
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = daemonSysProcAttr()
+
+	// The daemon has no terminal to prompt for the passphrase, it reads it
+	// from the stdin pipe instead
+	if storagePassphrase != nil {
+		cmd.Stdin = bytes.NewReader(append(append([]byte(nil), storagePassphrase...), '\n'))
+	}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start daemon: %v", err)
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+	deadline := time.After(daemonStartTimeout)
+
+	socketPath := controlSocketPath(port)
+
+	for {
+		select {
+		case err := <-exited:
+			return fmt.Errorf("daemon exited during startup (%v), see %s", err, logPath)
+		case <-deadline:
+			return fmt.Errorf("daemon did not come up within %v, see %s", daemonStartTimeout, logPath)
+		case <-ticker.C:
+			if control.Call(socketPath, "ping", nil, nil) == nil {
+				fmt.Printf("✓ RTSP server started in background (PID %d, port %d)\n", cmd.Process.Pid, port)
+				fmt.Printf("Logs: %s\n", logPath)
+				return nil
+			}
+		}
+	}
+}
+
+// serveControl exposes the running server on its control socket and writes the
+// PID file. stop is closed when a client requests shutdown.
+func serveControl(server *rtsp.RTSPServer, recordManager *record.Manager, sessionKeeper *session.Keeper, version string, stop chan struct{}) (func(), error) {
+	port := server.GetPort()
+	startedAt := time.Now()
+
+	controlServer := control.NewServer(controlSocketPath(port))
+
+	controlServer.Handle("status", func(json.RawMessage) (any, error) {
+		return statusResponse{
+			PID:       os.Getpid(),
+			Version:   version,
+			StartedAt: startedAt,
+			Stats:     server.GetStats(),
+			Streams:   server.GetStreams(),
+			Sessions:  sessionKeeper.Status(),
+		}, nil
+	})
+
+	controlServer.Handle("streams", func(json.RawMessage) (any, error) {
+		return server.GetStreams(), nil
+	})
+
+	controlServer.Handle("clients", func(json.RawMessage) (any, error) {
+		return server.GetClients(), nil
+	})
+
+	handleRecord(controlServer, recordManager)
+
+	var stopOnce sync.Once
+	controlServer.Handle("stop", func(json.RawMessage) (any, error) {
+		stopOnce.Do(func() { close(stop) })
+		return nil, nil
+	})
+
+	if err := controlServer.Listen(); err != nil {
+		return nil, err
+	}
+
+	pidPath := pidFilePath(port)
+	if err := os.WriteFile(pidPath, []byte(strconv.Itoa(os.Getpid())+"\n"), 0600); err != nil {
+		controlServer.Close()
+		return nil, fmt.Errorf("failed to write PID file: %v", err)
+	}
+
+	cleanup := func() {
+		controlServer.Close()
+		os.Remove(pidPath)
+	}
+
+	return cleanup, nil
+}
+
+// signalFromPIDFile stops a server that doesn't answer on its control socket
+func signalFromPIDFile(port int) error {
+	data, err := os.ReadFile(pidFilePath(port))
+	if err != nil {
+		return control.ErrNotRunning
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return fmt.Errorf("invalid PID file: %v", err)
+	}
+
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return control.ErrNotRunning
+	}
+
+	if err := process.Signal(syscall.SIGTERM); err != nil {
+		os.Remove(pidFilePath(port))
+		return control.ErrNotRunning
+	}
+
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !windows
+
+package rtsp
+
+import "syscall"
+
+func daemonSysProcAttr() *syscall.SysProcAttr {
+	// New session, detached from the controlling terminal
+	return &syscall.SysProcAttr{Setsid: true}
+}
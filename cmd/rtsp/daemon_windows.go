@@ -0,0 +1,11 @@
+//go:build windows
+
+package rtsp
+
+import "syscall"
+
+const detachedProcess = 0x00000008
+
+func daemonSysProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{CreationFlags: detachedProcess | syscall.CREATE_NEW_PROCESS_GROUP}
+}
@@ -0,0 +1,104 @@
+package rtsp
+
+import (
+	"fmt"
+	"strings"
+
+	"tuya-ipc-terminal/pkg/core"
+	"tuya-ipc-terminal/pkg/events"
+	"tuya-ipc-terminal/pkg/record"
+	"tuya-ipc-terminal/pkg/tuya"
+)
+
+type eventsConfig struct {
+	Webhooks    []string // "[type,type=]url"
+	ClipOnEvent []string // Event types that trigger clips
+	ClipCameras []string // Pre-roll cameras, their resolution is used for clips
+	Publish     bool     // Other consumers subscribe to the returned bus
+}
+
+// startEvents listens to camera events if webhooks, clips or other consumers
+// use them, the bus is nil otherwise
+func startEvents(config eventsConfig, recordManager *record.Manager) (*events.Bus, func(), error) {
+	if len(config.Webhooks) == 0 && len(config.ClipOnEvent) == 0 && !config.Publish {
+		return nil, func() {}, nil
+	}
+
+	webhooks := make([]events.Webhook, 0, len(config.Webhooks))
+	for _, value := range config.Webhooks {
+		webhook, err := events.ParseWebhook(value)
+		if err != nil {
+			return nil, nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+
+	clipTypes := make([]tuya.EventType, 0, len(config.ClipOnEvent))
+	for _, value := range config.ClipOnEvent {
+		eventType, err := tuya.ParseEventType(value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid --clip-on-event: %v", err)
+		}
+		clipTypes = append(clipTypes, eventType)
+	}
+
+	bus := events.NewBus()
+	dispatcher := events.NewDispatcher(bus, webhooks)
+
+	var clipSubscription *events.Subscription
+	if len(clipTypes) > 0 {
+		clipSubscription = bus.Subscribe(clipTypes...)
+		go clipOnEvents(clipSubscription, recordManager, clipResolutions(config.ClipCameras))
+	}
+
+	listener := events.NewListener(storageManager, bus)
+	if err := listener.Start(); err != nil {
+		dispatcher.Close()
+		if clipSubscription != nil {
+			clipSubscription.Close()
+		}
+		return nil, nil, fmt.Errorf("failed to listen to events: %v", err)
+	}
+
+	return bus, func() {
+		listener.Close()
+		dispatcher.Close()
+		if clipSubscription != nil {
+			clipSubscription.Close()
+		}
+	}, nil
+}
+
+// clipOnEvents clips the camera of every event, with the pre-roll if the
+// camera keeps one
+func clipOnEvents(subscription *events.Subscription, recordManager *record.Manager, resolutions map[string]string) {
+	for event := range subscription.C {
+		if event.Camera == "" {
+			continue
+		}
+
+		resolution := resolutions[event.Camera]
+		if resolution == "" {
+			resolution = "hd"
+		}
+
+		if _, err := recordManager.Clip(event.Camera, resolution, string(event.Type)); err != nil {
+			core.Logger.Error().Err(err).Msgf("Failed to clip %s event of %s", event.Type, event.CameraName)
+		}
+	}
+}
+
+// clipResolutions maps the RTSP paths of --clip-camera to their resolution,
+// HD wins if both are buffered
+func clipResolutions(clipCameras []string) map[string]string {
+	resolutions := make(map[string]string, len(clipCameras))
+	for _, camera := range clipCameras {
+		cameraPath, resolution := parseCameraResolution(camera)
+		cameraPath = "/" + strings.TrimPrefix(cameraPath, "/")
+
+		if resolutions[cameraPath] != "hd" {
+			resolutions[cameraPath] = resolution
+		}
+	}
+	return resolutions
+}
@@ -0,0 +1,84 @@
+package rtsp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"tuya-ipc-terminal/pkg/core"
+	"tuya-ipc-terminal/pkg/hls"
+	"tuya-ipc-terminal/pkg/rtsp"
+	"tuya-ipc-terminal/pkg/webrtc"
+	"tuya-ipc-terminal/pkg/whep"
+)
+
+// parsePortRange parses "min-max" into the two ports
+func parsePortRange(value string) ([]uint16, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	minValue, maxValue, found := strings.Cut(value, "-")
+	minPort, err1 := strconv.ParseUint(minValue, 10, 16)
+	maxPort, err2 := strconv.ParseUint(maxValue, 10, 16)
+	if !found || err1 != nil || err2 != nil || minPort > maxPort {
+		return nil, fmt.Errorf("invalid port range: %s", value)
+	}
+
+	return []uint16{uint16(minPort), uint16(maxPort)}, nil
+}
+
+type mediaConfig struct {
+	Listen        string
+	HLSLowLatency bool
+
+	// WebRTCListen is the shared ICE UDP/TCP port, random ports are used when empty
+	WebRTCListen  string
+	WebRTCFilters webrtc.Filters
+}
+
+// serveMedia serves the HTTP media endpoints (HLS, WHEP) next to the RTSP server
+func serveMedia(config mediaConfig, rtspServer *rtsp.RTSPServer) (func(), error) {
+	listener, err := net.Listen("tcp", config.Listen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %v", config.Listen, err)
+	}
+
+	api, err := webrtc.NewServerAPI("", config.WebRTCListen, &config.WebRTCFilters)
+	if err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to create WebRTC API: %v", err)
+	}
+
+	candidates := config.WebRTCFilters.Candidates
+	whepServer := whep.NewServer(rtspServer, whep.Config{API: api, Candidates: candidates})
+	hlsServer := hls.NewServer(rtspServer, hls.Config{LowLatency: config.HLSLowLatency})
+
+	mux := http.NewServeMux()
+	mux.Handle("/hls/", hlsServer)
+	mux.Handle("/whep/", whepServer)
+
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	core.Logger.Info().Msgf("HLS available on http://%s/hls/<camera>/index.m3u8", listener.Addr())
+	core.Logger.Info().Msgf("WHEP available on http://%s/whep/<camera>", listener.Addr())
+
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			core.Logger.Error().Err(err).Msg("Media server failed")
+		}
+	}()
+
+	return func() {
+		server.Close()
+		hlsServer.Close()
+		whepServer.Close()
+	}, nil
+}
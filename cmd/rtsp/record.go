@@ -0,0 +1,116 @@
+package rtsp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"tuya-ipc-terminal/pkg/control"
+	"tuya-ipc-terminal/pkg/core"
+	"tuya-ipc-terminal/pkg/record"
+)
+
+const clipHookTimeout = time.Minute
+
+// RecordArgs are the arguments of the "record-start" and "record-stop" control commands
+type RecordArgs struct {
+	Camera     string `json:"camera"`
+	Resolution string `json:"resolution"`
+}
+
+// ClipArgs are the arguments of the "record-clip" control command
+type ClipArgs struct {
+	Camera     string `json:"camera"`
+	Resolution string `json:"resolution"`
+	Reason     string `json:"reason"`
+}
+
+// RecordStatusResponse is the result of the "record-status" control command
+type RecordStatusResponse struct {
+	Dir       string          `json:"dir"`
+	Recorders []record.Status `json:"recorders"`
+}
+
+// handleRecord adds the recording commands to the control socket
+func handleRecord(controlServer *control.Server, recordManager *record.Manager) {
+	controlServer.Handle("record-start", func(raw json.RawMessage) (any, error) {
+		var args RecordArgs
+		if err := json.Unmarshal(raw, &args); err != nil {
+			return nil, err
+		}
+		return recordManager.Start(args.Camera, args.Resolution)
+	})
+
+	controlServer.Handle("record-stop", func(raw json.RawMessage) (any, error) {
+		var args RecordArgs
+		if err := json.Unmarshal(raw, &args); err != nil {
+			return nil, err
+		}
+		return recordManager.Stop(args.Camera, args.Resolution), nil
+	})
+
+	controlServer.Handle("record-clip", func(raw json.RawMessage) (any, error) {
+		var args ClipArgs
+		if err := json.Unmarshal(raw, &args); err != nil {
+			return nil, err
+		}
+		return recordManager.Clip(args.Camera, args.Resolution, args.Reason)
+	})
+
+	controlServer.Handle("record-status", func(json.RawMessage) (any, error) {
+		return RecordStatusResponse{Dir: recordManager.Dir(), Recorders: recordManager.Status()}, nil
+	})
+}
+
+// startRecordings starts the recorders of the configured cameras and the
+// pre-roll buffers of clipCameras ("<camera>[/sd]")
+func startRecordings(recordManager *record.Manager, clipCameras []string) {
+	cameras, err := storageManager.GetRecordedCameras()
+	if err != nil {
+		core.Logger.Error().Err(err).Msg("Failed to load recorded cameras")
+	}
+
+	for _, camera := range cameras {
+		if _, err := recordManager.Start(camera.Path, camera.Resolution); err != nil {
+			core.Logger.Error().Err(err).Msgf("Failed to start recording %s", camera.Path)
+		}
+	}
+
+	for _, camera := range clipCameras {
+		cameraPath, resolution := parseCameraResolution(camera)
+		if _, err := recordManager.KeepPreRoll(cameraPath, resolution); err != nil {
+			core.Logger.Error().Err(err).Msgf("Failed to keep pre-roll of %s", camera)
+		}
+	}
+}
+
+// clipHookFunc runs command for every finished clip with the file as argument,
+// the clip is also passed in TUYA_CLIP_* environment variables
+func clipHookFunc(command string) func(clip record.Clip) {
+	if command == "" {
+		return nil
+	}
+
+	return func(clip record.Clip) {
+		ctx, cancel := context.WithTimeout(context.Background(), clipHookTimeout)
+		defer cancel()
+
+		cmd := exec.CommandContext(ctx, command, clip.File)
+		cmd.Env = append(os.Environ(),
+			"TUYA_CLIP_FILE="+clip.File,
+			"TUYA_CLIP_CAMERA="+clip.Camera,
+			"TUYA_CLIP_RESOLUTION="+clip.Resolution,
+			"TUYA_CLIP_REASON="+clip.Reason,
+			"TUYA_CLIP_START="+clip.Start.Format(time.RFC3339),
+			fmt.Sprintf("TUYA_CLIP_DURATION=%d", int(clip.Duration.Seconds())),
+		)
+
+		if output, err := cmd.CombinedOutput(); err != nil {
+			core.Logger.Error().Err(err).Msgf("Clip hook failed for %s: %s", clip.File, strings.TrimSpace(string(output)))
+		}
+	}
+}
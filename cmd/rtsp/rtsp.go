@@ -10,18 +10,40 @@ import (
 
 	"github.com/spf13/cobra"
 
+	"tuya-ipc-terminal/pkg/api"
+	"tuya-ipc-terminal/pkg/config"
+	"tuya-ipc-terminal/pkg/control"
 	"tuya-ipc-terminal/pkg/core"
+	"tuya-ipc-terminal/pkg/homeassistant"
+	"tuya-ipc-terminal/pkg/metrics"
+	"tuya-ipc-terminal/pkg/onvif"
+	"tuya-ipc-terminal/pkg/record"
 	"tuya-ipc-terminal/pkg/rtsp"
+	"tuya-ipc-terminal/pkg/session"
+	"tuya-ipc-terminal/pkg/snapshot"
 	"tuya-ipc-terminal/pkg/storage"
+	"tuya-ipc-terminal/pkg/webrtc"
 )
 
-var storageManager *storage.StorageManager
-var rtspServer *rtsp.RTSPServer
+var (
+	storageManager    *storage.StorageManager
+	storagePassphrase []byte
+)
+
+const (
+	apiTokenEnv   = "TUYA_IPC_API_TOKEN"
+	haPasswordEnv = "TUYA_IPC_HA_PASSWORD"
+)
 
 func SetStorageManager(sm *storage.StorageManager) {
 	storageManager = sm
 }
 
+// SetStoragePassphrase passes the prompted passphrase on to the daemon
+func SetStoragePassphrase(passphrase []byte) {
+	storagePassphrase = passphrase
+}
+
 func NewRTSPCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "rtsp",
@@ -32,7 +54,9 @@ func NewRTSPCmd() *cobra.Command {
 	cmd.AddCommand(newStartCmd())
 	cmd.AddCommand(newStopCmd())
 	cmd.AddCommand(newStatusCmd())
+	cmd.AddCommand(newClientsCmd())
 	cmd.AddCommand(newListEndpointsCmd())
+	cmd.AddCommand(newUsersCmd())
 
 	return cmd
 }
@@ -46,27 +70,88 @@ func newStartCmd() *cobra.Command {
 	}
 
 	cmd.Flags().IntP("port", "p", 8554, "RTSP server port")
+	cmd.Flags().String("bind", "", "Address the RTSP server listens on, e.g. 127.0.0.1 (default all)")
 	cmd.Flags().BoolP("daemon", "d", false, "Run as daemon (background)")
+	cmd.Flags().Bool("auth", false, "Require RTSP authentication (see 'rtsp users')")
+	cmd.Flags().Bool("auth-basic", false, "Also accept Basic authentication (credentials are sent in clear text)")
+	cmd.Flags().Duration("describe-timeout", rtsp.DefaultDescribeTimeout, "How long DESCRIBE waits for the camera's first keyframe")
+	cmd.Flags().Int("gop-cache-size", rtsp.DefaultGOPCacheSize>>20, "GOP cache per stream in MiB, replayed to new clients (0 disables)")
+	cmd.Flags().StringToInt("gop-cache-camera", nil, "GOP cache size in MiB for single cameras by RTSP path, device ID or name, e.g. /FrontDoor=0")
+	cmd.Flags().String("api-listen", "", "Serve the HTTP management API on this address, e.g. 127.0.0.1:8080")
+	cmd.Flags().String("api-token", "", "Bearer token for the HTTP API (default $"+apiTokenEnv+")")
+	cmd.Flags().String("metrics-listen", "", "Serve Prometheus metrics on /metrics of this address, e.g. :9090")
+	cmd.Flags().String("http-listen", "", "Serve HLS and WebRTC (WHEP) on this address, e.g. :8888")
+	cmd.Flags().Bool("hls-low-latency", false, "Serve Low-Latency HLS with partial segments")
+	cmd.Flags().String("webrtc-listen", "", "Fixed ICE UDP/TCP port for WebRTC viewers, e.g. :8555 (default random ports)")
+	cmd.Flags().StringSlice("webrtc-candidate", nil, "Extra ICE candidate announced to WebRTC viewers, e.g. 203.0.113.5:8555 or stun:8555")
+	cmd.Flags().StringSlice("webrtc-interface", nil, "Network interfaces used for WebRTC candidates (default all)")
+	cmd.Flags().StringSlice("webrtc-ip", nil, "IP addresses used for WebRTC candidates (default all)")
+	cmd.Flags().String("webrtc-udp-ports", "", "UDP port range for WebRTC without --webrtc-listen, e.g. 50000-50100")
+	cmd.Flags().String("record-dir", "", "Directory for recordings (default <data dir>/recordings)")
+	cmd.Flags().Duration("record-segment", record.DefaultSegmentDuration, "Length of recorded segments")
+	cmd.Flags().Duration("record-max-age", 0, "Delete recordings older than this, e.g. 168h (0 keeps them)")
+	cmd.Flags().Float64("record-max-size", 0, "Total size of recordings in GiB, the oldest are deleted first (0 is unlimited)")
+	cmd.Flags().String("clip-dir", "", "Directory for event clips (default <data dir>/clips)")
+	cmd.Flags().StringSlice("clip-camera", nil, "Keep a pre-roll buffer for clips of this camera, e.g. FrontDoor or FrontDoor/sd")
+	cmd.Flags().Duration("clip-pre-roll", record.DefaultPreRoll, "Video kept before a clip trigger")
+	cmd.Flags().Duration("clip-post-roll", record.DefaultPostRoll, "Video recorded after the last clip trigger")
+	cmd.Flags().Duration("clip-max-duration", record.DefaultMaxClipDuration, "Maximum length of a clip extended by triggers")
+	cmd.Flags().String("clip-hook", "", "Command run for every finished clip with the file path as argument")
+	cmd.Flags().StringArray("webhook", nil, "POST camera events as JSON to this URL, optionally only some types: motion,person=https://...")
+	cmd.Flags().StringSlice("clip-on-event", nil, "Event types that trigger a clip of the camera: motion, person, doorbell, tamper")
+	cmd.Flags().Duration("session-check-interval", session.DefaultCheckInterval, "How often the Tuya sessions are used to keep them alive and checked")
+	cmd.Flags().Duration("session-warn-before", session.DefaultWarnBefore, "Warn this long before a session's cookies expire")
+	cmd.Flags().String("ha-broker", "", "Publish cameras with Home Assistant MQTT discovery to this broker, e.g. tcp://localhost:1883")
+	cmd.Flags().String("ha-username", "", "Username for the Home Assistant MQTT broker")
+	cmd.Flags().String("ha-password", "", "Password for the Home Assistant MQTT broker (default $"+haPasswordEnv+")")
+	cmd.Flags().String("ha-discovery-prefix", homeassistant.DefaultDiscoveryPrefix, "Home Assistant discovery topic prefix")
+	cmd.Flags().String("ha-topic-prefix", homeassistant.DefaultTopicPrefix, "Topic prefix for camera state and commands")
+	cmd.Flags().String("ha-rtsp-host", "", "Host in the stream URLs announced to Home Assistant (default hostname)")
+	cmd.Flags().Bool("ha-privacy", false, "Add privacy mode switches, for cameras that have one")
+	cmd.Flags().Duration("ha-snapshot-interval", 0, "Add camera entities with a snapshot taken this often and after events, wakes idle cameras (default off)")
+	cmd.Flags().String("ha-ffmpeg", snapshot.DefaultFFmpeg, "FFmpeg command that decodes the snapshots")
+	cmd.Flags().String("onvif-listen", "", "Serve every camera as an ONVIF device with WS-Discovery on this address, e.g. :8000")
 
 	return cmd
 }
 
 func newStopCmd() *cobra.Command {
-	return &cobra.Command{
+	cmd := &cobra.Command{
 		Use:   "stop",
 		Short: "Stop RTSP server",
 		Long:  "Stop the running RTSP server.",
 		RunE:  runStopServer,
 	}
+
+	cmd.Flags().IntP("port", "p", 0, "Port of the server to stop (required if several are running)")
+
+	return cmd
 }
 
 func newStatusCmd() *cobra.Command {
-	return &cobra.Command{
+	cmd := &cobra.Command{
 		Use:   "status",
 		Short: "Show RTSP server status",
 		Long:  "Display current status and statistics of the RTSP server.",
 		RunE:  runServerStatus,
 	}
+
+	cmd.Flags().IntP("port", "p", 0, "Port of the server to query (required if several are running)")
+
+	return cmd
+}
+
+func newClientsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "clients",
+		Short: "List connected RTSP clients",
+		Long:  "Display the sessions connected to the running RTSP server.",
+		RunE:  runListClients,
+	}
+
+	cmd.Flags().IntP("port", "p", 0, "Port of the server to query (required if several are running)")
+
+	return cmd
 }
 
 func newListEndpointsCmd() *cobra.Command {
@@ -78,13 +163,89 @@ func newListEndpointsCmd() *cobra.Command {
 	}
 
 	cmd.Flags().BoolP("online-only", "o", false, "Show only online cameras")
+	cmd.Flags().IntP("port", "p", 0, "RTSP port to show (defaults to the running server or 8554)")
 
 	return cmd
 }
 
 func runStartServer(cmd *cobra.Command, args []string) error {
+	if err := config.ApplyFlags(cmd.Flags(), startFlags(appConfig)); err != nil {
+		return err
+	}
+
 	port, _ := cmd.Flags().GetInt("port")
+	bind, _ := cmd.Flags().GetString("bind")
 	daemon, _ := cmd.Flags().GetBool("daemon")
+	enableAuth, _ := cmd.Flags().GetBool("auth")
+	allowBasic, _ := cmd.Flags().GetBool("auth-basic")
+	describeTimeout, _ := cmd.Flags().GetDuration("describe-timeout")
+	gopCacheSize, _ := cmd.Flags().GetInt("gop-cache-size")
+	gopCacheCameras, _ := cmd.Flags().GetStringToInt("gop-cache-camera")
+	apiListen, _ := cmd.Flags().GetString("api-listen")
+	apiToken, _ := cmd.Flags().GetString("api-token")
+	metricsListen, _ := cmd.Flags().GetString("metrics-listen")
+	httpListen, _ := cmd.Flags().GetString("http-listen")
+	hlsLowLatency, _ := cmd.Flags().GetBool("hls-low-latency")
+	webrtcListen, _ := cmd.Flags().GetString("webrtc-listen")
+	webrtcCandidates, _ := cmd.Flags().GetStringSlice("webrtc-candidate")
+	webrtcInterfaces, _ := cmd.Flags().GetStringSlice("webrtc-interface")
+	webrtcIPs, _ := cmd.Flags().GetStringSlice("webrtc-ip")
+	webrtcUDPPorts, _ := cmd.Flags().GetString("webrtc-udp-ports")
+	recordDir, _ := cmd.Flags().GetString("record-dir")
+	recordSegment, _ := cmd.Flags().GetDuration("record-segment")
+	recordMaxAge, _ := cmd.Flags().GetDuration("record-max-age")
+	recordMaxSize, _ := cmd.Flags().GetFloat64("record-max-size")
+	clipDir, _ := cmd.Flags().GetString("clip-dir")
+	clipCameras, _ := cmd.Flags().GetStringSlice("clip-camera")
+	clipPreRoll, _ := cmd.Flags().GetDuration("clip-pre-roll")
+	clipPostRoll, _ := cmd.Flags().GetDuration("clip-post-roll")
+	clipMaxDuration, _ := cmd.Flags().GetDuration("clip-max-duration")
+	clipHook, _ := cmd.Flags().GetString("clip-hook")
+	webhooks, _ := cmd.Flags().GetStringArray("webhook")
+	clipOnEvent, _ := cmd.Flags().GetStringSlice("clip-on-event")
+	sessionCheckInterval, _ := cmd.Flags().GetDuration("session-check-interval")
+	sessionWarnBefore, _ := cmd.Flags().GetDuration("session-warn-before")
+	haBroker, _ := cmd.Flags().GetString("ha-broker")
+	haUsername, _ := cmd.Flags().GetString("ha-username")
+	haPassword, _ := cmd.Flags().GetString("ha-password")
+	haDiscoveryPrefix, _ := cmd.Flags().GetString("ha-discovery-prefix")
+	haTopicPrefix, _ := cmd.Flags().GetString("ha-topic-prefix")
+	haRTSPHost, _ := cmd.Flags().GetString("ha-rtsp-host")
+	haPrivacy, _ := cmd.Flags().GetBool("ha-privacy")
+	haSnapshotInterval, _ := cmd.Flags().GetDuration("ha-snapshot-interval")
+	haFFmpeg, _ := cmd.Flags().GetString("ha-ffmpeg")
+	onvifListen, _ := cmd.Flags().GetString("onvif-listen")
+
+	if apiToken == "" {
+		apiToken = os.Getenv(apiTokenEnv)
+	}
+	if haPassword == "" {
+		haPassword = os.Getenv(haPasswordEnv)
+	}
+	if haRTSPHost == "" {
+		haRTSPHost, _ = os.Hostname()
+	}
+
+	udpPorts, err := parsePortRange(webrtcUDPPorts)
+	if err != nil {
+		return err
+	}
+
+	if recordDir == "" {
+		recordDir = storageManager.GetRecordingsDir()
+	}
+	if clipDir == "" {
+		clipDir = storageManager.GetClipsDir()
+	}
+
+	if apiListen != "" && apiToken == "" {
+		return fmt.Errorf("--api-listen requires --api-token or $%s", apiTokenEnv)
+	}
+
+	cameraGOPCacheSize := make(map[string]int, len(gopCacheCameras))
+	for camera, size := range gopCacheCameras {
+		cameraGOPCacheSize[camera] = size << 20
+	}
 
 	// Check if we have any authenticated users
 	users, err := storageManager.ListUsers()
@@ -112,8 +273,33 @@ func runStartServer(cmd *cobra.Command, args []string) error {
 		return errors.New("no cameras found")
 	}
 
+	warnUnknownCameras()
+	warnDuplicatePaths()
+
+	// Detach into the background, the child process runs the code below
+	if daemon && os.Getenv(daemonEnv) == "" {
+		if _, err := FindControlSocket(port); err == nil {
+			return fmt.Errorf("RTSP server is already running on port %d", port)
+		}
+		return spawnDaemon(port)
+	}
+
+	// Cameras are looked up on every connection, 'cameras refresh' may change them
+	if err := storageManager.WatchCameraRegistry(); err != nil {
+		core.Logger.Warn().Err(err).Msg("Camera registry is read from disk on every lookup")
+	}
+	defer storageManager.Close()
+
 	// Create and start RTSP server
-	rtspServer = rtsp.NewRTSPServer(port, storageManager)
+	rtspServer := rtsp.NewRTSPServer(rtsp.ServerConfig{
+		Host:                 bind,
+		Port:                 port,
+		EnableAuthentication: enableAuth || allowBasic,
+		AllowBasicAuth:       allowBasic,
+		DescribeTimeout:      describeTimeout,
+		GOPCacheSize:         gopCacheSize << 20,
+		CameraGOPCacheSize:   cameraGOPCacheSize,
+	}, storageManager)
 
 	core.Logger.Info().Msgf("Starting RTSP server on port %d...", port)
 
@@ -121,20 +307,133 @@ func runStartServer(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to start RTSP server: %v", err)
 	}
 
-	if daemon {
-		core.Logger.Info().Msgf("RTSP server started in daemon mode")
-		return nil
+	recordManager := record.NewManager(rtspServer, record.Config{
+		Dir:             recordDir,
+		SegmentDuration: recordSegment,
+		MaxAge:          recordMaxAge,
+		MaxSize:         int64(recordMaxSize * (1 << 30)),
+		ClipDir:         clipDir,
+		PreRoll:         clipPreRoll,
+		PostRoll:        clipPostRoll,
+		MaxClipDuration: clipMaxDuration,
+		OnClip:          clipHookFunc(clipHook),
+	})
+	defer recordManager.Close()
+
+	eventBus, closeEvents, err := startEvents(eventsConfig{
+		Webhooks:    webhooks,
+		ClipOnEvent: clipOnEvent,
+		ClipCameras: clipCameras,
+		Publish:     haBroker != "",
+	}, recordManager)
+	if err != nil {
+		rtspServer.Stop()
+		return err
 	}
+	defer closeEvents()
 
-	// Wait for interrupt signal
-	core.Logger.Info().Msgf("RTSP server is running. Press Ctrl+C to stop.")
+	sessionKeeper := session.NewKeeper(storageManager, eventBus, session.Config{
+		CheckInterval: sessionCheckInterval,
+		WarnBefore:    sessionWarnBefore,
+	})
+	sessionKeeper.Start()
+	defer sessionKeeper.Close()
+
+	stopChan := make(chan struct{})
+	cleanup, err := serveControl(rtspServer, recordManager, sessionKeeper, cmd.Root().Version, stopChan)
+	if err != nil {
+		rtspServer.Stop()
+		return err
+	}
+	defer cleanup()
+
+	startRecordings(recordManager, clipCameras)
+
+	if haBroker != "" {
+		publisher := homeassistant.NewPublisher(homeassistant.Config{
+			Broker:          haBroker,
+			Username:        haUsername,
+			Password:        haPassword,
+			DiscoveryPrefix: haDiscoveryPrefix,
+			TopicPrefix:     haTopicPrefix,
+			RTSPHost:        haRTSPHost,
+			RTSPPort:        port,
+			PrivacySwitch:   haPrivacy,
+			Version:         cmd.Root().Version,
+
+			SnapshotInterval: haSnapshotInterval,
+			FFmpeg:           haFFmpeg,
+		}, rtspServer, storageManager, eventBus)
+		if err := publisher.Start(); err != nil {
+			rtspServer.Stop()
+			return fmt.Errorf("failed to start Home Assistant publisher: %v", err)
+		}
+		defer publisher.Close()
+	}
+
+	if apiListen != "" {
+		apiServer := api.NewServer(api.Config{Listen: apiListen, Token: apiToken}, rtspServer, recordManager, storageManager)
+		if err := apiServer.Start(); err != nil {
+			rtspServer.Stop()
+			return fmt.Errorf("failed to start HTTP API: %v", err)
+		}
+		defer apiServer.Stop()
+	}
+
+	if metricsListen != "" {
+		metricsServer, err := metrics.Serve(metricsListen)
+		if err != nil {
+			rtspServer.Stop()
+			return fmt.Errorf("failed to start metrics server: %v", err)
+		}
+		defer metricsServer.Close()
+	}
+
+	if httpListen != "" {
+		closeMedia, err := serveMedia(mediaConfig{
+			Listen:        httpListen,
+			HLSLowLatency: hlsLowLatency,
+			WebRTCListen:  webrtcListen,
+			WebRTCFilters: webrtc.Filters{
+				Candidates: webrtcCandidates,
+				Interfaces: webrtcInterfaces,
+				IPs:        webrtcIPs,
+				UDPPorts:   udpPorts,
+			},
+		}, rtspServer)
+		if err != nil {
+			rtspServer.Stop()
+			return fmt.Errorf("failed to start media server: %v", err)
+		}
+		defer closeMedia()
+	}
+
+	if onvifListen != "" {
+		onvifServer := onvif.NewServer(onvif.Config{
+			Listen:   onvifListen,
+			RTSPPort: port,
+			Version:  cmd.Root().Version,
+		}, rtspServer, storageManager)
+		if err := onvifServer.Start(); err != nil {
+			rtspServer.Stop()
+			return fmt.Errorf("failed to start ONVIF server: %v", err)
+		}
+		defer onvifServer.Close()
+	}
+
+	// Wait for interrupt signal or a stop command
+	core.Logger.Info().Msgf("RTSP server is running. Press Ctrl+C or run 'rtsp stop' to stop.")
 
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
 
-	<-signalChan
+	select {
+	case <-signalChan:
+	case <-stopChan:
+	}
 
 	core.Logger.Info().Msgf("Shutting down RTSP server...")
+	recordManager.Close()
 	if err := rtspServer.Stop(); err != nil {
 		return fmt.Errorf("error stopping server: %v", err)
 	}
@@ -144,48 +443,173 @@ func runStartServer(cmd *cobra.Command, args []string) error {
 }
 
 func runStopServer(cmd *cobra.Command, args []string) error {
-	if rtspServer == nil {
-		return errors.New("no RTSP server instance found")
-	}
+	port, _ := cmd.Flags().GetInt("port")
 
-	if !rtspServer.IsRunning() {
-		core.Logger.Info().Msg("RTSP server is not running")
+	socketPath, err := FindControlSocket(port)
+	if err != nil {
+		if !errors.Is(err, control.ErrNotRunning) {
+			return err
+		}
+
+		// No answer on the socket, a hung server can still be signaled
+		if port == 0 || signalFromPIDFile(port) != nil {
+			core.Logger.Info().Msg("RTSP server is not running")
+			return nil
+		}
+
+		core.Logger.Info().Msg("Sent SIGTERM to unresponsive RTSP server")
 		return nil
 	}
 
 	core.Logger.Info().Msg("Stopping RTSP server...")
-	if err := rtspServer.Stop(); err != nil {
-		return fmt.Errorf("failed to stop server: %v", err)
+
+	// The server may close the connection before the answer is read
+	if err := control.Call(socketPath, "stop", nil, nil); err != nil && !errors.Is(err, control.ErrNotRunning) {
+		core.Logger.Debug().Err(err).Msg("Stop command")
 	}
 
-	core.Logger.Info().Msg("RTSP server stopped.")
-	return nil
+	deadline := time.Now().Add(30 * time.Second)
+	for time.Now().Before(deadline) {
+		if control.Call(socketPath, "ping", nil, nil) != nil {
+			core.Logger.Info().Msg("RTSP server stopped.")
+			return nil
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+
+	return errors.New("RTSP server did not stop within 30s")
 }
 
 func runServerStatus(cmd *cobra.Command, args []string) error {
-	if rtspServer == nil {
-		fmt.Println("RTSP Server Status: Not initialized")
+	port, _ := cmd.Flags().GetInt("port")
+
+	socketPath, err := FindControlSocket(port)
+	if errors.Is(err, control.ErrNotRunning) {
+		fmt.Println("RTSP Server Status: Not running")
 		return nil
+	} else if err != nil {
+		return err
 	}
 
-	stats := rtspServer.GetStats()
+	var status statusResponse
+	if err := control.Call(socketPath, "status", nil, &status); err != nil {
+		return fmt.Errorf("failed to get status: %v", err)
+	}
+
+	stats := status.Stats
 
 	fmt.Println("RTSP Server Status:")
 	fmt.Println("==================")
+	fmt.Printf("PID: %d\n", status.PID)
+	fmt.Printf("Version: %s\n", status.Version)
+	fmt.Printf("Uptime: %s\n", time.Since(status.StartedAt).Round(time.Second))
 	fmt.Printf("Port: %d\n", stats.Port)
 	fmt.Printf("Running: %v\n", stats.Running)
 	fmt.Printf("Connected clients: %d\n", stats.ClientCount)
 	fmt.Printf("Active streams: %d\n", stats.StreamCount)
 	fmt.Printf("Total streams: %d\n", stats.TotalStreams)
+	fmt.Printf("Upstream reconnects: %d\n", stats.Reconnects)
+
+	if len(status.Sessions) > 0 {
+		fmt.Println("\nSessions:")
+		for _, s := range status.Sessions {
+			fmt.Printf("  %s [%s]", s.UserKey, s.State)
+			if s.Expires != nil {
+				fmt.Printf(", expires %s", s.Expires.Local().Format("2006-01-02 15:04:05"))
+			}
+			if s.Error != "" {
+				fmt.Printf(", %s", s.Error)
+			}
+			fmt.Println()
+		}
+	}
+
+	if len(status.Streams) > 0 {
+		fmt.Println("\nStreams:")
+		for _, stream := range status.Streams {
+			state := "idle"
+			switch {
+			case stream.Connecting:
+				state = "connecting"
+			case stream.Active:
+				state = "active"
+			}
+
+			fmt.Printf("  %s (%s) [%s]\n", stream.CameraName, stream.ID, state)
+			fmt.Printf("    Clients: %d, Reconnects: %d", stream.ClientCount, stream.Reconnects)
+			if !stream.LastActivity.IsZero() {
+				fmt.Printf(", Last packet: %s ago", time.Since(stream.LastActivity).Round(time.Second))
+			}
+			fmt.Println()
+		}
+	}
 
 	if stats.Running {
-		fmt.Printf("\nServer has been running since startup\n")
-		fmt.Printf("Access cameras via: rtsp://localhost:%d/[camera-path]\n", stats.Port)
+		fmt.Printf("\nAccess cameras via: rtsp://localhost:%d/[camera-path]\n", stats.Port)
 	}
 
 	return nil
 }
 
+func runListClients(cmd *cobra.Command, args []string) error {
+	port, _ := cmd.Flags().GetInt("port")
+
+	socketPath, err := FindControlSocket(port)
+	if errors.Is(err, control.ErrNotRunning) {
+		fmt.Println("RTSP server is not running")
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var clients []rtsp.ClientInfo
+	if err := control.Call(socketPath, "clients", nil, &clients); err != nil {
+		return fmt.Errorf("failed to get clients: %v", err)
+	}
+
+	if len(clients) == 0 {
+		fmt.Println("No clients connected.")
+		return nil
+	}
+
+	fmt.Printf("Connected clients (%d):\n", len(clients))
+	fmt.Println("=====================")
+
+	for i, client := range clients {
+		state := "setup"
+		if client.Playing {
+			state = "playing"
+		}
+
+		fmt.Printf("%d. %s -> %s (%s)\n", i+1, client.RemoteAddr, client.Path, client.CameraName)
+		fmt.Printf("   Session: %s\n", client.Session)
+		fmt.Printf("   Transport: %s, State: %s, Connected: %s ago\n",
+			client.Transport, state, time.Since(client.ConnectedAt).Round(time.Second))
+
+		if client.User != "" {
+			fmt.Printf("   User: %s\n", client.User)
+		}
+
+		if client.RTCP != nil {
+			printReceiverReport("Video", client.RTCP.Video)
+			printReceiverReport("Audio", client.RTCP.Audio)
+		}
+
+		fmt.Println()
+	}
+
+	return nil
+}
+
+func printReceiverReport(kind string, report *rtsp.ReceiverReport) {
+	if report == nil {
+		return
+	}
+
+	fmt.Printf("   %s: %.1f%% loss (%d lost), jitter %.1f ms\n",
+		kind, report.FractionLost*100, report.PacketsLost, report.Jitter)
+}
+
 func runListEndpoints(cmd *cobra.Command, args []string) error {
 	cameras, err := storageManager.GetAllCameras()
 	if err != nil {
@@ -203,9 +627,14 @@ func runListEndpoints(cmd *cobra.Command, args []string) error {
 		return nil
 	}
 
-	port := 8554
-	if rtspServer != nil {
-		port = rtspServer.GetPort()
+	port, _ := cmd.Flags().GetInt("port")
+	if port == 0 {
+		port = 8554
+
+		var status statusResponse
+		if socketPath, err := FindControlSocket(0); err == nil && control.Call(socketPath, "status", nil, &status) == nil {
+			port = status.Stats.Port
+		}
 	}
 
 	fmt.Println("Available RTSP Endpoints:")
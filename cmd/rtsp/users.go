@@ -0,0 +1,190 @@
+package rtsp
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+
+	"tuya-ipc-terminal/pkg/rtsp"
+)
+
+func newUsersCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "users",
+		Short: "Manage RTSP users",
+		Long:  "Commands to add, remove and list the credentials RTSP clients authenticate with when the server runs with --auth.",
+	}
+
+	cmd.AddCommand(newUsersAddCmd())
+	cmd.AddCommand(newUsersRemoveCmd())
+	cmd.AddCommand(newUsersListCmd())
+
+	return cmd
+}
+
+func newUsersAddCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "add [username]",
+		Short: "Add or update an RTSP user",
+		Long: `Add an RTSP user or update the password and allowed paths of an existing one.
+
+Paths are RTSP camera paths as shown by 'rtsp list-endpoints' and may contain
+wildcards. Without --path the user may open every camera.
+
+ONVIF clients that send WS-Security password digests need the password
+itself, keep it with --remember-password (needs encrypted storage).
+
+Example:
+  tuya-ipc-terminal rtsp users add nvr
+  tuya-ipc-terminal rtsp users add frigate --path /FrontDoor --path "/Garden*"`,
+		Args: cobra.ExactArgs(1),
+		RunE: runUsersAdd,
+	}
+
+	cmd.Flags().String("password", "", "Password (prompted if not set)")
+	cmd.Flags().StringSlice("path", nil, "Allowed RTSP path, can be repeated (default: all cameras)")
+	cmd.Flags().Bool("remember-password", false, "Keep the password in encrypted storage for WS-Security password digests of ONVIF clients")
+
+	return cmd
+}
+
+func newUsersRemoveCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "remove [username]",
+		Short: "Remove an RTSP user",
+		Long:  "Remove stored RTSP credentials.",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runUsersRemove,
+	}
+}
+
+func newUsersListCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List RTSP users",
+		Long:  "Display all RTSP users and the camera paths they may access.",
+		RunE:  runUsersList,
+	}
+}
+
+func runUsersAdd(cmd *cobra.Command, args []string) error {
+	username := args[0]
+	password, _ := cmd.Flags().GetString("password")
+	paths, _ := cmd.Flags().GetStringSlice("path")
+	remember, _ := cmd.Flags().GetBool("remember-password")
+
+	if strings.Contains(username, ":") {
+		return errors.New("username must not contain ':'")
+	}
+
+	for _, path := range paths {
+		if path != "*" && !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("invalid path %q, paths must start with '/'", path)
+		}
+	}
+
+	if remember && !storageManager.Encrypted() {
+		return errors.New("--remember-password needs encrypted storage, set --storage-key-file, --storage-passphrase or $TUYA_IPC_STORAGE_KEY")
+	}
+
+	if password == "" {
+		var err error
+		if password, err = promptNewPassword(); err != nil {
+			return err
+		}
+	}
+
+	existing, err := storageManager.GetRTSPUser(username)
+	if err != nil {
+		return fmt.Errorf("failed to check existing user: %v", err)
+	}
+
+	user := rtsp.NewRTSPUser(username, password, paths)
+	if remember {
+		if user.Password, err = storageManager.SealPassword(password); err != nil {
+			return fmt.Errorf("failed to encrypt password: %v", err)
+		}
+	}
+	if err := storageManager.SaveRTSPUser(user); err != nil {
+		return fmt.Errorf("failed to save RTSP user: %v", err)
+	}
+
+	if existing != nil {
+		fmt.Printf("✓ Updated RTSP user %s\n", username)
+	} else {
+		fmt.Printf("✓ Added RTSP user %s\n", username)
+	}
+	fmt.Printf("Allowed paths: %s\n", strings.Join(user.Paths, ", "))
+
+	return nil
+}
+
+func runUsersRemove(cmd *cobra.Command, args []string) error {
+	username := args[0]
+
+	removed, err := storageManager.RemoveRTSPUser(username)
+	if err != nil {
+		return fmt.Errorf("failed to remove RTSP user: %v", err)
+	}
+
+	if !removed {
+		return fmt.Errorf("RTSP user %s not found", username)
+	}
+
+	fmt.Printf("✓ Removed RTSP user %s\n", username)
+	return nil
+}
+
+func runUsersList(cmd *cobra.Command, args []string) error {
+	users, err := storageManager.GetRTSPUsers()
+	if err != nil {
+		return fmt.Errorf("failed to list RTSP users: %v", err)
+	}
+
+	if len(users) == 0 {
+		fmt.Println("No RTSP users found.")
+		fmt.Println("Use 'tuya-ipc-terminal rtsp users add [username]' to add a user.")
+		return nil
+	}
+
+	fmt.Printf("Found %d RTSP user(s):\n\n", len(users))
+
+	for i, user := range users {
+		fmt.Printf("%d. %s\n", i+1, user.Username)
+		fmt.Printf("   Paths: %s\n", strings.Join(user.Paths, ", "))
+		fmt.Printf("   Created: %s\n", user.CreatedAt.Format("2006-01-02 15:04:05"))
+		fmt.Println()
+	}
+
+	return nil
+}
+
+func promptNewPassword() (string, error) {
+	fmt.Print("Enter password: ")
+	password, err := term.ReadPassword(int(syscall.Stdin))
+	fmt.Println()
+	if err != nil {
+		return "", fmt.Errorf("failed to read password: %v", err)
+	}
+
+	fmt.Print("Repeat password: ")
+	repeated, err := term.ReadPassword(int(syscall.Stdin))
+	fmt.Println()
+	if err != nil {
+		return "", fmt.Errorf("failed to read password: %v", err)
+	}
+
+	if string(password) != string(repeated) {
+		return "", errors.New("passwords do not match")
+	}
+
+	if len(password) == 0 {
+		return "", errors.New("password must not be empty")
+	}
+
+	return string(password), nil
+}
@@ -15,6 +15,6 @@ func main() {
 
 	if err := cmd.Execute(VERSION); err != nil {
 		fmt.Println("Command execution failed")
-		os.Exit(1)
+		os.Exit(cmd.ExitCode(err))
 	}
 }
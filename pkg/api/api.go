@@ -0,0 +1,343 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"tuya-ipc-terminal/pkg/core"
+	"tuya-ipc-terminal/pkg/discovery"
+	"tuya-ipc-terminal/pkg/ptz"
+	"tuya-ipc-terminal/pkg/record"
+	"tuya-ipc-terminal/pkg/rtsp"
+	"tuya-ipc-terminal/pkg/storage"
+	"tuya-ipc-terminal/pkg/tuya"
+)
+
+type Config struct {
+	Listen string // e.g. ":8080" or "127.0.0.1:8080"
+	Token  string // Required as "Authorization: Bearer <token>"
+}
+
+// Server is the HTTP management API of an RTSPServer, see README for the schema
+type Server struct {
+	config         Config
+	rtspServer     *rtsp.RTSPServer
+	recordManager  *record.Manager
+	storageManager *storage.StorageManager
+	ptzController  *ptz.Controller
+	httpServer     *http.Server
+
+	// Only one camera refresh at a time
+	refreshMutex sync.Mutex
+}
+
+// ErrorResponse is returned with every non 2xx status
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+type CameraResponse struct {
+	DeviceID   string `json:"deviceId"`
+	DeviceName string `json:"deviceName"`
+	Path       string `json:"path"`
+	Category   string `json:"category"`
+	UserKey    string `json:"userKey"`
+}
+
+type CamerasResponse struct {
+	Cameras     []CameraResponse `json:"cameras"`
+	LastUpdated time.Time        `json:"lastUpdated"`
+}
+
+type RefreshResponse struct {
+	Users   []discovery.UserResult `json:"users"`
+	Cameras int                    `json:"cameras"`
+}
+
+type startStreamRequest struct {
+	Resolution string `json:"resolution"`
+}
+
+type clipRequest struct {
+	Resolution string `json:"resolution"`
+	Reason     string `json:"reason"`
+}
+
+type ClipResponse struct {
+	Path string `json:"path"`
+}
+
+func NewServer(config Config, rtspServer *rtsp.RTSPServer, recordManager *record.Manager, storageManager *storage.StorageManager) *Server {
+	s := &Server{
+		config:         config,
+		rtspServer:     rtspServer,
+		recordManager:  recordManager,
+		storageManager: storageManager,
+		ptzController:  ptz.NewController(storageManager),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/stats", s.handleStats)
+	mux.HandleFunc("GET /api/streams", s.handleStreams)
+	mux.HandleFunc("DELETE /api/streams/{id}", s.handleStopStream)
+	mux.HandleFunc("GET /api/clients", s.handleClients)
+	mux.HandleFunc("DELETE /api/clients/{session}", s.handleKickClient)
+	mux.HandleFunc("GET /api/cameras", s.handleCameras)
+	mux.HandleFunc("POST /api/cameras/refresh", s.handleRefreshCameras)
+	mux.HandleFunc("POST /api/cameras/{camera}/start", s.handleStartStream)
+	mux.HandleFunc("POST /api/cameras/{camera}/clip", s.handleClip)
+	mux.HandleFunc("POST /api/cameras/{camera}/ptz", s.handlePTZ)
+
+	s.httpServer = &http.Server{
+		Handler:           s.authenticate(mux),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	return s
+}
+
+func (s *Server) Start() error {
+	if s.config.Token == "" {
+		return errors.New("API token is required")
+	}
+
+	listener, err := net.Listen("tcp", s.config.Listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", s.config.Listen, err)
+	}
+
+	core.Logger.Info().Msgf("HTTP API listening on %s", listener.Addr())
+
+	go func() {
+		if err := s.httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			core.Logger.Error().Err(err).Msg("HTTP API server failed")
+		}
+	}()
+
+	return nil
+}
+
+func (s *Server) Stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := s.httpServer.Shutdown(ctx)
+	s.ptzController.Close()
+	return err
+}
+
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.config.Token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="tuya-ipc-terminal"`)
+			writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.rtspServer.GetStats())
+}
+
+func (s *Server) handleStreams(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.rtspServer.GetStreams())
+}
+
+func (s *Server) handleStartStream(w http.ResponseWriter, r *http.Request) {
+	var request startStreamRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %v", err))
+			return
+		}
+	}
+	if resolution := r.URL.Query().Get("resolution"); resolution != "" {
+		request.Resolution = resolution
+	}
+
+	stream, err := s.rtspServer.StartStream(r.PathValue("camera"), request.Resolution)
+	if errors.Is(err, rtsp.ErrCameraNotFound) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, stream)
+}
+
+func (s *Server) handleClip(w http.ResponseWriter, r *http.Request) {
+	var request clipRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %v", err))
+			return
+		}
+	}
+	if resolution := r.URL.Query().Get("resolution"); resolution != "" {
+		request.Resolution = resolution
+	}
+	if reason := r.URL.Query().Get("reason"); reason != "" {
+		request.Reason = reason
+	}
+
+	path, err := s.recordManager.Clip(r.PathValue("camera"), request.Resolution, request.Reason)
+	if errors.Is(err, rtsp.ErrCameraNotFound) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, ClipResponse{Path: path})
+}
+
+func (s *Server) handlePTZ(w http.ResponseWriter, r *http.Request) {
+	var command tuya.PTZCommand
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&command); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %v", err))
+			return
+		}
+	}
+	if action := r.URL.Query().Get("action"); action != "" {
+		command.Action = tuya.PTZAction(action)
+	}
+	if preset := r.URL.Query().Get("preset"); preset != "" {
+		number, err := strconv.Atoi(preset)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid preset: %s", preset))
+			return
+		}
+		command.Preset = number
+	}
+
+	if err := command.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	camera, err := s.rtspServer.LookupCamera(r.PathValue("camera"))
+	if errors.Is(err, rtsp.ErrCameraNotFound) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	err = s.ptzController.Send(camera, command)
+	if errors.Is(err, ptz.ErrNotSupported) {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	} else if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleStopStream(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !s.rtspServer.StopStream(id) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("stream not found: %s", id))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleClients(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.rtspServer.GetClients())
+}
+
+func (s *Server) handleKickClient(w http.ResponseWriter, r *http.Request) {
+	session := r.PathValue("session")
+	if !s.rtspServer.KickClient(session) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("client not found: %s", session))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleCameras(w http.ResponseWriter, r *http.Request) {
+	registry, err := s.storageManager.GetCameraRegistry()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// With the overrides of the config applied
+	cameras, err := s.storageManager.GetAllCameras()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	response := CamerasResponse{
+		Cameras:     make([]CameraResponse, 0, len(cameras)),
+		LastUpdated: registry.LastUpdated,
+	}
+
+	for _, camera := range cameras {
+		response.Cameras = append(response.Cameras, CameraResponse{
+			DeviceID:   camera.DeviceID,
+			DeviceName: camera.DeviceName,
+			Path:       camera.RTSPPath,
+			Category:   camera.Category,
+			UserKey:    camera.UserKey,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (s *Server) handleRefreshCameras(w http.ResponseWriter, r *http.Request) {
+	if !s.refreshMutex.TryLock() {
+		writeError(w, http.StatusConflict, errors.New("camera refresh already in progress"))
+		return
+	}
+	defer s.refreshMutex.Unlock()
+
+	core.Logger.Info().Msg("Refreshing cameras on API request")
+
+	results, err := discovery.RefreshAll(s.storageManager)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	response := RefreshResponse{Users: results}
+	for _, result := range results {
+		response.Cameras += result.Cameras
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, ErrorResponse{Error: err.Error()})
+}
@@ -0,0 +1,387 @@
+// Package config reads the YAML configuration file. Its values are defaults,
+// command line flags override them.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"tuya-ipc-terminal/pkg/events"
+	"tuya-ipc-terminal/pkg/storage"
+	"tuya-ipc-terminal/pkg/tuya"
+)
+
+const (
+	ConfigEnv  = "TUYA_IPC_CONFIG"
+	DataDirEnv = "TUYA_IPC_DATA_DIR"
+
+	appName = "tuya-ipc-terminal"
+
+	// Data directory of older versions, relative to the working directory
+	legacyDataDir = ".tuya-data"
+)
+
+type Config struct {
+	DataDir       string            `yaml:"dataDir"`
+	Log           Log               `yaml:"log"`
+	RTSP          RTSP              `yaml:"rtsp"`
+	API           API               `yaml:"api"`
+	Metrics       Metrics           `yaml:"metrics"`
+	HTTP          HTTP              `yaml:"http"`
+	WebRTC        WebRTC            `yaml:"webrtc"`
+	Record        Record            `yaml:"record"`
+	Clips         Clips             `yaml:"clips"`
+	Events        Events            `yaml:"events"`
+	Session       Session           `yaml:"session"`
+	HomeAssistant HomeAssistant     `yaml:"homeAssistant"`
+	ONVIF         ONVIF             `yaml:"onvif"`
+	PathTemplate  string            `yaml:"pathTemplate"` // RTSP paths of new cameras, e.g. /{home}/{room}/{name}
+	Cameras       map[string]Camera `yaml:"cameras"`      // By device ID, RTSP path or name
+
+	Path string `yaml:"-"` // File the config was read from, empty without one
+}
+
+type Log struct {
+	Level  string `yaml:"level"`  // trace, debug, info, warn, error
+	Format string `yaml:"format"` // console or json
+}
+
+type RTSP struct {
+	Port            int           `yaml:"port"`
+	Bind            string        `yaml:"bind"` // Address to listen on, default all
+	Auth            bool          `yaml:"auth"`
+	AuthBasic       bool          `yaml:"authBasic"`
+	DescribeTimeout time.Duration `yaml:"describeTimeout"`
+	GOPCacheSize    *int          `yaml:"gopCacheSize"` // MiB, 0 disables
+}
+
+type API struct {
+	Listen string `yaml:"listen"`
+	Token  string `yaml:"token"`
+}
+
+type Metrics struct {
+	Listen string `yaml:"listen"`
+}
+
+type HTTP struct {
+	Listen        string `yaml:"listen"`
+	HLSLowLatency bool   `yaml:"hlsLowLatency"`
+}
+
+type WebRTC struct {
+	Listen     string   `yaml:"listen"`
+	Candidates []string `yaml:"candidates"`
+	Interfaces []string `yaml:"interfaces"`
+	IPs        []string `yaml:"ips"`
+	UDPPorts   string   `yaml:"udpPorts"`
+}
+
+type Record struct {
+	Dir     string        `yaml:"dir"`
+	Segment time.Duration `yaml:"segment"`
+	MaxAge  time.Duration `yaml:"maxAge"`
+	MaxSize float64       `yaml:"maxSize"` // GiB
+}
+
+type Clips struct {
+	Dir         string        `yaml:"dir"`
+	Cameras     []string      `yaml:"cameras"`
+	PreRoll     time.Duration `yaml:"preRoll"`
+	PostRoll    time.Duration `yaml:"postRoll"`
+	MaxDuration time.Duration `yaml:"maxDuration"`
+	Hook        string        `yaml:"hook"`
+	OnEvent     []string      `yaml:"onEvent"`
+}
+
+type Events struct {
+	Webhooks []string `yaml:"webhooks"` // "[type,type=]url"
+}
+
+type Session struct {
+	CheckInterval time.Duration `yaml:"checkInterval"`
+	WarnBefore    time.Duration `yaml:"warnBefore"`
+}
+
+type HomeAssistant struct {
+	Broker          string `yaml:"broker"`
+	Username        string `yaml:"username"`
+	Password        string `yaml:"password"`
+	DiscoveryPrefix string `yaml:"discoveryPrefix"`
+	TopicPrefix     string `yaml:"topicPrefix"`
+	RTSPHost        string `yaml:"rtspHost"`
+	Privacy         bool   `yaml:"privacy"`
+
+	SnapshotInterval time.Duration `yaml:"snapshotInterval"`
+	FFmpeg           string        `yaml:"ffmpeg"`
+}
+
+type ONVIF struct {
+	Listen string `yaml:"listen"`
+}
+
+// Camera overrides the stored camera
+type Camera struct {
+	Path       string `yaml:"path"`       // RTSP path alias
+	Resolution string `yaml:"resolution"` // Stream of paths without /hd or /sd
+	Enabled    *bool  `yaml:"enabled"`
+	Audio      *bool  `yaml:"audio"`
+	GOPCache   *int   `yaml:"gopCache"` // MiB, 0 disables
+}
+
+// DefaultPath returns the config file used without --config and
+// $TUYA_IPC_CONFIG, it may not exist
+func DefaultPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, appName, "config.yaml")
+}
+
+// Load reads the config file at path, or the one of $TUYA_IPC_CONFIG or the
+// default location. Without a file the config is empty.
+func Load(path string) (*Config, error) {
+	required := true
+	if path == "" {
+		path = os.Getenv(ConfigEnv)
+	}
+	if path == "" {
+		path = DefaultPath()
+		required = false
+	}
+
+	config := &Config{}
+	if path == "" {
+		return config, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) && !required {
+			return config, nil
+		}
+		return nil, fmt.Errorf("failed to read config: %v", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(config); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	config.Path = path
+	return config, nil
+}
+
+// Validate checks the values, the error names every invalid key
+func (c *Config) Validate() error {
+	var errs []error
+	invalid := func(key, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+	}
+
+	if c.Log.Level != "" && !isLogLevel(c.Log.Level) {
+		invalid("log.level", "unknown level %q, use trace, debug, info, warn or error", c.Log.Level)
+	}
+	if c.Log.Format != "" && c.Log.Format != "console" && c.Log.Format != "json" {
+		invalid("log.format", "unknown format %q, use console or json", c.Log.Format)
+	}
+
+	if c.RTSP.Port < 0 || c.RTSP.Port > 65535 {
+		invalid("rtsp.port", "%d is not a port", c.RTSP.Port)
+	}
+	if c.RTSP.Bind != "" && strings.Contains(c.RTSP.Bind, ":") && net.ParseIP(c.RTSP.Bind) == nil {
+		invalid("rtsp.bind", "%q must be an address without port, e.g. 127.0.0.1", c.RTSP.Bind)
+	}
+	if c.RTSP.GOPCacheSize != nil && *c.RTSP.GOPCacheSize < 0 {
+		invalid("rtsp.gopCacheSize", "must not be negative")
+	}
+
+	listens := []struct{ key, value string }{
+		{"api.listen", c.API.Listen},
+		{"metrics.listen", c.Metrics.Listen},
+		{"http.listen", c.HTTP.Listen},
+		{"webrtc.listen", c.WebRTC.Listen},
+		{"onvif.listen", c.ONVIF.Listen},
+	}
+	for _, listen := range listens {
+		if listen.value == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(listen.value); err != nil {
+			invalid(listen.key, "%q is not an address like :8080 or 127.0.0.1:8080", listen.value)
+		}
+	}
+
+	durations := []struct {
+		key   string
+		value time.Duration
+	}{
+		{"rtsp.describeTimeout", c.RTSP.DescribeTimeout},
+		{"record.segment", c.Record.Segment},
+		{"record.maxAge", c.Record.MaxAge},
+		{"clips.preRoll", c.Clips.PreRoll},
+		{"clips.postRoll", c.Clips.PostRoll},
+		{"clips.maxDuration", c.Clips.MaxDuration},
+		{"session.checkInterval", c.Session.CheckInterval},
+		{"session.warnBefore", c.Session.WarnBefore},
+		{"homeAssistant.snapshotInterval", c.HomeAssistant.SnapshotInterval},
+	}
+	for _, duration := range durations {
+		if duration.value < 0 {
+			invalid(duration.key, "must not be negative")
+		}
+	}
+
+	if c.Record.MaxSize < 0 {
+		invalid("record.maxSize", "must not be negative")
+	}
+
+	for i, value := range c.Clips.OnEvent {
+		if _, err := tuya.ParseEventType(value); err != nil {
+			invalid(fmt.Sprintf("clips.onEvent[%d]", i), "%v", err)
+		}
+	}
+	for i, value := range c.Events.Webhooks {
+		if _, err := events.ParseWebhook(value); err != nil {
+			invalid(fmt.Sprintf("events.webhooks[%d]", i), "%v", err)
+		}
+	}
+
+	if c.PathTemplate != "" {
+		if err := storage.ValidatePathTemplate(c.PathTemplate); err != nil {
+			invalid("pathTemplate", "%v", err)
+		}
+	}
+
+	names := make([]string, 0, len(c.Cameras))
+	for name := range c.Cameras {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	paths := make(map[string]string)
+	for _, name := range names {
+		camera := c.Cameras[name]
+		key := "cameras." + name
+		if camera.Resolution != "" && camera.Resolution != "hd" && camera.Resolution != "sd" {
+			invalid(key+".resolution", "%q is neither hd nor sd", camera.Resolution)
+		}
+		if camera.GOPCache != nil && *camera.GOPCache < 0 {
+			invalid(key+".gopCache", "must not be negative")
+		}
+
+		if camera.Path == "" {
+			continue
+		}
+		path := storage.CleanPath(camera.Path)
+		if err := storage.ValidatePath(path); err != nil {
+			invalid(key+".path", "%v", err)
+		} else if other, exists := paths[path]; exists {
+			invalid(key+".path", "%s is already the path of %s", path, other)
+		}
+		paths[path] = name
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	source := "config"
+	if c.Path != "" {
+		source = c.Path
+	}
+	return fmt.Errorf("invalid %s:\n%w", source, errors.Join(errs...))
+}
+
+// ResolveDataDir returns the data directory of the flag, $TUYA_IPC_DATA_DIR or the
+// config. Without any, .tuya-data in the working directory is used if it
+// exists, the user's data directory otherwise.
+func (c *Config) ResolveDataDir(flag string) (string, error) {
+	for _, dir := range []string{flag, os.Getenv(DataDirEnv), c.DataDir} {
+		if dir != "" {
+			return filepath.Abs(dir)
+		}
+	}
+
+	if cwd, err := os.Getwd(); err == nil {
+		legacy := filepath.Join(cwd, legacyDataDir)
+		if info, err := os.Stat(legacy); err == nil && info.IsDir() {
+			return legacy, nil
+		}
+	}
+
+	return defaultDataDir()
+}
+
+// defaultDataDir follows the XDG base directories on Unix
+func defaultDataDir() (string, error) {
+	if dir := os.Getenv("XDG_DATA_HOME"); dir != "" && filepath.IsAbs(dir) {
+		return filepath.Join(dir, appName), nil
+	}
+
+	switch runtime.GOOS {
+	case "windows", "darwin", "ios", "plan9":
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(dir, appName), nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "share", appName), nil
+}
+
+// CameraOverrides returns the camera settings for the storage manager
+func (c *Config) CameraOverrides() map[string]storage.CameraOverride {
+	overrides := make(map[string]storage.CameraOverride, len(c.Cameras))
+	for name, camera := range c.Cameras {
+		overrides[name] = storage.CameraOverride{
+			Path:       camera.Path,
+			Resolution: camera.Resolution,
+			Disabled:   camera.Enabled != nil && !*camera.Enabled,
+			NoAudio:    camera.Audio != nil && !*camera.Audio,
+		}
+	}
+	return overrides
+}
+
+// CameraGOPCacheSizes returns the GOP cache sizes in MiB of the cameras that
+// set one, nil without any
+func (c *Config) CameraGOPCacheSizes() map[string]int {
+	var sizes map[string]int
+	for name, camera := range c.Cameras {
+		if camera.GOPCache == nil {
+			continue
+		}
+		if sizes == nil {
+			sizes = make(map[string]int)
+		}
+		sizes[name] = *camera.GOPCache
+	}
+	return sizes
+}
+
+func isLogLevel(level string) bool {
+	switch level {
+	case "trace", "debug", "info", "warn", "error":
+		return true
+	}
+	return false
+}
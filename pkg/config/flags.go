@@ -0,0 +1,58 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// ApplyFlags sets the flags that weren't given on the command line to their
+// config values. Values are keyed by flag name, zero values are skipped.
+func ApplyFlags(flags *pflag.FlagSet, values map[string]any) error {
+	for name, value := range values {
+		flag := flags.Lookup(name)
+		if flag == nil {
+			return fmt.Errorf("unknown flag %s", name)
+		}
+		if flag.Changed {
+			continue
+		}
+
+		v := reflect.ValueOf(value)
+		if !v.IsValid() || v.IsZero() {
+			continue
+		}
+		if v.Kind() == reflect.Pointer {
+			v = v.Elem()
+		}
+
+		var err error
+		switch value := v.Interface().(type) {
+		case []string:
+			sliceValue, ok := flag.Value.(pflag.SliceValue)
+			if !ok {
+				return fmt.Errorf("flag %s isn't a list", name)
+			}
+			err = sliceValue.Replace(value)
+		case map[string]int:
+			// In the key=value syntax of the flag
+			pairs := make([]string, 0, len(value))
+			for key, n := range value {
+				pairs = append(pairs, fmt.Sprintf("%s=%d", key, n))
+			}
+			sort.Strings(pairs)
+			err = flag.Value.Set(strings.Join(pairs, ","))
+		default:
+			err = flag.Value.Set(fmt.Sprint(v.Interface()))
+		}
+
+		if err != nil {
+			return fmt.Errorf("invalid config value for --%s: %v", name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,209 @@
+package control
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"tuya-ipc-terminal/pkg/core"
+)
+
+// Request is sent by a client as a single JSON line, the server answers with one Response line
+type Request struct {
+	Command string          `json:"command"`
+	Args    json.RawMessage `json:"args,omitempty"`
+}
+
+type Response struct {
+	OK    bool            `json:"ok"`
+	Error string          `json:"error,omitempty"`
+	Data  json.RawMessage `json:"data,omitempty"`
+}
+
+type HandlerFunc func(args json.RawMessage) (any, error)
+
+// ErrNotRunning is returned by Call when nothing listens on the socket
+var ErrNotRunning = errors.New("daemon is not running")
+
+const callTimeout = 10 * time.Second
+
+// Server serves control commands on a Unix socket
+type Server struct {
+	path     string
+	listener net.Listener
+	handlers map[string]HandlerFunc
+	mutex    sync.RWMutex
+}
+
+func NewServer(path string) *Server {
+	return &Server{
+		path:     path,
+		handlers: make(map[string]HandlerFunc),
+	}
+}
+
+func (s *Server) Handle(command string, handler HandlerFunc) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.handlers[command] = handler
+}
+
+// Listen creates the socket, a stale socket left by a crashed process is replaced
+func (s *Server) Listen() error {
+	if _, err := os.Stat(s.path); err == nil {
+		if err := Call(s.path, "ping", nil, nil); err == nil {
+			return fmt.Errorf("another instance is listening on %s", s.path)
+		}
+		os.Remove(s.path)
+	}
+
+	listener, err := listenPrivate(s.path)
+	if err != nil {
+		return err
+	}
+
+	s.listener = listener
+	s.Handle("ping", func(json.RawMessage) (any, error) { return "pong", nil })
+
+	go s.serve()
+
+	return nil
+}
+
+// listenPrivate creates the socket in a new directory only the owner can
+// enter and moves it to path once its permissions are set, so nobody else can
+// connect in between
+func listenPrivate(path string) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".control-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create control socket: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	private := filepath.Join(dir, "sock")
+	listener, err := net.Listen("unix", private)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on control socket: %v", err)
+	}
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+
+	// Only the owner may control the daemon
+	if err := os.Chmod(private, 0600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to set control socket permissions: %v", err)
+	}
+
+	if err := os.Rename(private, path); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to create control socket: %v", err)
+	}
+
+	return listener, nil
+}
+
+func (s *Server) Close() error {
+	if s.listener == nil {
+		return nil
+	}
+
+	err := s.listener.Close()
+	os.Remove(s.path)
+	return err
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go s.handleConn(conn)
+	}
+}
+
+func (s *Server) handleConn(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(callTimeout))
+
+	var request Request
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&request); err != nil {
+		s.writeResponse(conn, nil, fmt.Errorf("invalid request: %v", err))
+		return
+	}
+
+	s.mutex.RLock()
+	handler, exists := s.handlers[request.Command]
+	s.mutex.RUnlock()
+
+	if !exists {
+		s.writeResponse(conn, nil, fmt.Errorf("unknown command: %s", request.Command))
+		return
+	}
+
+	core.Logger.Trace().Msgf("Control command: %s", request.Command)
+
+	result, err := handler(request.Args)
+	s.writeResponse(conn, result, err)
+}
+
+func (s *Server) writeResponse(conn net.Conn, result any, err error) {
+	response := Response{OK: err == nil}
+
+	if err != nil {
+		response.Error = err.Error()
+	} else if result != nil {
+		data, err := json.Marshal(result)
+		if err != nil {
+			response = Response{Error: fmt.Sprintf("failed to encode result: %v", err)}
+		} else {
+			response.Data = data
+		}
+	}
+
+	json.NewEncoder(conn).Encode(response)
+}
+
+// Call sends a command to the daemon at path and decodes the result into result (if not nil)
+func Call(path, command string, args any, result any) error {
+	conn, err := net.DialTimeout("unix", path, 2*time.Second)
+	if err != nil {
+		return ErrNotRunning
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(callTimeout))
+
+	request := Request{Command: command}
+	if args != nil {
+		if request.Args, err = json.Marshal(args); err != nil {
+			return fmt.Errorf("failed to encode arguments: %v", err)
+		}
+	}
+
+	if err := json.NewEncoder(conn).Encode(request); err != nil {
+		return fmt.Errorf("failed to send command: %v", err)
+	}
+
+	var response Response
+	if err := json.NewDecoder(conn).Decode(&response); err != nil {
+		return fmt.Errorf("failed to read response: %v", err)
+	}
+
+	if !response.OK {
+		return errors.New(response.Error)
+	}
+
+	if result != nil && response.Data != nil {
+		if err := json.Unmarshal(response.Data, result); err != nil {
+			return fmt.Errorf("failed to decode response: %v", err)
+		}
+	}
+
+	return nil
+}
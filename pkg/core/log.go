@@ -3,6 +3,7 @@
 package core
 
 import (
+	"fmt"
 	"io"
 	"os"
 	"sync"
@@ -45,6 +46,27 @@ func InitLogger() zerolog.Logger {
 	return Logger
 }
 
+// ConfigureLogger sets the level and the format, console or json, empty
+// values keep the current ones
+func ConfigureLogger(level, format string) error {
+	if format == "json" {
+		Logger = zerolog.New(zerolog.MultiLevelWriter(os.Stdout, MemoryLog)).
+			Level(Logger.GetLevel()).With().Timestamp().Logger()
+	} else if format != "" && format != "console" {
+		return fmt.Errorf("unknown log format: %s", format)
+	}
+
+	if level != "" {
+		lvl, err := zerolog.ParseLevel(level)
+		if err != nil || lvl == zerolog.NoLevel {
+			return fmt.Errorf("unknown log level: %s", level)
+		}
+		Logger = Logger.Level(lvl)
+	}
+
+	return nil
+}
+
 func newBuffer() *circularBuffer {
 	b := &circularBuffer{chunks: make([][]byte, 0, chunkCount)}
 	// create first chunk
@@ -0,0 +1,182 @@
+package discovery
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"tuya-ipc-terminal/pkg/storage"
+	"tuya-ipc-terminal/pkg/tuya"
+)
+
+// UserResult is the outcome of refreshing the cameras of one account
+type UserResult struct {
+	UserKey string `json:"userKey"`
+	Email   string `json:"email"`
+	Region  string `json:"region"`
+	Cameras int    `json:"cameras"`
+	Error   string `json:"error,omitempty"`
+}
+
+// RefreshUser discovers the cameras of a user and stores them in the registry
+func RefreshUser(storageManager *storage.StorageManager, user *storage.UserSession) (int, error) {
+	cameras, err := DiscoverCameras(storageManager, user)
+	if err != nil {
+		return 0, fmt.Errorf("failed to discover cameras: %v", err)
+	}
+
+	if err := storageManager.UpdateCamerasForUser(user.UserKey, cameras); err != nil {
+		return 0, fmt.Errorf("failed to save cameras: %v", err)
+	}
+
+	return len(cameras), nil
+}
+
+// RefreshAll refreshes the cameras of all authenticated users
+func RefreshAll(storageManager *storage.StorageManager) ([]UserResult, error) {
+	users, err := storageManager.ListUsers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %v", err)
+	}
+
+	results := make([]UserResult, 0, len(users))
+
+	for _, user := range users {
+		result := UserResult{
+			UserKey: user.UserKey,
+			Email:   user.Email,
+			Region:  user.Region,
+		}
+
+		count, err := RefreshUser(storageManager, &user)
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Cameras = count
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// DiscoverCameras lists the cameras of the user's homes and shared homes
+func DiscoverCameras(storageManager *storage.StorageManager, user *storage.UserSession) ([]storage.CameraInfo, error) {
+	if user.SessionData == nil {
+		return nil, errors.New("user has no valid session data")
+	}
+
+	httpClient := NewHTTPClient(user.SessionData)
+	if httpClient == nil {
+		return nil, errors.New("failed to create HTTP client")
+	}
+
+	// Test session validity first
+	_, err := tuya.GetAppInfo(httpClient, user.SessionData.ServerHost)
+	if err != nil {
+		return nil, fmt.Errorf("session is invalid: %v", err)
+	}
+
+	var devices []device
+
+	// Get home list
+	homes, _ := tuya.GetHomeList(httpClient, user.SessionData.ServerHost)
+	if homes != nil && len(homes.Result) > 0 {
+		for _, home := range homes.Result {
+			// Get room list with devices
+			roomList, err := tuya.GetRoomList(httpClient, user.SessionData.ServerHost, strconv.Itoa(home.Gid))
+			if err != nil {
+				continue // Skip this home if we can't get rooms
+			}
+
+			// Extract cameras from rooms
+			for _, room := range roomList.Result {
+				for _, d := range room.DeviceList {
+					// Check if device is a camera (sp = smart camera, dghsxj = another camera type)
+					if (d.Category == "sp" || d.Category == "dghsxj") && !containsDevice(devices, d.DeviceId) {
+						devices = append(devices, device{Device: d, home: home.Name, room: room.RoomName})
+					}
+				}
+			}
+		}
+	}
+
+	// Get shared home list
+	sharedHomes, _ := tuya.GetSharedHomeList(httpClient, user.SessionData.ServerHost)
+	if sharedHomes != nil && len(sharedHomes.Result.SecurityWebCShareInfoList) > 0 {
+
+		// Extract cameras from shared homes
+		for _, sharedHome := range sharedHomes.Result.SecurityWebCShareInfoList {
+			for _, d := range sharedHome.DeviceInfoList {
+				// Check if device is a camera (sp = smart camera, dghsxj = another camera type)
+				if (d.Category == "sp" || d.Category == "dghsxj") && !containsDevice(devices, d.DeviceId) {
+					devices = append(devices, device{Device: d})
+				}
+			}
+		}
+	}
+
+	if len(devices) == 0 {
+		return []storage.CameraInfo{}, nil
+	}
+
+	var allCameras []storage.CameraInfo
+
+	for _, device := range devices {
+		webrtcConfig, err := tuya.GetWebRTCConfig(httpClient, user.SessionData.ServerHost, device.DeviceId)
+		if err != nil {
+			continue // Skip if we can't get WebRTC config
+		}
+
+		// The path is assigned when the cameras are saved
+		camera := storage.CameraInfo{
+			UserKey:    user.UserKey,
+			DeviceID:   device.DeviceId,
+			DeviceName: device.DeviceName,
+			Category:   device.Category,
+			ProductID:  device.ProductId,
+			UUID:       device.Uuid,
+			Skill:      webrtcConfig.Result.Skill,
+			Home:       device.home,
+			Room:       device.room,
+		}
+
+		allCameras = append(allCameras, camera)
+	}
+
+	return allCameras, nil
+}
+
+// NewHTTPClient returns a client carrying the cookies of a session, its jar
+// is a *tuya.CookieJar
+func NewHTTPClient(session *tuya.SessionData) *http.Client {
+	jar, err := tuya.NewCookieJar(session)
+	if err != nil {
+		return nil
+	}
+
+	return &http.Client{
+		Timeout: 30 * time.Second,
+		Jar:     jar,
+	}
+}
+
+// device is a camera with the home and room it was found in, shared devices
+// have neither
+type device struct {
+	tuya.Device
+	home string
+	room string
+}
+
+func containsDevice(devices []device, deviceID string) bool {
+	for _, device := range devices {
+		if device.DeviceId == deviceID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+// Package events delivers camera notifications from Tuya MQTT to webhooks and
+// in-process subscribers
+package events
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"tuya-ipc-terminal/pkg/core"
+	"tuya-ipc-terminal/pkg/metrics"
+	"tuya-ipc-terminal/pkg/tuya"
+)
+
+// Events queued per subscriber before new ones are dropped
+const subscriberBuffer = 64
+
+var eventsReceived = metrics.NewCounter("tuya_ipc_events_total",
+	"Camera and account events by type", "type")
+
+// Event is a camera event or, with User instead of the camera, an account event
+type Event struct {
+	Type       tuya.EventType `json:"type"`
+	Camera     string         `json:"camera,omitempty"` // RTSP path
+	CameraName string         `json:"cameraName,omitempty"`
+	DeviceID   string         `json:"deviceId,omitempty"`
+	User       string         `json:"user,omitempty"`
+	Time       time.Time      `json:"time"`
+}
+
+// Bus passes every published event to all subscribers. Slow subscribers lose
+// events, publishers never block.
+type Bus struct {
+	subscribers map[*Subscription]struct{}
+	mutex       sync.Mutex
+}
+
+type Subscription struct {
+	C     <-chan Event
+	c     chan Event
+	types []tuya.EventType
+	bus   *Bus
+}
+
+func NewBus() *Bus {
+	return &Bus{subscribers: make(map[*Subscription]struct{})}
+}
+
+// Subscribe receives events of the types, all events without types
+func (b *Bus) Subscribe(types ...tuya.EventType) *Subscription {
+	c := make(chan Event, subscriberBuffer)
+	s := &Subscription{C: c, c: c, types: types, bus: b}
+
+	b.mutex.Lock()
+	b.subscribers[s] = struct{}{}
+	b.mutex.Unlock()
+
+	return s
+}
+
+// Close ends the subscription and closes C
+func (s *Subscription) Close() {
+	s.bus.mutex.Lock()
+	defer s.bus.mutex.Unlock()
+
+	if _, ok := s.bus.subscribers[s]; ok {
+		delete(s.bus.subscribers, s)
+		close(s.c)
+	}
+}
+
+func (b *Bus) Publish(event Event) {
+	eventsReceived.Inc(string(event.Type))
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for s := range b.subscribers {
+		if len(s.types) > 0 && !slices.Contains(s.types, event.Type) {
+			continue
+		}
+
+		select {
+		case s.c <- event:
+		default:
+			core.Logger.Warn().Msgf("Event subscriber is too slow, dropped %s event of %s", event.Type, event.CameraName)
+		}
+	}
+}
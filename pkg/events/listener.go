@@ -0,0 +1,171 @@
+package events
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"tuya-ipc-terminal/pkg/core"
+	"tuya-ipc-terminal/pkg/discovery"
+	"tuya-ipc-terminal/pkg/storage"
+	"tuya-ipc-terminal/pkg/tuya"
+)
+
+const (
+	connectionCheckInterval = 10 * time.Second
+	minReconnectDelay       = 5 * time.Second
+	maxReconnectDelay       = 5 * time.Minute
+
+	// Repeated reports of one event, e.g. the doorbell DP and its picture
+	duplicateWindow = 5 * time.Second
+)
+
+// Listener keeps an MQTT connection per account subscribed to the events of
+// its cameras and publishes them on the bus
+type Listener struct {
+	storageManager *storage.StorageManager
+	bus            *Bus
+
+	lastEvents map[string]time.Time // Device ID and type -> time
+	mutex      sync.Mutex
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewListener(storageManager *storage.StorageManager, bus *Bus) *Listener {
+	return &Listener{
+		storageManager: storageManager,
+		bus:            bus,
+		lastEvents:     make(map[string]time.Time),
+		stop:           make(chan struct{}),
+	}
+}
+
+// Start listens to all accounts with cameras, connections are retried in
+// the background
+func (l *Listener) Start() error {
+	users, err := l.storageManager.ListUsers()
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		cameras, err := l.storageManager.GetCamerasForUser(user.UserKey)
+		if err != nil {
+			return err
+		}
+		if len(cameras) == 0 {
+			continue
+		}
+
+		l.wg.Add(1)
+		go l.run(user, cameras)
+	}
+
+	return nil
+}
+
+func (l *Listener) Close() {
+	close(l.stop)
+	l.wg.Wait()
+}
+
+func (l *Listener) run(user storage.UserSession, cameras []storage.CameraInfo) {
+	defer l.wg.Done()
+
+	delay := minReconnectDelay
+
+	for {
+		client, err := l.connect(&user, cameras)
+		if err != nil {
+			core.Logger.Error().Err(err).Msgf("Event listener for %s failed, retrying in %s", user.UserKey, delay)
+		} else {
+			core.Logger.Info().Msgf("Listening to events of %d camera(s) of %s", len(cameras), user.UserKey)
+			delay = minReconnectDelay
+
+			l.wait(client)
+			client.Stop()
+
+			core.Logger.Warn().Msgf("Event listener for %s lost its connection, reconnecting in %s", user.UserKey, delay)
+		}
+
+		select {
+		case <-l.stop:
+			return
+		case <-time.After(delay):
+		}
+
+		delay = min(delay*2, maxReconnectDelay)
+	}
+}
+
+func (l *Listener) connect(user *storage.UserSession, cameras []storage.CameraInfo) (*tuya.MQTTClient, error) {
+	httpClient := discovery.NewHTTPClient(user.SessionData)
+	if httpClient == nil {
+		return nil, errors.New("failed to create HTTP client")
+	}
+
+	client, err := tuya.ConnectMQTT(httpClient, user.SessionData)
+	if err != nil {
+		return nil, err
+	}
+
+	byDevice := make(map[string]storage.CameraInfo, len(cameras))
+	deviceIds := make([]string, 0, len(cameras))
+	for _, camera := range cameras {
+		byDevice[camera.DeviceID] = camera
+		deviceIds = append(deviceIds, camera.DeviceID)
+	}
+
+	err = client.SubscribeEvents(deviceIds, func(event tuya.DeviceEvent) {
+		if camera, ok := byDevice[event.DeviceID]; ok {
+			l.publish(&camera, event)
+		}
+	})
+	if err != nil {
+		client.Stop()
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// wait returns when the connection is gone or the listener stops
+func (l *Listener) wait(client *tuya.MQTTClient) {
+	ticker := time.NewTicker(connectionCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			if !client.IsConnected() {
+				return
+			}
+		}
+	}
+}
+
+func (l *Listener) publish(camera *storage.CameraInfo, event tuya.DeviceEvent) {
+	key := event.DeviceID + "/" + string(event.Type)
+
+	l.mutex.Lock()
+	if last, ok := l.lastEvents[key]; ok && event.Time.Sub(last) < duplicateWindow {
+		l.mutex.Unlock()
+		return
+	}
+	l.lastEvents[key] = event.Time
+	l.mutex.Unlock()
+
+	core.Logger.Info().Msgf("Event %s on camera %s", event.Type, camera.DeviceName)
+
+	l.bus.Publish(Event{
+		Type:       event.Type,
+		Camera:     camera.RTSPPath,
+		CameraName: camera.DeviceName,
+		DeviceID:   camera.DeviceID,
+		Time:       event.Time,
+	})
+}
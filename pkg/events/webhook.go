@@ -0,0 +1,162 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"tuya-ipc-terminal/pkg/core"
+	"tuya-ipc-terminal/pkg/metrics"
+	"tuya-ipc-terminal/pkg/tuya"
+)
+
+const (
+	webhookTimeout     = 10 * time.Second
+	webhookAttempts    = 5
+	webhookRetryDelay  = time.Second // Doubled after every failed attempt
+	webhookContentType = "application/json"
+)
+
+var webhookErrors = metrics.NewCounter("tuya_ipc_webhook_errors_total",
+	"Events that couldn't be delivered to a webhook")
+
+type Webhook struct {
+	URL   string
+	Types []tuya.EventType // All events if empty
+}
+
+// ParseWebhook parses "[type,type=]url", e.g. "doorbell,person=https://example.com/hook"
+func ParseWebhook(value string) (Webhook, error) {
+	var webhook Webhook
+
+	if types, url, found := strings.Cut(value, "="); found && !strings.Contains(types, "://") {
+		for _, name := range strings.Split(types, ",") {
+			eventType, err := tuya.ParseEventType(strings.TrimSpace(name))
+			if err != nil {
+				return webhook, err
+			}
+			webhook.Types = append(webhook.Types, eventType)
+		}
+		value = url
+	}
+
+	if !strings.HasPrefix(value, "http://") && !strings.HasPrefix(value, "https://") {
+		return webhook, fmt.Errorf("invalid webhook URL: %s", value)
+	}
+
+	webhook.URL = value
+	return webhook, nil
+}
+
+// Dispatcher posts the events of the bus as JSON to webhooks. Each webhook
+// gets the events in order, failed deliveries are retried with backoff.
+type Dispatcher struct {
+	subscriptions []*Subscription
+	client        *http.Client
+	ctx           context.Context
+	cancel        context.CancelFunc
+	wg            sync.WaitGroup
+}
+
+func NewDispatcher(bus *Bus, webhooks []Webhook) *Dispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	d := &Dispatcher{
+		client: &http.Client{Timeout: webhookTimeout},
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	for _, webhook := range webhooks {
+		subscription := bus.Subscribe(webhook.Types...)
+		d.subscriptions = append(d.subscriptions, subscription)
+
+		d.wg.Add(1)
+		go d.run(webhook, subscription)
+	}
+
+	return d
+}
+
+// Close stops the deliveries, pending events are dropped
+func (d *Dispatcher) Close() {
+	d.cancel()
+	for _, subscription := range d.subscriptions {
+		subscription.Close()
+	}
+	d.wg.Wait()
+}
+
+func (d *Dispatcher) run(webhook Webhook, subscription *Subscription) {
+	defer d.wg.Done()
+
+	for event := range subscription.C {
+		if err := d.deliver(webhook.URL, event); err != nil {
+			if d.ctx.Err() != nil {
+				return
+			}
+
+			webhookErrors.Inc()
+			core.Logger.Error().Err(err).Msgf("Failed to deliver %s event of %s to %s", event.Type, event.CameraName, webhook.URL)
+		}
+	}
+}
+
+func (d *Dispatcher) deliver(url string, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	delay := webhookRetryDelay
+
+	for attempt := 1; ; attempt++ {
+		retry, err := d.post(url, body)
+		if err == nil {
+			core.Logger.Debug().Msgf("Delivered %s event of %s to %s", event.Type, event.CameraName, url)
+			return nil
+		}
+
+		if !retry || attempt == webhookAttempts {
+			return err
+		}
+
+		core.Logger.Debug().Err(err).Msgf("Webhook %s failed, retrying in %s", url, delay)
+
+		select {
+		case <-d.ctx.Done():
+			return d.ctx.Err()
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+	}
+}
+
+// post sends one attempt, client errors other than 408 and 429 aren't retried
+func (d *Dispatcher) post(url string, body []byte) (bool, error) {
+	request, err := http.NewRequestWithContext(d.ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", webhookContentType)
+
+	response, err := d.client.Do(request)
+	if err != nil {
+		return true, err
+	}
+	response.Body.Close()
+
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return false, nil
+	}
+
+	err = fmt.Errorf("webhook returned %s", response.Status)
+	retry := response.StatusCode >= 500 || response.StatusCode == http.StatusRequestTimeout || response.StatusCode == http.StatusTooManyRequests
+	return retry, err
+}
@@ -0,0 +1,82 @@
+package h264
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+const (
+	NALUTypeIFrame = 5
+	NALUTypeSEI    = 6
+	NALUTypeSPS    = 7
+	NALUTypePPS    = 8
+	NALUTypeAUD    = 9
+
+	NALUTypeSTAPA = 24 // RTP aggregation packet
+	NALUTypeFUA   = 28 // RTP fragmentation unit
+)
+
+func NALUType(nalu []byte) byte {
+	return nalu[0] & 0x1F
+}
+
+// PayloadNALUs returns the NAL units carried by a single RTP payload.
+// Fragmented units (FU-A) are not reassembled.
+func PayloadNALUs(payload []byte) [][]byte {
+	if len(payload) == 0 {
+		return nil
+	}
+
+	if NALUType(payload) != NALUTypeSTAPA {
+		return [][]byte{payload}
+	}
+
+	var nalus [][]byte
+	for b := payload[1:]; len(b) > 2; {
+		size := int(b[0])<<8 | int(b[1])
+		if size == 0 || len(b) < 2+size {
+			break
+		}
+		nalus = append(nalus, b[2:2+size])
+		b = b[2+size:]
+	}
+
+	return nalus
+}
+
+// IsKeyframe reports whether the RTP payload starts or contains an IDR frame or an SPS
+func IsKeyframe(payload []byte) bool {
+	if len(payload) < 2 {
+		return false
+	}
+
+	switch NALUType(payload) {
+	case NALUTypeFUA:
+		// Start of a fragmented IDR
+		return payload[1]&0x80 != 0 && payload[1]&0x1F == NALUTypeIFrame
+	case NALUTypeSTAPA:
+		for _, nalu := range PayloadNALUs(payload) {
+			if t := NALUType(nalu); t == NALUTypeIFrame || t == NALUTypeSPS {
+				return true
+			}
+		}
+		return false
+	default:
+		t := NALUType(payload)
+		return t == NALUTypeIFrame || t == NALUTypeSPS
+	}
+}
+
+// ProfileLevelID returns the profile-level-id fmtp value of an SPS (profile_idc, constraints, level_idc)
+func ProfileLevelID(sps []byte) string {
+	if len(sps) < 4 {
+		return ""
+	}
+	return strings.ToUpper(hex.EncodeToString(sps[1:4]))
+}
+
+// SpropParameterSets returns the sprop-parameter-sets fmtp value
+func SpropParameterSets(sps, pps []byte) string {
+	return base64.StdEncoding.EncodeToString(sps) + "," + base64.StdEncoding.EncodeToString(pps)
+}
@@ -0,0 +1,135 @@
+package h264
+
+import "tuya-ipc-terminal/pkg/utils"
+
+type SPS struct {
+	ProfileIDC uint8
+	LevelIDC   uint8
+	Width      int
+	Height     int
+}
+
+// DecodeSPS reads the picture size from an SPS NAL unit
+func DecodeSPS(nalu []byte) (*SPS, error) {
+	r := utils.NewBitReader(utils.RemoveEmulationPrevention(nalu))
+	r.Skip(8) // NAL header
+
+	sps := &SPS{}
+	sps.ProfileIDC = uint8(r.ReadBits(8))
+	r.Skip(8) // Constraint flags
+	sps.LevelIDC = uint8(r.ReadBits(8))
+	r.ReadUE() // seq_parameter_set_id
+
+	chromaFormatIDC := uint32(1)
+
+	switch sps.ProfileIDC {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		chromaFormatIDC = r.ReadUE()
+		if chromaFormatIDC == 3 {
+			r.Skip(1) // separate_colour_plane_flag
+		}
+		r.ReadUE() // bit_depth_luma_minus8
+		r.ReadUE() // bit_depth_chroma_minus8
+		r.Skip(1)  // qpprime_y_zero_transform_bypass_flag
+
+		if r.ReadFlag() { // seq_scaling_matrix_present_flag
+			lists := 8
+			if chromaFormatIDC == 3 {
+				lists = 12
+			}
+			for i := 0; i < lists; i++ {
+				if !r.ReadFlag() {
+					continue
+				}
+				size := 16
+				if i >= 6 {
+					size = 64
+				}
+				skipScalingList(r, size)
+			}
+		}
+	}
+
+	r.ReadUE() // log2_max_frame_num_minus4
+
+	switch r.ReadUE() { // pic_order_cnt_type
+	case 0:
+		r.ReadUE() // log2_max_pic_order_cnt_lsb_minus4
+	case 1:
+		r.Skip(1)  // delta_pic_order_always_zero_flag
+		r.ReadSE() // offset_for_non_ref_pic
+		r.ReadSE() // offset_for_top_to_bottom_field
+		for i := r.ReadUE(); i > 0 && r.Err == nil; i-- {
+			r.ReadSE()
+		}
+	}
+
+	r.ReadUE() // max_num_ref_frames
+	r.Skip(1)  // gaps_in_frame_num_value_allowed_flag
+
+	widthInMBs := int(r.ReadUE()) + 1
+	heightInMapUnits := int(r.ReadUE()) + 1
+
+	frameMBsOnly := r.ReadFlag()
+	if !frameMBsOnly {
+		r.Skip(1) // mb_adaptive_frame_field_flag
+	}
+	r.Skip(1) // direct_8x8_inference_flag
+
+	fieldFactor := 1
+	if !frameMBsOnly {
+		fieldFactor = 2
+	}
+
+	sps.Width = widthInMBs * 16
+	sps.Height = heightInMapUnits * 16 * fieldFactor
+
+	if r.ReadFlag() { // frame_cropping_flag
+		cropUnitX, cropUnitY := 1, fieldFactor
+		switch chromaFormatIDC {
+		case 1:
+			cropUnitX, cropUnitY = 2, 2*fieldFactor
+		case 2:
+			cropUnitX = 2
+		}
+
+		left, right := int(r.ReadUE()), int(r.ReadUE())
+		top, bottom := int(r.ReadUE()), int(r.ReadUE())
+
+		sps.Width -= (left + right) * cropUnitX
+		sps.Height -= (top + bottom) * cropUnitY
+	}
+
+	if r.Err != nil {
+		return nil, r.Err
+	}
+
+	return sps, nil
+}
+
+func skipScalingList(r *utils.BitReader, size int) {
+	last, next := int32(8), int32(8)
+	for i := 0; i < size && r.Err == nil; i++ {
+		if next != 0 {
+			next = (last + r.ReadSE() + 256) % 256
+		}
+		if next != 0 {
+			last = next
+		}
+	}
+}
+
+// AVCDecoderConfig returns an avcC record with 4 byte NAL unit lengths
+func AVCDecoderConfig(sps, pps []byte) []byte {
+	b := []byte{1, sps[1], sps[2], sps[3], 0xFF, 0xE1}
+	b = append(b, byte(len(sps)>>8), byte(len(sps)))
+	b = append(b, sps...)
+	b = append(b, 1, byte(len(pps)>>8), byte(len(pps)))
+	b = append(b, pps...)
+	return b
+}
+
+// CodecString returns the RFC 6381 codecs value, e.g. avc1.64001F
+func CodecString(sps []byte) string {
+	return "avc1." + ProfileLevelID(sps)
+}
@@ -0,0 +1,77 @@
+package h265
+
+const (
+	NALUTypeIDRWRADL = 19
+	NALUTypeIDRNLP   = 20
+	NALUTypeCRA      = 21
+	NALUTypeVPS      = 32
+	NALUTypeSPS      = 33
+	NALUTypePPS      = 34
+
+	NALUTypeAP = 48 // RTP aggregation packet
+	NALUTypeFU = 49 // RTP fragmentation unit
+)
+
+func NALUType(nalu []byte) byte {
+	return (nalu[0] >> 1) & 0x3F
+}
+
+// PayloadNALUs returns the NAL units carried by a single RTP payload (no DONL,
+// sprop-max-don-diff is never signaled). Fragmentation units are not reassembled.
+func PayloadNALUs(payload []byte) [][]byte {
+	if len(payload) < 2 {
+		return nil
+	}
+
+	if NALUType(payload) != NALUTypeAP {
+		return [][]byte{payload}
+	}
+
+	var nalus [][]byte
+	for b := payload[2:]; len(b) > 2; {
+		size := int(b[0])<<8 | int(b[1])
+		if size == 0 || len(b) < 2+size {
+			break
+		}
+		nalus = append(nalus, b[2:2+size])
+		b = b[2+size:]
+	}
+
+	return nalus
+}
+
+func isKeyframeType(t byte) bool {
+	return t == NALUTypeIDRWRADL || t == NALUTypeIDRNLP || t == NALUTypeCRA || t == NALUTypeVPS
+}
+
+// IsKeyframe reports whether the RTP payload starts or contains an IRAP frame or a VPS
+func IsKeyframe(payload []byte) bool {
+	if len(payload) < 3 {
+		return false
+	}
+
+	switch NALUType(payload) {
+	case NALUTypeFU:
+		// Start of a fragmented IRAP
+		return payload[2]&0x80 != 0 && isKeyframeType(payload[2]&0x3F)
+	case NALUTypeAP:
+		for _, nalu := range PayloadNALUs(payload) {
+			if isKeyframeType(NALUType(nalu)) {
+				return true
+			}
+		}
+		return false
+	default:
+		return isKeyframeType(NALUType(payload))
+	}
+}
+
+// ProfileID returns general_profile_idc of an SPS (1 = Main, 2 = Main 10), 0 if too short
+func ProfileID(sps []byte) byte {
+	// 2 bytes NAL header, 4 bits vps id, 3 bits max sub layers, 1 bit nesting,
+	// then profile_tier_level starts with 2 bits space, 1 bit tier, 5 bits profile
+	if len(sps) < 4 {
+		return 0
+	}
+	return sps[3] & 0x1F
+}
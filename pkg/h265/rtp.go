@@ -0,0 +1,74 @@
+package h265
+
+import "github.com/pion/rtp"
+
+// Depacketizer reassembles access units from RTP (single NAL unit, AP and FU packets)
+type Depacketizer struct {
+	onAccessUnit func(nalus [][]byte, timestamp uint32)
+
+	nalus     [][]byte
+	timestamp uint32
+	fragment  []byte
+	lastSeq   uint16
+	started   bool
+}
+
+func NewDepacketizer(onAccessUnit func(nalus [][]byte, timestamp uint32)) *Depacketizer {
+	return &Depacketizer{onAccessUnit: onAccessUnit}
+}
+
+func (d *Depacketizer) Push(packet *rtp.Packet) {
+	payload := packet.Payload
+	if len(payload) < 2 {
+		return
+	}
+
+	// A lost packet breaks the fragment in progress
+	if d.started && packet.SequenceNumber != d.lastSeq+1 {
+		d.fragment = nil
+	}
+	d.lastSeq = packet.SequenceNumber
+
+	if d.started && packet.Timestamp != d.timestamp {
+		d.flush()
+	}
+	d.started = true
+	d.timestamp = packet.Timestamp
+
+	switch NALUType(payload) {
+	case NALUTypeAP:
+		for _, nalu := range PayloadNALUs(payload) {
+			d.nalus = append(d.nalus, append([]byte(nil), nalu...))
+		}
+	case NALUTypeFU:
+		if len(payload) < 3 {
+			return
+		}
+
+		header := payload[2]
+		if header&0x80 != 0 {
+			d.fragment = append([]byte{payload[0]&0x81 | (header&0x3F)<<1, payload[1]}, payload[3:]...)
+		} else if d.fragment != nil {
+			d.fragment = append(d.fragment, payload[3:]...)
+		}
+
+		if header&0x40 != 0 && d.fragment != nil {
+			d.nalus = append(d.nalus, d.fragment)
+			d.fragment = nil
+		}
+	default:
+		d.nalus = append(d.nalus, append([]byte(nil), payload...))
+	}
+
+	if packet.Marker {
+		d.flush()
+	}
+}
+
+func (d *Depacketizer) flush() {
+	if len(d.nalus) > 0 {
+		d.onAccessUnit(d.nalus, d.timestamp)
+	}
+	d.nalus = nil
+	d.fragment = nil
+}
@@ -0,0 +1,158 @@
+package h265
+
+import (
+	"fmt"
+	"strings"
+
+	"tuya-ipc-terminal/pkg/utils"
+)
+
+type SPS struct {
+	ProfileTierLevel   [12]byte // General profile_tier_level as stored in hvcC
+	MaxSubLayersMinus1 uint8
+	TemporalIDNesting  bool
+	ChromaFormatIDC    uint8
+	BitDepthLuma       uint8
+	BitDepthChroma     uint8
+	Width              int
+	Height             int
+}
+
+// DecodeSPS reads the fields of an SPS NAL unit needed for the hvcC record
+func DecodeSPS(nalu []byte) (*SPS, error) {
+	rbsp := utils.RemoveEmulationPrevention(nalu)
+	if len(rbsp) < 15 {
+		return nil, utils.ErrBitstreamEnd
+	}
+
+	r := utils.NewBitReader(rbsp)
+	r.Skip(16) // NAL header
+	r.Skip(4)  // sps_video_parameter_set_id
+
+	sps := &SPS{}
+	sps.MaxSubLayersMinus1 = uint8(r.ReadBits(3))
+	sps.TemporalIDNesting = r.ReadFlag()
+
+	// General profile, tier and level are byte aligned
+	copy(sps.ProfileTierLevel[:], rbsp[3:15])
+	r.Skip(12 * 8)
+
+	var profilePresent, levelPresent [8]bool
+	for i := 0; i < int(sps.MaxSubLayersMinus1); i++ {
+		profilePresent[i] = r.ReadFlag()
+		levelPresent[i] = r.ReadFlag()
+	}
+	if sps.MaxSubLayersMinus1 > 0 {
+		r.Skip(2 * (8 - int(sps.MaxSubLayersMinus1)))
+	}
+	for i := 0; i < int(sps.MaxSubLayersMinus1); i++ {
+		if profilePresent[i] {
+			r.Skip(88)
+		}
+		if levelPresent[i] {
+			r.Skip(8)
+		}
+	}
+
+	r.ReadUE() // sps_seq_parameter_set_id
+
+	sps.ChromaFormatIDC = uint8(r.ReadUE())
+	if sps.ChromaFormatIDC == 3 {
+		r.Skip(1) // separate_colour_plane_flag
+	}
+
+	sps.Width = int(r.ReadUE())
+	sps.Height = int(r.ReadUE())
+
+	if r.ReadFlag() { // conformance_window_flag
+		subWidth, subHeight := 1, 1
+		switch sps.ChromaFormatIDC {
+		case 1:
+			subWidth, subHeight = 2, 2
+		case 2:
+			subWidth = 2
+		}
+
+		left, right := int(r.ReadUE()), int(r.ReadUE())
+		top, bottom := int(r.ReadUE()), int(r.ReadUE())
+
+		sps.Width -= (left + right) * subWidth
+		sps.Height -= (top + bottom) * subHeight
+	}
+
+	sps.BitDepthLuma = uint8(r.ReadUE()) + 8
+	sps.BitDepthChroma = uint8(r.ReadUE()) + 8
+
+	if r.Err != nil {
+		return nil, r.Err
+	}
+
+	return sps, nil
+}
+
+// HEVCDecoderConfig returns an hvcC record with 4 byte NAL unit lengths
+func HEVCDecoderConfig(sps *SPS, vps, spsNALU, pps []byte) []byte {
+	b := []byte{1}
+	b = append(b, sps.ProfileTierLevel[:]...)
+	b = append(b,
+		0xF0, 0x00, // min_spatial_segmentation_idc
+		0xFC, // parallelismType
+		0xFC|sps.ChromaFormatIDC&0x03,
+		0xF8|(sps.BitDepthLuma-8)&0x07,
+		0xF8|(sps.BitDepthChroma-8)&0x07,
+		0, 0, // avgFrameRate
+	)
+
+	// constantFrameRate 0, numTemporalLayers, temporalIdNested, lengthSizeMinusOne 3
+	flags := (sps.MaxSubLayersMinus1+1)<<3 | 0x03
+	if sps.TemporalIDNesting {
+		flags |= 0x04
+	}
+	b = append(b, flags, 3)
+
+	for _, nalu := range [][]byte{vps, spsNALU, pps} {
+		b = append(b, 0x80|NALUType(nalu), 0, 1, byte(len(nalu)>>8), byte(len(nalu)))
+		b = append(b, nalu...)
+	}
+
+	return b
+}
+
+// CodecString returns the RFC 6381 codecs value, e.g. hvc1.1.6.L93.B0
+func CodecString(sps *SPS) string {
+	ptl := sps.ProfileTierLevel
+
+	space := ""
+	if s := ptl[0] >> 6; s > 0 {
+		space = string(rune('A' + s - 1))
+	}
+
+	// Compatibility flags in reverse bit order
+	compat := uint32(ptl[1])<<24 | uint32(ptl[2])<<16 | uint32(ptl[3])<<8 | uint32(ptl[4])
+	var reversed uint32
+	for i := 0; i < 32; i++ {
+		reversed |= (compat >> i & 1) << (31 - i)
+	}
+
+	tier := "L"
+	if ptl[0]&0x20 != 0 {
+		tier = "H"
+	}
+
+	codec := fmt.Sprintf("hvc1.%s%d.%X.%s%d", space, ptl[0]&0x1F, reversed, tier, ptl[11])
+
+	// Constraint bytes, trailing zero bytes omitted
+	constraints := ptl[5:11]
+	for len(constraints) > 0 && constraints[len(constraints)-1] == 0 {
+		constraints = constraints[:len(constraints)-1]
+	}
+	var parts []string
+	for _, c := range constraints {
+		parts = append(parts, fmt.Sprintf("%X", c))
+	}
+	if len(parts) > 0 {
+		codec += "." + strings.Join(parts, ".")
+	}
+
+	return codec
+}
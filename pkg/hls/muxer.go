@@ -0,0 +1,367 @@
+package hls
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+
+	"tuya-ipc-terminal/pkg/mp4"
+	"tuya-ipc-terminal/pkg/rtsp"
+)
+
+const (
+	clockRate = 90000
+	trackID   = 1
+
+	// Complete segments kept in the playlist
+	playlistSegments = 6
+	// Segments at the live edge listed with their parts in LL-HLS
+	partSegments = 3
+
+	maxBufferedPackets = 4096
+)
+
+var errMuxerClosed = errors.New("stream closed")
+
+type part struct {
+	data        []byte
+	duration    float64
+	independent bool
+}
+
+type segment struct {
+	msn      int
+	parts    []*part
+	duration float64
+	complete bool
+}
+
+func (s *segment) data() []byte {
+	var b []byte
+	for _, p := range s.parts {
+		b = append(b, p.data...)
+	}
+	return b
+}
+
+// muxer turns the camera's video RTP into fMP4 segments and parts held in memory
+type muxer struct {
+	lowLatency    bool
+	segmentTarget float64 // Seconds
+	partTarget    float64
+
+	sampler  *mp4.VideoSampler
+	buffered []*rtp.Packet // Until the codec is known
+	init     []byte
+	dts      uint64 // Decode time of the next sample
+
+	samples       []mp4.Sample // Of the part being built
+	partStart     uint64
+	partDuration  uint32
+	fragmentSeq   uint32
+	segments      []*segment // Last one is in progress
+	maxPart       float64
+	targetSeconds int
+
+	updated    chan struct{} // Closed and replaced whenever a part is added
+	closed     bool
+	lastAccess time.Time
+	mutex      sync.Mutex
+}
+
+func newMuxer(lowLatency bool, segmentDuration, partDuration time.Duration) *muxer {
+	return &muxer{
+		lowLatency:    lowLatency,
+		segmentTarget: segmentDuration.Seconds(),
+		partTarget:    partDuration.Seconds(),
+		updated:       make(chan struct{}),
+		lastAccess:    time.Now(),
+		targetSeconds: int(math.Ceil(segmentDuration.Seconds())),
+	}
+}
+
+// configure sets the codec once the stream's media info is known and
+// processes the packets buffered so far
+func (m *muxer) configure(info *rtsp.MediaInfo) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.sampler != nil {
+		return
+	}
+
+	m.sampler = mp4.NewVideoSampler(info.HEVC, trackID, info.VPS, info.SPS, info.PPS, m.addSample)
+
+	for _, packet := range m.buffered {
+		m.sampler.Push(packet)
+	}
+	m.buffered = nil
+}
+
+// WriteRTP implements rtsp.Viewer, audio is not muxed
+func (m *muxer) WriteRTP(packet *rtp.Packet, video bool) {
+	if !video {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.closed {
+		return
+	}
+
+	if m.sampler != nil {
+		m.sampler.Push(packet)
+		return
+	}
+
+	if len(m.buffered) < maxBufferedPackets {
+		clone := *packet
+		clone.Payload = append([]byte(nil), packet.Payload...)
+		m.buffered = append(m.buffered, &clone)
+	}
+}
+
+// Close implements rtsp.Viewer
+func (m *muxer) Close() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if !m.closed {
+		m.closed = true
+		m.notify()
+	}
+}
+
+func (m *muxer) isClosed() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.closed
+}
+
+func (m *muxer) touch() {
+	m.mutex.Lock()
+	m.lastAccess = time.Now()
+	m.mutex.Unlock()
+}
+
+func (m *muxer) idleSince() time.Time {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.lastAccess
+}
+
+// addSample is called by the sampler with the mutex held
+func (m *muxer) addSample(track *mp4.Track, sample mp4.Sample) {
+	// A player can't switch the init segment, a new session is needed for new parameter sets
+	if m.init == nil {
+		m.init = mp4.InitSegment(track)
+	}
+
+	current := m.current()
+	duration := sample.Duration
+
+	if sample.Keyframe && (current == nil || current.duration+m.partSeconds() >= m.segmentTarget) {
+		m.finishPart()
+		m.finishSegment()
+	} else if len(m.samples) > 0 && float64(m.partDuration+duration)/clockRate > m.partTarget {
+		m.finishPart()
+	}
+
+	// Nothing before the first keyframe
+	if m.current() == nil {
+		return
+	}
+
+	if len(m.samples) == 0 {
+		m.partStart = m.dts
+	}
+
+	m.samples = append(m.samples, sample)
+	m.partDuration += duration
+	m.dts += uint64(duration)
+}
+
+func (m *muxer) current() *segment {
+	if len(m.segments) == 0 {
+		return nil
+	}
+	return m.segments[len(m.segments)-1]
+}
+
+func (m *muxer) partSeconds() float64 {
+	return float64(m.partDuration) / clockRate
+}
+
+func (m *muxer) finishPart() {
+	current := m.current()
+	if current == nil || len(m.samples) == 0 {
+		return
+	}
+
+	m.fragmentSeq++
+
+	p := &part{
+		data:        mp4.Fragment(m.fragmentSeq, mp4.TrackFragment{TrackID: trackID, BaseTime: m.partStart, Samples: m.samples}),
+		duration:    m.partSeconds(),
+		independent: m.samples[0].Keyframe,
+	}
+
+	current.parts = append(current.parts, p)
+	current.duration += p.duration
+	m.maxPart = max(m.maxPart, p.duration)
+
+	m.samples = nil
+	m.partDuration = 0
+
+	m.notify()
+}
+
+func (m *muxer) finishSegment() {
+	msn := 0
+
+	if current := m.current(); current != nil {
+		current.complete = true
+		msn = current.msn + 1
+
+		m.targetSeconds = max(m.targetSeconds, int(math.Ceil(current.duration)))
+	}
+
+	m.segments = append(m.segments, &segment{msn: msn})
+
+	// Keep the window plus the segment in progress
+	if len(m.segments) > playlistSegments+1 {
+		m.segments = m.segments[len(m.segments)-playlistSegments-1:]
+	}
+
+	m.notify()
+}
+
+// notify must be called with the mutex held
+func (m *muxer) notify() {
+	close(m.updated)
+	m.updated = make(chan struct{})
+}
+
+// wait blocks until ready returns true (called with the mutex held) or the timeout expires
+func (m *muxer) wait(timeout time.Duration, ready func() bool) error {
+	deadline := time.After(timeout)
+
+	for {
+		m.mutex.Lock()
+		if m.closed {
+			m.mutex.Unlock()
+			return errMuxerClosed
+		}
+		if ready() {
+			m.mutex.Unlock()
+			return nil
+		}
+		updated := m.updated
+		m.mutex.Unlock()
+
+		select {
+		case <-updated:
+		case <-deadline:
+			return errors.New("timeout")
+		}
+	}
+}
+
+// hasPlaylist reports whether there is something to play, must be called with the mutex held
+func (m *muxer) hasPlaylist() bool {
+	for _, s := range m.segments {
+		if s.complete || (m.lowLatency && len(s.parts) > 0) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *muxer) ready() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.hasPlaylist()
+}
+
+// hasPart reports whether part of segment msn exists or was skipped, must be called with the mutex held
+func (m *muxer) hasPart(msn, partIndex int) bool {
+	current := m.current()
+	if current == nil {
+		return false
+	}
+	if current.msn > msn {
+		return true
+	}
+	return current.msn == msn && len(current.parts) > partIndex
+}
+
+func (m *muxer) segment(msn int) *segment {
+	for _, s := range m.segments {
+		if s.msn == msn {
+			return s
+		}
+	}
+	return nil
+}
+
+// playlist must be called with the mutex held
+func (m *muxer) playlist() string {
+	var b strings.Builder
+
+	var segments []*segment
+	for _, s := range m.segments {
+		if s.complete || m.lowLatency {
+			segments = append(segments, s)
+		}
+	}
+
+	version := 7
+	if m.lowLatency {
+		version = 9
+	}
+
+	b.WriteString("#EXTM3U\n")
+	fmt.Fprintf(&b, "#EXT-X-VERSION:%d\n", version)
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", m.targetSeconds)
+
+	if m.lowLatency {
+		partTarget := max(m.partTarget, m.maxPart)
+		fmt.Fprintf(&b, "#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=%.3f\n", 3*partTarget)
+		fmt.Fprintf(&b, "#EXT-X-PART-INF:PART-TARGET=%.3f\n", partTarget)
+	}
+
+	if len(segments) > 0 {
+		fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", segments[0].msn)
+	}
+
+	b.WriteString("#EXT-X-MAP:URI=\"init.mp4\"\n")
+
+	for i, s := range segments {
+		if m.lowLatency && i >= len(segments)-partSegments {
+			for j, p := range s.parts {
+				fmt.Fprintf(&b, "#EXT-X-PART:DURATION=%.3f,URI=\"part%d.%d.m4s\"", p.duration, s.msn, j)
+				if p.independent {
+					b.WriteString(",INDEPENDENT=YES")
+				}
+				b.WriteString("\n")
+			}
+		}
+
+		if s.complete {
+			fmt.Fprintf(&b, "#EXTINF:%.3f,\nseg%d.m4s\n", s.duration, s.msn)
+		}
+	}
+
+	if current := m.current(); m.lowLatency && current != nil {
+		fmt.Fprintf(&b, "#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"part%d.%d.m4s\"\n", current.msn, len(current.parts))
+	}
+
+	return b.String()
+}
@@ -0,0 +1,356 @@
+package hls
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"tuya-ipc-terminal/pkg/core"
+	"tuya-ipc-terminal/pkg/rtsp"
+)
+
+type Config struct {
+	// LowLatency enables LL-HLS partial segments and blocking playlist reloads
+	LowLatency bool
+
+	SegmentDuration time.Duration
+	PartDuration    time.Duration
+
+	// Sessions without requests for this long are detached from the camera
+	IdleTimeout time.Duration
+}
+
+const (
+	defaultSegmentDuration = 2 * time.Second
+	defaultPartDuration    = 500 * time.Millisecond
+	defaultIdleTimeout     = 30 * time.Second
+
+	mediaInfoTimeout = 30 * time.Second
+	blockTimeout     = 10 * time.Second
+)
+
+type session struct {
+	id     string
+	stream *rtsp.CameraStream
+	muxer  *muxer
+}
+
+// Server serves /hls/<camera path>[/sd]/index.m3u8 and the segments of it
+type Server struct {
+	rtspServer *rtsp.RTSPServer
+	config     Config
+	sessions   map[string]*session // Camera path and resolution -> session
+	closed     chan struct{}
+	mutex      sync.Mutex
+}
+
+func NewServer(rtspServer *rtsp.RTSPServer, config Config) *Server {
+	if config.SegmentDuration <= 0 {
+		config.SegmentDuration = defaultSegmentDuration
+	}
+	if config.PartDuration <= 0 {
+		config.PartDuration = defaultPartDuration
+	}
+	if !config.LowLatency {
+		// Parts are not announced, one per second keeps the fragment count low
+		config.PartDuration = max(config.PartDuration, time.Second)
+	}
+	if config.IdleTimeout <= 0 {
+		config.IdleTimeout = defaultIdleTimeout
+	}
+
+	s := &Server{
+		rtspServer: rtspServer,
+		config:     config,
+		sessions:   make(map[string]*session),
+		closed:     make(chan struct{}),
+	}
+
+	go s.janitor()
+
+	return s
+}
+
+// Close detaches all sessions from their cameras
+func (s *Server) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	select {
+	case <-s.closed:
+		return
+	default:
+		close(s.closed)
+	}
+
+	for key, sess := range s.sessions {
+		sess.stream.RemoveViewer(sess.id)
+		sess.muxer.Close()
+		delete(s.sessions, key)
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/hls")
+	slash := strings.LastIndex(path, "/")
+	if slash <= 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	cameraPath, resolution := rtsp.ParseCameraPath(path[:slash])
+	file := path[slash+1:]
+
+	if cameraPath == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if !s.rtspServer.AuthorizeHTTP(w, r, cameraPath) {
+		return
+	}
+
+	// Players on other origins (dashboards) fetch the playlist directly
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	sess, err := s.getSession(cameraPath, resolution)
+	if err != nil {
+		if errors.Is(err, rtsp.ErrCameraNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		core.Logger.Error().Err(err).Msgf("HLS: failed to open stream %s", cameraPath)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	sess.muxer.touch()
+
+	switch {
+	case file == "index.m3u8":
+		s.servePlaylist(w, r, sess.muxer)
+	case file == "init.mp4":
+		s.serveInit(w, sess.muxer)
+	case strings.HasPrefix(file, "seg") && strings.HasSuffix(file, ".m4s"):
+		s.serveSegment(w, r, sess.muxer, strings.TrimSuffix(strings.TrimPrefix(file, "seg"), ".m4s"))
+	case strings.HasPrefix(file, "part") && strings.HasSuffix(file, ".m4s"):
+		s.servePart(w, r, sess.muxer, strings.TrimSuffix(strings.TrimPrefix(file, "part"), ".m4s"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// getSession returns the session of a camera, attaching a new one to the stream if needed
+func (s *Server) getSession(cameraPath, resolution string) (*session, error) {
+	key := cameraPath + "/" + resolution
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	select {
+	case <-s.closed:
+		return nil, errors.New("server closed")
+	default:
+	}
+
+	if sess, exists := s.sessions[key]; exists {
+		if !sess.muxer.isClosed() {
+			return sess, nil
+		}
+		delete(s.sessions, key)
+	}
+
+	id := "hls-" + key
+	m := newMuxer(s.config.LowLatency, s.config.SegmentDuration, s.config.PartDuration)
+
+	stream, err := s.rtspServer.AttachViewer(cameraPath, resolution, id, m)
+	if err != nil {
+		return nil, err
+	}
+
+	sess := &session{id: id, stream: stream, muxer: m}
+	s.sessions[key] = sess
+
+	core.Logger.Info().Msgf("HLS session started for camera %s (%s)", stream.Camera().DeviceName, resolution)
+
+	// The codec is known once the bridge is connected
+	go func() {
+		info := stream.WaitMediaInfo(mediaInfoTimeout)
+		if info == nil {
+			core.Logger.Warn().Msgf("HLS: no video from camera %s", stream.Camera().DeviceName)
+			s.removeSession(key, sess)
+			return
+		}
+		m.configure(info)
+	}()
+
+	return sess, nil
+}
+
+func (s *Server) removeSession(key string, sess *session) {
+	s.mutex.Lock()
+	if s.sessions[key] == sess {
+		delete(s.sessions, key)
+	}
+	s.mutex.Unlock()
+
+	sess.stream.RemoveViewer(sess.id)
+	sess.muxer.Close()
+}
+
+// janitor detaches sessions nobody requested for a while
+func (s *Server) janitor() {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.closed:
+			return
+		case <-ticker.C:
+		}
+
+		s.mutex.Lock()
+		var idle []string
+		for key, sess := range s.sessions {
+			if sess.muxer.isClosed() || time.Since(sess.muxer.idleSince()) > s.config.IdleTimeout {
+				idle = append(idle, key)
+			}
+		}
+		s.mutex.Unlock()
+
+		for _, key := range idle {
+			s.mutex.Lock()
+			sess := s.sessions[key]
+			s.mutex.Unlock()
+
+			if sess != nil {
+				core.Logger.Info().Msgf("HLS session for %s idle, detaching", key)
+				s.removeSession(key, sess)
+			}
+		}
+	}
+}
+
+func (s *Server) servePlaylist(w http.ResponseWriter, r *http.Request, m *muxer) {
+	ready := m.hasPlaylist
+	timeout := mediaInfoTimeout
+
+	// LL-HLS blocking playlist reload
+	if m.lowLatency && r.URL.Query().Has("_HLS_msn") {
+		msn, err := strconv.Atoi(r.URL.Query().Get("_HLS_msn"))
+		if err != nil {
+			http.Error(w, "invalid _HLS_msn", http.StatusBadRequest)
+			return
+		}
+
+		partIndex := -1
+		if value := r.URL.Query().Get("_HLS_part"); value != "" {
+			if partIndex, err = strconv.Atoi(value); err != nil {
+				http.Error(w, "invalid _HLS_part", http.StatusBadRequest)
+				return
+			}
+		}
+
+		ready = func() bool {
+			if partIndex < 0 {
+				return m.hasPart(msn+1, -1)
+			}
+			return m.hasPart(msn, partIndex)
+		}
+		timeout = blockTimeout
+	}
+
+	if err := m.wait(timeout, ready); err != nil && !m.ready() {
+		http.Error(w, fmt.Sprintf("stream not available: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+
+	m.mutex.Lock()
+	playlist := m.playlist()
+	m.mutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write([]byte(playlist))
+}
+
+func (s *Server) serveInit(w http.ResponseWriter, m *muxer) {
+	var init []byte
+	m.wait(mediaInfoTimeout, func() bool {
+		init = m.init
+		return init != nil
+	})
+
+	if init == nil {
+		http.Error(w, "stream not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "video/mp4")
+	w.Write(init)
+}
+
+func (s *Server) serveSegment(w http.ResponseWriter, r *http.Request, m *muxer, name string) {
+	msn, err := strconv.Atoi(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	m.mutex.Lock()
+	var data []byte
+	if seg := m.segment(msn); seg != nil && seg.complete {
+		data = seg.data()
+	}
+	m.mutex.Unlock()
+
+	if data == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "video/iso.segment")
+	w.Write(data)
+}
+
+func (s *Server) servePart(w http.ResponseWriter, r *http.Request, m *muxer, name string) {
+	msnValue, partValue, found := strings.Cut(name, ".")
+	msn, err1 := strconv.Atoi(msnValue)
+	partIndex, err2 := strconv.Atoi(partValue)
+	if !found || err1 != nil || err2 != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	// The preload hint points at the next part, the request is held until it exists
+	var data []byte
+	m.wait(blockTimeout, func() bool {
+		seg := m.segment(msn)
+		if seg == nil {
+			return m.hasPart(msn, partIndex)
+		}
+		if partIndex < len(seg.parts) {
+			data = seg.parts[partIndex].data
+			return true
+		}
+		return seg.complete
+	})
+
+	if data == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "video/iso.segment")
+	w.Write(data)
+}
@@ -0,0 +1,148 @@
+package homeassistant
+
+import (
+	"fmt"
+	"strings"
+
+	"tuya-ipc-terminal/pkg/core"
+	"tuya-ipc-terminal/pkg/storage"
+	"tuya-ipc-terminal/pkg/tuya"
+)
+
+// PTZ buttons, the payload is the PTZ action
+var ptzButtons = []struct {
+	action tuya.PTZAction
+	name   string
+	icon   string
+}{
+	{tuya.PTZUp, "Tilt up", "mdi:arrow-up-bold"},
+	{tuya.PTZDown, "Tilt down", "mdi:arrow-down-bold"},
+	{tuya.PTZLeft, "Pan left", "mdi:arrow-left-bold"},
+	{tuya.PTZRight, "Pan right", "mdi:arrow-right-bold"},
+	{tuya.PTZZoomIn, "Zoom in", "mdi:magnify-plus"},
+	{tuya.PTZZoomOut, "Zoom out", "mdi:magnify-minus"},
+}
+
+var eventSensors = []struct {
+	eventType   tuya.EventType
+	name        string
+	deviceClass string
+	icon        string
+}{
+	{tuya.EventMotion, "Motion", "motion", ""},
+	{tuya.EventPerson, "Person", "occupancy", "mdi:account"},
+	{tuya.EventDoorbell, "Doorbell", "", "mdi:doorbell"},
+	{tuya.EventTamper, "Tamper", "tamper", ""},
+}
+
+// discoveryConfigs returns the discovery topics of a camera with their configs
+func (p *Publisher) discoveryConfigs(camera *storage.CameraInfo) map[string]map[string]any {
+	id := "tuya_" + camera.DeviceID
+	configs := make(map[string]map[string]any)
+
+	// Entities of the camera are unavailable while it can't be reached, the
+	// diagnostic ones only depend on the bridge
+	availability := []map[string]string{{"topic": p.availabilityTopic()}}
+	cameraAvailability := append(availability, map[string]string{"topic": p.cameraTopic(camera, "availability")})
+
+	entity := func(component, object, name string, config map[string]any) {
+		config["name"] = name
+		config["unique_id"] = id + "_" + object
+		config["object_id"] = strings.ToLower(strings.ReplaceAll(strings.TrimPrefix(camera.RTSPPath, "/"), "/", "_")) + "_" + object
+		config["device"] = p.device(camera)
+		if config["entity_category"] == "diagnostic" {
+			config["availability"] = availability
+		} else {
+			config["availability"] = cameraAvailability
+			config["availability_mode"] = "all"
+		}
+
+		configs[p.discoveryTopic(component, id, object)] = config
+	}
+
+	// MQTT cameras only show still images, the URL is for the Generic Camera
+	// integration or stream cards
+	if p.config.SnapshotInterval > 0 {
+		entity("camera", "camera", "Snapshot", map[string]any{
+			"topic":                 p.cameraTopic(camera, "snapshot"),
+			"json_attributes_topic": p.cameraTopic(camera, "attributes"),
+		})
+	}
+
+	entity("sensor", "stream_url", "Stream URL", map[string]any{
+		"state_topic":           p.cameraTopic(camera, "url"),
+		"json_attributes_topic": p.cameraTopic(camera, "attributes"),
+		"icon":                  "mdi:video",
+		"entity_category":       "diagnostic",
+	})
+
+	if p.bus != nil {
+		for _, sensor := range eventSensors {
+			config := map[string]any{
+				"state_topic": p.cameraTopic(camera, string(sensor.eventType)),
+				"off_delay":   eventOffDelays[sensor.eventType],
+			}
+			if sensor.deviceClass != "" {
+				config["device_class"] = sensor.deviceClass
+			}
+			if sensor.icon != "" {
+				config["icon"] = sensor.icon
+			}
+			entity("binary_sensor", string(sensor.eventType), sensor.name, config)
+		}
+	}
+
+	entity("sensor", "stream", "Stream", map[string]any{
+		"state_topic":     p.cameraTopic(camera, "stream"),
+		"device_class":    "enum",
+		"options":         []string{"idle", "connecting", "active"},
+		"icon":            "mdi:cctv",
+		"entity_category": "diagnostic",
+	})
+
+	entity("sensor", "viewers", "Viewers", map[string]any{
+		"state_topic":     p.cameraTopic(camera, "viewers"),
+		"state_class":     "measurement",
+		"icon":            "mdi:eye",
+		"entity_category": "diagnostic",
+	})
+
+	if p.config.PrivacySwitch {
+		entity("switch", "privacy", "Privacy mode", map[string]any{
+			"command_topic": p.cameraTopic(camera, "privacy/set"),
+			"state_topic":   p.cameraTopic(camera, "privacy"),
+			"optimistic":    true,
+			"icon":          "mdi:eye-off",
+		})
+	}
+
+	supported, err := p.ptzController.Supports(camera)
+	if err != nil {
+		core.Logger.Warn().Err(err).Msgf("Failed to check PTZ support of %s", camera.DeviceName)
+	}
+	if supported {
+		for _, button := range ptzButtons {
+			entity("button", "ptz_"+strings.ReplaceAll(string(button.action), "-", "_"), button.name, map[string]any{
+				"command_topic": p.cameraTopic(camera, "ptz/set"),
+				"payload_press": string(button.action),
+				"icon":          button.icon,
+			})
+		}
+	}
+
+	return configs
+}
+
+func (p *Publisher) discoveryTopic(component, id, object string) string {
+	return fmt.Sprintf("%s/%s/%s/%s/config", p.config.DiscoveryPrefix, component, id, object)
+}
+
+func (p *Publisher) device(camera *storage.CameraInfo) map[string]any {
+	return map[string]any{
+		"identifiers":  []string{"tuya_" + camera.DeviceID},
+		"name":         camera.DeviceName,
+		"manufacturer": "Tuya",
+		"model":        camera.ProductID,
+		"sw_version":   p.config.Version,
+	}
+}
@@ -0,0 +1,456 @@
+// Package homeassistant publishes the cameras to an MQTT broker with Home
+// Assistant discovery, including stream state, events, privacy and PTZ
+package homeassistant
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"tuya-ipc-terminal/pkg/core"
+	"tuya-ipc-terminal/pkg/events"
+	"tuya-ipc-terminal/pkg/ptz"
+	"tuya-ipc-terminal/pkg/rtsp"
+	"tuya-ipc-terminal/pkg/snapshot"
+	"tuya-ipc-terminal/pkg/storage"
+	"tuya-ipc-terminal/pkg/tuya"
+	"tuya-ipc-terminal/pkg/utils"
+)
+
+const (
+	DefaultDiscoveryPrefix = "homeassistant"
+	DefaultTopicPrefix     = "tuya-ipc-terminal"
+
+	stateInterval = 5 * time.Second
+
+	// PTZ buttons move the camera this long
+	ptzStep = 500 * time.Millisecond
+)
+
+// How long event sensors stay on, the cameras only report the start
+var eventOffDelays = map[tuya.EventType]int{
+	tuya.EventMotion:   30,
+	tuya.EventPerson:   30,
+	tuya.EventDoorbell: 10,
+	tuya.EventTamper:   60,
+}
+
+type Config struct {
+	Broker          string // e.g. "tcp://localhost:1883"
+	Username        string
+	Password        string
+	DiscoveryPrefix string
+	TopicPrefix     string
+	RTSPHost        string // Host in the announced stream URLs
+	RTSPPort        int
+	PrivacySwitch   bool // Cameras don't report whether they have a privacy mode
+	Version         string
+
+	// Camera entities show a snapshot taken this often and after events, 0
+	// disables them. Snapshots connect idle cameras.
+	SnapshotInterval time.Duration
+	FFmpeg           string // Command that decodes the snapshots
+}
+
+// Publisher announces every camera as a Home Assistant device and keeps
+// its state topics up to date while the broker is connected
+type Publisher struct {
+	config         Config
+	rtspServer     *rtsp.RTSPServer
+	storageManager *storage.StorageManager
+	ptzController  *ptz.Controller
+	bus            *events.Bus
+	client         mqtt.Client
+
+	cameras map[string]storage.CameraInfo // Device ID -> camera
+	states  map[string]string             // Topic -> last published state
+	mutex   sync.Mutex
+
+	snapshotRequests chan string // Device IDs
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewPublisher creates a publisher, events are shown if bus isn't nil
+func NewPublisher(config Config, rtspServer *rtsp.RTSPServer, storageManager *storage.StorageManager, bus *events.Bus) *Publisher {
+	if config.DiscoveryPrefix == "" {
+		config.DiscoveryPrefix = DefaultDiscoveryPrefix
+	}
+	if config.TopicPrefix == "" {
+		config.TopicPrefix = DefaultTopicPrefix
+	}
+
+	return &Publisher{
+		config:         config,
+		rtspServer:     rtspServer,
+		storageManager: storageManager,
+		ptzController:  ptz.NewController(storageManager),
+		bus:            bus,
+		cameras:        make(map[string]storage.CameraInfo),
+		states:         make(map[string]string),
+		stop:           make(chan struct{}),
+
+		snapshotRequests: make(chan string, 16),
+	}
+}
+
+// Start connects to the broker in the background, it keeps retrying if the
+// broker isn't reachable
+func (p *Publisher) Start() error {
+	cameras, err := p.storageManager.GetAllCameras()
+	if err != nil {
+		return fmt.Errorf("failed to get cameras: %v", err)
+	}
+	for _, camera := range cameras {
+		p.cameras[camera.DeviceID] = camera
+	}
+
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(p.config.Broker)
+	opts.SetClientID(p.config.TopicPrefix + "-" + utils.RandString(8, 16))
+	opts.SetUsername(p.config.Username)
+	opts.SetPassword(p.config.Password)
+	opts.SetWill(p.availabilityTopic(), "offline", 1, true)
+	opts.SetOnConnectHandler(p.onConnect)
+	opts.SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+		core.Logger.Warn().Err(err).Msg("Lost connection to the Home Assistant MQTT broker")
+	})
+	opts.SetAutoReconnect(true)
+	opts.SetConnectRetry(true)
+	opts.SetConnectTimeout(10 * time.Second)
+	opts.SetOrderMatters(false) // PTZ and privacy commands take a while
+
+	p.client = mqtt.NewClient(opts)
+	p.client.Connect()
+
+	p.wg.Add(1)
+	go p.run()
+
+	if p.config.SnapshotInterval > 0 {
+		p.wg.Add(1)
+		go p.takeSnapshots()
+	}
+
+	return nil
+}
+
+func (p *Publisher) Close() {
+	close(p.stop)
+	p.wg.Wait()
+
+	if p.client.IsConnectionOpen() {
+		p.client.Publish(p.availabilityTopic(), 1, true, "offline").WaitTimeout(time.Second)
+	}
+	p.client.Disconnect(250)
+	p.ptzController.Close()
+}
+
+func (p *Publisher) onConnect(client mqtt.Client) {
+	core.Logger.Info().Msgf("Connected to the Home Assistant MQTT broker %s", p.config.Broker)
+
+	handlers := map[string]mqtt.MessageHandler{
+		p.config.TopicPrefix + "/+/privacy/set": p.onPrivacy,
+		p.config.TopicPrefix + "/+/ptz/set":     p.onPTZ,
+		p.config.DiscoveryPrefix + "/status":    p.onHomeAssistantStatus,
+	}
+	for topic, handler := range handlers {
+		if token := client.Subscribe(topic, 1, handler); token.Wait() && token.Error() != nil {
+			core.Logger.Error().Err(token.Error()).Msgf("Failed to subscribe to %s", topic)
+		}
+	}
+
+	// Retained state may be from an earlier run
+	p.mutex.Lock()
+	p.states = make(map[string]string)
+	p.mutex.Unlock()
+
+	p.announce()
+	p.publish(p.availabilityTopic(), "online", true)
+	p.publishStates()
+}
+
+// onHomeAssistantStatus announces the cameras again when Home Assistant
+// restarts, it may have lost the discovery messages
+func (p *Publisher) onHomeAssistantStatus(_ mqtt.Client, msg mqtt.Message) {
+	if string(msg.Payload()) == "online" {
+		p.announce()
+	}
+}
+
+func (p *Publisher) run() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(stateInterval)
+	defer ticker.Stop()
+
+	var eventChan <-chan events.Event
+	if p.bus != nil {
+		subscription := p.bus.Subscribe()
+		defer subscription.Close()
+		eventChan = subscription.C
+	}
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			if p.client.IsConnectionOpen() {
+				p.publishStates()
+			}
+		case event := <-eventChan:
+			if camera, ok := p.cameras[event.DeviceID]; ok {
+				p.publish(p.cameraTopic(&camera, string(event.Type)), "ON", false)
+				p.requestSnapshot(camera.DeviceID)
+			}
+		}
+	}
+}
+
+// announce publishes the discovery configs of all cameras
+func (p *Publisher) announce() {
+	for _, camera := range p.cameras {
+		// Without snapshots there's no image to show
+		if p.config.SnapshotInterval <= 0 {
+			p.publish(p.discoveryTopic("camera", "tuya_"+camera.DeviceID, "camera"), "", true)
+		}
+
+		for topic, config := range p.discoveryConfigs(&camera) {
+			payload, err := json.Marshal(config)
+			if err != nil {
+				core.Logger.Error().Err(err).Msgf("Failed to encode discovery config of %s", camera.DeviceName)
+				continue
+			}
+			p.publish(topic, string(payload), true)
+		}
+
+		attributes, _ := json.Marshal(map[string]string{
+			"rtsp_url":    p.streamURL(&camera, ""),
+			"rtsp_url_sd": p.streamURL(&camera, "/sd"),
+			"device_id":   camera.DeviceID,
+		})
+		p.publish(p.cameraTopic(&camera, "attributes"), string(attributes), true)
+		p.publish(p.cameraTopic(&camera, "url"), p.streamURL(&camera, ""), true)
+	}
+
+	core.Logger.Debug().Msgf("Announced %d camera(s) to Home Assistant", len(p.cameras))
+}
+
+// publishStates publishes the stream state, viewers and availability of every
+// camera if they changed. A camera is offline while its stream reconnects
+// after it was lost, idle cameras are assumed to be online.
+func (p *Publisher) publishStates() {
+	type cameraState struct {
+		state   string
+		viewers int
+	}
+
+	states := make(map[string]*cameraState, len(p.cameras))
+	for id := range p.cameras {
+		states[id] = &cameraState{state: "idle"}
+	}
+
+	// Cameras with a stream that is active or not failing
+	online := make(map[string]bool, len(p.cameras))
+
+	for _, stream := range p.rtspServer.GetStreams() {
+		state := states[stream.DeviceID]
+		if state == nil {
+			continue
+		}
+
+		state.viewers += stream.ClientCount + stream.ViewerCount
+		switch {
+		case stream.Active:
+			state.state = "active"
+		case stream.Connecting && state.state == "idle":
+			state.state = "connecting"
+		}
+
+		if stream.Active || !stream.Connecting || stream.Reconnects == 0 {
+			online[stream.DeviceID] = true
+		}
+	}
+
+	for id, state := range states {
+		camera := p.cameras[id]
+		p.publishChanged(p.cameraTopic(&camera, "stream"), state.state)
+		p.publishChanged(p.cameraTopic(&camera, "viewers"), fmt.Sprint(state.viewers))
+
+		availability := "online"
+		if state.state != "idle" && !online[id] {
+			availability = "offline"
+		}
+		p.publishChanged(p.cameraTopic(&camera, "availability"), availability)
+	}
+}
+
+// requestSnapshot takes a snapshot of the camera soon, unless snapshots are
+// disabled or too many are waiting
+func (p *Publisher) requestSnapshot(deviceID string) {
+	if p.config.SnapshotInterval <= 0 {
+		return
+	}
+
+	select {
+	case p.snapshotRequests <- deviceID:
+	default:
+	}
+}
+
+// takeSnapshots publishes a snapshot of every camera at start and each
+// interval, one at a time so the cameras aren't all woken at once
+func (p *Publisher) takeSnapshots() {
+	defer p.wg.Done()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-p.stop
+		cancel()
+	}()
+
+	ticker := time.NewTicker(p.config.SnapshotInterval)
+	defer ticker.Stop()
+
+	all := func() {
+		for id := range p.cameras {
+			if ctx.Err() != nil {
+				return
+			}
+			p.publishSnapshot(ctx, id)
+		}
+	}
+
+	all()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			all()
+		case id := <-p.snapshotRequests:
+			p.publishSnapshot(ctx, id)
+		}
+	}
+}
+
+func (p *Publisher) publishSnapshot(ctx context.Context, deviceID string) {
+	camera := p.cameras[deviceID]
+	resolution := camera.Resolution
+	if resolution == "" {
+		resolution = "hd"
+	}
+
+	image, err := snapshot.Grab(ctx, p.rtspServer, camera.RTSPPath, resolution, snapshot.Config{FFmpeg: p.config.FFmpeg})
+	if err != nil {
+		if ctx.Err() == nil {
+			core.Logger.Warn().Err(err).Msgf("Failed to take a snapshot of %s", camera.DeviceName)
+		}
+		return
+	}
+
+	p.publish(p.cameraTopic(&camera, "snapshot"), string(image), true)
+	core.Logger.Debug().Msgf("Published a snapshot of %s (%d bytes)", camera.DeviceName, len(image))
+}
+
+func (p *Publisher) publishChanged(topic, payload string) {
+	p.mutex.Lock()
+	changed := p.states[topic] != payload
+	p.states[topic] = payload
+	p.mutex.Unlock()
+
+	if changed {
+		p.publish(topic, payload, true)
+	}
+}
+
+func (p *Publisher) publish(topic, payload string, retained bool) {
+	token := p.client.Publish(topic, 1, retained, payload)
+	go func() {
+		if token.WaitTimeout(10*time.Second) && token.Error() != nil {
+			core.Logger.Error().Err(token.Error()).Msgf("Failed to publish %s", topic)
+		}
+	}()
+}
+
+func (p *Publisher) onPrivacy(_ mqtt.Client, msg mqtt.Message) {
+	camera, err := p.commandCamera(msg.Topic())
+	if err != nil {
+		core.Logger.Warn().Err(err).Msg("Ignoring privacy command")
+		return
+	}
+
+	enabled := strings.EqualFold(string(msg.Payload()), "ON")
+	if err := p.ptzController.SetPrivacyMode(camera, enabled); err != nil {
+		core.Logger.Error().Err(err).Msgf("Failed to set privacy mode of %s", camera.DeviceName)
+		return
+	}
+
+	state := "OFF"
+	if enabled {
+		state = "ON"
+	}
+	p.publishChanged(p.cameraTopic(camera, "privacy"), state)
+}
+
+func (p *Publisher) onPTZ(_ mqtt.Client, msg mqtt.Message) {
+	camera, err := p.commandCamera(msg.Topic())
+	if err != nil {
+		core.Logger.Warn().Err(err).Msg("Ignoring PTZ command")
+		return
+	}
+
+	command, err := tuya.ParsePTZCommand(strings.Fields(string(msg.Payload())))
+	if err != nil {
+		core.Logger.Warn().Err(err).Msgf("Ignoring PTZ command for %s", camera.DeviceName)
+		return
+	}
+
+	if err := p.ptzController.Send(camera, command); err != nil {
+		core.Logger.Error().Err(err).Msgf("PTZ %s on %s failed", command.Action, camera.DeviceName)
+		return
+	}
+
+	// Buttons move a step, presets stop by themselves
+	if command.Action != tuya.PTZPreset && command.Action != tuya.PTZSavePreset && command.Action != tuya.PTZStop {
+		time.Sleep(ptzStep)
+		if err := p.ptzController.Send(camera, tuya.PTZCommand{Action: tuya.PTZStop}); err != nil {
+			core.Logger.Error().Err(err).Msgf("PTZ stop on %s failed", camera.DeviceName)
+		}
+	}
+}
+
+// commandCamera returns the camera of a "<prefix>/<device id>/<entity>/set" topic
+func (p *Publisher) commandCamera(topic string) (*storage.CameraInfo, error) {
+	parts := strings.Split(strings.TrimPrefix(topic, p.config.TopicPrefix+"/"), "/")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid topic: %s", topic)
+	}
+
+	camera, ok := p.cameras[parts[0]]
+	if !ok {
+		return nil, errors.New("unknown camera: " + parts[0])
+	}
+	return &camera, nil
+}
+
+func (p *Publisher) availabilityTopic() string {
+	return p.config.TopicPrefix + "/status"
+}
+
+func (p *Publisher) cameraTopic(camera *storage.CameraInfo, name string) string {
+	return fmt.Sprintf("%s/%s/%s", p.config.TopicPrefix, camera.DeviceID, name)
+}
+
+func (p *Publisher) streamURL(camera *storage.CameraInfo, suffix string) string {
+	return fmt.Sprintf("rtsp://%s%s%s", net.JoinHostPort(p.config.RTSPHost, fmt.Sprint(p.config.RTSPPort)), camera.RTSPPath, suffix)
+}
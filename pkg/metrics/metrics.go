@@ -0,0 +1,334 @@
+// Package metrics implements the counters, gauges and histograms the bridge
+// exposes in the Prometheus text format
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tuya-ipc-terminal/pkg/core"
+)
+
+// DefaultBuckets are latency buckets in seconds
+var DefaultBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+var (
+	registry      []metric
+	registryMutex sync.Mutex
+)
+
+func register(m metric) {
+	registryMutex.Lock()
+	registry = append(registry, m)
+	registryMutex.Unlock()
+}
+
+// Handler serves all registered metrics
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+		registryMutex.Lock()
+		metrics := append([]metric(nil), registry...)
+		registryMutex.Unlock()
+
+		bw := bufio.NewWriter(w)
+		for _, m := range metrics {
+			m.write(bw)
+		}
+		bw.Flush()
+	})
+}
+
+// Value is a float64 that can be updated concurrently
+type Value struct {
+	bits atomic.Uint64
+}
+
+func (v *Value) Add(delta float64) {
+	for {
+		old := v.bits.Load()
+		if v.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+func (v *Value) Set(value float64) {
+	v.bits.Store(math.Float64bits(value))
+}
+
+func (v *Value) Get() float64 {
+	return math.Float64frombits(v.bits.Load())
+}
+
+// family holds the series of one metric, keyed by their label values
+type family[T any] struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	series map[string]*T
+	values map[string][]string
+	create func() *T
+	mutex  sync.RWMutex
+}
+
+func newFamily[T any](name, help, kind string, labels []string, create func() *T) *family[T] {
+	f := &family[T]{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		series: make(map[string]*T),
+		values: make(map[string][]string),
+		create: create,
+	}
+
+	// A metric without labels is reported from the start
+	if len(labels) == 0 {
+		f.with(nil)
+	}
+
+	return f
+}
+
+func (f *family[T]) with(labelValues []string) *T {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.name, len(f.labels), len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, "\xff")
+
+	f.mutex.RLock()
+	s, exists := f.series[key]
+	f.mutex.RUnlock()
+
+	if exists {
+		return s
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if s, exists = f.series[key]; !exists {
+		s = f.create()
+		f.series[key] = s
+		f.values[key] = append([]string(nil), labelValues...)
+	}
+
+	return s
+}
+
+// each calls fn for all series sorted by label values
+func (f *family[T]) each(fn func(labels string, s *T)) {
+	f.mutex.RLock()
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	f.mutex.RUnlock()
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		f.mutex.RLock()
+		s, values := f.series[key], f.values[key]
+		f.mutex.RUnlock()
+
+		fn(formatLabels(f.labels, values), s)
+	}
+}
+
+func (f *family[T]) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, f.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+}
+
+type Counter struct {
+	*family[Value]
+}
+
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{newFamily(name, help, "counter", labels, func() *Value { return &Value{} })}
+	register(c)
+	return c
+}
+
+// With returns the series for the label values, callers on hot paths can keep it
+func (c *Counter) With(labelValues ...string) *Value {
+	return c.with(labelValues)
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.with(labelValues).Add(1)
+}
+
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	c.with(labelValues).Add(delta)
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.writeHeader(w)
+	c.each(func(labels string, v *Value) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, labels, formatValue(v.Get()))
+	})
+}
+
+type Gauge struct {
+	*family[Value]
+}
+
+func NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{newFamily(name, help, "gauge", labels, func() *Value { return &Value{} })}
+	register(g)
+	return g
+}
+
+func (g *Gauge) With(labelValues ...string) *Value {
+	return g.with(labelValues)
+}
+
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.with(labelValues).Set(value)
+}
+
+func (g *Gauge) Inc(labelValues ...string) {
+	g.with(labelValues).Add(1)
+}
+
+func (g *Gauge) Dec(labelValues ...string) {
+	g.with(labelValues).Add(-1)
+}
+
+func (g *Gauge) write(w *bufio.Writer) {
+	g.writeHeader(w)
+	g.each(func(labels string, v *Value) {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, labels, formatValue(v.Get()))
+	})
+}
+
+type histogramSeries struct {
+	buckets []atomic.Uint64 // Not cumulative, summed up on output
+	count   atomic.Uint64
+	sum     Value
+}
+
+type Histogram struct {
+	*family[histogramSeries]
+	bounds []float64
+}
+
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	bounds := append([]float64(nil), buckets...)
+	sort.Float64s(bounds)
+
+	h := &Histogram{bounds: bounds}
+	h.family = newFamily(name, help, "histogram", labels, func() *histogramSeries {
+		return &histogramSeries{buckets: make([]atomic.Uint64, len(bounds))}
+	})
+	register(h)
+	return h
+}
+
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	s := h.with(labelValues)
+
+	if i := sort.SearchFloat64s(h.bounds, value); i < len(h.bounds) {
+		s.buckets[i].Add(1)
+	}
+	s.count.Add(1)
+	s.sum.Add(value)
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.writeHeader(w)
+	h.each(func(labels string, s *histogramSeries) {
+		var cumulative uint64
+		for i, bound := range h.bounds {
+			cumulative += s.buckets[i].Load()
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, withLabel(labels, "le", formatValue(bound)), cumulative)
+		}
+
+		count := s.count.Load()
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, withLabel(labels, "le", "+Inf"), count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labels, formatValue(s.sum.Get()))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labels, count)
+	})
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, name, labelEscaper.Replace(values[i]))
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func withLabel(labels, name, value string) string {
+	pair := fmt.Sprintf(`%s="%s"`, name, value)
+	if labels == "" {
+		return "{" + pair + "}"
+	}
+	return labels[:len(labels)-1] + "," + pair + "}"
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// Serve exposes the metrics on /metrics of address until the returned server is closed
+func Serve(address string) (*http.Server, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %v", address, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", Handler())
+
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	core.Logger.Info().Msgf("Metrics available on http://%s/metrics", listener.Addr())
+
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			core.Logger.Error().Err(err).Msg("Metrics server failed")
+		}
+	}()
+
+	return server, nil
+}
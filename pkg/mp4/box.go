@@ -0,0 +1,58 @@
+package mp4
+
+import "encoding/binary"
+
+// writer builds nested ISO BMFF boxes
+type writer struct {
+	buf    []byte
+	starts []int
+}
+
+func (w *writer) start(boxType string) {
+	w.starts = append(w.starts, len(w.buf))
+	w.buf = append(w.buf, 0, 0, 0, 0)
+	w.buf = append(w.buf, boxType...)
+}
+
+// startFull starts a full box with version and flags
+func (w *writer) startFull(boxType string, version byte, flags uint32) {
+	w.start(boxType)
+	w.u32(uint32(version)<<24 | flags&0xFFFFFF)
+}
+
+func (w *writer) end() {
+	start := w.starts[len(w.starts)-1]
+	w.starts = w.starts[:len(w.starts)-1]
+	binary.BigEndian.PutUint32(w.buf[start:], uint32(len(w.buf)-start))
+}
+
+func (w *writer) u8(v uint8) {
+	w.buf = append(w.buf, v)
+}
+
+func (w *writer) u16(v uint16) {
+	w.buf = binary.BigEndian.AppendUint16(w.buf, v)
+}
+
+func (w *writer) u32(v uint32) {
+	w.buf = binary.BigEndian.AppendUint32(w.buf, v)
+}
+
+func (w *writer) u64(v uint64) {
+	w.buf = binary.BigEndian.AppendUint64(w.buf, v)
+}
+
+func (w *writer) bytes(b []byte) {
+	w.buf = append(w.buf, b...)
+}
+
+func (w *writer) zeros(n int) {
+	w.buf = append(w.buf, make([]byte, n)...)
+}
+
+// matrix writes the identity transformation matrix of mvhd and tkhd
+func (w *writer) matrix() {
+	for _, v := range []uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000} {
+		w.u32(v)
+	}
+}
@@ -0,0 +1,304 @@
+// Package mp4 writes fragmented MP4 (CMAF) with a video and an optional G.711 audio track
+package mp4
+
+const (
+	CodecH264 = "avc1"
+	CodecH265 = "hvc1"
+	CodecPCMU = "ulaw"
+	CodecPCMA = "alaw"
+
+	movieTimeScale = 1000
+
+	// Sample flags of trun
+	flagsKeyframe    = 0x02000000 // sample_depends_on = 2
+	flagsNonKeyframe = 0x01010000 // sample_depends_on = 1, non sync sample
+)
+
+type Track struct {
+	ID        uint32
+	Codec     string // CodecH264, CodecH265, CodecPCMU or CodecPCMA
+	Config    []byte // avcC or hvcC record, video only
+	Width     int
+	Height    int
+	TimeScale uint32 // The sample rate for audio
+}
+
+func (t *Track) IsVideo() bool {
+	return t.Codec == CodecH264 || t.Codec == CodecH265
+}
+
+// Sample is one access unit with 4 byte length prefixed NAL units or a chunk of audio
+type Sample struct {
+	Duration uint32
+	Keyframe bool // Always set for audio
+	Data     []byte
+}
+
+// TrackFragment holds the samples of one track starting at decode time BaseTime
+type TrackFragment struct {
+	TrackID  uint32
+	BaseTime uint64
+	Samples  []Sample
+}
+
+// InitSegment returns ftyp and moov for the tracks
+func InitSegment(tracks ...*Track) []byte {
+	w := &writer{}
+
+	w.start("ftyp")
+	w.bytes([]byte("iso6"))
+	w.u32(1)
+	w.bytes([]byte("iso6cmfcmp41dash"))
+	w.end()
+
+	w.start("moov")
+
+	var nextID uint32
+	for _, track := range tracks {
+		nextID = max(nextID, track.ID+1)
+	}
+
+	w.startFull("mvhd", 0, 0)
+	w.u32(0) // Creation time
+	w.u32(0) // Modification time
+	w.u32(movieTimeScale)
+	w.u32(0)          // Duration
+	w.u32(0x00010000) // Rate
+	w.u16(0x0100)     // Volume
+	w.zeros(10)
+	w.matrix()
+	w.zeros(24)
+	w.u32(nextID)
+	w.end()
+
+	for _, track := range tracks {
+		writeTrack(w, track)
+	}
+
+	w.start("mvex")
+	for _, track := range tracks {
+		w.startFull("trex", 0, 0)
+		w.u32(track.ID)
+		w.u32(1) // Sample description index
+		w.u32(0) // Default duration
+		w.u32(0) // Default size
+		w.u32(0) // Default flags
+		w.end()
+	}
+	w.end()
+
+	w.end() // moov
+
+	return w.buf
+}
+
+func writeTrack(w *writer, track *Track) {
+	w.start("trak")
+
+	var volume uint16
+	if !track.IsVideo() {
+		volume = 0x0100
+	}
+
+	w.startFull("tkhd", 0, 3) // Enabled, in movie
+	w.u32(0)
+	w.u32(0)
+	w.u32(track.ID)
+	w.u32(0)
+	w.u32(0) // Duration
+	w.zeros(8)
+	w.u16(0) // Layer
+	w.u16(0) // Alternate group
+	w.u16(volume)
+	w.u16(0)
+	w.matrix()
+	w.u32(uint32(track.Width) << 16)
+	w.u32(uint32(track.Height) << 16)
+	w.end()
+
+	w.start("mdia")
+
+	w.startFull("mdhd", 0, 0)
+	w.u32(0)
+	w.u32(0)
+	w.u32(track.TimeScale)
+	w.u32(0)
+	w.u16(0x55C4) // Language "und"
+	w.u16(0)
+	w.end()
+
+	w.startFull("hdlr", 0, 0)
+	w.u32(0)
+	if track.IsVideo() {
+		w.bytes([]byte("vide"))
+		w.zeros(12)
+		w.bytes([]byte("VideoHandler\x00"))
+	} else {
+		w.bytes([]byte("soun"))
+		w.zeros(12)
+		w.bytes([]byte("SoundHandler\x00"))
+	}
+	w.end()
+
+	w.start("minf")
+
+	if track.IsVideo() {
+		w.startFull("vmhd", 0, 1)
+		w.zeros(8)
+	} else {
+		w.startFull("smhd", 0, 0)
+		w.zeros(4) // Balance
+	}
+	w.end()
+
+	w.start("dinf")
+	w.startFull("dref", 0, 0)
+	w.u32(1)
+	w.startFull("url ", 0, 1) // Media in the same file
+	w.end()
+	w.end()
+	w.end()
+
+	w.start("stbl")
+
+	w.startFull("stsd", 0, 0)
+	w.u32(1)
+	writeSampleEntry(w, track)
+	w.end()
+
+	// Samples are in the fragments, the tables stay empty
+	for _, box := range []string{"stts", "stsc", "stco"} {
+		w.startFull(box, 0, 0)
+		w.u32(0)
+		w.end()
+	}
+	w.startFull("stsz", 0, 0)
+	w.u32(0)
+	w.u32(0)
+	w.end()
+
+	w.end() // stbl
+	w.end() // minf
+	w.end() // mdia
+	w.end() // trak
+}
+
+func writeSampleEntry(w *writer, track *Track) {
+	if !track.IsVideo() {
+		writeAudioSampleEntry(w, track)
+		return
+	}
+
+	w.start(track.Codec)
+	w.zeros(6)
+	w.u16(1) // Data reference index
+	w.zeros(16)
+	w.u16(uint16(track.Width))
+	w.u16(uint16(track.Height))
+	w.u32(0x00480000) // 72 dpi
+	w.u32(0x00480000)
+	w.u32(0)
+	w.u16(1) // Frame count
+	w.zeros(32)
+	w.u16(0x0018) // Depth
+	w.u16(0xFFFF) // Pre-defined
+
+	if track.Codec == CodecH265 {
+		w.start("hvcC")
+	} else {
+		w.start("avcC")
+	}
+	w.bytes(track.Config)
+	w.end()
+
+	w.end()
+}
+
+// writeAudioSampleEntry writes a mono 8 bit G.711 entry
+func writeAudioSampleEntry(w *writer, track *Track) {
+	w.start(track.Codec)
+	w.zeros(6)
+	w.u16(1) // Data reference index
+	w.zeros(8)
+	w.u16(1)  // Channels
+	w.u16(16) // Sample size
+	w.u16(0)
+	w.u16(0)
+	w.u32(track.TimeScale << 16)
+	w.end()
+}
+
+// Fragment returns moof and mdat for the track fragments, empty ones are left out
+func Fragment(sequence uint32, fragments ...TrackFragment) []byte {
+	w := &writer{}
+
+	w.start("moof")
+
+	w.startFull("mfhd", 0, 0)
+	w.u32(sequence)
+	w.end()
+
+	var dataOffsets []int
+	var trafs []TrackFragment
+
+	for _, fragment := range fragments {
+		if len(fragment.Samples) == 0 {
+			continue
+		}
+		trafs = append(trafs, fragment)
+
+		w.start("traf")
+
+		w.startFull("tfhd", 0, 0x020000) // default-base-is-moof
+		w.u32(fragment.TrackID)
+		w.end()
+
+		w.startFull("tfdt", 1, 0)
+		w.u64(fragment.BaseTime)
+		w.end()
+
+		// Data offset, sample duration, size and flags present
+		w.startFull("trun", 0, 0x000701)
+		w.u32(uint32(len(fragment.Samples)))
+		dataOffsets = append(dataOffsets, len(w.buf))
+		w.u32(0) // Patched below
+		for _, sample := range fragment.Samples {
+			w.u32(sample.Duration)
+			w.u32(uint32(len(sample.Data)))
+			if sample.Keyframe {
+				w.u32(flagsKeyframe)
+			} else {
+				w.u32(flagsNonKeyframe)
+			}
+		}
+		w.end()
+
+		w.end() // traf
+	}
+
+	w.end() // moof
+
+	// Samples start after the mdat header, one track after the other
+	offset := uint32(len(w.buf) + 8)
+	for i, fragment := range trafs {
+		at := dataOffsets[i]
+		w.buf[at] = byte(offset >> 24)
+		w.buf[at+1] = byte(offset >> 16)
+		w.buf[at+2] = byte(offset >> 8)
+		w.buf[at+3] = byte(offset)
+
+		for _, sample := range fragment.Samples {
+			offset += uint32(len(sample.Data))
+		}
+	}
+
+	w.start("mdat")
+	for _, fragment := range trafs {
+		for _, sample := range fragment.Samples {
+			w.bytes(sample.Data)
+		}
+	}
+	w.end()
+
+	return w.buf
+}
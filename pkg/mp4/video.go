@@ -0,0 +1,190 @@
+package mp4
+
+import (
+	"bytes"
+
+	"github.com/pion/rtp"
+
+	"tuya-ipc-terminal/pkg/h264"
+	"tuya-ipc-terminal/pkg/h265"
+)
+
+const (
+	videoClockRate = 90000
+
+	// Frame duration used when the timestamps jump, e.g. after an upstream reconnect
+	defaultFrameDuration = videoClockRate / 15
+	maxFrameDuration     = 5 * videoClockRate
+)
+
+// VideoSampler turns the RTP of an H.264 or H.265 stream into samples. The
+// durations come from the RTP timestamps, jumps (reconnects) are smoothed
+// over so the sample timeline stays continuous.
+type VideoSampler struct {
+	hevc         bool
+	trackID      uint32
+	depacketizer interface{ Push(*rtp.Packet) }
+
+	vps, sps, pps []byte
+	track         *Track
+
+	pending      *Sample
+	pendingTS    uint32
+	lastDuration uint32
+
+	onSample func(track *Track, sample Sample)
+}
+
+// NewVideoSampler calls onSample for every access unit once its duration is
+// known, starting with the first keyframe. The track is replaced when the
+// parameter sets change. Known parameter sets may be passed in advance.
+func NewVideoSampler(hevc bool, trackID uint32, vps, sps, pps []byte, onSample func(track *Track, sample Sample)) *VideoSampler {
+	s := &VideoSampler{
+		hevc:     hevc,
+		trackID:  trackID,
+		vps:      vps,
+		sps:      sps,
+		pps:      pps,
+		onSample: onSample,
+	}
+
+	if hevc {
+		s.depacketizer = h265.NewDepacketizer(s.onAccessUnit)
+	} else {
+		s.depacketizer = h264.NewDepacketizer(s.onAccessUnit)
+	}
+
+	return s
+}
+
+func (s *VideoSampler) Push(packet *rtp.Packet) {
+	s.depacketizer.Push(packet)
+}
+
+// Track returns the current track, nil before the first keyframe
+func (s *VideoSampler) Track() *Track {
+	return s.track
+}
+
+func (s *VideoSampler) onAccessUnit(nalus [][]byte, timestamp uint32) {
+	var keyframe, changed bool
+	var data []byte
+
+	update := func(current *[]byte, nalu []byte) {
+		if !bytes.Equal(*current, nalu) {
+			*current = nalu
+			changed = true
+		}
+	}
+
+	for _, nalu := range nalus {
+		if len(nalu) == 0 {
+			continue
+		}
+
+		if s.hevc {
+			switch t := h265.NALUType(nalu); {
+			case t == h265.NALUTypeVPS:
+				update(&s.vps, nalu)
+				continue
+			case t == h265.NALUTypeSPS:
+				update(&s.sps, nalu)
+				continue
+			case t == h265.NALUTypePPS:
+				update(&s.pps, nalu)
+				continue
+			case t == 35: // AUD
+				continue
+			case t >= 16 && t <= 21: // IRAP
+				keyframe = true
+			}
+		} else {
+			switch h264.NALUType(nalu) {
+			case h264.NALUTypeSPS:
+				update(&s.sps, nalu)
+				continue
+			case h264.NALUTypePPS:
+				update(&s.pps, nalu)
+				continue
+			case h264.NALUTypeAUD:
+				continue
+			case h264.NALUTypeIFrame:
+				keyframe = true
+			}
+		}
+
+		size := len(nalu)
+		data = append(data, byte(size>>24), byte(size>>16), byte(size>>8), byte(size))
+		data = append(data, nalu...)
+	}
+
+	if len(data) == 0 {
+		return
+	}
+
+	if keyframe && (s.track == nil || changed) {
+		if track := s.createTrack(); track != nil {
+			// Samples of the old track are flushed first
+			s.flush(timestamp)
+			s.track = track
+		}
+	}
+
+	if s.track == nil {
+		return
+	}
+
+	s.flush(timestamp)
+
+	s.pending = &Sample{Keyframe: keyframe, Data: data}
+	s.pendingTS = timestamp
+}
+
+// flush emits the pending sample, its duration ends at timestamp
+func (s *VideoSampler) flush(timestamp uint32) {
+	if s.pending == nil {
+		return
+	}
+
+	duration := timestamp - s.pendingTS
+	if int32(duration) <= 0 || duration > maxFrameDuration {
+		duration = s.lastDuration
+		if duration == 0 {
+			duration = defaultFrameDuration
+		}
+	}
+
+	s.lastDuration = duration
+	s.pending.Duration = duration
+	s.onSample(s.track, *s.pending)
+	s.pending = nil
+}
+
+// createTrack builds the track from the parameter sets seen so far
+func (s *VideoSampler) createTrack() *Track {
+	if s.sps == nil || s.pps == nil || (s.hevc && s.vps == nil) {
+		return nil
+	}
+
+	track := &Track{ID: s.trackID, TimeScale: videoClockRate}
+
+	if s.hevc {
+		sps, err := h265.DecodeSPS(s.sps)
+		if err != nil {
+			return nil
+		}
+		track.Codec = CodecH265
+		track.Config = h265.HEVCDecoderConfig(sps, s.vps, s.sps, s.pps)
+		track.Width, track.Height = sps.Width, sps.Height
+	} else {
+		sps, err := h264.DecodeSPS(s.sps)
+		if err != nil {
+			return nil
+		}
+		track.Codec = CodecH264
+		track.Config = h264.AVCDecoderConfig(s.sps, s.pps)
+		track.Width, track.Height = sps.Width, sps.Height
+	}
+
+	return track
+}
@@ -0,0 +1,111 @@
+package onvif
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"tuya-ipc-terminal/pkg/storage"
+)
+
+func (s *Server) handleDevice(w http.ResponseWriter, dev *device, action string, request *envelope) {
+	switch action {
+	case "GetDeviceInformation":
+		writeResponse(w, fmt.Sprintf(`<tds:GetDeviceInformationResponse>`+
+			`<tds:Manufacturer>Tuya</tds:Manufacturer>`+
+			`<tds:Model>%s</tds:Model>`+
+			`<tds:FirmwareVersion>%s</tds:FirmwareVersion>`+
+			`<tds:SerialNumber>%s</tds:SerialNumber>`+
+			`<tds:HardwareId>%s</tds:HardwareId>`+
+			`</tds:GetDeviceInformationResponse>`,
+			escape(dev.camera.DeviceName), escape(s.config.Version), escape(dev.camera.DeviceID), escape(dev.camera.ProductID)))
+
+	case "GetSystemDateAndTime":
+		now := time.Now().UTC()
+		writeResponse(w, fmt.Sprintf(`<tds:GetSystemDateAndTimeResponse><tds:SystemDateAndTime>`+
+			`<tt:DateTimeType>NTP</tt:DateTimeType><tt:DaylightSavings>false</tt:DaylightSavings>`+
+			`<tt:TimeZone><tt:TZ>UTC0</tt:TZ></tt:TimeZone>`+
+			`<tt:UTCDateTime><tt:Time><tt:Hour>%d</tt:Hour><tt:Minute>%d</tt:Minute><tt:Second>%d</tt:Second></tt:Time>`+
+			`<tt:Date><tt:Year>%d</tt:Year><tt:Month>%d</tt:Month><tt:Day>%d</tt:Day></tt:Date></tt:UTCDateTime>`+
+			`</tds:SystemDateAndTime></tds:GetSystemDateAndTimeResponse>`,
+			now.Hour(), now.Minute(), now.Second(), now.Year(), now.Month(), now.Day()))
+
+	case "GetCapabilities":
+		ptzCapabilities := ""
+		if s.supportsPTZ(dev) {
+			ptzCapabilities = fmt.Sprintf(`<tt:PTZ><tt:XAddr>%s/ptz_service</tt:XAddr></tt:PTZ>`, dev.baseURL)
+		}
+
+		writeResponse(w, fmt.Sprintf(`<tds:GetCapabilitiesResponse><tds:Capabilities>`+
+			`<tt:Device><tt:XAddr>%s/device_service</tt:XAddr></tt:Device>`+
+			`<tt:Media><tt:XAddr>%s/media_service</tt:XAddr><tt:StreamingCapabilities>`+
+			`<tt:RTPMulticast>false</tt:RTPMulticast><tt:RTP_TCP>true</tt:RTP_TCP><tt:RTP_RTSP_TCP>true</tt:RTP_RTSP_TCP>`+
+			`</tt:StreamingCapabilities></tt:Media>%s`+
+			`</tds:Capabilities></tds:GetCapabilitiesResponse>`,
+			dev.baseURL, dev.baseURL, ptzCapabilities))
+
+	case "GetServices":
+		services := []string{
+			service(nsDevice, dev.baseURL+"/device_service"),
+			service(nsMedia, dev.baseURL+"/media_service"),
+		}
+		if s.supportsPTZ(dev) {
+			services = append(services, service(nsPTZ, dev.baseURL+"/ptz_service"))
+		}
+
+		writeResponse(w, `<tds:GetServicesResponse>`+strings.Join(services, "")+`</tds:GetServicesResponse>`)
+
+	case "GetServiceCapabilities":
+		writeResponse(w, `<tds:GetServiceCapabilitiesResponse><tds:Capabilities>`+
+			`<tds:Network/><tds:Security UsernameToken="true" HttpDigest="true"/><tds:System/>`+
+			`</tds:Capabilities></tds:GetServiceCapabilitiesResponse>`)
+
+	case "GetScopes":
+		var items strings.Builder
+		for _, scope := range scopes(dev.camera, s.supportsPTZ(dev)) {
+			fmt.Fprintf(&items, `<tds:Scopes><tt:ScopeDef>Fixed</tt:ScopeDef><tt:ScopeItem>%s</tt:ScopeItem></tds:Scopes>`, escape(scope))
+		}
+
+		writeResponse(w, `<tds:GetScopesResponse>`+items.String()+`</tds:GetScopesResponse>`)
+
+	case "GetHostname":
+		writeResponse(w, fmt.Sprintf(`<tds:GetHostnameResponse><tds:HostnameInformation>`+
+			`<tt:FromDHCP>false</tt:FromDHCP><tt:Name>%s</tt:Name>`+
+			`</tds:HostnameInformation></tds:GetHostnameResponse>`, escape(cameraName(dev.camera))))
+
+	default:
+		writeFault(w, http.StatusBadRequest, "ter:ActionNotSupported", action+" is not supported")
+	}
+}
+
+func service(namespace, xaddr string) string {
+	return fmt.Sprintf(`<tds:Service><tds:Namespace>%s</tds:Namespace><tds:XAddr>%s</tds:XAddr>`+
+		`<tds:Version><tt:Major>2</tt:Major><tt:Minor>0</tt:Minor></tds:Version></tds:Service>`, namespace, xaddr)
+}
+
+// scopes describe the device in WS-Discovery and GetScopes
+func scopes(camera *storage.CameraInfo, ptz bool) []string {
+	scopes := []string{
+		"onvif://www.onvif.org/type/video_encoder",
+		"onvif://www.onvif.org/Profile/Streaming",
+		"onvif://www.onvif.org/name/" + url.PathEscape(camera.DeviceName),
+		"onvif://www.onvif.org/hardware/" + url.PathEscape(camera.ProductID),
+	}
+
+	if ptz {
+		scopes = append(scopes, "onvif://www.onvif.org/type/ptz")
+	}
+
+	return scopes
+}
+
+// supportsPTZ asks the camera's config once, errors are treated as no PTZ
+func (s *Server) supportsPTZ(dev *device) bool {
+	supported, err := s.ptzController.Supports(dev.camera)
+	if err != nil {
+		return false
+	}
+	return supported
+}
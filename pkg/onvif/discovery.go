@@ -0,0 +1,143 @@
+package onvif
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"tuya-ipc-terminal/pkg/core"
+	"tuya-ipc-terminal/pkg/storage"
+)
+
+const discoveryAddress = "239.255.255.250:3702"
+
+const probeMatchTemplate = `<?xml version="1.0" encoding="UTF-8"?>
+<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope"` +
+	` xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing"` +
+	` xmlns:d="http://schemas.xmlsoap.org/ws/2005/04/discovery"` +
+	` xmlns:dn="http://www.onvif.org/ver10/network/wsdl">` +
+	`<s:Header>` +
+	`<a:MessageID>urn:uuid:%s</a:MessageID>` +
+	`<a:RelatesTo>%s</a:RelatesTo>` +
+	`<a:To>http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous</a:To>` +
+	`<a:Action>http://schemas.xmlsoap.org/ws/2005/04/discovery/ProbeMatches</a:Action>` +
+	`</s:Header>` +
+	`<s:Body><d:ProbeMatches><d:ProbeMatch>` +
+	`<a:EndpointReference><a:Address>%s</a:Address></a:EndpointReference>` +
+	`<d:Types>dn:NetworkVideoTransmitter</d:Types>` +
+	`<d:Scopes>%s</d:Scopes>` +
+	`<d:XAddrs>%s</d:XAddrs>` +
+	`<d:MetadataVersion>1</d:MetadataVersion>` +
+	`</d:ProbeMatch></d:ProbeMatches></s:Body></s:Envelope>`
+
+type probe struct {
+	Header struct {
+		MessageID string `xml:"MessageID"`
+	} `xml:"Header"`
+	Body struct {
+		Probe *struct {
+			Types string `xml:"Types"`
+		} `xml:"Probe"`
+	} `xml:"Body"`
+}
+
+// discovery answers WS-Discovery probes with a match for every camera
+type discovery struct {
+	server   *Server
+	conn     *net.UDPConn
+	httpAddr *net.TCPAddr
+}
+
+func startDiscovery(server *Server, httpAddr *net.TCPAddr) (*discovery, error) {
+	group, err := net.ResolveUDPAddr("udp4", discoveryAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		return nil, fmt.Errorf("failed to join %s: %v", discoveryAddress, err)
+	}
+
+	d := &discovery{server: server, conn: conn, httpAddr: httpAddr}
+	go d.run()
+
+	return d, nil
+}
+
+func (d *discovery) close() {
+	d.conn.Close()
+}
+
+func (d *discovery) run() {
+	buffer := make([]byte, 64<<10)
+
+	for {
+		n, addr, err := d.conn.ReadFromUDP(buffer)
+		if err != nil {
+			return
+		}
+
+		d.handle(buffer[:n], addr)
+	}
+}
+
+func (d *discovery) handle(data []byte, addr *net.UDPAddr) {
+	var message probe
+	if err := xml.Unmarshal(data, &message); err != nil || message.Body.Probe == nil {
+		return
+	}
+
+	// Probes without types look for any device
+	types := message.Body.Probe.Types
+	if types != "" && !strings.Contains(types, "NetworkVideoTransmitter") && !strings.Contains(types, "Device") {
+		return
+	}
+
+	cameras, err := d.server.storageManager.GetAllCameras()
+	if err != nil {
+		core.Logger.Error().Err(err).Msg("ONVIF discovery failed to list cameras")
+		return
+	}
+
+	host := net.JoinHostPort(d.localIP(addr).String(), fmt.Sprint(d.httpAddr.Port))
+
+	core.Logger.Debug().Msgf("ONVIF probe from %s, announcing %d camera(s) at %s", addr, len(cameras), host)
+
+	for _, camera := range cameras {
+		xaddr := fmt.Sprintf("http://%s/onvif/%s/device_service", host, cameraName(&camera))
+
+		response := fmt.Sprintf(probeMatchTemplate,
+			uuid.NewString(), escape(message.Header.MessageID), endpointAddress(&camera),
+			escape(strings.Join(scopes(&camera, false), " ")), escape(xaddr))
+
+		if _, err := d.conn.WriteToUDP([]byte(response), addr); err != nil {
+			core.Logger.Debug().Err(err).Msgf("Failed to answer ONVIF probe from %s", addr)
+			return
+		}
+	}
+}
+
+// localIP returns the address of the HTTP server as seen from the prober
+func (d *discovery) localIP(addr *net.UDPAddr) net.IP {
+	if !d.httpAddr.IP.IsUnspecified() {
+		return d.httpAddr.IP
+	}
+
+	// Connecting a UDP socket sends nothing, it only picks the route
+	conn, err := net.DialUDP("udp4", nil, addr)
+	if err != nil {
+		return net.IPv4(127, 0, 0, 1)
+	}
+	defer conn.Close()
+
+	return conn.LocalAddr().(*net.UDPAddr).IP
+}
+
+// endpointAddress is the stable WS-Discovery identity of a camera
+func endpointAddress(camera *storage.CameraInfo) string {
+	return "urn:uuid:" + uuid.NewSHA1(uuid.NameSpaceURL, []byte("tuya-ipc-terminal:"+camera.DeviceID)).String()
+}
@@ -0,0 +1,137 @@
+package onvif
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type profileRequest struct {
+	ProfileToken string `xml:"ProfileToken"`
+}
+
+func (s *Server) handleMedia(w http.ResponseWriter, dev *device, action string, request *envelope) {
+	switch action {
+	case "GetProfiles":
+		ptzSupported := s.supportsPTZ(dev)
+		streams := profiles(dev.camera)
+
+		var result strings.Builder
+		for _, p := range streams {
+			result.WriteString(mediaProfile("trt:Profiles", p, streams[0], ptzSupported))
+		}
+
+		writeResponse(w, `<trt:GetProfilesResponse>`+result.String()+`</trt:GetProfilesResponse>`)
+
+	case "GetProfile":
+		p, ok := requestedProfile(w, dev, request)
+		if !ok {
+			return
+		}
+
+		writeResponse(w, `<trt:GetProfileResponse>`+mediaProfile("trt:Profile", *p, profiles(dev.camera)[0], s.supportsPTZ(dev))+`</trt:GetProfileResponse>`)
+
+	case "GetStreamUri":
+		p, ok := requestedProfile(w, dev, request)
+		if !ok {
+			return
+		}
+
+		uri := fmt.Sprintf("rtsp://%s%s", net.JoinHostPort(hostname(dev.host), fmt.Sprint(s.config.RTSPPort)), dev.camera.RTSPPath)
+		if p.token == "sd" {
+			uri += "/sd"
+		}
+
+		writeResponse(w, fmt.Sprintf(`<trt:GetStreamUriResponse><trt:MediaUri>`+
+			`<tt:Uri>%s</tt:Uri><tt:InvalidAfterConnect>false</tt:InvalidAfterConnect>`+
+			`<tt:InvalidAfterReboot>false</tt:InvalidAfterReboot><tt:Timeout>PT0S</tt:Timeout>`+
+			`</trt:MediaUri></trt:GetStreamUriResponse>`, escape(uri)))
+
+	case "GetSnapshotUri":
+		// There's no decoder to make a JPEG of the stream
+		writeFault(w, http.StatusBadRequest, "ter:ActionNotSupported", "snapshots are not supported")
+
+	case "GetVideoSources":
+		hd := profiles(dev.camera)[0]
+		writeResponse(w, fmt.Sprintf(`<trt:GetVideoSourcesResponse><trt:VideoSources token="source">`+
+			`<tt:Framerate>15</tt:Framerate><tt:Resolution><tt:Width>%d</tt:Width><tt:Height>%d</tt:Height></tt:Resolution>`+
+			`</trt:VideoSources></trt:GetVideoSourcesResponse>`, hd.width, hd.height))
+
+	case "GetVideoSourceConfigurations":
+		writeResponse(w, `<trt:GetVideoSourceConfigurationsResponse>`+
+			videoSourceConfiguration("trt:Configurations", profiles(dev.camera)[0])+
+			`</trt:GetVideoSourceConfigurationsResponse>`)
+
+	case "GetVideoEncoderConfigurations":
+		var result strings.Builder
+		for _, p := range profiles(dev.camera) {
+			result.WriteString(videoEncoderConfiguration("trt:Configurations", p))
+		}
+
+		writeResponse(w, `<trt:GetVideoEncoderConfigurationsResponse>`+result.String()+`</trt:GetVideoEncoderConfigurationsResponse>`)
+
+	case "GetServiceCapabilities":
+		writeResponse(w, `<trt:GetServiceCapabilitiesResponse><trt:Capabilities SnapshotUri="false" Rotation="false">`+
+			`<trt:ProfileCapabilities MaximumNumberOfProfiles="2"/>`+
+			`<trt:StreamingCapabilities RTPMulticast="false" RTP_TCP="true" RTP_RTSP_TCP="true"/>`+
+			`</trt:Capabilities></trt:GetServiceCapabilitiesResponse>`)
+
+	default:
+		writeFault(w, http.StatusBadRequest, "ter:ActionNotSupported", action+" is not supported")
+	}
+}
+
+// requestedProfile returns the profile of the request's ProfileToken, it
+// writes a fault if there's none
+func requestedProfile(w http.ResponseWriter, dev *device, request *envelope) (*profile, bool) {
+	var params profileRequest
+	if err := request.decode(&params); err != nil {
+		writeFault(w, http.StatusBadRequest, "ter:InvalidArgVal", "invalid request")
+		return nil, false
+	}
+
+	p := findProfile(dev.camera, params.ProfileToken)
+	if p == nil {
+		writeFault(w, http.StatusBadRequest, "ter:InvalidArgVal/ter:NoProfile", "profile not found: "+params.ProfileToken)
+		return nil, false
+	}
+
+	return p, true
+}
+
+// mediaProfile describes a stream, source is the HD stream
+func mediaProfile(element string, p profile, source profile, ptz bool) string {
+	ptzConfiguration := ""
+	if ptz {
+		ptzConfiguration = ptzConfigurationElement("tt:PTZConfiguration")
+	}
+
+	return fmt.Sprintf(`<%s token="%s" fixed="true"><tt:Name>%s</tt:Name>%s%s%s</%s>`,
+		element, p.token, p.name,
+		videoSourceConfiguration("tt:VideoSourceConfiguration", source),
+		videoEncoderConfiguration("tt:VideoEncoderConfiguration", p),
+		ptzConfiguration, element)
+}
+
+// videoSourceConfiguration is shared by the profiles, the camera has one sensor
+func videoSourceConfiguration(element string, hd profile) string {
+	return fmt.Sprintf(`<%s token="source"><tt:Name>VideoSource</tt:Name><tt:UseCount>2</tt:UseCount>`+
+		`<tt:SourceToken>source</tt:SourceToken><tt:Bounds x="0" y="0" width="%d" height="%d"/></%s>`,
+		element, hd.width, hd.height, element)
+}
+
+func videoEncoderConfiguration(element string, p profile) string {
+	return fmt.Sprintf(`<%s token="%s"><tt:Name>%s</tt:Name><tt:UseCount>1</tt:UseCount>`+
+		`<tt:Encoding>%s</tt:Encoding><tt:Resolution><tt:Width>%d</tt:Width><tt:Height>%d</tt:Height></tt:Resolution>`+
+		`<tt:Quality>5</tt:Quality><tt:SessionTimeout>PT60S</tt:SessionTimeout></%s>`,
+		element, p.token, p.name, p.encoding, p.width, p.height, element)
+}
+
+// hostname strips the port of a Host header
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
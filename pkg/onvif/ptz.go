@@ -0,0 +1,269 @@
+package onvif
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"tuya-ipc-terminal/pkg/core"
+	"tuya-ipc-terminal/pkg/ptz"
+	"tuya-ipc-terminal/pkg/tuya"
+)
+
+const (
+	// Tuya cameras don't report their presets, numbered slots are offered
+	presetSlots = 6
+
+	// Velocities below this don't move an axis
+	velocityThreshold = 0.1
+
+	// Moves without a timeout stop after this, as advertised in the configuration
+	defaultPTZTimeout = 5 * time.Second
+)
+
+type continuousMoveRequest struct {
+	Velocity struct {
+		PanTilt struct {
+			X float64 `xml:"x,attr"`
+			Y float64 `xml:"y,attr"`
+		} `xml:"PanTilt"`
+		Zoom struct {
+			X float64 `xml:"x,attr"`
+		} `xml:"Zoom"`
+	} `xml:"Velocity"`
+	Timeout string `xml:"Timeout"`
+}
+
+type presetRequest struct {
+	PresetToken string `xml:"PresetToken"`
+	PresetName  string `xml:"PresetName"`
+}
+
+func (s *Server) handlePTZ(w http.ResponseWriter, dev *device, action string, request *envelope) {
+	if !s.supportsPTZ(dev) {
+		writeFault(w, http.StatusBadRequest, "ter:ActionNotSupported", "camera doesn't support PTZ")
+		return
+	}
+
+	switch action {
+	case "GetNodes":
+		writeResponse(w, `<tptz:GetNodesResponse>`+ptzNode("tptz:PTZNode")+`</tptz:GetNodesResponse>`)
+
+	case "GetNode":
+		writeResponse(w, `<tptz:GetNodeResponse>`+ptzNode("tptz:PTZNode")+`</tptz:GetNodeResponse>`)
+
+	case "GetConfigurations":
+		writeResponse(w, `<tptz:GetConfigurationsResponse>`+ptzConfigurationElement("tptz:PTZConfiguration")+`</tptz:GetConfigurationsResponse>`)
+
+	case "GetConfiguration":
+		writeResponse(w, `<tptz:GetConfigurationResponse>`+ptzConfigurationElement("tptz:PTZConfiguration")+`</tptz:GetConfigurationResponse>`)
+
+	case "GetConfigurationOptions":
+		writeResponse(w, `<tptz:GetConfigurationOptionsResponse><tptz:PTZConfigurationOptions>`+
+			`<tt:Spaces>`+velocitySpaces+`</tt:Spaces>`+
+			`<tt:PTZTimeout><tt:Min>PT1S</tt:Min><tt:Max>PT60S</tt:Max></tt:PTZTimeout>`+
+			`</tptz:PTZConfigurationOptions></tptz:GetConfigurationOptionsResponse>`)
+
+	case "GetServiceCapabilities":
+		writeResponse(w, `<tptz:GetServiceCapabilitiesResponse><tptz:Capabilities EFlip="false" Reverse="false" GetCompatibleConfigurations="false"/></tptz:GetServiceCapabilitiesResponse>`)
+
+	case "GetStatus":
+		writeResponse(w, fmt.Sprintf(`<tptz:GetStatusResponse><tptz:PTZStatus>`+
+			`<tt:MoveStatus><tt:PanTilt>IDLE</tt:PanTilt><tt:Zoom>IDLE</tt:Zoom></tt:MoveStatus>`+
+			`<tt:UtcTime>%s</tt:UtcTime></tptz:PTZStatus></tptz:GetStatusResponse>`, time.Now().UTC().Format(time.RFC3339)))
+
+	case "ContinuousMove":
+		var params continuousMoveRequest
+		if err := request.decode(&params); err != nil {
+			writeFault(w, http.StatusBadRequest, "ter:InvalidArgVal", "invalid request")
+			return
+		}
+
+		command := moveCommand(params.Velocity.PanTilt.X, params.Velocity.PanTilt.Y, params.Velocity.Zoom.X)
+
+		timeout := defaultPTZTimeout
+		if params.Timeout != "" {
+			var err error
+			if timeout, err = parseDuration(params.Timeout); err != nil {
+				writeFault(w, http.StatusBadRequest, "ter:InvalidArgVal", err.Error())
+				return
+			}
+		}
+
+		if command.Action == tuya.PTZStop {
+			timeout = 0
+		}
+
+		if !s.sendPTZ(w, dev, command) {
+			return
+		}
+		s.scheduleStop(dev, timeout)
+
+		writeResponse(w, `<tptz:ContinuousMoveResponse/>`)
+
+	case "Stop":
+		s.scheduleStop(dev, 0)
+		if !s.sendPTZ(w, dev, tuya.PTZCommand{Action: tuya.PTZStop}) {
+			return
+		}
+
+		writeResponse(w, `<tptz:StopResponse/>`)
+
+	case "GetPresets":
+		var presets strings.Builder
+		for i := 1; i <= presetSlots; i++ {
+			fmt.Fprintf(&presets, `<tptz:Preset token="%d"><tt:Name>Preset %d</tt:Name></tptz:Preset>`, i, i)
+		}
+
+		writeResponse(w, `<tptz:GetPresetsResponse>`+presets.String()+`</tptz:GetPresetsResponse>`)
+
+	case "GotoPreset", "SetPreset":
+		var params presetRequest
+		if err := request.decode(&params); err != nil {
+			writeFault(w, http.StatusBadRequest, "ter:InvalidArgVal", "invalid request")
+			return
+		}
+
+		preset := presetNumber(params.PresetToken)
+		if preset == 0 {
+			preset = presetNumber(params.PresetName)
+		}
+		if preset == 0 {
+			writeFault(w, http.StatusBadRequest, "ter:InvalidArgVal/ter:NoToken", fmt.Sprintf("presets are numbered 1 to %d", presetSlots))
+			return
+		}
+
+		command := tuya.PTZCommand{Action: tuya.PTZPreset, Preset: preset}
+		if action == "SetPreset" {
+			command.Action = tuya.PTZSavePreset
+		}
+
+		if !s.sendPTZ(w, dev, command) {
+			return
+		}
+
+		if action == "SetPreset" {
+			writeResponse(w, fmt.Sprintf(`<tptz:SetPresetResponse><tptz:PresetToken>%d</tptz:PresetToken></tptz:SetPresetResponse>`, preset))
+		} else {
+			writeResponse(w, `<tptz:GotoPresetResponse/>`)
+		}
+
+	default:
+		writeFault(w, http.StatusBadRequest, "ter:ActionNotSupported", action+" is not supported")
+	}
+}
+
+func (s *Server) sendPTZ(w http.ResponseWriter, dev *device, command tuya.PTZCommand) bool {
+	err := s.ptzController.Send(dev.camera, command)
+	if errors.Is(err, ptz.ErrNotSupported) {
+		writeFault(w, http.StatusBadRequest, "ter:ActionNotSupported", err.Error())
+		return false
+	} else if err != nil {
+		core.Logger.Error().Err(err).Msgf("ONVIF PTZ %s on %s failed", command.Action, dev.camera.DeviceName)
+		writeFault(w, http.StatusInternalServerError, "ter:Action", err.Error())
+		return false
+	}
+	return true
+}
+
+// scheduleStop stops a move after the timeout, a zero timeout only cancels
+// the pending stop
+func (s *Server) scheduleStop(dev *device, timeout time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if timer := s.ptzStops[dev.camera.DeviceID]; timer != nil {
+		timer.Stop()
+		delete(s.ptzStops, dev.camera.DeviceID)
+	}
+
+	if timeout <= 0 {
+		return
+	}
+
+	camera := dev.camera
+	s.ptzStops[camera.DeviceID] = time.AfterFunc(timeout, func() {
+		if err := s.ptzController.Send(camera, tuya.PTZCommand{Action: tuya.PTZStop}); err != nil {
+			core.Logger.Error().Err(err).Msgf("ONVIF PTZ stop on %s failed", camera.DeviceName)
+		}
+	})
+}
+
+// moveCommand maps a velocity to the nearest of the eight directions, or a
+// zoom if there's no pan and tilt
+func moveCommand(x, y, zoom float64) tuya.PTZCommand {
+	horizontal, vertical := "", ""
+	if x >= velocityThreshold {
+		horizontal = "right"
+	} else if x <= -velocityThreshold {
+		horizontal = "left"
+	}
+	if y >= velocityThreshold {
+		vertical = "up"
+	} else if y <= -velocityThreshold {
+		vertical = "down"
+	}
+
+	// A mostly horizontal or vertical move shouldn't go diagonal
+	if horizontal != "" && vertical != "" {
+		if math.Abs(x) > 2*math.Abs(y) {
+			vertical = ""
+		} else if math.Abs(y) > 2*math.Abs(x) {
+			horizontal = ""
+		}
+	}
+
+	switch {
+	case horizontal != "" && vertical != "":
+		return tuya.PTZCommand{Action: tuya.PTZAction(vertical + "-" + horizontal)}
+	case horizontal != "":
+		return tuya.PTZCommand{Action: tuya.PTZAction(horizontal)}
+	case vertical != "":
+		return tuya.PTZCommand{Action: tuya.PTZAction(vertical)}
+	case zoom >= velocityThreshold:
+		return tuya.PTZCommand{Action: tuya.PTZZoomIn}
+	case zoom <= -velocityThreshold:
+		return tuya.PTZCommand{Action: tuya.PTZZoomOut}
+	}
+
+	return tuya.PTZCommand{Action: tuya.PTZStop}
+}
+
+// presetNumber parses tokens like "2" and names like "Preset 2", 0 if invalid
+func presetNumber(value string) int {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return 0
+	}
+
+	number, err := strconv.Atoi(fields[len(fields)-1])
+	if err != nil || number < 1 || number > presetSlots {
+		return 0
+	}
+	return number
+}
+
+const velocitySpaces = `<tt:ContinuousPanTiltVelocitySpace><tt:URI>` + panTiltVelocitySpace + `</tt:URI>` +
+	`<tt:XRange><tt:Min>-1</tt:Min><tt:Max>1</tt:Max></tt:XRange><tt:YRange><tt:Min>-1</tt:Min><tt:Max>1</tt:Max></tt:YRange>` +
+	`</tt:ContinuousPanTiltVelocitySpace>` +
+	`<tt:ContinuousZoomVelocitySpace><tt:URI>` + zoomVelocitySpace + `</tt:URI>` +
+	`<tt:XRange><tt:Min>-1</tt:Min><tt:Max>1</tt:Max></tt:XRange></tt:ContinuousZoomVelocitySpace>`
+
+func ptzNode(element string) string {
+	return fmt.Sprintf(`<%s token="ptz" FixedHomePosition="false"><tt:Name>PTZ</tt:Name>`+
+		`<tt:SupportedPTZSpaces>%s</tt:SupportedPTZSpaces>`+
+		`<tt:MaximumNumberOfPresets>%d</tt:MaximumNumberOfPresets><tt:HomeSupported>false</tt:HomeSupported></%s>`,
+		element, velocitySpaces, presetSlots, element)
+}
+
+func ptzConfigurationElement(element string) string {
+	return fmt.Sprintf(`<%s token="ptz"><tt:Name>PTZ</tt:Name><tt:UseCount>2</tt:UseCount><tt:NodeToken>ptz</tt:NodeToken>`+
+		`<tt:DefaultContinuousPanTiltVelocitySpace>%s</tt:DefaultContinuousPanTiltVelocitySpace>`+
+		`<tt:DefaultContinuousZoomVelocitySpace>%s</tt:DefaultContinuousZoomVelocitySpace>`+
+		`<tt:DefaultPTZTimeout>PT5S</tt:DefaultPTZTimeout></%s>`,
+		element, panTiltVelocitySpace, zoomVelocitySpace, element)
+}
@@ -0,0 +1,409 @@
+package onvif
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"tuya-ipc-terminal/pkg/core"
+	"tuya-ipc-terminal/pkg/ptz"
+	"tuya-ipc-terminal/pkg/rtsp"
+	"tuya-ipc-terminal/pkg/storage"
+	"tuya-ipc-terminal/pkg/tuya"
+	"tuya-ipc-terminal/pkg/utils"
+)
+
+const (
+	maxRequestSize = 64 << 10
+	nonceLifetime  = 5 * time.Minute
+	maxUsedNonces  = 1024            // Digest nonces with a nonce count
+	maxClockSkew   = 5 * time.Minute // Of the Created time of WS-Security password digests
+)
+
+// Operations answered without credentials, clients call them before
+// authenticating (ONVIF Core, access classes PRE_AUTH)
+var preAuthActions = map[string]bool{
+	"GetSystemDateAndTime":   true,
+	"GetServices":            true,
+	"GetServiceCapabilities": true,
+}
+
+type Config struct {
+	Listen   string // e.g. ":8000"
+	RTSPPort int
+	Version  string // Reported as firmware version
+}
+
+// Server makes every camera a virtual ONVIF device (Profile S) at
+// /onvif/<camera path>/device_service, media_service and ptz_service, found by
+// WS-Discovery. Streams point at the RTSP server, credentials are the RTSP users.
+type Server struct {
+	config         Config
+	rtspServer     *rtsp.RTSPServer
+	storageManager *storage.StorageManager
+	ptzController  *ptz.Controller
+	httpServer     *http.Server
+	discovery      *discovery
+
+	nonceKey    []byte                  // Signs the HTTP Digest nonces, they need no state until used
+	nonces      map[string]*digestNonce // Used HTTP Digest nonces
+	tokenNonces map[string]time.Time    // Used WS-Security nonces -> expiry
+	ptzStops    map[string]*time.Timer  // Device ID -> stop of a move with timeout
+	mutex       sync.Mutex
+}
+
+type digestNonce struct {
+	expires time.Time
+	nc      uint64 // Highest nonce count used
+}
+
+// device is the virtual ONVIF device of a camera for one request
+type device struct {
+	camera  *storage.CameraInfo
+	baseURL string // http://host:port/onvif/<name>
+	host    string // Host the client connected to
+}
+
+func NewServer(config Config, rtspServer *rtsp.RTSPServer, storageManager *storage.StorageManager) *Server {
+	nonceKey := make([]byte, 32)
+	rand.Read(nonceKey)
+
+	s := &Server{
+		config:         config,
+		rtspServer:     rtspServer,
+		storageManager: storageManager,
+		ptzController:  ptz.NewController(storageManager),
+		nonceKey:       nonceKey,
+		nonces:         make(map[string]*digestNonce),
+		tokenNonces:    make(map[string]time.Time),
+		ptzStops:       make(map[string]*time.Timer),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /onvif/{camera}/{service}", s.handleService)
+
+	s.httpServer = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	return s
+}
+
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.config.Listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", s.config.Listen, err)
+	}
+
+	s.discovery, err = startDiscovery(s, listener.Addr().(*net.TCPAddr))
+	if err != nil {
+		// Clients can still add the devices by address
+		core.Logger.Warn().Err(err).Msg("ONVIF WS-Discovery is not available")
+	}
+
+	core.Logger.Info().Msgf("ONVIF available on http://%s/onvif/<camera>/device_service", listener.Addr())
+
+	go func() {
+		if err := s.httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			core.Logger.Error().Err(err).Msg("ONVIF server failed")
+		}
+	}()
+
+	return nil
+}
+
+func (s *Server) Close() {
+	if s.discovery != nil {
+		s.discovery.close()
+	}
+
+	s.httpServer.Close()
+	s.ptzController.Close()
+
+	s.mutex.Lock()
+	for id, timer := range s.ptzStops {
+		timer.Stop()
+		delete(s.ptzStops, id)
+	}
+	s.mutex.Unlock()
+}
+
+func (s *Server) handleService(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestSize))
+	if err != nil {
+		writeFault(w, http.StatusBadRequest, "ter:InvalidArgs", "failed to read request")
+		return
+	}
+
+	var request envelope
+	if err := xml.Unmarshal(body, &request); err != nil {
+		writeFault(w, http.StatusBadRequest, "ter:WellFormed", "invalid SOAP envelope")
+		return
+	}
+
+	action := request.action()
+
+	camera, err := s.rtspServer.LookupCamera(r.PathValue("camera"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if !s.authorize(w, r, &request, camera, action) {
+		return
+	}
+
+	dev := &device{
+		camera:  camera,
+		baseURL: fmt.Sprintf("http://%s/onvif/%s", r.Host, cameraName(camera)),
+		host:    r.Host,
+	}
+
+	core.Logger.Trace().Msgf("ONVIF %s %s for %s", r.PathValue("service"), action, camera.DeviceName)
+
+	switch r.PathValue("service") {
+	case "device_service":
+		s.handleDevice(w, dev, action, &request)
+	case "media_service":
+		s.handleMedia(w, dev, action, &request)
+	case "ptz_service":
+		s.handlePTZ(w, dev, action, &request)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// authorize checks a UsernameToken or HTTP Digest against the RTSP users.
+// Password digests of WS-Security need a kept password, their nonces are used
+// once and their Created time must be within maxClockSkew. Digest nonce counts
+// must increase.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request, request *envelope, camera *storage.CameraInfo, action string) bool {
+	if !s.rtspServer.AuthenticationEnabled() || preAuthActions[action] {
+		return true
+	}
+
+	var user *storage.RTSPUser
+
+	token := request.Header.Security.UsernameToken
+	switch {
+	case token.Username == "":
+	case token.Password.Type == "" || strings.HasSuffix(token.Password.Type, "#PasswordText"):
+		user = s.rtspServer.CheckPassword(token.Username, token.Password.Value)
+	case strings.HasSuffix(token.Password.Type, "#PasswordDigest"):
+		user = s.checkPasswordDigest(token.Username, token.Password.Value, token.Nonce, token.Created)
+	}
+
+	if scheme, params, ok := strings.Cut(r.Header.Get("Authorization"), " "); user == nil && ok && strings.EqualFold(scheme, "digest") {
+		authParams := rtsp.ParseAuthParams(params)
+		if expires, ok := s.checkNonce(authParams["nonce"]); ok {
+			user = s.rtspServer.CheckDigest(r.Method, r.RequestURI, authParams)
+			if user != nil && !s.useNonceCount(authParams["nonce"], expires, authParams["qop"], authParams["nc"]) {
+				core.Logger.Warn().Msgf("ONVIF user %s replayed a digest nonce count", user.Username)
+				user = nil
+			}
+		}
+	}
+
+	if user == nil {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Digest realm="%s", qop="auth", nonce="%s", algorithm=MD5`, rtsp.DigestRealm, s.newNonce()))
+		writeFault(w, http.StatusUnauthorized, "ter:NotAuthorized", "sender not authorized")
+		return false
+	}
+
+	if !user.CanAccess(camera.RTSPPath) {
+		core.Logger.Warn().Msgf("ONVIF user %s is not allowed to access %s", user.Username, camera.RTSPPath)
+		writeFault(w, http.StatusForbidden, "ter:NotAuthorized", "sender not authorized")
+		return false
+	}
+
+	return true
+}
+
+func (s *Server) newNonce() string {
+	return s.nonceAt(time.Now())
+}
+
+// nonceAt returns a nonce created at the given time: the time in hex, random
+// characters and their signature
+func (s *Server) nonceAt(created time.Time) string {
+	value := fmt.Sprintf("%016x%s", created.UnixNano(), utils.RandString(16, 16))
+	return value + s.signNonce(value)
+}
+
+func (s *Server) signNonce(value string) string {
+	mac := hmac.New(sha256.New, s.nonceKey)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// checkNonce verifies the signature and age of a nonce, it returns when the
+// nonce expires
+func (s *Server) checkNonce(nonce string) (time.Time, bool) {
+	if len(nonce) != 64 {
+		return time.Time{}, false
+	}
+
+	value, signature := nonce[:32], nonce[32:]
+	if !hmac.Equal([]byte(signature), []byte(s.signNonce(value))) {
+		return time.Time{}, false
+	}
+
+	created, err := strconv.ParseInt(value[:16], 16, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	expires := time.Unix(0, created).Add(nonceLifetime)
+	return expires, time.Now().Before(expires)
+}
+
+// useNonceCount records the nonce count of a checked digest, it must be
+// higher than every count used with the nonce before. Only nonces of
+// authenticated requests are kept, until they expire.
+func (s *Server) useNonceCount(nonce string, expires time.Time, qop, nc string) bool {
+	if qop != "auth" {
+		return false
+	}
+	count, err := strconv.ParseUint(nc, 16, 64)
+	if err != nil {
+		return false
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	known, ok := s.nonces[nonce]
+	if !ok {
+		now := time.Now()
+		for used, n := range s.nonces {
+			if now.After(n.expires) {
+				delete(s.nonces, used)
+			}
+		}
+		if len(s.nonces) >= maxUsedNonces {
+			core.Logger.Warn().Msg("Too many ONVIF digest nonces in use, rejecting new ones")
+			return false
+		}
+
+		known = &digestNonce{expires: expires}
+		s.nonces[nonce] = known
+	}
+
+	if count <= known.nc {
+		return false
+	}
+	known.nc = count
+	return true
+}
+
+// checkPasswordDigest checks a WS-Security password digest and remembers its
+// nonce until the Created time is out of the allowed skew
+func (s *Server) checkPasswordDigest(username, digest, encodedNonce, created string) *storage.RTSPUser {
+	createdAt, err := time.Parse(time.RFC3339, created)
+	if err != nil {
+		return nil
+	}
+	now := time.Now()
+	if skew := now.Sub(createdAt); skew > maxClockSkew || skew < -maxClockSkew {
+		core.Logger.Warn().Msgf("ONVIF user %s sent a password digest created at %s", username, created)
+		return nil
+	}
+
+	nonce, err := base64.StdEncoding.DecodeString(encodedNonce)
+	if err != nil || len(nonce) == 0 {
+		return nil
+	}
+
+	user := s.rtspServer.CheckPasswordDigest(username, digest, nonce, created)
+	if user == nil {
+		return nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for known, expires := range s.tokenNonces {
+		if now.After(expires) {
+			delete(s.tokenNonces, known)
+		}
+	}
+	if _, used := s.tokenNonces[encodedNonce]; used {
+		core.Logger.Warn().Msgf("ONVIF user %s replayed a password digest", username)
+		return nil
+	}
+	s.tokenNonces[encodedNonce] = createdAt.Add(maxClockSkew)
+
+	return user
+}
+
+// cameraName is the URL path element of a camera
+func cameraName(camera *storage.CameraInfo) string {
+	return url.PathEscape(strings.TrimPrefix(camera.RTSPPath, "/"))
+}
+
+// profile is a stream of a camera, hd or sd
+type profile struct {
+	token    string
+	name     string
+	encoding string
+	width    int
+	height   int
+}
+
+// profiles returns the streams of the skill, HD and SD with common sizes if
+// the skill doesn't list them
+func profiles(camera *storage.CameraInfo) []profile {
+	var skill tuya.Skill
+	json.Unmarshal([]byte(camera.Skill), &skill)
+
+	result := []profile{
+		{token: "hd", name: "MainStream", encoding: "H264", width: 1920, height: 1080},
+		{token: "sd", name: "SubStream", encoding: "H264", width: 640, height: 360},
+	}
+
+	for _, video := range skill.Videos {
+		i := -1
+		switch video.StreamType {
+		case 2:
+			i = 0
+		case 4:
+			i = 1
+		}
+		if i < 0 {
+			continue
+		}
+
+		if video.Width > 0 && video.Height > 0 {
+			result[i].width, result[i].height = video.Width, video.Height
+		}
+		if video.CodecType == 4 {
+			result[i].encoding = "H265"
+		}
+	}
+
+	return result
+}
+
+func findProfile(camera *storage.CameraInfo, token string) *profile {
+	for _, p := range profiles(camera) {
+		if p.token == token {
+			return &p
+		}
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package onvif
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"tuya-ipc-terminal/pkg/rtsp"
+	"tuya-ipc-terminal/pkg/storage"
+)
+
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+
+	sm, err := storage.NewStorageManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.EnableEncryption([]byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+
+	kept := rtsp.NewRTSPUser("nvr", "pass", nil)
+	if kept.Password, err = sm.SealPassword("pass"); err != nil {
+		t.Fatal(err)
+	}
+	for _, user := range []storage.RTSPUser{kept, rtsp.NewRTSPUser("hashed", "pass", nil)} {
+		if err := sm.SaveRTSPUser(user); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rtspServer := rtsp.NewRTSPServer(rtsp.ServerConfig{EnableAuthentication: true}, sm)
+	return NewServer(Config{}, rtspServer, sm)
+}
+
+func authorizeRequest(s *Server, r *http.Request, request *envelope) int {
+	w := httptest.NewRecorder()
+	camera := &storage.CameraInfo{RTSPPath: "/Garden"}
+	if s.authorize(w, r, request, camera, "GetProfiles") {
+		return http.StatusOK
+	}
+	return w.Code
+}
+
+func TestAuthorizePasswordDigest(t *testing.T) {
+	s := newTestServer(t)
+	now := time.Now().UTC()
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		nonce    string
+		created  time.Time
+		want     int
+	}{
+		{"valid", "nvr", "pass", "bm9uY2Ux", now, http.StatusOK},
+		{"replayed nonce", "nvr", "pass", "bm9uY2Ux", now, http.StatusUnauthorized},
+		{"new nonce", "nvr", "pass", "bm9uY2Uy", now.Add(time.Second), http.StatusOK},
+		{"wrong password", "nvr", "wrong", "bm9uY2Uz", now, http.StatusUnauthorized},
+		{"created too old", "nvr", "pass", "bm9uY2U0", now.Add(-maxClockSkew - time.Minute), http.StatusUnauthorized},
+		{"created in the future", "nvr", "pass", "bm9uY2U1", now.Add(maxClockSkew + time.Minute), http.StatusUnauthorized},
+		{"password not kept", "hashed", "pass", "bm9uY2U2", now, http.StatusUnauthorized},
+		{"unknown user", "other", "pass", "bm9uY2U3", now, http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			nonce, _ := base64.StdEncoding.DecodeString(test.nonce)
+			created := test.created.Format(time.RFC3339)
+			sum := sha1.Sum([]byte(string(nonce) + created + test.password))
+
+			var request envelope
+			token := &request.Header.Security.UsernameToken
+			token.Username = test.username
+			token.Password.Type = "http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-username-token-profile-1.0#PasswordDigest"
+			token.Password.Value = base64.StdEncoding.EncodeToString(sum[:])
+			token.Nonce = test.nonce
+			token.Created = created
+
+			r := httptest.NewRequest(http.MethodPost, "/onvif/Garden/media_service", nil)
+			if got := authorizeRequest(s, r, &request); got != test.want {
+				t.Errorf("got status %d, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestAuthorizeDigestNonceCount(t *testing.T) {
+	s := newTestServer(t)
+	nonce := s.newNonce()
+	expired := s.nonceAt(time.Now().Add(-nonceLifetime - time.Minute))
+	forged := nonce[:32] + strings.Repeat("0", 32)
+
+	md5Hex := func(s string) string {
+		sum := md5.Sum([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+
+	tests := []struct {
+		name  string
+		nonce string
+		qop   string
+		nc    string
+		want  int
+	}{
+		{"first", nonce, "auth", "00000001", http.StatusOK},
+		{"replayed count", nonce, "auth", "00000001", http.StatusUnauthorized},
+		{"next count", nonce, "auth", "00000002", http.StatusOK},
+		{"lower count", nonce, "auth", "00000001", http.StatusUnauthorized},
+		{"skipped counts", nonce, "auth", "0000000a", http.StatusOK},
+		{"without qop", nonce, "", "", http.StatusUnauthorized},
+		{"invalid count", nonce, "auth", "xyz", http.StatusUnauthorized},
+		{"unknown nonce", "unknown", "auth", "00000001", http.StatusUnauthorized},
+		{"expired nonce", expired, "auth", "00000001", http.StatusUnauthorized},
+		{"forged nonce", forged, "auth", "00000001", http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			uri := "/onvif/Garden/media_service"
+			ha1 := md5Hex("nvr:" + rtsp.DigestRealm + ":pass")
+			ha2 := md5Hex(http.MethodPost + ":" + uri)
+
+			var header string
+			if test.qop == "" {
+				response := md5Hex(ha1 + ":" + test.nonce + ":" + ha2)
+				header = fmt.Sprintf(`Digest username="nvr", realm="%s", nonce="%s", uri="%s", response="%s"`,
+					rtsp.DigestRealm, test.nonce, uri, response)
+			} else {
+				response := md5Hex(ha1 + ":" + test.nonce + ":" + test.nc + ":abc:" + test.qop + ":" + ha2)
+				header = fmt.Sprintf(`Digest username="nvr", realm="%s", nonce="%s", uri="%s", qop=%s, nc=%s, cnonce="abc", response="%s"`,
+					rtsp.DigestRealm, test.nonce, uri, test.qop, test.nc, response)
+			}
+
+			r := httptest.NewRequest(http.MethodPost, uri, nil)
+			r.Header.Set("Authorization", header)
+			if got := authorizeRequest(s, r, &envelope{}); got != test.want {
+				t.Errorf("got status %d, want %d", got, test.want)
+			}
+		})
+	}
+
+	// Challenges keep no state, only the used nonce is known
+	for i := 0; i < 10; i++ {
+		authorizeRequest(s, httptest.NewRequest(http.MethodPost, "/onvif/Garden/media_service", nil), &envelope{})
+	}
+	if len(s.nonces) != 1 {
+		t.Errorf("got %d known nonces, want 1", len(s.nonces))
+	}
+}
@@ -0,0 +1,125 @@
+package onvif
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	nsDevice = "http://www.onvif.org/ver10/device/wsdl"
+	nsMedia  = "http://www.onvif.org/ver10/media/wsdl"
+	nsPTZ    = "http://www.onvif.org/ver20/ptz/wsdl"
+
+	panTiltVelocitySpace = "http://www.onvif.org/ver10/tptz/PanTiltSpaces/VelocityGenericSpace"
+	zoomVelocitySpace    = "http://www.onvif.org/ver10/tptz/ZoomSpaces/VelocityGenericSpace"
+)
+
+const envelopeStart = `<?xml version="1.0" encoding="UTF-8"?>
+<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope"` +
+	` xmlns:tds="http://www.onvif.org/ver10/device/wsdl"` +
+	` xmlns:trt="http://www.onvif.org/ver10/media/wsdl"` +
+	` xmlns:tptz="http://www.onvif.org/ver20/ptz/wsdl"` +
+	` xmlns:tt="http://www.onvif.org/ver10/schema"` +
+	` xmlns:ter="http://www.onvif.org/ver10/error">` +
+	`<s:Body>`
+
+const envelopeEnd = `</s:Body></s:Envelope>`
+
+// envelope is a SOAP request, only the parts used by the services are parsed
+type envelope struct {
+	Header struct {
+		Security struct {
+			UsernameToken struct {
+				Username string `xml:"Username"`
+				Password struct {
+					Type  string `xml:"Type,attr"`
+					Value string `xml:",chardata"`
+				} `xml:"Password"`
+				Nonce   string `xml:"Nonce"` // Base64
+				Created string `xml:"Created"`
+			} `xml:"UsernameToken"`
+		} `xml:"Security"`
+	} `xml:"Header"`
+	Body struct {
+		Content []byte `xml:",innerxml"`
+	} `xml:"Body"`
+}
+
+// action returns the name of the operation, the first element of the body
+func (e *envelope) action() string {
+	decoder := xml.NewDecoder(bytes.NewReader(e.Body.Content))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return ""
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return start.Name.Local
+		}
+	}
+}
+
+// decode unmarshals the operation's element into v
+func (e *envelope) decode(v any) error {
+	return xml.Unmarshal(e.Body.Content, v)
+}
+
+// writeResponse writes a SOAP envelope around the body
+func writeResponse(w http.ResponseWriter, body string) {
+	w.Header().Set("Content-Type", "application/soap+xml; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, envelopeStart, body, envelopeEnd)
+}
+
+// writeFault writes a SOAP fault, sender faults are answered with 400
+// and receiver faults with 500
+func writeFault(w http.ResponseWriter, status int, subcode, reason string) {
+	code := "s:Sender"
+	if status >= http.StatusInternalServerError {
+		code = "s:Receiver"
+	}
+
+	w.Header().Set("Content-Type", "application/soap+xml; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `%s<s:Fault><s:Code><s:Value>%s</s:Value><s:Subcode><s:Value>%s</s:Value></s:Subcode></s:Code>`+
+		`<s:Reason><s:Text xml:lang="en">%s</s:Text></s:Reason></s:Fault>%s`,
+		envelopeStart, code, subcode, escape(reason), envelopeEnd)
+}
+
+func escape(s string) string {
+	var buffer bytes.Buffer
+	xml.EscapeText(&buffer, []byte(s))
+	return buffer.String()
+}
+
+// parseDuration parses the xs:duration timeouts sent by clients, e.g. PT1S or PT0.5S
+func parseDuration(value string) (time.Duration, error) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(value), "PT")
+	if !ok {
+		return 0, fmt.Errorf("invalid duration: %s", value)
+	}
+
+	var duration time.Duration
+	for rest != "" {
+		i := strings.IndexAny(rest, "HMS")
+		if i <= 0 {
+			return 0, fmt.Errorf("invalid duration: %s", value)
+		}
+
+		number, err := strconv.ParseFloat(rest[:i], 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration: %s", value)
+		}
+
+		unit := map[byte]time.Duration{'H': time.Hour, 'M': time.Minute, 'S': time.Second}[rest[i]]
+		duration += time.Duration(number * float64(unit))
+		rest = rest[i+1:]
+	}
+
+	return duration, nil
+}
@@ -0,0 +1,263 @@
+package ptz
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"tuya-ipc-terminal/pkg/core"
+	"tuya-ipc-terminal/pkg/discovery"
+	"tuya-ipc-terminal/pkg/storage"
+	"tuya-ipc-terminal/pkg/tuya"
+)
+
+// Connections are closed after this long without commands
+const idleTimeout = 2 * time.Minute
+
+var ErrNotSupported = errors.New("camera doesn't support PTZ")
+
+// Controller sends PTZ and privacy commands to cameras. The MQTT connection of an account
+// is kept for a while, a move and its stop shouldn't reconnect.
+type Controller struct {
+	storageManager *storage.StorageManager
+	connections    map[string]*connection // User key -> connection
+	devices        map[string]*deviceInfo // Device ID -> WebRTC config values
+	closed         bool
+	mutex          sync.Mutex // Only held for the maps, not for requests
+}
+
+type connection struct {
+	client *tuya.MQTTClient
+	idle   *time.Timer
+}
+
+type deviceInfo struct {
+	supportsPTZ bool
+	localKey    string
+}
+
+func NewController(storageManager *storage.StorageManager) *Controller {
+	return &Controller{
+		storageManager: storageManager,
+		connections:    make(map[string]*connection),
+		devices:        make(map[string]*deviceInfo),
+	}
+}
+
+// Send sends a command to the camera, moves and zooms continue until a stop
+func (c *Controller) Send(camera *storage.CameraInfo, command tuya.PTZCommand) error {
+	if err := command.Validate(); err != nil {
+		return err
+	}
+
+	user, httpClient, err := c.session(camera)
+	if err != nil {
+		return err
+	}
+
+	info, err := c.device(camera, user, httpClient)
+	if err != nil {
+		return err
+	}
+
+	if !info.supportsPTZ {
+		return fmt.Errorf("%w: %s", ErrNotSupported, camera.DeviceName)
+	}
+
+	client, err := c.connect(user, httpClient)
+	if err != nil {
+		return err
+	}
+
+	if err := client.SendPTZ(dpDevice(camera, info), command); err != nil {
+		return fmt.Errorf("failed to send PTZ command: %v", err)
+	}
+
+	if command.Preset > 0 {
+		core.Logger.Info().Msgf("PTZ %s %d on camera %s", command.Action, command.Preset, camera.DeviceName)
+	} else {
+		core.Logger.Info().Msgf("PTZ %s on camera %s", command.Action, camera.DeviceName)
+	}
+
+	return nil
+}
+
+// SetPrivacyMode turns the privacy mode of the camera on or off, cameras
+// without one ignore it
+func (c *Controller) SetPrivacyMode(camera *storage.CameraInfo, enabled bool) error {
+	user, httpClient, err := c.session(camera)
+	if err != nil {
+		return err
+	}
+
+	info, err := c.device(camera, user, httpClient)
+	if err != nil {
+		return err
+	}
+
+	client, err := c.connect(user, httpClient)
+	if err != nil {
+		return err
+	}
+
+	if err := client.SetPrivacyMode(dpDevice(camera, info), enabled); err != nil {
+		return fmt.Errorf("failed to set privacy mode: %v", err)
+	}
+
+	core.Logger.Info().Msgf("Privacy mode %v on camera %s", enabled, camera.DeviceName)
+	return nil
+}
+
+// Supports reports whether the camera has PTZ, it's asked once per camera
+func (c *Controller) Supports(camera *storage.CameraInfo) (bool, error) {
+	c.mutex.Lock()
+	info, ok := c.devices[camera.DeviceID]
+	c.mutex.Unlock()
+	if ok {
+		return info.supportsPTZ, nil
+	}
+
+	user, httpClient, err := c.session(camera)
+	if err != nil {
+		return false, err
+	}
+
+	info, err = c.device(camera, user, httpClient)
+	if err != nil {
+		return false, err
+	}
+	return info.supportsPTZ, nil
+}
+
+// device returns the PTZ support and local key of the camera, they're asked
+// once per camera
+func (c *Controller) device(camera *storage.CameraInfo, user *storage.UserSession, httpClient *http.Client) (*deviceInfo, error) {
+	c.mutex.Lock()
+	info, ok := c.devices[camera.DeviceID]
+	c.mutex.Unlock()
+	if ok {
+		return info, nil
+	}
+
+	webRTCConfig, err := tuya.GetWebRTCConfig(httpClient, user.SessionData.ServerHost, camera.DeviceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get WebRTC config: %v", err)
+	}
+
+	// Without a schema the commands can't be sent
+	info = &deviceInfo{
+		supportsPTZ: webRTCConfig.Result.SupportsPtz && tuya.HasDPSchema(camera.Category),
+		localKey:    webRTCConfig.Result.LocalKey,
+	}
+
+	c.mutex.Lock()
+	c.devices[camera.DeviceID] = info
+	c.mutex.Unlock()
+
+	return info, nil
+}
+
+func dpDevice(camera *storage.CameraInfo, info *deviceInfo) tuya.DPDevice {
+	return tuya.DPDevice{ID: camera.DeviceID, Category: camera.Category, LocalKey: info.localKey}
+}
+
+// Close disconnects all accounts
+func (c *Controller) Close() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.closed = true
+	for userKey, conn := range c.connections {
+		conn.idle.Stop()
+		conn.client.Stop()
+		delete(c.connections, userKey)
+	}
+}
+
+// connect returns the MQTT connection of the account, a new one if there's
+// none or it was lost
+func (c *Controller) connect(user *storage.UserSession, httpClient *http.Client) (*tuya.MQTTClient, error) {
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		return nil, errors.New("PTZ controller is closed")
+	}
+
+	conn := c.connections[user.UserKey]
+	if conn != nil && !conn.client.IsConnected() {
+		c.remove(user.UserKey, conn)
+		conn = nil
+	}
+	if conn != nil {
+		conn.idle.Reset(idleTimeout)
+		c.mutex.Unlock()
+		return conn.client, nil
+	}
+	c.mutex.Unlock()
+
+	client, err := tuya.ConnectMQTT(httpClient, user.SessionData)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		client.Stop()
+		return nil, errors.New("PTZ controller is closed")
+	}
+
+	// Another command may have connected meanwhile
+	if other := c.connections[user.UserKey]; other != nil && other.client.IsConnected() {
+		client.Stop()
+		other.idle.Reset(idleTimeout)
+		return other.client, nil
+	}
+
+	conn = &connection{client: client}
+	conn.idle = time.AfterFunc(idleTimeout, func() {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		c.remove(user.UserKey, conn)
+	})
+	c.connections[user.UserKey] = conn
+
+	return client, nil
+}
+
+// remove stops the connection if it's still the one of the account, must be
+// called with the mutex held
+func (c *Controller) remove(userKey string, conn *connection) {
+	if c.connections[userKey] != conn {
+		return
+	}
+
+	conn.idle.Stop()
+	conn.client.Stop()
+	delete(c.connections, userKey)
+}
+
+// session returns the account of the camera with an HTTP client
+func (c *Controller) session(camera *storage.CameraInfo) (*storage.UserSession, *http.Client, error) {
+	users, err := c.storageManager.ListUsers()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, user := range users {
+		if user.UserKey != camera.UserKey {
+			continue
+		}
+
+		httpClient := discovery.NewHTTPClient(user.SessionData)
+		if httpClient == nil {
+			return nil, nil, errors.New("failed to create HTTP client")
+		}
+		return &user, httpClient, nil
+	}
+
+	return nil, nil, fmt.Errorf("user not found for key: %s", camera.UserKey)
+}
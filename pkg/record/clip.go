@@ -0,0 +1,268 @@
+package record
+
+import (
+	"path/filepath"
+	"time"
+
+	"tuya-ipc-terminal/pkg/core"
+	"tuya-ipc-terminal/pkg/mp4"
+)
+
+const (
+	DefaultPreRoll         = 10 * time.Second
+	DefaultPostRoll        = 10 * time.Second
+	DefaultMaxClipDuration = 5 * time.Minute
+
+	// Upper bound of a pre-roll buffer, high bitrates shorten the pre-roll
+	maxPreRollSize = 32 << 20
+)
+
+// Clip is a finished event clip
+type Clip struct {
+	Camera     string        `json:"camera"` // RTSP path
+	Resolution string        `json:"resolution"`
+	Reason     string        `json:"reason"`
+	File       string        `json:"file"`
+	Start      time.Time     `json:"start"`
+	Duration   time.Duration `json:"duration"`
+}
+
+type bufferedSample struct {
+	video    bool
+	sample   mp4.Sample
+	position uint64 // Video ticks before the sample
+}
+
+// Clipper keeps a rolling pre-roll buffer of a camera and writes it with the
+// following post-roll to a clip file on trigger. Triggers during a clip extend
+// it. Clippers created by a trigger detach after their clip, persistent ones
+// stay attached so the pre-roll is always available.
+type Clipper struct {
+	manager    *Manager
+	feed       *feed
+	dir        string
+	persistent bool
+
+	calls chan func()
+	stop  chan struct{}
+	done  chan struct{}
+
+	// Only used by the run goroutine
+	track    *mp4.Track
+	buffer   []bufferedSample
+	size     int
+	position uint64
+	segment  *segmentWriter
+	clip     Clip
+	until    time.Time
+	pending  string // Reason of a trigger waiting for video
+}
+
+func newClipper(manager *Manager, path, resolution string, persistent bool) *Clipper {
+	return &Clipper{
+		manager:    manager,
+		feed:       newFeed(manager, path, resolution, "clip-"+path+"/"+resolution),
+		dir:        filepath.Join(CameraDir(manager.config.ClipDir, path), resolution),
+		persistent: persistent,
+		calls:      make(chan func(), 16),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+func (c *Clipper) Stop() {
+	close(c.stop)
+	<-c.done
+}
+
+// trigger starts or extends a clip, it's called with the manager's lock
+func (c *Clipper) trigger(reason string) {
+	select {
+	case c.calls <- func() { c.onTrigger(reason) }:
+	default:
+		// Enough triggers queued
+	}
+}
+
+func (c *Clipper) run() {
+	defer close(c.done)
+
+	for {
+		err := c.feed.run(c, c.stop, c.calls, func() {})
+		c.finish()
+		c.track, c.buffer, c.size, c.position = nil, nil, 0, 0
+
+		if err == nil || !c.persistent {
+			if err != nil {
+				core.Logger.Warn().Err(err).Msgf("Clip of %s failed", c.feed.path)
+			}
+			c.manager.removeClipper(c)
+			return
+		}
+
+		core.Logger.Warn().Err(err).Msgf("Pre-roll buffer of %s interrupted, retrying in %v", c.feed.path, retryDelay)
+
+		select {
+		case <-c.stop:
+			return
+		case <-time.After(retryDelay):
+		}
+	}
+}
+
+func (c *Clipper) onTrigger(reason string) {
+	config := c.manager.config
+
+	if c.segment != nil {
+		until := time.Now().Add(config.PostRoll)
+		if limit := c.clip.Start.Add(config.MaxClipDuration); until.After(limit) {
+			until = limit
+		}
+		if until.After(c.until) {
+			c.until = until
+		}
+		return
+	}
+
+	c.pending = reason
+	c.begin()
+}
+
+// begin writes the buffer into a new clip, it waits for video if the buffer
+// has no keyframe yet
+func (c *Clipper) begin() {
+	if len(c.buffer) == 0 || !c.buffer[0].video {
+		return
+	}
+
+	config := c.manager.config
+	buffered := time.Duration(c.position-c.buffer[0].position) * time.Second / videoClockRate
+	start := time.Now().Add(-buffered)
+
+	segment, err := createSegment(c.dir, start, c.track, c.feed.audioCodec)
+	if err != nil {
+		core.Logger.Error().Err(err).Msgf("Clip of %s failed", c.feed.path)
+		c.pending = ""
+		return
+	}
+
+	c.segment = segment
+	c.clip = Clip{
+		Camera:     c.feed.path,
+		Resolution: c.feed.resolution,
+		Reason:     c.pending,
+		File:       segment.name,
+		Start:      start,
+	}
+	c.until = time.Now().Add(config.PostRoll)
+	c.pending = ""
+
+	core.Logger.Info().Msgf("Clip of %s started (%s) with %v pre-roll", c.feed.path, c.clip.Reason, buffered.Round(time.Second))
+
+	for _, buffered := range c.buffer {
+		if buffered.video {
+			err = segment.writeVideo(buffered.sample)
+		} else {
+			segment.writeAudio(buffered.sample)
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	if err != nil {
+		core.Logger.Error().Err(err).Msgf("Clip of %s failed", c.feed.path)
+		c.finish()
+	}
+}
+
+// finish closes the clip and reports it
+func (c *Clipper) finish() {
+	if c.segment == nil {
+		return
+	}
+
+	segment := c.segment
+	c.segment = nil
+
+	c.clip.Duration = time.Duration(segment.duration) * time.Second / videoClockRate
+	if err := segment.close(); err != nil {
+		core.Logger.Error().Err(err).Msgf("Clip of %s failed", c.feed.path)
+		return
+	}
+
+	core.Logger.Info().Msgf("Clip of %s written to %s (%v)", c.feed.path, c.clip.File, c.clip.Duration.Round(time.Second))
+
+	if onClip := c.manager.config.OnClip; onClip != nil {
+		go onClip(c.clip)
+	}
+
+	if !c.persistent {
+		c.feed.quit = c.manager.releaseClipper(c)
+	}
+}
+
+func (c *Clipper) video(track *mp4.Track, sample mp4.Sample) {
+	if track != c.track {
+		// The buffer can't be mixed with new parameter sets
+		c.finish()
+		c.track, c.buffer, c.size, c.position = track, nil, 0, 0
+	}
+
+	if c.segment != nil && time.Now().After(c.until) {
+		c.finish()
+	}
+
+	if len(c.buffer) > 0 || sample.Keyframe {
+		c.buffer = append(c.buffer, bufferedSample{video: true, sample: sample, position: c.position})
+		c.size += len(sample.Data)
+	}
+	c.position += uint64(sample.Duration)
+	c.trim()
+
+	switch {
+	case c.segment != nil:
+		if err := c.segment.writeVideo(sample); err != nil {
+			core.Logger.Error().Err(err).Msgf("Clip of %s failed", c.feed.path)
+			c.finish()
+		}
+	case c.pending != "" && sample.Keyframe:
+		c.begin()
+	}
+}
+
+func (c *Clipper) audio(sample mp4.Sample) {
+	if len(c.buffer) > 0 {
+		c.buffer = append(c.buffer, bufferedSample{sample: sample, position: c.position})
+		c.size += len(sample.Data)
+	}
+
+	if c.segment != nil {
+		c.segment.writeAudio(sample)
+	}
+}
+
+// trim drops the buffer up to the next keyframe as long as the pre-roll is
+// kept, the buffer always starts with a keyframe
+func (c *Clipper) trim() {
+	preRoll := uint64(c.manager.config.PreRoll.Seconds() * videoClockRate)
+
+	for {
+		next := 0
+		for i := 1; i < len(c.buffer); i++ {
+			if c.buffer[i].video && c.buffer[i].sample.Keyframe {
+				next = i
+				break
+			}
+		}
+
+		if next == 0 || (c.position-c.buffer[next].position < preRoll && c.size <= maxPreRollSize) {
+			return
+		}
+
+		for _, dropped := range c.buffer[:next] {
+			c.size -= len(dropped.sample.Data)
+		}
+		c.buffer = append(c.buffer[:0], c.buffer[next:]...)
+	}
+}
@@ -0,0 +1,102 @@
+package record
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"tuya-ipc-terminal/pkg/core"
+	"tuya-ipc-terminal/pkg/storage"
+)
+
+var (
+	pathEscaper   = strings.NewReplacer("%", "%25", "/", "%2F")
+	pathUnescaper = strings.NewReplacer("%2F", "/", "%2f", "/", "%25", "%")
+)
+
+// CameraDir returns the directory of a camera below dir. Paths with several
+// elements are kept in one directory with escaped slashes, e.g.
+// /Home/Garden is written to Home%2FGarden.
+func CameraDir(dir, path string) string {
+	return filepath.Join(dir, pathEscaper.Replace(strings.TrimPrefix(path, "/")))
+}
+
+// migrateCameraDir moves recordings of the camera's previous paths, and of
+// the nested directories of older versions, to its directory. Paths taken by
+// other cameras are skipped.
+func migrateCameraDir(dir string, camera *storage.CameraInfo, taken func(path string) bool) {
+	if dir == "" {
+		return
+	}
+
+	target := CameraDir(dir, camera.RTSPPath)
+
+	var sources []string
+	for _, path := range append([]string{camera.RTSPPath}, camera.PreviousPaths...) {
+		name := strings.TrimPrefix(path, "/")
+		if name == "" || path != camera.RTSPPath && taken(path) {
+			continue
+		}
+		sources = append(sources, CameraDir(dir, path), filepath.Join(dir, filepath.FromSlash(name)))
+	}
+
+	for _, source := range sources {
+		if source == target {
+			continue
+		}
+		if _, err := os.Stat(source); err != nil {
+			continue
+		}
+
+		if err := moveTree(source, target); err != nil {
+			core.Logger.Warn().Err(err).Msgf("Failed to move %s to %s", source, target)
+			continue
+		}
+		core.Logger.Info().Msgf("Moved recordings of %s to %s", source, target)
+
+		// Parents of nested directories are left empty
+		for parent := filepath.Dir(source); parent != filepath.Clean(dir); parent = filepath.Dir(parent) {
+			if os.Remove(parent) != nil {
+				break
+			}
+		}
+	}
+}
+
+// moveTree renames source to target, directories are merged into an existing
+// target. Files that exist in both are left in source.
+func moveTree(source, target string) error {
+	info, err := os.Lstat(target)
+	if os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		return os.Rename(source, target)
+	}
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", target)
+	}
+
+	entries, err := os.ReadDir(source)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			if _, err := os.Lstat(filepath.Join(target, entry.Name())); err == nil {
+				continue
+			}
+		}
+		if err := moveTree(filepath.Join(source, entry.Name()), filepath.Join(target, entry.Name())); err != nil {
+			return err
+		}
+	}
+
+	// Fails if files were left
+	os.Remove(source)
+	return nil
+}
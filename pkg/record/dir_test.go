@@ -0,0 +1,111 @@
+package record
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"tuya-ipc-terminal/pkg/storage"
+)
+
+func TestCameraDir(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/FrontDoor", "FrontDoor"},
+		{"FrontDoor", "FrontDoor"},
+		{"/Home/Living_Room/Camera", "Home%2FLiving_Room%2FCamera"},
+		{"/50%/Garden", "50%25%2FGarden"},
+	}
+
+	for _, test := range tests {
+		if got := CameraDir("base", test.path); got != filepath.Join("base", test.want) {
+			t.Errorf("CameraDir(%q) = %q, want %q", test.path, got, test.want)
+		}
+	}
+}
+
+func TestMigrateCameraDir(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		previous []string
+		taken    string
+		files    []string
+		want     []string
+	}{
+		{
+			name:  "nested directory of older versions",
+			path:  "/Home/Garden",
+			files: []string{"Home/Garden/hd/2024-05-01/10-00-00.mp4"},
+			want:  []string{"Home%2FGarden/hd/2024-05-01/10-00-00.mp4"},
+		},
+		{
+			name:     "previous path",
+			path:     "/Home/Garden",
+			previous: []string{"/Garden"},
+			files:    []string{"Garden/hd/2024-05-01/10-00-00.mp4"},
+			want:     []string{"Home%2FGarden/hd/2024-05-01/10-00-00.mp4"},
+		},
+		{
+			name:     "merged into existing directory",
+			path:     "/Front",
+			previous: []string{"/Home/Front"},
+			files: []string{
+				"Front/hd/2024-05-01/10-00-00.mp4",
+				"Home%2FFront/hd/2024-05-01/09-00-00.mp4",
+				"Home%2FFront/sd/2024-05-01/09-00-00.mp4",
+			},
+			want: []string{
+				"Front/hd/2024-05-01/09-00-00.mp4",
+				"Front/hd/2024-05-01/10-00-00.mp4",
+				"Front/sd/2024-05-01/09-00-00.mp4",
+			},
+		},
+		{
+			name:     "previous path taken by another camera",
+			path:     "/Garden_1111",
+			previous: []string{"/Garden"},
+			taken:    "/Garden",
+			files:    []string{"Garden/hd/2024-05-01/10-00-00.mp4"},
+			want:     []string{"Garden/hd/2024-05-01/10-00-00.mp4"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, file := range test.files {
+				writeSegment(t, dir, file, 1)
+			}
+
+			camera := &storage.CameraInfo{RTSPPath: test.path, PreviousPaths: test.previous}
+			migrateCameraDir(dir, camera, func(path string) bool { return path == test.taken })
+
+			var got []string
+			filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+				if err == nil && !entry.IsDir() {
+					rel, _ := filepath.Rel(dir, path)
+					got = append(got, filepath.ToSlash(rel))
+				}
+				return nil
+			})
+
+			if len(got) != len(test.want) {
+				t.Fatalf("got %v, want %v", got, test.want)
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Errorf("got %v, want %v", got, test.want)
+					break
+				}
+			}
+
+			// Emptied parents are removed
+			if _, err := os.Stat(filepath.Join(dir, "Home")); test.taken == "" && err == nil {
+				t.Error("empty nested directory is left")
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package record
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"github.com/pion/rtp"
+
+	"tuya-ipc-terminal/pkg/mp4"
+)
+
+// sink receives the samples of a feed, always on the goroutine of feed.run
+type sink interface {
+	video(track *mp4.Track, sample mp4.Sample)
+	audio(sample mp4.Sample)
+}
+
+type queuedPacket struct {
+	packet *rtp.Packet
+	video  bool
+}
+
+// feed attaches to a camera stream as viewer, which keeps the camera
+// connected, and turns the packets into MP4 samples
+type feed struct {
+	manager    *Manager
+	path       string
+	resolution string
+	id         string // Viewer ID
+
+	packets chan queuedPacket
+	dropped atomic.Uint64
+
+	// Only used by the run goroutine
+	audioCodec string
+	quit       bool // Set by the sink to detach
+}
+
+func newFeed(manager *Manager, path, resolution, id string) *feed {
+	return &feed{
+		manager:    manager,
+		path:       path,
+		resolution: resolution,
+		id:         id,
+		packets:    make(chan queuedPacket, packetQueueSize),
+	}
+}
+
+// run attaches to the stream and passes the samples to the sink until the
+// stream stops (error), stop is closed or the sink sets quit (nil). Functions
+// sent on calls are run in between, started is called once media arrives.
+func (f *feed) run(s sink, stop <-chan struct{}, calls <-chan func(), started func()) error {
+	// Packets of an earlier attachment would mix with the replayed GOP
+	for len(f.packets) > 0 {
+		<-f.packets
+	}
+
+	viewer := &attachment{feed: f, detached: make(chan struct{})}
+
+	stream, err := f.manager.rtspServer.AttachViewer(f.path, f.resolution, f.id, viewer)
+	if err != nil {
+		return err
+	}
+	defer stream.RemoveViewer(f.id)
+
+	info := stream.WaitMediaInfo(mediaInfoTimeout)
+	if info == nil {
+		return errors.New("no video from camera")
+	}
+
+	sampler := mp4.NewVideoSampler(info.HEVC, videoTrackID, info.VPS, info.SPS, info.PPS, s.video)
+	if info.HasAudio {
+		f.audioCodec = audioCodec(info.AudioPayloadType)
+	}
+
+	started()
+
+	for !f.quit {
+		select {
+		case p := <-f.packets:
+			if p.video {
+				sampler.Push(p.packet)
+			} else if len(p.packet.Payload) > 0 {
+				if f.audioCodec == "" {
+					f.audioCodec = audioCodec(p.packet.PayloadType)
+				}
+				// G.711 has one byte per sample
+				s.audio(mp4.Sample{Duration: uint32(len(p.packet.Payload)), Keyframe: true, Data: p.packet.Payload})
+			}
+		case call := <-calls:
+			call()
+		case <-viewer.detached:
+			return errors.New("stream stopped")
+		case <-stop:
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func audioCodec(payloadType uint8) string {
+	switch payloadType {
+	case 0:
+		return mp4.CodecPCMU
+	case 8:
+		return mp4.CodecPCMA
+	}
+	return ""
+}
+
+// attachment is the viewer of one attach, it's closed when the stream stops
+type attachment struct {
+	feed     *feed
+	detached chan struct{}
+}
+
+func (a *attachment) WriteRTP(packet *rtp.Packet, video bool) {
+	clone := *packet
+	clone.Payload = append([]byte(nil), packet.Payload...)
+
+	select {
+	case a.feed.packets <- queuedPacket{packet: &clone, video: video}:
+	default:
+		// The disk can't keep up, don't block the stream
+		a.feed.dropped.Add(1)
+	}
+}
+
+func (a *attachment) Close() {
+	close(a.detached)
+}
@@ -0,0 +1,428 @@
+package record
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"tuya-ipc-terminal/pkg/core"
+	"tuya-ipc-terminal/pkg/rtsp"
+	"tuya-ipc-terminal/pkg/storage"
+)
+
+type Config struct {
+	Dir             string
+	SegmentDuration time.Duration
+
+	// Retention, zero disables the limit
+	MaxAge  time.Duration
+	MaxSize int64 // Bytes
+
+	// Event clips
+	ClipDir         string
+	PreRoll         time.Duration
+	PostRoll        time.Duration
+	MaxClipDuration time.Duration
+	OnClip          func(clip Clip) // Called for every finished clip
+}
+
+const (
+	DefaultSegmentDuration = time.Minute
+
+	pruneInterval = 10 * time.Minute
+)
+
+// Manager runs the recorders of the RTSP server and applies the retention
+type Manager struct {
+	rtspServer *rtsp.RTSPServer
+	config     Config
+	recorders  map[string]*Recorder // Path and resolution -> recorder
+	clippers   map[string]*Clipper  // Path and resolution -> clipper
+	stop       chan struct{}
+	mutex      sync.Mutex
+}
+
+func NewManager(rtspServer *rtsp.RTSPServer, config Config) *Manager {
+	if config.SegmentDuration <= 0 {
+		config.SegmentDuration = DefaultSegmentDuration
+	}
+	if config.PreRoll < 0 {
+		config.PreRoll = 0
+	}
+	if config.PostRoll <= 0 {
+		config.PostRoll = DefaultPostRoll
+	}
+	if config.MaxClipDuration <= 0 {
+		config.MaxClipDuration = DefaultMaxClipDuration
+	}
+
+	m := &Manager{
+		rtspServer: rtspServer,
+		config:     config,
+		recorders:  make(map[string]*Recorder),
+		clippers:   make(map[string]*Clipper),
+		stop:       make(chan struct{}),
+	}
+
+	if config.MaxAge > 0 || config.MaxSize > 0 {
+		go m.pruneLoop()
+	}
+
+	return m
+}
+
+func (m *Manager) Dir() string {
+	return m.config.Dir
+}
+
+// Start records a camera until Stop. The camera is given by RTSP path or
+// device ID, the RTSP path of the camera is returned.
+func (m *Manager) Start(cameraID, resolution string) (string, error) {
+	camera, err := m.rtspServer.LookupCamera(cameraID)
+	if err != nil {
+		return "", err
+	}
+
+	cameraPath := camera.RTSPPath
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	key := cameraPath + "/" + resolution
+	if _, exists := m.recorders[key]; exists {
+		return cameraPath, nil
+	}
+
+	m.migrate(m.config.Dir, camera)
+	recorder := newRecorder(m, cameraPath, resolution)
+	m.recorders[key] = recorder
+	go recorder.run()
+
+	return cameraPath, nil
+}
+
+// Stop returns false if the camera wasn't recorded
+func (m *Manager) Stop(cameraPath, resolution string) bool {
+	key := cameraPath + "/" + resolution
+
+	m.mutex.Lock()
+	recorder, exists := m.recorders[key]
+	delete(m.recorders, key)
+	m.mutex.Unlock()
+
+	if !exists {
+		return false
+	}
+
+	recorder.Stop()
+	core.Logger.Info().Msgf("Recording of %s (%s) stopped", cameraPath, resolution)
+
+	return true
+}
+
+func (m *Manager) Status() []Status {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	statuses := make([]Status, 0, len(m.recorders))
+	for _, recorder := range m.recorders {
+		statuses = append(statuses, recorder.Status())
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Path+statuses[i].Resolution < statuses[j].Path+statuses[j].Resolution
+	})
+
+	return statuses
+}
+
+// Close stops all recorders, the last segments are finished
+func (m *Manager) Close() {
+	m.mutex.Lock()
+	select {
+	case <-m.stop:
+		m.mutex.Unlock()
+		return
+	default:
+	}
+
+	close(m.stop)
+	recorders := m.recorders
+	m.recorders = make(map[string]*Recorder)
+	clippers := m.clippers
+	m.clippers = make(map[string]*Clipper)
+	m.mutex.Unlock()
+
+	for _, recorder := range recorders {
+		recorder.Stop()
+	}
+	for _, clipper := range clippers {
+		clipper.Stop()
+	}
+}
+
+// KeepPreRoll keeps a camera attached with a pre-roll buffer, so clips of it
+// start before their trigger. The RTSP path of the camera is returned.
+func (m *Manager) KeepPreRoll(cameraID, resolution string) (string, error) {
+	camera, err := m.rtspServer.LookupCamera(cameraID)
+	if err != nil {
+		return "", err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	key := camera.RTSPPath + "/" + resolution
+	if _, exists := m.clippers[key]; exists {
+		return camera.RTSPPath, nil
+	}
+
+	m.migrate(m.config.ClipDir, camera)
+	clipper := newClipper(m, camera.RTSPPath, resolution, true)
+	m.clippers[key] = clipper
+	go clipper.run()
+
+	return camera.RTSPPath, nil
+}
+
+// Clip writes a clip of a camera from the pre-roll to the post-roll after
+// the last trigger. Without pre-roll buffer the camera is connected now and
+// the clip starts with the stream's GOP cache. The RTSP path of the camera
+// is returned.
+func (m *Manager) Clip(cameraID, resolution, reason string) (string, error) {
+	camera, err := m.rtspServer.LookupCamera(cameraID)
+	if err != nil {
+		return "", err
+	}
+
+	if resolution == "" {
+		resolution = "hd"
+	} else if resolution != "hd" && resolution != "sd" {
+		return "", fmt.Errorf("invalid resolution: %s", resolution)
+	}
+
+	if reason == "" {
+		reason = "manual"
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	key := camera.RTSPPath + "/" + resolution
+	clipper, exists := m.clippers[key]
+	if !exists {
+		m.migrate(m.config.ClipDir, camera)
+		clipper = newClipper(m, camera.RTSPPath, resolution, false)
+		m.clippers[key] = clipper
+		go clipper.run()
+	}
+
+	core.Logger.Debug().Msgf("Clip of %s triggered (%s)", camera.RTSPPath, reason)
+	clipper.trigger(reason)
+
+	return camera.RTSPPath, nil
+}
+
+// migrate moves earlier recordings of the camera to its directory
+func (m *Manager) migrate(dir string, camera *storage.CameraInfo) {
+	migrateCameraDir(dir, camera, func(path string) bool {
+		other, err := m.rtspServer.LookupCamera(path)
+		return err == nil && other.DeviceID != camera.DeviceID
+	})
+}
+
+// releaseClipper removes a clipper after its clip unless it was triggered
+// again in the meantime
+func (m *Manager) releaseClipper(clipper *Clipper) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if len(clipper.calls) > 0 {
+		return false
+	}
+
+	m.removeClipperLocked(clipper)
+	return true
+}
+
+func (m *Manager) removeClipper(clipper *Clipper) {
+	m.mutex.Lock()
+	m.removeClipperLocked(clipper)
+	m.mutex.Unlock()
+}
+
+func (m *Manager) removeClipperLocked(clipper *Clipper) {
+	key := clipper.feed.path + "/" + clipper.feed.resolution
+	if m.clippers[key] == clipper {
+		delete(m.clippers, key)
+	}
+}
+
+// Prune applies the retention now
+func (m *Manager) Prune() (*PruneResult, error) {
+	keep := make(map[string]bool)
+
+	m.mutex.Lock()
+	for _, recorder := range m.recorders {
+		if file := recorder.currentFile(); file != "" {
+			keep[file] = true
+		}
+	}
+	m.mutex.Unlock()
+
+	return Prune(m.config.Dir, m.config.MaxAge, m.config.MaxSize, keep)
+}
+
+func (m *Manager) pruneLoop() {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+
+	for {
+		result, err := m.Prune()
+		if err != nil {
+			core.Logger.Error().Err(err).Msg("Failed to prune recordings")
+		} else if result.Files > 0 {
+			core.Logger.Info().Msgf("Pruned %d recordings (%d MiB)", result.Files, result.Bytes>>20)
+		}
+
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Segment is a recorded file
+type Segment struct {
+	Camera     string    `json:"camera"` // RTSP path without the leading "/"
+	Resolution string    `json:"resolution"`
+	File       string    `json:"file"`
+	Start      time.Time `json:"start"`
+	Size       int64     `json:"size"`
+
+	index int
+}
+
+// ListSegments returns the segments below dir, oldest first. The start time
+// is taken from the file name, <camera>/<resolution>/<date>/<time>.mp4. The
+// camera directory may be nested, as written by older versions.
+func ListSegments(dir string) ([]Segment, error) {
+	var segments []Segment
+
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+
+		if entry.IsDir() || !strings.HasSuffix(path, ".mp4") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return nil
+		}
+
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		n := len(parts)
+		if n < 4 {
+			return nil
+		}
+
+		// Segments started within the same second have a counter suffix
+		name, index := strings.TrimSuffix(parts[n-1], ".mp4"), 0
+		if len(name) > 9 && name[8] == '-' {
+			if index, err = strconv.Atoi(name[9:]); err != nil {
+				return nil
+			}
+			name = name[:8]
+		}
+
+		start, err := time.ParseInLocation("2006-01-02 15-04-05", parts[n-2]+" "+name, time.Local)
+		if err != nil {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+
+		segments = append(segments, Segment{
+			Camera:     pathUnescaper.Replace(strings.Join(parts[:n-3], "/")),
+			Resolution: parts[n-3],
+			File:       path,
+			Start:      start,
+			Size:       info.Size(),
+			index:      index,
+		})
+
+		return nil
+	})
+
+	sort.Slice(segments, func(i, j int) bool {
+		if !segments[i].Start.Equal(segments[j].Start) {
+			return segments[i].Start.Before(segments[j].Start)
+		}
+		return segments[i].index < segments[j].index
+	})
+
+	return segments, err
+}
+
+type PruneResult struct {
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+// Prune deletes segments older than maxAge, then the oldest ones until the
+// total size is below maxSize. Files in keep (being written) are skipped.
+func Prune(dir string, maxAge time.Duration, maxSize int64, keep map[string]bool) (*PruneResult, error) {
+	segments, err := ListSegments(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list recordings: %v", err)
+	}
+
+	var total int64
+	for _, segment := range segments {
+		total += segment.Size
+	}
+
+	result := &PruneResult{}
+	for _, segment := range segments {
+		expired := maxAge > 0 && time.Since(segment.Start) > maxAge
+		overQuota := maxSize > 0 && total > maxSize
+
+		if !expired && !overQuota {
+			break
+		}
+
+		if keep[segment.File] {
+			continue
+		}
+
+		if err := os.Remove(segment.File); err != nil {
+			core.Logger.Warn().Err(err).Msgf("Failed to remove %s", segment.File)
+			continue
+		}
+
+		total -= segment.Size
+		result.Files++
+		result.Bytes += segment.Size
+
+		// Remove the day directory with its last file
+		os.Remove(filepath.Dir(segment.File))
+	}
+
+	return result, nil
+}
@@ -0,0 +1,178 @@
+package record
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeSegment(t *testing.T, dir, name string, size int) string {
+	t.Helper()
+
+	file := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestListSegments(t *testing.T) {
+	tests := []struct {
+		name       string
+		file       string
+		camera     string
+		resolution string
+		start      string
+	}{
+		{"flat", "FrontDoor/hd/2024-05-01/10-00-00.mp4", "FrontDoor", "hd", "2024-05-01 10:00:00"},
+		{"counter suffix", "FrontDoor/hd/2024-05-01/10-00-00-2.mp4", "FrontDoor", "hd", "2024-05-01 10:00:00"},
+		{"escaped template path", "Home%2FLiving_Room%2FCamera/sd/2024-05-01/10-00-00.mp4", "Home/Living_Room/Camera", "sd", "2024-05-01 10:00:00"},
+		{"escaped percent", "50%25%2FGarden/hd/2024-05-01/10-00-00.mp4", "50%/Garden", "hd", "2024-05-01 10:00:00"},
+		{"nested template path", "Home/Garden/hd/2024-05-01/10-00-00.mp4", "Home/Garden", "hd", "2024-05-01 10:00:00"},
+		{"too short", "hd/2024-05-01/10-00-00.mp4", "", "", ""},
+		{"no mp4", "FrontDoor/hd/2024-05-01/10-00-00.txt", "", "", ""},
+		{"invalid date", "FrontDoor/hd/2024-13-01/10-00-00.mp4", "", "", ""},
+		{"invalid time", "FrontDoor/hd/2024-05-01/10-00.mp4", "", "", ""},
+		{"invalid counter", "FrontDoor/hd/2024-05-01/10-00-00-x.mp4", "", "", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			file := writeSegment(t, dir, test.file, 10)
+
+			segments, err := ListSegments(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if test.camera == "" {
+				if len(segments) != 0 {
+					t.Fatalf("got %+v, want no segments", segments)
+				}
+				return
+			}
+
+			if len(segments) != 1 {
+				t.Fatalf("got %d segments, want 1", len(segments))
+			}
+			segment := segments[0]
+			start, _ := time.ParseInLocation("2006-01-02 15:04:05", test.start, time.Local)
+			if segment.Camera != test.camera || segment.Resolution != test.resolution ||
+				!segment.Start.Equal(start) || segment.File != file || segment.Size != 10 {
+				t.Errorf("got %+v, want %s (%s) at %s", segment, test.camera, test.resolution, test.start)
+			}
+		})
+	}
+}
+
+func TestListSegmentsOrder(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"Home%2FGarden/hd/2024-05-01/10-00-01.mp4",
+		"FrontDoor/hd/2024-05-01/10-00-00-10.mp4",
+		"FrontDoor/hd/2024-05-01/10-00-00-2.mp4",
+		"FrontDoor/hd/2024-05-01/10-00-00.mp4",
+		"Home/Yard/sd/2024-04-30/23-59-59.mp4",
+	} {
+		writeSegment(t, dir, name, 1)
+	}
+
+	segments, err := ListSegments(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"Home/Yard/sd/2024-04-30/23-59-59.mp4",
+		"FrontDoor/hd/2024-05-01/10-00-00.mp4",
+		"FrontDoor/hd/2024-05-01/10-00-00-2.mp4",
+		"FrontDoor/hd/2024-05-01/10-00-00-10.mp4",
+		"Home%2FGarden/hd/2024-05-01/10-00-01.mp4",
+	}
+	if len(segments) != len(want) {
+		t.Fatalf("got %d segments, want %d", len(segments), len(want))
+	}
+	for i, segment := range segments {
+		if rel, _ := filepath.Rel(dir, segment.File); filepath.ToSlash(rel) != want[i] {
+			t.Errorf("segment %d is %s, want %s", i, rel, want[i])
+		}
+	}
+}
+
+func TestListSegmentsMissingDir(t *testing.T) {
+	segments, err := ListSegments(filepath.Join(t.TempDir(), "missing"))
+	if err != nil || len(segments) != 0 {
+		t.Errorf("got %v, %v, want no segments", segments, err)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	now := time.Now()
+	old := now.Add(-48 * time.Hour)
+	name := func(camera string, start time.Time, suffix string) string {
+		return camera + "/hd/" + start.Format("2006-01-02") + "/" + start.Format("15-04-05") + suffix + ".mp4"
+	}
+
+	files := []string{
+		name("FrontDoor", old, ""),
+		name("FrontDoor", old, "-2"),
+		name("Home%2FGarden", old.Add(time.Second), ""),
+		name("Home/Yard", old.Add(2*time.Second), ""),
+		name("FrontDoor", now.Add(-time.Minute), ""),
+		name("Home%2FGarden", now, ""),
+	}
+
+	tests := []struct {
+		name    string
+		maxAge  time.Duration
+		maxSize int64
+		keep    []int
+		removed []int
+	}{
+		{"age", 24 * time.Hour, 0, nil, []int{0, 1, 2, 3}},
+		{"age keeps current file", 24 * time.Hour, 0, []int{1}, []int{0, 2, 3}},
+		{"size removes oldest first", 0, 30, nil, []int{0, 1, 2}},
+		{"size and age", time.Hour, 10, nil, []int{0, 1, 2, 3, 4}},
+		{"nothing to prune", 72 * time.Hour, 100, nil, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			paths := make([]string, len(files))
+			for i, file := range files {
+				paths[i] = writeSegment(t, dir, file, 10)
+			}
+
+			keep := make(map[string]bool)
+			for _, i := range test.keep {
+				keep[paths[i]] = true
+			}
+
+			result, err := Prune(dir, test.maxAge, test.maxSize, keep)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			removed := make(map[int]bool)
+			for _, i := range test.removed {
+				removed[i] = true
+			}
+			for i, path := range paths {
+				_, err := os.Stat(path)
+				if exists := err == nil; exists == removed[i] {
+					t.Errorf("%s exists: %v, want %v", files[i], exists, !removed[i])
+				}
+			}
+
+			if result.Files != len(test.removed) || result.Bytes != int64(10*len(test.removed)) {
+				t.Errorf("got %+v, want %d files", result, len(test.removed))
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package record
+
+import (
+	"path/filepath"
+	"sync"
+	"time"
+
+	"tuya-ipc-terminal/pkg/core"
+	"tuya-ipc-terminal/pkg/mp4"
+)
+
+const (
+	videoTrackID = 1
+	audioTrackID = 2
+
+	videoClockRate = 90000
+	audioClockRate = 8000
+
+	fragmentDuration = videoClockRate // One moof per second
+	mediaInfoTimeout = 30 * time.Second
+	retryDelay       = 10 * time.Second
+	packetQueueSize  = 4096
+)
+
+// Status describes a running recorder
+type Status struct {
+	Path       string    `json:"path"`
+	Resolution string    `json:"resolution"`
+	Recording  bool      `json:"recording"`
+	File       string    `json:"file,omitempty"`
+	Since      time.Time `json:"since"`
+	Dropped    uint64    `json:"dropped"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Recorder writes a camera stream into fixed length fMP4 segments. It stays
+// attached to the stream as a viewer, which keeps the camera connected.
+// Upstream reconnects happen within the stream, the sample timeline is
+// continued so the segments have no gaps.
+type Recorder struct {
+	manager *Manager
+	feed    *feed
+	dir     string
+
+	stop chan struct{}
+	done chan struct{}
+
+	// Only used by the run goroutine
+	segment *segmentWriter
+
+	status Status
+	mutex  sync.Mutex
+}
+
+func newRecorder(manager *Manager, path, resolution string) *Recorder {
+	return &Recorder{
+		manager: manager,
+		feed:    newFeed(manager, path, resolution, "record-"+path+"/"+resolution),
+		dir:     filepath.Join(CameraDir(manager.config.Dir, path), resolution),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+		status:  Status{Path: path, Resolution: resolution, Since: time.Now()},
+	}
+}
+
+func (r *Recorder) Status() Status {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	status := r.status
+	status.Dropped = r.feed.dropped.Load()
+	return status
+}
+
+// currentFile returns the segment being written, it must not be pruned
+func (r *Recorder) currentFile() string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.status.File
+}
+
+func (r *Recorder) setStatus(update func(status *Status)) {
+	r.mutex.Lock()
+	update(&r.status)
+	r.mutex.Unlock()
+}
+
+func (r *Recorder) Stop() {
+	close(r.stop)
+	<-r.done
+}
+
+func (r *Recorder) run() {
+	defer close(r.done)
+
+	for {
+		err := r.feed.run(r, r.stop, nil, r.started)
+		r.closeSegment()
+
+		if err == nil {
+			return
+		}
+
+		core.Logger.Warn().Err(err).Msgf("Recording of %s interrupted, retrying in %v", r.feed.path, retryDelay)
+		r.setStatus(func(status *Status) {
+			status.Recording = false
+			status.Error = err.Error()
+		})
+
+		select {
+		case <-r.stop:
+			return
+		case <-time.After(retryDelay):
+		}
+	}
+}
+
+func (r *Recorder) started() {
+	core.Logger.Info().Msgf("Recording %s (%s) to %s", r.feed.path, r.feed.resolution, r.dir)
+	r.setStatus(func(status *Status) {
+		status.Recording = true
+		status.Error = ""
+	})
+}
+
+func (r *Recorder) video(track *mp4.Track, sample mp4.Sample) {
+	segmentTicks := uint64(r.manager.config.SegmentDuration.Seconds() * videoClockRate)
+
+	switch {
+	case r.segment == nil && !sample.Keyframe:
+		// Files start with a keyframe
+		return
+	case r.segment == nil || track != r.segment.videoTrack || (sample.Keyframe && r.segment.duration >= segmentTicks):
+		// New parameter sets need a new init segment, so a new file
+		r.closeSegment()
+
+		segment, err := createSegment(r.dir, time.Now(), track, r.feed.audioCodec)
+		if err != nil {
+			r.fail(err)
+			return
+		}
+
+		r.segment = segment
+		r.setStatus(func(status *Status) { status.File = segment.name })
+		core.Logger.Debug().Msgf("Recording %s to %s", r.feed.path, segment.name)
+	}
+
+	if err := r.segment.writeVideo(sample); err != nil {
+		// The next keyframe starts a new file
+		r.fail(err)
+		r.closeSegment()
+	}
+}
+
+func (r *Recorder) audio(sample mp4.Sample) {
+	if r.segment != nil {
+		r.segment.writeAudio(sample)
+	}
+}
+
+func (r *Recorder) fail(err error) {
+	core.Logger.Error().Err(err).Msgf("Recording of %s failed", r.feed.path)
+	r.setStatus(func(status *Status) { status.Error = err.Error() })
+}
+
+func (r *Recorder) closeSegment() {
+	if r.segment == nil {
+		return
+	}
+
+	if err := r.segment.close(); err != nil {
+		r.fail(err)
+	}
+
+	r.segment = nil
+	r.setStatus(func(status *Status) { status.File = "" })
+}
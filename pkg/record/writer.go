@@ -0,0 +1,120 @@
+package record
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"tuya-ipc-terminal/pkg/mp4"
+)
+
+// segmentWriter writes one fragmented MP4 file, a fragment per keyframe or
+// second of video
+type segmentWriter struct {
+	file       *os.File
+	name       string
+	videoTrack *mp4.Track
+	audioCodec string
+
+	sequence   uint32
+	videoBase  uint64
+	audioBase  uint64
+	video      []mp4.Sample
+	audio      []mp4.Sample
+	videoTicks uint32 // Of the buffered fragment
+	duration   uint64 // Video ticks in the file
+}
+
+// createSegment creates <dir>/<date>/<time>.mp4 with the init segment. Audio
+// is left out if audioCodec is empty.
+func createSegment(dir string, start time.Time, videoTrack *mp4.Track, audioCodec string) (*segmentWriter, error) {
+	dir = filepath.Join(dir, start.Format("2006-01-02"))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create recording directory: %v", err)
+	}
+
+	base := start.Format("15-04-05")
+	name := filepath.Join(dir, base+".mp4")
+	for i := 1; ; i++ {
+		if _, err := os.Stat(name); os.IsNotExist(err) {
+			break
+		}
+		name = filepath.Join(dir, fmt.Sprintf("%s-%d.mp4", base, i))
+	}
+
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create segment: %v", err)
+	}
+
+	tracks := []*mp4.Track{videoTrack}
+	if audioCodec != "" {
+		tracks = append(tracks, &mp4.Track{ID: audioTrackID, Codec: audioCodec, TimeScale: audioClockRate})
+	}
+
+	if _, err := file.Write(mp4.InitSegment(tracks...)); err != nil {
+		file.Close()
+		os.Remove(name)
+		return nil, fmt.Errorf("failed to write segment: %v", err)
+	}
+
+	return &segmentWriter{file: file, name: name, videoTrack: videoTrack, audioCodec: audioCodec}, nil
+}
+
+// writeVideo buffers a sample of the file's track, the fragment before a
+// keyframe is written first
+func (w *segmentWriter) writeVideo(sample mp4.Sample) error {
+	if sample.Keyframe || w.videoTicks >= fragmentDuration {
+		if err := w.flush(); err != nil {
+			return err
+		}
+	}
+
+	w.video = append(w.video, sample)
+	w.videoTicks += sample.Duration
+	w.duration += uint64(sample.Duration)
+
+	return nil
+}
+
+func (w *segmentWriter) writeAudio(sample mp4.Sample) {
+	if w.audioCodec != "" {
+		w.audio = append(w.audio, sample)
+	}
+}
+
+// flush writes the buffered samples as one moof/mdat
+func (w *segmentWriter) flush() error {
+	if len(w.video) == 0 {
+		return nil
+	}
+
+	w.sequence++
+	fragment := mp4.Fragment(w.sequence,
+		mp4.TrackFragment{TrackID: videoTrackID, BaseTime: w.videoBase, Samples: w.video},
+		mp4.TrackFragment{TrackID: audioTrackID, BaseTime: w.audioBase, Samples: w.audio},
+	)
+
+	for _, sample := range w.video {
+		w.videoBase += uint64(sample.Duration)
+	}
+	for _, sample := range w.audio {
+		w.audioBase += uint64(sample.Duration)
+	}
+	w.video, w.audio, w.videoTicks = nil, nil, 0
+
+	if _, err := w.file.Write(fragment); err != nil {
+		return fmt.Errorf("failed to write segment: %v", err)
+	}
+
+	return nil
+}
+
+func (w *segmentWriter) close() error {
+	err := w.flush()
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
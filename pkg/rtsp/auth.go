@@ -0,0 +1,265 @@
+package rtsp
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"tuya-ipc-terminal/pkg/core"
+	"tuya-ipc-terminal/pkg/storage"
+	"tuya-ipc-terminal/pkg/utils"
+)
+
+// DigestRealm is part of the stored HA1 hashes, changing it invalidates all RTSP users
+const DigestRealm = "TuyaIPCTerminal"
+
+// NewRTSPUser hashes the password so that only HA1 ends up on disk
+func NewRTSPUser(username, password string, paths []string) storage.RTSPUser {
+	if len(paths) == 0 {
+		paths = []string{"*"}
+	}
+
+	return storage.RTSPUser{
+		Username:  username,
+		HA1:       md5Hex(fmt.Sprintf("%s:%s:%s", username, DigestRealm, password)),
+		Paths:     paths,
+		CreatedAt: time.Now(),
+	}
+}
+
+// authenticate validates the Authorization header of a request against the
+// stored RTSP users. It returns nil if the credentials are missing or wrong.
+func (s *RTSPServer) authenticate(client *RTSPClient, request *RTSPRequest) *storage.RTSPUser {
+	authorization := request.Headers["Authorization"]
+	if authorization == "" {
+		return nil
+	}
+
+	scheme, params, _ := strings.Cut(authorization, " ")
+
+	switch strings.ToLower(scheme) {
+	case "digest":
+		return s.authenticateDigest(client, request, ParseAuthParams(params))
+	case "basic":
+		if !s.config.AllowBasicAuth {
+			return nil
+		}
+		return s.authenticateBasic(strings.TrimSpace(params))
+	}
+
+	return nil
+}
+
+func (s *RTSPServer) authenticateDigest(client *RTSPClient, request *RTSPRequest, params map[string]string) *storage.RTSPUser {
+	// The nonce is bound to the connection, so a nonce from another client is never accepted
+	if client.authNonce == "" || params["nonce"] != client.authNonce {
+		return nil
+	}
+
+	return s.CheckDigest(request.Method, request.URL, params)
+}
+
+// CheckDigest validates the response of Digest parameters for a request to
+// target against the stored users, the caller has to check the nonce
+func (s *RTSPServer) CheckDigest(method, target string, params map[string]string) *storage.RTSPUser {
+	if params["realm"] != DigestRealm || !digestURIMatches(params["uri"], target) {
+		return nil
+	}
+
+	user, err := s.storageManager.GetRTSPUser(params["username"])
+	if err != nil || user == nil {
+		return nil
+	}
+
+	ha2 := md5Hex(fmt.Sprintf("%s:%s", method, params["uri"]))
+
+	var expected string
+	if qop := params["qop"]; qop == "auth" {
+		expected = md5Hex(fmt.Sprintf("%s:%s:%s:%s:%s:%s", user.HA1, params["nonce"], params["nc"], params["cnonce"], qop, ha2))
+	} else {
+		expected = md5Hex(fmt.Sprintf("%s:%s:%s", user.HA1, params["nonce"], ha2))
+	}
+
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(strings.ToLower(params["response"]))) != 1 {
+		return nil
+	}
+
+	return user
+}
+
+// digestURIMatches reports whether the uri of Digest parameters is the request
+// target, absolute RTSP URLs also match by their path
+func digestURIMatches(uri, target string) bool {
+	if uri == target {
+		return true
+	}
+	u, err := url.Parse(target)
+	return err == nil && u.IsAbs() && uri == u.RequestURI()
+}
+
+func (s *RTSPServer) authenticateBasic(encoded string) *storage.RTSPUser {
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil
+	}
+
+	username, password, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return nil
+	}
+
+	return s.CheckPassword(username, password)
+}
+
+// CheckPassword returns the RTSP user with these credentials, nil if they're wrong
+func (s *RTSPServer) CheckPassword(username, password string) *storage.RTSPUser {
+	user, err := s.storageManager.GetRTSPUser(username)
+	if err != nil || user == nil {
+		return nil
+	}
+
+	ha1 := md5Hex(fmt.Sprintf("%s:%s:%s", username, DigestRealm, password))
+	if subtle.ConstantTimeCompare([]byte(ha1), []byte(user.HA1)) != 1 {
+		return nil
+	}
+
+	return user
+}
+
+// CheckPasswordDigest checks a WS-Security password digest,
+// Base64(SHA1(nonce + created + password)). Only users with a kept password
+// can be checked.
+func (s *RTSPServer) CheckPasswordDigest(username, digest string, nonce []byte, created string) *storage.RTSPUser {
+	user, err := s.storageManager.GetRTSPUser(username)
+	if err != nil || user == nil {
+		return nil
+	}
+
+	password, err := s.storageManager.RTSPUserPassword(user)
+	if err != nil {
+		core.Logger.Warn().Err(err).Msgf("Failed to check the password digest of %s", username)
+		return nil
+	}
+	if password == "" {
+		return nil
+	}
+
+	h := sha1.New()
+	h.Write(nonce)
+	h.Write([]byte(created))
+	h.Write([]byte(password))
+	expected := base64.StdEncoding.EncodeToString(h.Sum(nil))
+
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(digest)) != 1 {
+		return nil
+	}
+
+	return user
+}
+
+// AuthenticationEnabled reports whether clients need an RTSP user
+func (s *RTSPServer) AuthenticationEnabled() bool {
+	return s.config.EnableAuthentication
+}
+
+// AuthorizeHTTP checks the Basic credentials of an HTTP media request (HLS,
+// WebRTC) against the RTSP users if authentication is enabled. On failure the
+// response is written and false returned.
+func (s *RTSPServer) AuthorizeHTTP(w http.ResponseWriter, r *http.Request, cameraPath string) bool {
+	if !s.config.EnableAuthentication {
+		return true
+	}
+
+	var user *storage.RTSPUser
+	if username, password, ok := r.BasicAuth(); ok {
+		user = s.CheckPassword(username, password)
+	}
+
+	if user == nil {
+		if _, _, ok := r.BasicAuth(); ok {
+			connectionsRejected.Inc("unauthorized")
+		}
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm="%s"`, DigestRealm))
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+
+	if !user.CanAccess(cameraPath) {
+		connectionsRejected.Inc("forbidden")
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false
+	}
+
+	return true
+}
+
+func (s *RTSPServer) sendUnauthorized(client *RTSPClient, request *RTSPRequest) {
+	if client.authNonce == "" {
+		client.authNonce = utils.RandString(32, 16)
+	}
+
+	challenges := []string{
+		fmt.Sprintf(`Digest realm="%s", nonce="%s", algorithm=MD5`, DigestRealm, client.authNonce),
+	}
+
+	if s.config.AllowBasicAuth {
+		challenges = append(challenges, fmt.Sprintf(`Basic realm="%s"`, DigestRealm))
+	}
+
+	headers := map[string]string{
+		"CSeq":             fmt.Sprintf("%d", request.CSeq),
+		"WWW-Authenticate": strings.Join(challenges, "\n"),
+	}
+
+	sendRTSPResponse(client.conn, 401, "Unauthorized", headers, "")
+}
+
+// ParseAuthParams parses `key="value", key=value` pairs of a Digest header
+func ParseAuthParams(s string) map[string]string {
+	params := make(map[string]string)
+
+	for len(s) > 0 {
+		s = strings.TrimLeft(s, " ,")
+
+		i := strings.IndexByte(s, '=')
+		if i < 0 {
+			break
+		}
+
+		key := strings.ToLower(strings.TrimSpace(s[:i]))
+		s = s[i+1:]
+
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			end := strings.IndexByte(s[1:], '"')
+			if end < 0 {
+				value, s = s[1:], ""
+			} else {
+				value, s = s[1:end+1], s[end+2:]
+			}
+		} else {
+			end := strings.IndexByte(s, ',')
+			if end < 0 {
+				value, s = s, ""
+			} else {
+				value, s = s[:end], s[end+1:]
+			}
+		}
+
+		params[key] = strings.TrimSpace(value)
+	}
+
+	return params
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,304 @@
+package rtsp
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"tuya-ipc-terminal/pkg/storage"
+)
+
+func newTestServer(t *testing.T, config ServerConfig) *RTSPServer {
+	t.Helper()
+
+	sm, err := storage.NewStorageManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.SaveRTSPUser(NewRTSPUser("nvr", "pass", nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	return NewRTSPServer(config, sm)
+}
+
+// digestResponse computes the response of a client for the given parameters
+func digestResponse(password, method string, params map[string]string) string {
+	ha1 := md5Hex(fmt.Sprintf("%s:%s:%s", params["username"], params["realm"], password))
+	ha2 := md5Hex(fmt.Sprintf("%s:%s", method, params["uri"]))
+	if params["qop"] != "" {
+		return md5Hex(fmt.Sprintf("%s:%s:%s:%s:%s:%s", ha1, params["nonce"], params["nc"], params["cnonce"], params["qop"], ha2))
+	}
+	return md5Hex(fmt.Sprintf("%s:%s:%s", ha1, params["nonce"], ha2))
+}
+
+func TestParseAuthParams(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   map[string]string
+	}{
+		{
+			name:   "quoted and token values",
+			header: `username="nvr", realm="TuyaIPCTerminal", nonce="abc", uri="rtsp://host/cam", qop=auth, nc=00000001, cnonce="xyz", response="0123"`,
+			want: map[string]string{
+				"username": "nvr", "realm": "TuyaIPCTerminal", "nonce": "abc", "uri": "rtsp://host/cam",
+				"qop": "auth", "nc": "00000001", "cnonce": "xyz", "response": "0123",
+			},
+		},
+		{
+			name:   "commas and equal signs in quotes",
+			header: `uri="/onvif/cam?a=1,b=2", username="a b"`,
+			want:   map[string]string{"uri": "/onvif/cam?a=1,b=2", "username": "a b"},
+		},
+		{
+			name:   "keys are lower case",
+			header: `Username="nvr", NC=00000002`,
+			want:   map[string]string{"username": "nvr", "nc": "00000002"},
+		},
+		{
+			name:   "empty value",
+			header: `username="", qop=auth`,
+			want:   map[string]string{"username": "", "qop": "auth"},
+		},
+		{
+			name:   "unterminated quote",
+			header: `username="nvr`,
+			want:   map[string]string{"username": "nvr"},
+		},
+		{
+			name:   "no parameters",
+			header: ``,
+			want:   map[string]string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := ParseAuthParams(test.header)
+			if len(got) != len(test.want) {
+				t.Fatalf("got %v, want %v", got, test.want)
+			}
+			for key, value := range test.want {
+				if got[key] != value {
+					t.Errorf("%s = %q, want %q", key, got[key], value)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckDigest(t *testing.T) {
+	s := newTestServer(t, ServerConfig{EnableAuthentication: true})
+
+	base := func() map[string]string {
+		return map[string]string{
+			"username": "nvr",
+			"realm":    DigestRealm,
+			"nonce":    "0123456789abcdef",
+			"uri":      "rtsp://127.0.0.1:8554/Garden",
+			"qop":      "auth",
+			"nc":       "00000001",
+			"cnonce":   "xyz",
+		}
+	}
+
+	tests := []struct {
+		name     string
+		target   string
+		password string
+		change   func(params map[string]string)
+		valid    bool
+	}{
+		{"qop auth", "rtsp://127.0.0.1:8554/Garden", "pass", nil, true},
+		{"without qop", "rtsp://127.0.0.1:8554/Garden", "pass", func(p map[string]string) {
+			delete(p, "qop")
+			delete(p, "nc")
+			delete(p, "cnonce")
+		}, true},
+		{"path of an absolute target", "rtsp://127.0.0.1:8554/Garden", "pass", func(p map[string]string) { p["uri"] = "/Garden" }, true},
+		{"HTTP request target", "/onvif/Garden/media_service", "pass", func(p map[string]string) { p["uri"] = "/onvif/Garden/media_service" }, true},
+		{"other nonce count", "rtsp://127.0.0.1:8554/Garden", "pass", func(p map[string]string) { p["nc"] = "00000002" }, true},
+		{"wrong password", "rtsp://127.0.0.1:8554/Garden", "wrong", nil, false},
+		{"wrong realm", "rtsp://127.0.0.1:8554/Garden", "pass", func(p map[string]string) { p["realm"] = "Other" }, false},
+		{"unknown user", "rtsp://127.0.0.1:8554/Garden", "pass", func(p map[string]string) { p["username"] = "other" }, false},
+		{"uri of another camera", "rtsp://127.0.0.1:8554/Door", "pass", nil, false},
+		{"uri of another path", "/onvif/Door/media_service", "pass", func(p map[string]string) { p["uri"] = "/onvif/Garden/media_service" }, false},
+		{"missing uri", "rtsp://127.0.0.1:8554/Garden", "pass", func(p map[string]string) { delete(p, "uri") }, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			params := base()
+			if test.change != nil {
+				test.change(params)
+			}
+			params["response"] = digestResponse(test.password, "DESCRIBE", params)
+
+			user := s.CheckDigest("DESCRIBE", test.target, params)
+			if (user != nil) != test.valid {
+				t.Errorf("got user %v, want valid %v", user, test.valid)
+			}
+		})
+	}
+
+	// The nonce count and cnonce are part of the response
+	params := base()
+	params["response"] = digestResponse("pass", "DESCRIBE", params)
+	params["nc"] = "00000002"
+	if s.CheckDigest("DESCRIBE", params["uri"], params) != nil {
+		t.Error("response of another nonce count accepted")
+	}
+}
+
+// TestPasswordsAfterDecrypt checks that decrypting the storage removes the
+// kept passwords while HTTP Digest keeps working
+func TestPasswordsAfterDecrypt(t *testing.T) {
+	dir := t.TempDir()
+	nonce := []byte("nonce")
+	created := "2026-01-01T00:00:00Z"
+	sum := sha1.Sum([]byte(string(nonce) + created + "pass"))
+	digest := base64.StdEncoding.EncodeToString(sum[:])
+
+	sm, err := storage.NewStorageManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.EnableEncryption([]byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sm.MigrateUsers(true); err != nil {
+		t.Fatal(err)
+	}
+
+	user := NewRTSPUser("nvr", "pass", nil)
+	if user.Password, err = sm.SealPassword("pass"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.SaveRTSPUser(user); err != nil {
+		t.Fatal(err)
+	}
+
+	if NewRTSPServer(ServerConfig{}, sm).CheckPasswordDigest("nvr", digest, nonce, created) == nil {
+		t.Fatal("password digest rejected on encrypted storage")
+	}
+
+	if _, err := sm.MigrateUsers(false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Later runs have no key, or a new one
+	plain, err := storage.NewStorageManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reencrypted, err := storage.NewStorageManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reencrypted.EnableEncryption([]byte("other")); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, sm := range map[string]*storage.StorageManager{"plain": plain, "new key": reencrypted} {
+		stored, err := sm.GetRTSPUser("nvr")
+		if err != nil || stored == nil {
+			t.Fatalf("%s: user lost: %v", name, err)
+		}
+		if password, err := sm.RTSPUserPassword(stored); password != "" || err != nil {
+			t.Errorf("%s: kept password %q (%v), want none", name, password, err)
+		}
+
+		s := NewRTSPServer(ServerConfig{}, sm)
+		if s.CheckPasswordDigest("nvr", digest, nonce, created) != nil {
+			t.Errorf("%s: password digest accepted without a kept password", name)
+		}
+
+		params := map[string]string{"username": "nvr", "realm": DigestRealm, "nonce": "abc", "uri": "/Garden"}
+		params["response"] = digestResponse("pass", "DESCRIBE", params)
+		if s.CheckDigest("DESCRIBE", "/Garden", params) == nil {
+			t.Errorf("%s: HTTP Digest rejected", name)
+		}
+	}
+}
+
+// readResponse reads the status code and headers of an RTSP response
+func readResponse(t *testing.T, reader *textproto.Reader) (int, textproto.MIMEHeader) {
+	t.Helper()
+
+	line, err := reader.ReadLine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var code int
+	if _, err := fmt.Sscanf(line, "RTSP/1.0 %d", &code); err != nil {
+		t.Fatalf("invalid status line %q", line)
+	}
+
+	header, err := reader.ReadMIMEHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code, header
+}
+
+// TestUnknownCameraNeedsAuthentication checks that unauthorized clients can't
+// tell existing from unknown camera paths
+func TestUnknownCameraNeedsAuthentication(t *testing.T) {
+	tests := []struct {
+		name         string
+		auth         bool
+		authenticate bool
+		want         int
+	}{
+		{"without authentication", false, false, 404},
+		{"unauthorized", true, false, 401},
+		{"authorized", true, true, 404},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestServer(t, ServerConfig{EnableAuthentication: test.auth})
+
+			conn, serverConn := net.Pipe()
+			defer conn.Close()
+			go s.handleConnection(serverConn)
+
+			reader := textproto.NewReader(bufio.NewReader(conn))
+			url := "rtsp://127.0.0.1:8554/Unknown"
+
+			fmt.Fprintf(conn, "DESCRIBE %s RTSP/1.0\r\nCSeq: 1\r\n\r\n", url)
+			code, header := readResponse(t, reader)
+
+			if test.authenticate {
+				if code != 401 {
+					t.Fatalf("got %d, want the 401 challenge", code)
+				}
+				challenge, _ := strings.CutPrefix(header.Get("WWW-Authenticate"), "Digest ")
+				params := ParseAuthParams(challenge)
+				params["username"] = "nvr"
+				params["uri"] = url
+				params["response"] = digestResponse("pass", "DESCRIBE", params)
+
+				fmt.Fprintf(conn, "DESCRIBE %s RTSP/1.0\r\nCSeq: 2\r\nAuthorization: Digest username=%q, realm=%q, nonce=%q, uri=%q, response=%q\r\n\r\n",
+					url, params["username"], params["realm"], params["nonce"], params["uri"], params["response"])
+				code, header = readResponse(t, reader)
+			}
+
+			if code != test.want {
+				t.Errorf("got %d, want %d", code, test.want)
+			}
+			if header.Get("CSeq") == "" {
+				t.Error("response without CSeq")
+			}
+			if len(s.GetClients()) != 0 {
+				t.Error("client of an unknown camera is listed")
+			}
+		})
+	}
+}
@@ -31,6 +31,7 @@ type WebRTCBridge struct {
 	resolution     string
 	streamType     int
 	isHEVC         bool
+	replayStart    time.Time // SD-card playback position, zero for live
 	user           *storage.UserSession
 	storageManager *storage.StorageManager
 
@@ -40,9 +41,11 @@ type WebRTCBridge struct {
 	mqttClient     *tuya.MQTTClient
 	cameraClient   *tuya.MQTTCameraClient
 	rtpForwarder   *RTPForwarder
+	media          *mediaProbe
 
 	// State
 	connected bool
+	stopped   bool
 	waiter    utils.Waiter
 	mutex     sync.RWMutex
 
@@ -61,14 +64,20 @@ type WebRTCBridge struct {
 	OnError       func(error)
 }
 
-func NewWebRTCBridge(camera *storage.CameraInfo, streamResolution string, user *storage.UserSession, storageManager *storage.StorageManager) *WebRTCBridge {
+// bridgeConnectTimeout bounds how long Start waits for the camera to answer and stream
+const bridgeConnectTimeout = 30 * time.Second
+
+// NewWebRTCBridge creates a bridge delivering into forwarder. The forwarder belongs
+// to the stream and outlives the bridge, so it is not stopped with it.
+func NewWebRTCBridge(camera *storage.CameraInfo, streamResolution string, user *storage.UserSession, storageManager *storage.StorageManager, forwarder *RTPForwarder) *WebRTCBridge {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	wb := &WebRTCBridge{
 		camera:         camera,
 		resolution:     streamResolution,
 		user:           user,
-		rtpForwarder:   NewRTPForwarder(),
+		rtpForwarder:   forwarder,
+		media:          newMediaProbe(),
 		storageManager: storageManager,
 		connected:      false,
 		waiter:         utils.Waiter{},
@@ -76,8 +85,6 @@ func NewWebRTCBridge(camera *storage.CameraInfo, streamResolution string, user *
 		cancel:         cancel,
 	}
 
-	wb.rtpForwarder.OnBackchannelAudio = wb.ForwardBackchannelAudioPacket
-
 	return wb
 }
 
@@ -91,22 +98,28 @@ func (wb *WebRTCBridge) Start() error {
 
 	core.Logger.Info().Msgf("Starting WebRTC bridge for camera: %s", wb.camera.DeviceName)
 
+	start := time.Now()
+	fail := func(reason string, err error) error {
+		bridgeStartFailures.Inc(reason)
+		return err
+	}
+
 	// Create HTTP client with session
 	httpClient := wb.createHTTPClient()
 	if httpClient == nil {
-		return errors.New("failed to create HTTP client")
+		return fail("http_client", errors.New("failed to create HTTP client"))
 	}
 
 	// Get app info
 	appInfo, err := tuya.GetAppInfo(httpClient, wb.user.SessionData.ServerHost)
 	if err != nil {
-		return fmt.Errorf("failed to get app info: %v", err)
+		return fail("app_info", fmt.Errorf("failed to get app info: %v", err))
 	}
 
 	// Get MQTT config
 	mqttConfig, err := tuya.GetMQTTConfig(httpClient, wb.user.SessionData.ServerHost)
 	if err != nil {
-		return fmt.Errorf("failed to get MQTT config: %v", err)
+		return fail("mqtt_config", fmt.Errorf("failed to get MQTT config: %v", err))
 	}
 
 	// Connect to MQTT broker
@@ -117,34 +130,36 @@ func (wb *WebRTCBridge) Start() error {
 	)
 
 	if err != nil {
-		return fmt.Errorf("failed to connect to MQTT: %v", err)
+		return fail("mqtt_connect", fmt.Errorf("failed to connect to MQTT: %v", err))
 	}
 
 	if err = wb.mqttClient.Connected.Wait(); err != nil {
-		return fmt.Errorf("MQTT connection failed: %v", err)
+		return fail("mqtt_connect", fmt.Errorf("MQTT connection failed: %v", err))
 	}
 
 	// Get WebRTC configuration
 	webRTCConfig, err := tuya.GetWebRTCConfig(httpClient, wb.user.SessionData.ServerHost, wb.camera.DeviceID)
 	if err != nil {
-		return fmt.Errorf("failed to get WebRTC config: %v", err)
+		return fail("webrtc_config", fmt.Errorf("failed to get WebRTC config: %v", err))
 	}
 
 	// Parse skill information
 	var skill tuya.Skill
 	if err := json.Unmarshal([]byte(webRTCConfig.Result.Skill), &skill); err != nil {
-		return fmt.Errorf("failed to parse skill info: %v", err)
+		return fail("skill", fmt.Errorf("failed to parse skill info: %v", err))
 	}
 
 	// Determine stream settings
 	wb.streamType = tuya.GetStreamType(&skill, wb.resolution)
 	wb.isHEVC = tuya.IsHEVC(&skill, wb.streamType)
+	wb.media.setHEVC(wb.isHEVC)
+	wb.rtpForwarder.SetHEVC(wb.isHEVC)
 
 	core.Logger.Info().Msgf("Stream settings - Resolution: %s, Type: %d, HEVC: %v", wb.resolution, wb.streamType, wb.isHEVC)
 
 	// Setup WebRTC peer connection
 	if err := wb.setupPeerConnection(&webRTCConfig.Result); err != nil {
-		return fmt.Errorf("failed to setup peer connection: %v", err)
+		return fail("peer_connection", fmt.Errorf("failed to setup peer connection: %v", err))
 	}
 
 	// Setup MQTT camera client
@@ -152,13 +167,27 @@ func (wb *WebRTCBridge) Start() error {
 
 	// Create and send offer
 	if err := wb.createAndSendOffer(); err != nil {
-		return fmt.Errorf("failed to create offer: %v", err)
+		return fail("offer", fmt.Errorf("failed to create offer: %v", err))
 	}
 
-	if err = wb.waiter.Wait(); err != nil {
-		return fmt.Errorf("failed to establish connection: %v", err)
+	waitErr := make(chan error, 1)
+	go func() {
+		waitErr <- wb.waiter.Wait()
+	}()
+
+	reason := "connection"
+	select {
+	case err = <-waitErr:
+	case <-time.After(bridgeConnectTimeout):
+		err = fmt.Errorf("no stream from camera within %v", bridgeConnectTimeout)
+		reason = "timeout"
+	}
+
+	if err != nil {
+		return fail(reason, fmt.Errorf("failed to establish connection: %v", err))
 	}
 
+	bridgeStartDuration.Observe(time.Since(start).Seconds())
 	wb.connected = true
 	core.Logger.Info().Msgf("WebRTC bridge started successfully for camera: %s", wb.camera.DeviceName)
 
@@ -169,10 +198,12 @@ func (wb *WebRTCBridge) Stop() {
 	wb.mutex.Lock()
 	defer wb.mutex.Unlock()
 
-	if !wb.connected {
+	// Also cleans up after a failed Start
+	if wb.stopped {
 		return
 	}
 
+	wb.stopped = true
 	wb.connected = false
 
 	core.Logger.Info().Msgf("Stopping WebRTC bridge for camera: %s", wb.camera.DeviceName)
@@ -195,11 +226,6 @@ func (wb *WebRTCBridge) Stop() {
 		wb.mqttClient.Stop()
 	}
 
-	// Stop RTP forwarder
-	if wb.rtpForwarder != nil {
-		wb.rtpForwarder.Stop()
-	}
-
 	core.Logger.Info().Msgf("WebRTC bridge stopped for camera: %s", wb.camera.DeviceName)
 }
 
@@ -272,9 +298,10 @@ func (wb *WebRTCBridge) setupPeerConnection(webRTCConfig *tuya.WebRTCConfig) err
 
 				switch packet.SSRC {
 				case wb.rtpForwarder.videoSSRC:
+					wb.media.observeVideo(packet)
 					wb.rtpForwarder.ForwardVideoPacket(packet)
 				case wb.rtpForwarder.audioSSRC:
-					wb.rtpForwarder.ForwardAudioPacket(packet)
+					wb.forwardAudio(packet)
 				}
 			}
 		})
@@ -366,6 +393,10 @@ func (wb *WebRTCBridge) setupMQTTCameraClient(webRTCConfig *tuya.WebRTCConfig) {
 	wb.cameraClient = tuya.NewMqttCameraClient(wb.mqttClient, device, webRTCConfig)
 	wb.mqttClient.AddCameraClient(wb.cameraClient.SessionId, wb.cameraClient)
 
+	if !wb.replayStart.IsZero() {
+		wb.cameraClient.SetReplay(wb.replayStart)
+	}
+
 	// Setup handlers
 	wb.cameraClient.HandleAnswer = func(answer tuya.AnswerFrame) {
 		core.Logger.Trace().Msgf("Received WebRTC answer")
@@ -482,6 +513,7 @@ func (wb *WebRTCBridge) handleVideoTrack(track *pion.TrackRemote) {
 				continue
 			}
 
+			wb.media.observeVideo(packet)
 			wb.rtpForwarder.ForwardVideoPacket(packet)
 		}
 	}
@@ -510,11 +542,21 @@ func (wb *WebRTCBridge) handleAudioTrack(track *pion.TrackRemote) {
 				continue
 			}
 
-			wb.rtpForwarder.ForwardAudioPacket(packet)
+			wb.forwardAudio(packet)
 		}
 	}
 }
 
+// forwardAudio passes audio to the clients unless it's disabled for the camera
+func (wb *WebRTCBridge) forwardAudio(packet *rtp.Packet) {
+	if wb.camera.NoAudio {
+		return
+	}
+
+	wb.media.observeAudio(packet)
+	wb.rtpForwarder.ForwardAudioPacket(packet)
+}
+
 func (wb *WebRTCBridge) createHTTPClient() *http.Client {
 	jar, err := cookiejar.New(&cookiejar.Options{
 		PublicSuffixList: publicsuffix.List,
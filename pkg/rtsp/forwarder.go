@@ -3,8 +3,10 @@ package rtsp
 import (
 	"fmt"
 	"net"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	"tuya-ipc-terminal/pkg/core"
 	"tuya-ipc-terminal/pkg/utils"
@@ -20,6 +22,13 @@ const (
 	TransportTCP               // Interleaved
 )
 
+func (m TransportMode) String() string {
+	if m == TransportTCP {
+		return "tcp"
+	}
+	return "udp"
+}
+
 type RTPForwarder struct {
 	clients map[string]*RTPClient
 	mutex   sync.RWMutex
@@ -28,9 +37,21 @@ type RTPForwarder struct {
 	videoSSRC uint32
 	audioSSRC uint32
 
-	// Packet count
-	firstVideoPacket bool
-	firstAudioPacket bool
+	// Set until the first packet of the track was sent, for logging
+	firstVideoPacket atomic.Bool
+	firstAudioPacket atomic.Bool
+
+	// Last GOP, replayed to clients on PLAY
+	gop *gopCache
+
+	// Incremented when the upstream reconnects
+	epoch uint32
+
+	// Closed to stop the RTCP sender report loop
+	rtcpDone chan struct{}
+
+	// In-process consumers (HLS, WebRTC)
+	viewers map[string]Viewer
 
 	OnBackchannelAudio func(*rtp.Packet)
 }
@@ -39,17 +60,11 @@ type RTPClient struct {
 	sessionID     string
 	transportMode TransportMode
 
-	// UDP transport - Outgoing connections (server -> client)
-	videoConn *net.UDPConn // For sending video to client
-	audioConn *net.UDPConn // For sending audio to client
-
-	// UDP transport - Client addresses
-	videoAddr *net.UDPAddr
-	audioAddr *net.UDPAddr
+	// UDP transport - Per track server sockets and client addresses
+	videoUDP *udpTrack
+	audioUDP *udpTrack
 
 	// UDP transport - Client ports
-	videoRTPPort          int // Client's video receiving port
-	audioRTPPort          int // Client's audio receiving port
 	backchannelClientPort int // Client's backchannel sending port
 
 	// UDP backchannel listeners (server side)
@@ -58,105 +73,179 @@ type RTPClient struct {
 	backchannelServerPort   int          // Server's RTP listening port
 	backchannelRTCPPort     int          // Server's RTCP listening port
 
-	// TCP interleaved transport
-	tcpConn             net.Conn
-	videoRTPChannel     byte
-	audioRTPChannel     byte
-	backAudioRTPChannel byte
+	// TCP interleaved transport, nil channels for tracks without SETUP
+	tcpConn       net.Conn
+	videoChannels *interleavedChannels
+	audioChannels *interleavedChannels
+
+	// RTCP sender/receiver state per track
+	videoRTCP *rtcpTrack
+	audioRTCP *rtcpTrack
+
+	// Packets are only sent after PLAY, with per client sequence numbers and timestamps
+	playing       bool
+	videoRewriter rtpRewriter
+	audioRewriter rtpRewriter
+
+	// Sent RTP, header included
+	packetsSent atomic.Uint64
+	bytesSent   atomic.Uint64
 
-	lastActivity time.Time
+	// Unix nanoseconds, written by the packet goroutines under the read lock
+	lastActivity atomic.Int64
+}
+
+// interleavedChannels are the RTP and RTCP channels of a track set up over
+// RTSP/TCP. Clients get no packets for tracks without them.
+type interleavedChannels struct {
+	rtp  byte
+	rtcp byte
+}
+
+func (c *interleavedChannels) hasRTP(channel byte) bool {
+	return c != nil && c.rtp == channel
+}
+
+func (c *interleavedChannels) hasRTCP(channel byte) bool {
+	return c != nil && c.rtcp == channel
+}
+
+func (c *interleavedChannels) String() string {
+	if c == nil {
+		return "none"
+	}
+	return fmt.Sprintf("%d-%d", c.rtp, c.rtcp)
+}
+
+// udpTrack sends RTP of one track from a fixed server port pair, so NATed
+// clients can reach us on the advertised server_port and we can latch to the
+// address their packets really come from (symmetric RTP)
+type udpTrack struct {
+	rtpConn  *net.UDPConn // Server RTP socket, also used for sending
+	rtcpConn *net.UDPConn // Server RTCP socket
+
+	rtpAddr  *net.UDPAddr // Client RTP address
+	rtcpAddr *net.UDPAddr // Client RTCP address
+
+	serverRTPPort  int
+	serverRTCPPort int
+
+	rtpLatched  bool
+	rtcpLatched bool
+	mutex       sync.RWMutex
+}
+
+func newRTPClient(sessionID string, transportMode TransportMode) *RTPClient {
+	client := &RTPClient{
+		sessionID:     sessionID,
+		transportMode: transportMode,
+		videoRTCP:     newRTCPTrack(videoClockRate),
+		audioRTCP:     newRTCPTrack(audioClockRate),
+		videoRewriter: rtpRewriter{clockRate: videoClockRate},
+		audioRewriter: rtpRewriter{clockRate: audioClockRate},
+	}
+	client.touch()
+	return client
+}
+
+func (c *RTPClient) touch() {
+	c.lastActivity.Store(time.Now().UnixNano())
+}
+
+func (c *RTPClient) idle(now time.Time) time.Duration {
+	return now.Sub(time.Unix(0, c.lastActivity.Load()))
 }
 
 func NewRTPForwarder() *RTPForwarder {
-	return &RTPForwarder{
-		clients:          make(map[string]*RTPClient),
-		videoSSRC:        0, // Default SSRC for video
-		audioSSRC:        1, // Default SSRC for audio
-		firstVideoPacket: true,
-		firstAudioPacket: true,
+	rf := &RTPForwarder{
+		clients:   make(map[string]*RTPClient),
+		viewers:   make(map[string]Viewer),
+		gop:       newGOPCache(0),
+		videoSSRC: 0, // Default SSRC for video
+		audioSSRC: 1, // Default SSRC for audio
 	}
+	rf.firstVideoPacket.Store(true)
+	rf.firstAudioPacket.Store(true)
+	return rf
 }
 
-func (rf *RTPForwarder) AddUDPClient(sessionID string, videoRTPPort, audioRTPPort int) error {
+// AddUDPClient sets up UDP delivery of one track (utils.KindVideo or utils.KindAudio)
+// to host:rtpPort and returns the server RTP/RTCP ports for the Transport header.
+// The client is created on the first call and updated by further SETUPs.
+func (rf *RTPForwarder) AddUDPClient(sessionID, kind, host string, rtpPort, rtcpPort int) (int, int, error) {
 	rf.mutex.Lock()
 	defer rf.mutex.Unlock()
 
-	// Check if client already exists
-	if client, exists := rf.clients[sessionID]; exists {
-		// Update existing client with new ports
-		client.videoRTPPort = videoRTPPort
-		client.audioRTPPort = audioRTPPort
-		client.lastActivity = time.Now()
-
-		// Create new connections if needed
-		if videoRTPPort > 0 && client.videoConn == nil {
-			videoAddr, _ := net.ResolveUDPAddr("udp", fmt.Sprintf("localhost:%d", videoRTPPort))
-			videoConn, _ := net.DialUDP("udp", nil, videoAddr)
-			client.videoAddr = videoAddr
-			client.videoConn = videoConn
-		}
+	client, exists := rf.clients[sessionID]
+	if !exists {
+		client = newRTPClient(sessionID, TransportUDP)
+	}
 
-		if audioRTPPort > 0 && client.audioConn == nil {
-			audioAddr, _ := net.ResolveUDPAddr("udp", fmt.Sprintf("localhost:%d", audioRTPPort))
-			audioConn, _ := net.DialUDP("udp", nil, audioAddr)
-			client.audioAddr = audioAddr
-			client.audioConn = audioConn
-		}
+	if client.transportMode != TransportUDP {
+		return 0, 0, fmt.Errorf("client %s is not using UDP transport", sessionID)
+	}
 
-		return nil
+	if rtcpPort == 0 {
+		rtcpPort = rtpPort + 1
 	}
 
-	client := &RTPClient{
-		sessionID:     sessionID,
-		transportMode: TransportUDP,
-		videoRTPPort:  videoRTPPort,
-		audioRTPPort:  audioRTPPort,
-		lastActivity:  time.Now(),
+	rtpAddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(host, strconv.Itoa(rtpPort)))
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to resolve %s UDP address: %v", kind, err)
 	}
 
-	// Create video connection if port provided
-	if videoRTPPort > 0 {
-		videoAddr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("localhost:%d", videoRTPPort))
-		if err != nil {
-			return fmt.Errorf("failed to resolve video UDP address: %v", err)
-		}
+	rtcpAddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(host, strconv.Itoa(rtcpPort)))
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to resolve %s RTCP address: %v", kind, err)
+	}
 
-		videoConn, err := net.DialUDP("udp", nil, videoAddr)
-		if err != nil {
-			return fmt.Errorf("failed to create video UDP connection: %v", err)
-		}
+	track := client.videoUDP
+	if kind == utils.KindAudio {
+		track = client.audioUDP
+	}
 
-		client.videoAddr = videoAddr
-		client.videoConn = videoConn
+	// Repeated SETUP for the same track only updates the destination
+	if track != nil {
+		track.mutex.Lock()
+		track.rtpAddr, track.rtcpAddr = rtpAddr, rtcpAddr
+		track.rtpLatched, track.rtcpLatched = false, false
+		track.mutex.Unlock()
+
+		client.touch()
+		return track.serverRTPPort, track.serverRTCPPort, nil
 	}
 
-	// Create audio connection if port provided
-	if audioRTPPort > 0 {
-		audioAddr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("localhost:%d", audioRTPPort))
-		if err != nil {
-			if client.videoConn != nil {
-				client.videoConn.Close()
-			}
-			return fmt.Errorf("failed to resolve audio UDP address: %v", err)
-		}
+	// Allocate consecutive server ports for RTP/RTCP
+	portPair, err := utils.DefaultPortAllocator.GetConsecutiveUDPPorts(nil, 10)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to allocate UDP ports for %s: %v", kind, err)
+	}
 
-		audioConn, err := net.DialUDP("udp", nil, audioAddr)
-		if err != nil {
-			if client.videoConn != nil {
-				client.videoConn.Close()
-			}
-			return fmt.Errorf("failed to create audio UDP connection: %v", err)
-		}
+	track = &udpTrack{
+		rtpConn:        portPair.RTPListener,
+		rtcpConn:       portPair.RTCPListener,
+		rtpAddr:        rtpAddr,
+		rtcpAddr:       rtcpAddr,
+		serverRTPPort:  portPair.RTPPort,
+		serverRTCPPort: portPair.RTCPPort,
+	}
 
-		client.audioAddr = audioAddr
-		client.audioConn = audioConn
+	if kind == utils.KindAudio {
+		client.audioUDP = track
+	} else {
+		client.videoUDP = track
 	}
 
 	rf.clients[sessionID] = client
+	rf.startRTCP()
 
-	core.Logger.Trace().Msgf("Added UDP RTP client %s (video port:%d, audio port:%d)",
-		sessionID, videoRTPPort, audioRTPPort)
-	return nil
+	go rf.handleUDPTrackRTP(sessionID, kind, track)
+	go rf.handleUDPTrackRTCP(sessionID, kind, track)
+
+	core.Logger.Trace().Msgf("Added UDP %s track for RTP client %s (client:%s, server ports:%d-%d)",
+		kind, sessionID, rtpAddr, portPair.RTPPort, portPair.RTCPPort)
+
+	return portPair.RTPPort, portPair.RTCPPort, nil
 }
 
 func (rf *RTPForwarder) SetupUDPBackchannel(sessionID string, clientPort int) (int, error) {
@@ -165,7 +254,8 @@ func (rf *RTPForwarder) SetupUDPBackchannel(sessionID string, clientPort int) (i
 
 	client, exists := rf.clients[sessionID]
 	if !exists {
-		return 0, fmt.Errorf("client %s not found", sessionID)
+		client = newRTPClient(sessionID, TransportUDP)
+		rf.clients[sessionID] = client
 	}
 
 	if client.transportMode != TransportUDP {
@@ -202,35 +292,30 @@ func (rf *RTPForwarder) SetupUDPBackchannel(sessionID string, clientPort int) (i
 	return portPair.RTPPort, nil
 }
 
-func (rf *RTPForwarder) AddTCPClient(sessionID string, conn net.Conn, videoRTPChannel, audioRTPChannel, backAudioRTPChannel byte) error {
+// AddTCPClient adds or updates a client with the interleaved channels of the
+// tracks it set up, nil for tracks without SETUP
+func (rf *RTPForwarder) AddTCPClient(sessionID string, conn net.Conn, video, audio *interleavedChannels) error {
 	rf.mutex.Lock()
 	defer rf.mutex.Unlock()
 
 	// Check if client already exists, update it
 	if existingClient, exists := rf.clients[sessionID]; exists {
-		core.Logger.Trace().Msgf("TCP client %s already exists, updating channels (video:%d->%d, audio:%d->%d, back:%d->%d)",
-			sessionID, existingClient.videoRTPChannel, videoRTPChannel, existingClient.audioRTPChannel, audioRTPChannel, existingClient.backAudioRTPChannel, backAudioRTPChannel)
-		existingClient.videoRTPChannel = videoRTPChannel
-		existingClient.audioRTPChannel = audioRTPChannel
-		existingClient.backAudioRTPChannel = backAudioRTPChannel
-		existingClient.lastActivity = time.Now()
+		core.Logger.Trace().Msgf("TCP client %s already exists, updating channels (video:%s, audio:%s)", sessionID, video, audio)
+		existingClient.videoChannels = video
+		existingClient.audioChannels = audio
+		existingClient.touch()
 		return nil
 	}
 
-	client := &RTPClient{
-		sessionID:           sessionID,
-		transportMode:       TransportTCP,
-		tcpConn:             conn,
-		videoRTPChannel:     videoRTPChannel,
-		audioRTPChannel:     audioRTPChannel,
-		backAudioRTPChannel: backAudioRTPChannel,
-		lastActivity:        time.Now(),
-	}
+	client := newRTPClient(sessionID, TransportTCP)
+	client.tcpConn = conn
+	client.videoChannels = video
+	client.audioChannels = audio
 
 	rf.clients[sessionID] = client
+	rf.startRTCP()
 
-	core.Logger.Trace().Msgf("Added TCP RTP client %s (video channel:%d, audio channel:%d, back audio channel:%d)",
-		sessionID, videoRTPChannel, audioRTPChannel, backAudioRTPChannel)
+	core.Logger.Trace().Msgf("Added TCP RTP client %s (video:%s, audio:%s)", sessionID, video, audio)
 	return nil
 }
 
@@ -239,20 +324,7 @@ func (rf *RTPForwarder) RemoveClient(sessionID string) {
 	defer rf.mutex.Unlock()
 
 	if client, exists := rf.clients[sessionID]; exists {
-		if client.transportMode == TransportUDP {
-			if client.videoConn != nil {
-				client.videoConn.Close()
-			}
-			if client.audioConn != nil {
-				client.audioConn.Close()
-			}
-			if client.backchannelListener != nil {
-				client.backchannelListener.Close()
-			}
-			if client.backchannelRTCPListener != nil {
-				client.backchannelRTCPListener.Close()
-			}
-		}
+		client.close()
 
 		delete(rf.clients, sessionID)
 		core.Logger.Trace().Msgf("Removed RTP client %s", sessionID)
@@ -263,41 +335,25 @@ func (rf *RTPForwarder) ForwardVideoPacket(packet *rtp.Packet) {
 	rf.mutex.RLock()
 	defer rf.mutex.RUnlock()
 
-	if len(rf.clients) == 0 {
-		return
-	}
-
 	// Serialize packet
-	data, err := packet.Marshal()
+	p, err := marshalPacket(packet, true, rf.epoch)
 	if err != nil {
 		core.Logger.Error().Err(err).Msg("Error marshaling video RTP packet")
 		return
 	}
 
+	rf.gop.addVideo(packet, p)
+
+	for _, viewer := range rf.viewers {
+		viewer.WriteRTP(packet, true)
+	}
+
 	// Forward to all clients
 	for sessionID, client := range rf.clients {
-		client.lastActivity = time.Now()
-
-		if client.transportMode == TransportUDP {
-			if client.videoConn != nil {
-				if _, err := client.videoConn.Write(data); err != nil {
-					core.Logger.Error().Err(err).Msgf("Error forwarding video packet to UDP client %s", sessionID)
-				} else if rf.firstVideoPacket {
-					rf.firstVideoPacket = false
-					core.Logger.Trace().Msgf("Successfully sent first video packet to UDP client %s on port %d",
-						sessionID, client.videoRTPPort)
-				}
-			}
-		} else if client.transportMode == TransportTCP {
-			if client.tcpConn != nil {
-				if err := rf.sendInterleavedRTP(client.tcpConn, client.videoRTPChannel, data); err != nil {
-					core.Logger.Error().Err(err).Msgf("Error forwarding video packet to TCP client %s", sessionID)
-				} else if rf.firstVideoPacket {
-					rf.firstVideoPacket = false
-					core.Logger.Trace().Msgf("Successfully sent first video packet to TCP client %s on channel %d",
-						sessionID, client.videoRTPChannel)
-				}
-			}
+		client.touch()
+
+		if client.playing {
+			rf.sendToClient(sessionID, client, p)
 		}
 	}
 }
@@ -306,59 +362,178 @@ func (rf *RTPForwarder) ForwardAudioPacket(packet *rtp.Packet) {
 	rf.mutex.RLock()
 	defer rf.mutex.RUnlock()
 
-	if len(rf.clients) == 0 {
-		return
-	}
-
 	// Serialize packet
-	data, err := packet.Marshal()
+	p, err := marshalPacket(packet, false, rf.epoch)
 	if err != nil {
 		core.Logger.Error().Err(err).Msg("Error marshaling audio RTP packet")
 		return
 	}
 
+	rf.gop.addAudio(p)
+
+	for _, viewer := range rf.viewers {
+		viewer.WriteRTP(packet, false)
+	}
+
 	// Forward to all clients
 	for sessionID, client := range rf.clients {
-		client.lastActivity = time.Now()
-
-		if client.transportMode == TransportUDP {
-			if client.audioConn != nil {
-				if _, err := client.audioConn.Write(data); err != nil {
-					core.Logger.Error().Err(err).Msgf("Error forwarding audio packet to UDP client %s", sessionID)
-				} else if rf.firstAudioPacket {
-					rf.firstAudioPacket = false
-					core.Logger.Trace().Msgf("Successfully sent first audio packet to UDP client %s on port %d",
-						sessionID, client.audioRTPPort)
-				}
-			}
-		} else if client.transportMode == TransportTCP {
-			if client.tcpConn != nil {
-				if err := rf.sendInterleavedRTP(client.tcpConn, client.audioRTPChannel, data); err != nil {
-					core.Logger.Error().Err(err).Msgf("Error forwarding audio packet to TCP client %s", sessionID)
-				} else if rf.firstAudioPacket {
-					rf.firstAudioPacket = false
-					core.Logger.Trace().Msgf("Successfully sent first audio packet to TCP client %s on channel %d",
-						sessionID, client.audioRTPChannel)
-				}
-			}
+		client.touch()
+
+		if client.playing {
+			rf.sendToClient(sessionID, client, p)
 		}
 	}
 }
 
+// StartPlayback starts forwarding to a client once it sent PLAY, beginning with
+// the cached GOP so it can decode right away
+func (rf *RTPForwarder) StartPlayback(sessionID string) {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+
+	client, exists := rf.clients[sessionID]
+	if !exists || client.playing {
+		return
+	}
+
+	// Holding the write lock keeps live packets out until the replay is done
+	cached := rf.gop.snapshot()
+	for _, p := range cached {
+		rf.sendToClient(sessionID, client, p)
+	}
+
+	client.playing = true
+
+	core.Logger.Trace().Msgf("Started playback for RTP client %s with %d cached packets", sessionID, len(cached))
+}
+
+func (rf *RTPForwarder) IsPlaying(sessionID string) bool {
+	rf.mutex.RLock()
+	defer rf.mutex.RUnlock()
+
+	client, exists := rf.clients[sessionID]
+	return exists && client.playing
+}
+
+// ClientCounters returns the RTP packets and bytes sent to a client
+func (rf *RTPForwarder) ClientCounters(sessionID string) (packets, bytes uint64) {
+	rf.mutex.RLock()
+	defer rf.mutex.RUnlock()
+
+	if client, exists := rf.clients[sessionID]; exists {
+		return client.packetsSent.Load(), client.bytesSent.Load()
+	}
+
+	return 0, 0
+}
+
+// sendToClient rewrites the packet header for the client and sends it on the
+// client's transport, p.data is modified
+func (rf *RTPForwarder) sendToClient(sessionID string, client *RTPClient, p *marshaledPacket) {
+	kind := utils.KindVideo
+	rewriter, stats, track, channels := &client.videoRewriter, client.videoRTCP, client.videoUDP, client.videoChannels
+	if !p.video {
+		kind = utils.KindAudio
+		rewriter, stats, track, channels = &client.audioRewriter, client.audioRTCP, client.audioUDP, client.audioChannels
+	}
+
+	// Tracks the client didn't set up get nothing, not even sender reports
+	switch client.transportMode {
+	case TransportUDP:
+		if track == nil {
+			return
+		}
+	case TransportTCP:
+		if client.tcpConn == nil || channels == nil {
+			return
+		}
+	}
+
+	ssrc, _, timestamp := rewriter.rewrite(p)
+	stats.onSent(ssrc, timestamp, p.payloadLen)
+
+	var target string
+	var err error
+
+	if client.transportMode == TransportUDP {
+		var addr *net.UDPAddr
+		addr, err = track.writeRTP(p.data)
+		target = fmt.Sprintf("UDP client %s at %s", sessionID, addr)
+	} else {
+		err = rf.sendInterleavedRTP(client.tcpConn, channels.rtp, p.data)
+		target = fmt.Sprintf("TCP client %s on channel %d", sessionID, channels.rtp)
+	}
+
+	if err != nil {
+		core.Logger.Error().Err(err).Msgf("Error forwarding %s packet to client %s", kind, sessionID)
+		forwarderWriteErrors.Inc(kind, client.transportMode.String())
+		return
+	}
+
+	client.packetsSent.Add(1)
+	client.bytesSent.Add(uint64(len(p.data)))
+	forwardedPackets.Inc(kind)
+	forwardedBytes.Add(float64(len(p.data)), kind)
+
+	first := &rf.firstVideoPacket
+	if !p.video {
+		first = &rf.firstAudioPacket
+	}
+
+	if first.CompareAndSwap(true, false) {
+		core.Logger.Trace().Msgf("Successfully sent first %s packet to %s", kind, target)
+	}
+}
+
+// SetGOPCacheSize sets the memory cap of the GOP cache in bytes, 0 disables it
+func (rf *RTPForwarder) SetGOPCacheSize(maxBytes int) {
+	rf.gop.mutex.Lock()
+	rf.gop.maxBytes = maxBytes
+	rf.gop.reset()
+	rf.gop.mutex.Unlock()
+}
+
+// Discontinuity marks the start of a new upstream connection. Clients keep
+// their sequence and the cached GOP of the old connection is dropped.
+func (rf *RTPForwarder) Discontinuity() {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+
+	rf.epoch++
+	rf.gop.clear()
+}
+
+// SetHEVC tells the forwarder the video codec, needed to find keyframes
+func (rf *RTPForwarder) SetHEVC(hevc bool) {
+	rf.gop.setHEVC(hevc)
+}
+
 func (rf *RTPForwarder) Stop() {
 	// Reset SSRCs
 	rf.videoSSRC = 0
 	rf.audioSSRC = 1
 
 	// Reset first packet flags
-	rf.firstVideoPacket = true
-	rf.firstAudioPacket = true
+	rf.firstVideoPacket.Store(true)
+	rf.firstAudioPacket.Store(true)
+
+	rf.gop.clear()
+
+	// Stop RTCP sender reports
+	rf.mutex.Lock()
+	if rf.rtcpDone != nil {
+		close(rf.rtcpDone)
+		rf.rtcpDone = nil
+	}
+	rf.mutex.Unlock()
 
 	// Clear all clients
 	for sessionID := range rf.clients {
 		rf.RemoveClient(sessionID)
 	}
 
+	rf.closeViewers()
+
 	core.Logger.Trace().Msg("RTPForwarder stopped and all clients cleared")
 }
 
@@ -376,33 +551,80 @@ func (rf *RTPForwarder) CleanupInactiveClients(timeout time.Duration) {
 	var toRemove []string
 
 	for sessionID, client := range rf.clients {
-		if now.Sub(client.lastActivity) > timeout {
+		if client.idle(now) > timeout {
 			toRemove = append(toRemove, sessionID)
 		}
 	}
 
 	for _, sessionID := range toRemove {
 		if client, exists := rf.clients[sessionID]; exists {
-			if client.transportMode == TransportUDP {
-				if client.videoConn != nil {
-					client.videoConn.Close()
-				}
-				if client.audioConn != nil {
-					client.audioConn.Close()
-				}
-				if client.backchannelListener != nil {
-					client.backchannelListener.Close()
-				}
-				if client.backchannelRTCPListener != nil {
-					client.backchannelRTCPListener.Close()
-				}
-			}
+			client.close()
 			delete(rf.clients, sessionID)
 			core.Logger.Trace().Msgf("Cleaned up inactive RTP client %s", sessionID)
 		}
 	}
 }
 
+// handleUDPTrackRTP latches the RTP destination to the source of packets the
+// client sends to our server port (e.g. FFmpeg sends them to open NAT bindings)
+func (rf *RTPForwarder) handleUDPTrackRTP(sessionID, kind string, track *udpTrack) {
+	buffer := make([]byte, 1500)
+
+	for {
+		_, addr, err := track.rtpConn.ReadFromUDP(buffer)
+		if err != nil {
+			break
+		}
+
+		track.mutex.Lock()
+		if !track.rtpLatched {
+			track.rtpLatched = true
+			if !udpAddrEqual(track.rtpAddr, addr) {
+				core.Logger.Trace().Msgf("Latched %s RTP of client %s from %s to %s", kind, sessionID, track.rtpAddr, addr)
+				track.rtpAddr = addr
+			}
+		}
+		track.mutex.Unlock()
+	}
+}
+
+// handleUDPTrackRTCP latches the RTCP destination to the source of incoming
+// RTCP and processes the client's reports. Until RTP itself is latched the
+// RTP destination follows that address.
+func (rf *RTPForwarder) handleUDPTrackRTCP(sessionID, kind string, track *udpTrack) {
+	buffer := make([]byte, 1500)
+
+	for {
+		n, addr, err := track.rtcpConn.ReadFromUDP(buffer)
+		if err != nil {
+			break
+		}
+
+		track.mutex.Lock()
+		if !track.rtcpLatched {
+			track.rtcpLatched = true
+
+			if !udpAddrEqual(track.rtcpAddr, addr) {
+				core.Logger.Trace().Msgf("Latched %s RTCP of client %s from %s to %s", kind, sessionID, track.rtcpAddr, addr)
+
+				if !track.rtpLatched {
+					rtpAddr := &net.UDPAddr{IP: addr.IP, Port: track.rtpAddr.Port, Zone: addr.Zone}
+					// A remapped RTCP port means a NAT in between, which usually keeps the RTP/RTCP pair
+					if addr.Port != track.rtcpAddr.Port {
+						rtpAddr.Port = addr.Port - 1
+					}
+					track.rtpAddr = rtpAddr
+				}
+
+				track.rtcpAddr = addr
+			}
+		}
+		track.mutex.Unlock()
+
+		rf.handleRTCP(sessionID, buffer[:n])
+	}
+}
+
 func (rf *RTPForwarder) handleUDPBackchannelRTP(sessionID string, listener *net.UDPConn) {
 	defer listener.Close()
 
@@ -446,6 +668,52 @@ func (rf *RTPForwarder) handleUDPBackchannelRTCP(listener *net.UDPConn) {
 	}
 }
 
+func (t *udpTrack) writeRTP(data []byte) (*net.UDPAddr, error) {
+	t.mutex.RLock()
+	addr := t.rtpAddr
+	t.mutex.RUnlock()
+
+	_, err := t.rtpConn.WriteToUDP(data, addr)
+	return addr, err
+}
+
+func (t *udpTrack) writeRTCP(data []byte) error {
+	t.mutex.RLock()
+	addr := t.rtcpAddr
+	t.mutex.RUnlock()
+
+	_, err := t.rtcpConn.WriteToUDP(data, addr)
+	return err
+}
+
+func (t *udpTrack) close() {
+	t.rtpConn.Close()
+	t.rtcpConn.Close()
+}
+
+func (c *RTPClient) close() {
+	if c.transportMode != TransportUDP {
+		return
+	}
+
+	if c.videoUDP != nil {
+		c.videoUDP.close()
+	}
+	if c.audioUDP != nil {
+		c.audioUDP.close()
+	}
+	if c.backchannelListener != nil {
+		c.backchannelListener.Close()
+	}
+	if c.backchannelRTCPListener != nil {
+		c.backchannelRTCPListener.Close()
+	}
+}
+
+func udpAddrEqual(a, b *net.UDPAddr) bool {
+	return a != nil && b != nil && a.IP.Equal(b.IP) && a.Port == b.Port
+}
+
 func (rf *RTPForwarder) sendInterleavedRTP(conn net.Conn, channel byte, rtpData []byte) error {
 	// Interleaved format: $ + channel + length(2 bytes) + RTP data
 	header := make([]byte, 4)
@@ -0,0 +1,110 @@
+package rtsp
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/pion/rtp"
+)
+
+// readChannels returns the interleaved channels of the frames written to conn
+// until it is closed
+func readChannels(conn net.Conn) <-chan []byte {
+	done := make(chan []byte, 1)
+
+	go func() {
+		var channels []byte
+		header := make([]byte, 4)
+		for {
+			if _, err := io.ReadFull(conn, header); err != nil {
+				done <- channels
+				return
+			}
+			if _, err := io.CopyN(io.Discard, conn, int64(binary.BigEndian.Uint16(header[2:]))); err != nil {
+				done <- channels
+				return
+			}
+			channels = append(channels, header[1])
+		}
+	}()
+
+	return done
+}
+
+func TestForwarderSendsOnlySetupTracks(t *testing.T) {
+	tests := []struct {
+		name    string
+		video   *interleavedChannels
+		audio   *interleavedChannels
+		want    []byte // Channels of video RTP, audio RTP, video SR, audio SR
+		packets uint64
+	}{
+		{"video only", &interleavedChannels{0, 1}, nil, []byte{0, 1}, 1},
+		{"audio only", nil, &interleavedChannels{0, 1}, []byte{0, 1}, 1},
+		{"video and audio", &interleavedChannels{0, 1}, &interleavedChannels{2, 3}, []byte{0, 2, 1, 3}, 2},
+		{"nothing set up", nil, nil, nil, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conn, serverConn := net.Pipe()
+			channels := readChannels(conn)
+
+			rf := NewRTPForwarder()
+			if err := rf.AddTCPClient("session", serverConn, test.video, test.audio); err != nil {
+				t.Fatal(err)
+			}
+			rf.StartPlayback("session")
+
+			rf.ForwardVideoPacket(&rtp.Packet{
+				Header:  rtp.Header{Version: 2, SequenceNumber: 1, SSRC: 1234},
+				Payload: idrPayload,
+			})
+			rf.ForwardAudioPacket(&rtp.Packet{
+				Header:  rtp.Header{Version: 2, SequenceNumber: 1, SSRC: 5678},
+				Payload: audioPayload,
+			})
+			rf.sendSenderReports()
+
+			packets, _ := rf.ClientCounters("session")
+			serverConn.Close()
+
+			got := <-channels
+			if string(got) != string(test.want) {
+				t.Errorf("got channels %v, want %v", got, test.want)
+			}
+			if packets != test.packets {
+				t.Errorf("got %d packets sent, want %d", packets, test.packets)
+			}
+		})
+	}
+}
+
+func TestInterleavedChannels(t *testing.T) {
+	var none *interleavedChannels
+	channels := &interleavedChannels{rtp: 2, rtcp: 3}
+
+	tests := []struct {
+		channels *interleavedChannels
+		channel  byte
+		rtp      bool
+		rtcp     bool
+	}{
+		{none, 0, false, false},
+		{none, 1, false, false},
+		{channels, 2, true, false},
+		{channels, 3, false, true},
+		{channels, 0, false, false},
+	}
+
+	for _, test := range tests {
+		if got := test.channels.hasRTP(test.channel); got != test.rtp {
+			t.Errorf("%s hasRTP(%d) = %v, want %v", test.channels, test.channel, got, test.rtp)
+		}
+		if got := test.channels.hasRTCP(test.channel); got != test.rtcp {
+			t.Errorf("%s hasRTCP(%d) = %v, want %v", test.channels, test.channel, got, test.rtcp)
+		}
+	}
+}
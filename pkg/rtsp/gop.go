@@ -0,0 +1,198 @@
+package rtsp
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+	"tuya-ipc-terminal/pkg/h264"
+	"tuya-ipc-terminal/pkg/h265"
+
+	"github.com/pion/rtp"
+)
+
+const DefaultGOPCacheSize = 4 << 20
+
+// marshaledPacket is a packet serialized once for all clients. The header in
+// data is rewritten per client on send, the fields keep the camera's values.
+type marshaledPacket struct {
+	video      bool
+	epoch      uint32 // Upstream connection the packet came from
+	ssrc       uint32
+	sequence   uint16
+	timestamp  uint32
+	payloadLen int
+	data       []byte
+}
+
+func marshalPacket(packet *rtp.Packet, video bool, epoch uint32) (*marshaledPacket, error) {
+	data, err := packet.Marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	return &marshaledPacket{
+		video:      video,
+		epoch:      epoch,
+		ssrc:       packet.SSRC,
+		sequence:   packet.SequenceNumber,
+		timestamp:  packet.Timestamp,
+		payloadLen: len(packet.Payload),
+		data:       data,
+	}, nil
+}
+
+// clone returns a copy with its own data to rewrite
+func (p *marshaledPacket) clone() *marshaledPacket {
+	c := *p
+	c.data = append([]byte(nil), p.data...)
+	return &c
+}
+
+// gopCache keeps the packets since the last keyframe (parameter sets included)
+// so new clients can start decoding immediately instead of waiting for the
+// next IDR. A GOP over maxBytes is dropped until the next keyframe.
+type gopCache struct {
+	maxBytes int
+	hevc     bool
+
+	packets  []*marshaledPacket
+	size     int
+	keyTS    uint32
+	started  bool
+	overflow bool
+	mutex    sync.Mutex
+}
+
+func newGOPCache(maxBytes int) *gopCache {
+	return &gopCache{maxBytes: maxBytes}
+}
+
+func (g *gopCache) setHEVC(hevc bool) {
+	g.mutex.Lock()
+	g.hevc = hevc
+	g.reset()
+	g.mutex.Unlock()
+}
+
+func (g *gopCache) addVideo(packet *rtp.Packet, p *marshaledPacket) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.maxBytes <= 0 {
+		return
+	}
+
+	var keyframe bool
+	if g.hevc {
+		keyframe = h265.IsKeyframe(packet.Payload)
+	} else {
+		keyframe = h264.IsKeyframe(packet.Payload)
+	}
+
+	// Parameter sets and IDR of one keyframe share the timestamp
+	if keyframe && (!g.started || packet.Timestamp != g.keyTS) {
+		g.reset()
+		g.started = true
+		g.keyTS = packet.Timestamp
+	}
+
+	g.add(p)
+}
+
+func (g *gopCache) addAudio(p *marshaledPacket) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.add(p)
+}
+
+// add must be called with the mutex held
+func (g *gopCache) add(p *marshaledPacket) {
+	if !g.started || g.overflow {
+		return
+	}
+
+	if g.size+len(p.data) > g.maxBytes {
+		g.reset()
+		g.overflow = true
+		return
+	}
+
+	g.packets = append(g.packets, p)
+	g.size += len(p.data)
+}
+
+// reset must be called with the mutex held
+func (g *gopCache) reset() {
+	g.packets = nil
+	g.size = 0
+	g.started = false
+	g.overflow = false
+}
+
+func (g *gopCache) clear() {
+	g.mutex.Lock()
+	g.reset()
+	g.mutex.Unlock()
+}
+
+// snapshot returns copies of the cached packets
+func (g *gopCache) snapshot() []*marshaledPacket {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	packets := make([]*marshaledPacket, len(g.packets))
+	for i, p := range g.packets {
+		packets[i] = p.clone()
+	}
+
+	return packets
+}
+
+// rtpRewriter maps the camera's SSRC, sequence numbers and timestamps of one
+// track onto a client sequence starting at seq 1 and timestamp 0, as announced
+// in RTP-Info, so replayed and live packets form one continuous stream. When the
+// upstream reconnects the new source continues right after the old one.
+type rtpRewriter struct {
+	clockRate uint32
+
+	started   bool
+	epoch     uint32
+	ssrc      uint32 // Sent to the client, the SSRC of the first source
+	seqOffset uint16
+	tsOffset  uint32
+
+	lastSeq  uint16
+	lastTS   uint32
+	lastSent time.Time
+}
+
+// rewrite patches the header of p.data for the client, the original values
+// are taken from the fields as data may already hold another client's values
+func (r *rtpRewriter) rewrite(p *marshaledPacket) (uint32, uint16, uint32) {
+	switch {
+	case !r.started:
+		r.started = true
+		r.epoch = p.epoch
+		r.ssrc = p.ssrc
+		r.seqOffset = 1 - p.sequence
+		r.tsOffset = -p.timestamp
+	case p.epoch != r.epoch:
+		// New upstream connection, advance the timestamp by the time without packets
+		elapsed := uint32(time.Since(r.lastSent).Seconds() * float64(r.clockRate))
+		r.epoch = p.epoch
+		r.seqOffset = r.lastSeq + 1 - p.sequence
+		r.tsOffset = r.lastTS + max(elapsed, 1) - p.timestamp
+	}
+
+	sequence := p.sequence + r.seqOffset
+	timestamp := p.timestamp + r.tsOffset
+
+	binary.BigEndian.PutUint16(p.data[2:4], sequence)
+	binary.BigEndian.PutUint32(p.data[4:8], timestamp)
+	binary.BigEndian.PutUint32(p.data[8:12], r.ssrc)
+
+	r.lastSeq, r.lastTS, r.lastSent = sequence, timestamp, time.Now()
+
+	return r.ssrc, sequence, timestamp
+}
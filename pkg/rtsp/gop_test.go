@@ -0,0 +1,284 @@
+package rtsp
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/pion/rtp"
+)
+
+var (
+	spsPayload   = []byte{0x67, 0x42, 0x00, 0x1f}
+	idrPayload   = []byte{0x65, 0x88, 0x84}
+	slicePayload = []byte{0x41, 0x9a, 0x02}
+	audioPayload = []byte{0xff, 0xff, 0xff, 0xff}
+)
+
+type testPacket struct {
+	video     bool
+	payload   []byte
+	sequence  uint16
+	timestamp uint32
+}
+
+func newTestPacket(t *testing.T, tp testPacket, ssrc, epoch uint32) (*rtp.Packet, *marshaledPacket) {
+	t.Helper()
+
+	packet := &rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			SequenceNumber: tp.sequence,
+			Timestamp:      tp.timestamp,
+			SSRC:           ssrc,
+		},
+		Payload: tp.payload,
+	}
+
+	p, err := marshalPacket(packet, tp.video, epoch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packet, p
+}
+
+func TestGOPCache(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxBytes int
+		packets  []testPacket
+		want     []uint16 // Cached sequence numbers
+	}{
+		{
+			name:     "nothing before the first keyframe",
+			maxBytes: 1 << 20,
+			packets: []testPacket{
+				{true, slicePayload, 1, 0},
+				{false, audioPayload, 2, 0},
+			},
+			want: nil,
+		},
+		{
+			name:     "parameter sets and IDR start one GOP",
+			maxBytes: 1 << 20,
+			packets: []testPacket{
+				{true, slicePayload, 1, 0},
+				{true, spsPayload, 2, 3000},
+				{true, idrPayload, 3, 3000},
+				{false, audioPayload, 4, 160},
+				{true, slicePayload, 5, 6000},
+			},
+			want: []uint16{2, 3, 4, 5},
+		},
+		{
+			name:     "next keyframe replaces the GOP",
+			maxBytes: 1 << 20,
+			packets: []testPacket{
+				{true, idrPayload, 1, 0},
+				{true, slicePayload, 2, 3000},
+				{true, spsPayload, 3, 6000},
+				{true, idrPayload, 4, 6000},
+				{true, slicePayload, 5, 9000},
+			},
+			want: []uint16{3, 4, 5},
+		},
+		{
+			name:     "sequence wraparound",
+			maxBytes: 1 << 20,
+			packets: []testPacket{
+				{true, idrPayload, 65534, 4294964296},
+				{true, slicePayload, 65535, 4294967295},
+				{true, slicePayload, 0, 2999},
+			},
+			want: []uint16{65534, 65535, 0},
+		},
+		{
+			name:     "overflow drops the GOP until the next keyframe",
+			maxBytes: 40,
+			packets: []testPacket{
+				{true, idrPayload, 1, 0},
+				{true, slicePayload, 2, 3000},
+				{true, slicePayload, 3, 6000},
+				{true, slicePayload, 4, 9000},
+				{true, idrPayload, 5, 12000},
+			},
+			want: []uint16{5},
+		},
+		{
+			name:     "disabled",
+			maxBytes: 0,
+			packets: []testPacket{
+				{true, idrPayload, 1, 0},
+				{true, slicePayload, 2, 3000},
+			},
+			want: nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gop := newGOPCache(test.maxBytes)
+			for _, tp := range test.packets {
+				packet, p := newTestPacket(t, tp, 1234, 0)
+				if tp.video {
+					gop.addVideo(packet, p)
+				} else {
+					gop.addAudio(p)
+				}
+			}
+
+			cached := gop.snapshot()
+			if len(cached) != len(test.want) {
+				t.Fatalf("got %d packets, want %d", len(cached), len(test.want))
+			}
+			for i, p := range cached {
+				if p.sequence != test.want[i] {
+					t.Errorf("packet %d has sequence %d, want %d", i, p.sequence, test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRTPRewriter(t *testing.T) {
+	type step struct {
+		ssrc      uint32
+		epoch     uint32
+		sequence  uint16
+		timestamp uint32
+		wantSeq   uint16
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "starts at sequence 1 and timestamp 0",
+			steps: []step{
+				{1234, 0, 1000, 90000, 1},
+				{1234, 0, 1001, 93000, 2},
+			},
+		},
+		{
+			name: "camera sequence and timestamp wraparound",
+			steps: []step{
+				{1234, 0, 65534, 4294964296, 1},
+				{1234, 0, 65535, 4294967295, 2},
+				{1234, 0, 0, 2999, 3},
+				{1234, 0, 1, 5999, 4},
+			},
+		},
+		{
+			name: "client sequence wraparound",
+			steps: []step{
+				{1234, 0, 100, 0, 1},
+				{1234, 0, 99, 0, 0},
+				{1234, 0, 98, 0, 65535},
+			},
+		},
+		{
+			name: "reconnects keep the SSRC and continue the sequence",
+			steps: []step{
+				{1234, 0, 500, 1000, 1},
+				{1234, 0, 501, 4000, 2},
+				{5678, 1, 65000, 7, 3},
+				{5678, 1, 65001, 3007, 4},
+				{9999, 2, 10, 4294967000, 5},
+				{9999, 2, 11, 2704, 6},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rewriter := rtpRewriter{clockRate: videoClockRate}
+			firstSSRC := test.steps[0].ssrc
+			var lastTS uint32
+
+			for i, s := range test.steps {
+				_, p := newTestPacket(t, testPacket{true, slicePayload, s.sequence, s.timestamp}, s.ssrc, s.epoch)
+
+				ssrc, sequence, timestamp := rewriter.rewrite(p)
+				if ssrc != firstSSRC {
+					t.Errorf("step %d: SSRC %d, want %d", i, ssrc, firstSSRC)
+				}
+				if sequence != s.wantSeq {
+					t.Errorf("step %d: sequence %d, want %d", i, sequence, s.wantSeq)
+				}
+
+				switch {
+				case i == 0:
+					if timestamp != 0 {
+						t.Errorf("step %d: timestamp %d, want 0", i, timestamp)
+					}
+				case s.epoch == test.steps[i-1].epoch:
+					if want := lastTS + s.timestamp - test.steps[i-1].timestamp; timestamp != want {
+						t.Errorf("step %d: timestamp %d, want %d", i, timestamp, want)
+					}
+				default:
+					// Advanced by the time without packets, at least one tick
+					if elapsed := timestamp - lastTS; elapsed == 0 || elapsed > videoClockRate {
+						t.Errorf("step %d: timestamp %d after %d", i, timestamp, lastTS)
+					}
+				}
+				lastTS = timestamp
+
+				// The header is rewritten too
+				if got := binary.BigEndian.Uint16(p.data[2:4]); got != sequence {
+					t.Errorf("step %d: header sequence %d, want %d", i, got, sequence)
+				}
+				if got := binary.BigEndian.Uint32(p.data[4:8]); got != timestamp {
+					t.Errorf("step %d: header timestamp %d, want %d", i, got, timestamp)
+				}
+				if got := binary.BigEndian.Uint32(p.data[8:12]); got != firstSSRC {
+					t.Errorf("step %d: header SSRC %d, want %d", i, got, firstSSRC)
+				}
+			}
+		})
+	}
+}
+
+// TestGOPReplay checks that the replay of the cached GOP and the following
+// live packets form one stream for every client, whatever client rewrote the
+// shared packets before
+func TestGOPReplay(t *testing.T) {
+	gop := newGOPCache(1 << 20)
+	var live []*marshaledPacket
+
+	packets := []testPacket{
+		{true, spsPayload, 65533, 4294964000},
+		{true, idrPayload, 65534, 4294964000},
+		{true, slicePayload, 65535, 4294967000},
+		{true, slicePayload, 0, 2704},
+	}
+	for _, tp := range packets {
+		packet, p := newTestPacket(t, tp, 1234, 0)
+		gop.addVideo(packet, p)
+		live = append(live, p)
+	}
+
+	// The first client was playing before and rewrote the shared packets
+	first := rtpRewriter{clockRate: videoClockRate}
+	_, early := newTestPacket(t, testPacket{true, slicePayload, 65000, 1000}, 1234, 0)
+	first.rewrite(early)
+	for _, p := range live {
+		first.rewrite(p)
+	}
+
+	for client := 0; client < 2; client++ {
+		rewriter := rtpRewriter{clockRate: videoClockRate}
+		replay := gop.snapshot()
+
+		_, next := newTestPacket(t, testPacket{true, slicePayload, 1, 5704}, 1234, 0)
+		stream := append(replay, next.clone())
+
+		wantTS := []uint32{0, 0, 3000, 6000, 9000}
+		for i, p := range stream {
+			ssrc, sequence, timestamp := rewriter.rewrite(p)
+			if ssrc != 1234 || sequence != uint16(i+1) || timestamp != wantTS[i] {
+				t.Errorf("client %d packet %d: SSRC %d, sequence %d, timestamp %d, want 1234, %d, %d",
+					client, i, ssrc, sequence, timestamp, i+1, wantTS[i])
+			}
+		}
+	}
+}
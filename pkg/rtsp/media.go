@@ -0,0 +1,125 @@
+package rtsp
+
+import (
+	"sync"
+	"time"
+	"tuya-ipc-terminal/pkg/h264"
+	"tuya-ipc-terminal/pkg/h265"
+
+	"github.com/pion/rtp"
+)
+
+// MediaInfo describes the stream as it actually arrives from the camera
+type MediaInfo struct {
+	HEVC             bool
+	VideoPayloadType uint8
+	VPS              []byte // H.265 only
+	SPS              []byte
+	PPS              []byte
+
+	HasAudio         bool
+	AudioPayloadType uint8
+}
+
+// HasParameterSets reports whether all parameter sets of the codec were captured
+func (m *MediaInfo) HasParameterSets() bool {
+	return m.SPS != nil && m.PPS != nil && (!m.HEVC || m.VPS != nil)
+}
+
+// mediaProbe watches the first packets of a bridge until the parameter sets
+// of the first keyframe are known
+type mediaProbe struct {
+	info      MediaInfo
+	seenVideo bool
+	done      bool
+	ready     chan struct{}
+	mutex     sync.Mutex
+}
+
+func newMediaProbe() *mediaProbe {
+	return &mediaProbe{ready: make(chan struct{})}
+}
+
+func (p *mediaProbe) setHEVC(hevc bool) {
+	p.mutex.Lock()
+	p.info.HEVC = hevc
+	p.mutex.Unlock()
+}
+
+func (p *mediaProbe) observeVideo(packet *rtp.Packet) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.done {
+		return
+	}
+
+	p.seenVideo = true
+	p.info.VideoPayloadType = packet.PayloadType
+
+	if p.info.HEVC {
+		for _, nalu := range h265.PayloadNALUs(packet.Payload) {
+			switch h265.NALUType(nalu) {
+			case h265.NALUTypeVPS:
+				p.info.VPS = append([]byte(nil), nalu...)
+			case h265.NALUTypeSPS:
+				p.info.SPS = append([]byte(nil), nalu...)
+			case h265.NALUTypePPS:
+				p.info.PPS = append([]byte(nil), nalu...)
+			}
+		}
+	} else {
+		for _, nalu := range h264.PayloadNALUs(packet.Payload) {
+			switch h264.NALUType(nalu) {
+			case h264.NALUTypeSPS:
+				p.info.SPS = append([]byte(nil), nalu...)
+			case h264.NALUTypePPS:
+				p.info.PPS = append([]byte(nil), nalu...)
+			}
+		}
+	}
+
+	if p.info.HasParameterSets() {
+		p.finish()
+	}
+}
+
+func (p *mediaProbe) observeAudio(packet *rtp.Packet) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if !p.info.HasAudio {
+		p.info.HasAudio = true
+		p.info.AudioPayloadType = packet.PayloadType
+	}
+}
+
+// finish must be called with the mutex held
+func (p *mediaProbe) finish() {
+	if !p.done {
+		p.done = true
+		close(p.ready)
+	}
+}
+
+// wait returns the captured media info or nil if no video arrived in time.
+// When video flows but carries no parameter sets the partial info is returned
+// and later calls don't wait again.
+func (p *mediaProbe) wait(timeout time.Duration) *MediaInfo {
+	select {
+	case <-p.ready:
+	case <-time.After(timeout):
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if !p.seenVideo {
+		return nil
+	}
+
+	p.finish()
+
+	info := p.info
+	return &info
+}
@@ -0,0 +1,24 @@
+package rtsp
+
+import "tuya-ipc-terminal/pkg/metrics"
+
+var (
+	connectionsAccepted = metrics.NewCounter("tuya_ipc_rtsp_connections_accepted_total",
+		"RTSP connections for a known camera")
+	connectionsRejected = metrics.NewCounter("tuya_ipc_rtsp_connections_rejected_total",
+		"RTSP connections and requests refused", "reason")
+	activeClients = metrics.NewGauge("tuya_ipc_rtsp_clients",
+		"RTSP clients attached to a camera stream", "camera")
+
+	bridgeStartDuration = metrics.NewHistogram("tuya_ipc_bridge_start_duration_seconds",
+		"Time from bridge start until the camera sends media", metrics.DefaultBuckets)
+	bridgeStartFailures = metrics.NewCounter("tuya_ipc_bridge_start_failures_total",
+		"Failed bridge starts", "reason")
+
+	forwardedPackets = metrics.NewCounter("tuya_ipc_forwarded_packets_total",
+		"RTP packets sent to clients", "track")
+	forwardedBytes = metrics.NewCounter("tuya_ipc_forwarded_bytes_total",
+		"RTP bytes sent to clients, headers included", "track")
+	forwarderWriteErrors = metrics.NewCounter("tuya_ipc_forwarder_write_errors_total",
+		"Failed RTP writes to clients", "track", "transport")
+)
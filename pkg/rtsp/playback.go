@@ -0,0 +1,201 @@
+package rtsp
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"tuya-ipc-terminal/pkg/core"
+)
+
+// SD-card playback is served at rtsp://host/<camera>[/sd]/playback?start=<time>.
+// Every client gets its own upstream connection in replay mode, PLAY with a
+// Range and PAUSE restart it at the new position.
+
+const playbackSuffix = "/playback"
+
+// Seeks closer than this to the current position are ignored, players send
+// a Range with every PLAY
+const seekTolerance = 3 * time.Second
+
+// playbackState is the position of an SD-card playback, guarded by the
+// stream's mutex
+type playbackState struct {
+	origin time.Time // Requested by the URL, npt ranges are relative to it
+	start  time.Time // Position the current upstream started at
+	since  time.Time // When the current upstream started playing, zero if not
+	paused bool
+}
+
+func (p *playbackState) position() time.Time {
+	if p.since.IsZero() {
+		return p.start
+	}
+	return p.start.Add(time.Since(p.since))
+}
+
+// hold keeps the position while no upstream is playing
+func (p *playbackState) hold() {
+	p.start = p.position()
+	p.since = time.Time{}
+}
+
+// parsePlaybackURL returns the URL path without the playback suffix and the
+// start time, a zero time for live URLs
+func parsePlaybackURL(rtspURL string) (string, time.Time, error) {
+	parsed, err := url.Parse(rtspURL)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	path, ok := strings.CutSuffix(strings.TrimSuffix(parsed.Path, "/"), playbackSuffix)
+	if !ok {
+		return parsed.Path, time.Time{}, nil
+	}
+
+	value := parsed.Query().Get("start")
+	if value == "" {
+		return "", time.Time{}, errors.New("playback needs a start time")
+	}
+
+	start, err := ParsePlaybackTime(value)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return path, start, nil
+}
+
+// ParsePlaybackTime accepts RFC 3339, local time like 2026-10-01T12:00:00 and
+// Unix seconds
+func ParsePlaybackTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04:05"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid playback time: %s", value)
+}
+
+// parseRange returns the start of a Range header ("clock=20261001T120000Z-"
+// or "npt=30-" relative to origin), zero if there is none
+func parseRange(header string, origin time.Time) time.Time {
+	header = strings.TrimSpace(header)
+
+	if value, ok := strings.CutPrefix(header, "clock="); ok {
+		value, _, _ = strings.Cut(value, "-")
+		for _, layout := range []string{"20060102T150405Z", "20060102T150405.999Z"} {
+			if t, err := time.Parse(layout, value); err == nil {
+				return t
+			}
+		}
+		return time.Time{}
+	}
+
+	if value, ok := strings.CutPrefix(header, "npt="); ok {
+		value, _, _ = strings.Cut(value, "-")
+		if value == "now" || value == "" {
+			return time.Time{}
+		}
+		if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+			return origin.Add(time.Duration(seconds * float64(time.Second)))
+		}
+	}
+
+	return time.Time{}
+}
+
+func formatClock(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+// createPlaybackStream starts a replay of the client's camera, it's removed
+// with the client
+func (s *RTSPServer) createPlaybackStream(client *RTSPClient) *CameraStream {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stream := NewCameraStream(client.camera, client.resolution, client.user, s.storageManager, s)
+	stream.streamId = fmt.Sprintf("%s-%s-playback-%s", client.camera.DeviceID, client.resolution, client.session)
+	stream.connecting = true
+	stream.playback = &playbackState{origin: client.playbackStart, start: client.playbackStart}
+	stream.webrtcBridge.replayStart = client.playbackStart
+
+	s.streams[stream.streamId] = stream
+
+	core.Logger.Info().Msgf("Created playback stream for camera %s from %s", client.camera.DeviceName, client.playbackStart.Format(time.RFC3339))
+	return stream
+}
+
+// PlayFrom continues a playback at t, a zero t resumes at the current position
+func (cs *CameraStream) PlayFrom(t time.Time) {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	p := cs.playback
+	if p == nil {
+		return
+	}
+
+	position := p.position()
+	if t.IsZero() {
+		t = position
+	}
+
+	if !p.paused && t.Sub(position).Abs() < seekTolerance {
+		return
+	}
+
+	core.Logger.Info().Msgf("Playback of %s seeks to %s", cs.camera.DeviceName, t.Format(time.RFC3339))
+
+	p.start, p.since, p.paused = t, time.Time{}, false
+	cs.restartUpstream()
+}
+
+// Pause stops the upstream of a playback, PlayFrom continues at the position
+func (cs *CameraStream) Pause() {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	p := cs.playback
+	if p == nil || p.paused {
+		return
+	}
+
+	p.hold()
+	p.paused = true
+	cs.restartUpstream()
+
+	core.Logger.Info().Msgf("Playback of %s paused at %s", cs.camera.DeviceName, p.start.Format(time.RFC3339))
+}
+
+// PlaybackPosition returns the position of a playback, zero for live streams
+func (cs *CameraStream) PlaybackPosition() time.Time {
+	cs.mutex.RLock()
+	defer cs.mutex.RUnlock()
+
+	if cs.playback == nil {
+		return time.Time{}
+	}
+	return cs.playback.position()
+}
+
+// restartUpstream makes the supervisor reconnect, must be called with the mutex held
+func (cs *CameraStream) restartUpstream() {
+	select {
+	case cs.restart <- struct{}{}:
+	default:
+	}
+}
@@ -3,19 +3,22 @@ package rtsp
 import (
 	"bufio"
 	"crypto/rand"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net"
-	"net/url"
 	"regexp"
 	"strconv"
 	"strings"
 	"time"
 	"tuya-ipc-terminal/pkg/core"
+	"tuya-ipc-terminal/pkg/h264"
+	"tuya-ipc-terminal/pkg/h265"
 	"tuya-ipc-terminal/pkg/storage"
 	"tuya-ipc-terminal/pkg/tuya"
+	"tuya-ipc-terminal/pkg/utils"
 
 	"github.com/pion/rtp"
 )
@@ -44,9 +47,11 @@ func sendRTSPResponse(conn net.Conn, statusCode int, status string, headers map[
 	fmt.Fprintf(&response, "Server: TuyaIPCTerminal/1.0\r\n")
 	fmt.Fprintf(&response, "Date: %s\r\n", time.Now().UTC().Format("Mon, 02 Jan 2006 15:04:05 GMT"))
 
-	// Add custom headers
+	// Add custom headers, a header with several values is separated by newlines
 	for key, value := range headers {
-		fmt.Fprintf(&response, "%s: %s\r\n", key, value)
+		for _, line := range strings.Split(value, "\n") {
+			fmt.Fprintf(&response, "%s: %s\r\n", key, line)
+		}
 	}
 
 	// Content headers if body exists
@@ -82,19 +87,15 @@ func sendRTSPResponse(conn net.Conn, statusCode int, status string, headers map[
 	return err
 }
 
-func extractCameraPath(rtspURL string) (string, string) {
-	parsed, err := url.Parse(rtspURL)
-	if err != nil {
-		return "", ""
-	}
-
+// ParseCameraPath splits a path like /MyCamera/sd into the camera path and
+// the resolution, empty if not given for the camera's default
+func ParseCameraPath(path string) (string, string) {
 	// Return path (e.g., "/MyCamera")
-	path := parsed.Path
 	if path == "" || path == "/" {
 		return "", ""
 	}
 
-	streamResolution := "hd" // Default to HD
+	streamResolution := ""
 
 	// check if ends with "/hd" or "/sd"
 	if strings.HasSuffix(path, "/hd") {
@@ -108,6 +109,17 @@ func extractCameraPath(rtspURL string) (string, string) {
 	return path, streamResolution
 }
 
+// cameraResolution returns the requested resolution or the camera's default
+func cameraResolution(camera *storage.CameraInfo, resolution string) string {
+	if resolution != "" {
+		return resolution
+	}
+	if camera.Resolution != "" {
+		return camera.Resolution
+	}
+	return "hd"
+}
+
 func generateSessionID() string {
 	bytes := make([]byte, 8)
 	rand.Read(bytes)
@@ -125,7 +137,7 @@ func (s *RTSPServer) handleRTSPProtocol(client *RTSPClient) {
 		// Check for interleaved RTP (backchannel)
 		firstByte, err := client.reader.Peek(1)
 		if err != nil {
-			if client.stream.active && !strings.Contains(err.Error(), "connection reset by peer") {
+			if client.stream != nil && client.stream.isActive() && !strings.Contains(err.Error(), "connection reset by peer") {
 				core.Logger.Error().Err(err).Msg("Error peeking connection")
 			}
 			break
@@ -143,7 +155,7 @@ func (s *RTSPServer) handleRTSPProtocol(client *RTSPClient) {
 		// Handle regular RTSP request
 		request, err := s.parseRTSPRequestFromReader(client.reader)
 		if err != nil {
-			if client.stream.active && !strings.Contains(err.Error(), "connection reset by peer") {
+			if client.stream != nil && client.stream.isActive() && !strings.Contains(err.Error(), "connection reset by peer") {
 				core.Logger.Error().Err(err).Msg("Error parsing RTSP request")
 			}
 			break
@@ -176,7 +188,7 @@ func (s *RTSPServer) handleInterleavedRTP(client *RTSPClient) error {
 	}
 
 	// Check if this is backchannel
-	if channel == client.backAudioRTPChannel {
+	if client.backAudioChannels.hasRTP(channel) {
 		// Parse und forward backchannel packet
 		packet := &rtp.Packet{}
 		if err := packet.Unmarshal(data); err != nil {
@@ -184,10 +196,13 @@ func (s *RTSPServer) handleInterleavedRTP(client *RTSPClient) error {
 		}
 
 		// Forward to WebRTC bridge
-		if client.stream != nil && client.stream.webrtcBridge != nil &&
-			client.stream.webrtcBridge.rtpForwarder != nil &&
-			client.stream.webrtcBridge.rtpForwarder.OnBackchannelAudio != nil {
-			client.stream.webrtcBridge.rtpForwarder.OnBackchannelAudio(packet)
+		if client.stream != nil && client.stream.forwarder.OnBackchannelAudio != nil {
+			client.stream.forwarder.OnBackchannelAudio(packet)
+		}
+	} else if client.videoChannels.hasRTCP(channel) || client.audioChannels.hasRTCP(channel) {
+		// Receiver Reports of the tracks we send
+		if client.stream != nil {
+			client.stream.forwarder.handleRTCP(client.session, data)
 		}
 	}
 
@@ -257,6 +272,44 @@ func (s *RTSPServer) parseRTSPRequestFromReader(reader *bufio.Reader) (*RTSPRequ
 func (s *RTSPServer) handleRTSPMethod(client *RTSPClient, request *RTSPRequest) bool {
 	close := false
 
+	// OPTIONS is answered without credentials, everything else needs an authorized user
+	if s.config.EnableAuthentication && request.Method != "OPTIONS" {
+		if client.authUser == nil {
+			client.authUser = s.authenticate(client, request)
+		}
+
+		if client.authUser == nil {
+			// Without credentials this is the usual challenge, not a rejection
+			if request.Headers["Authorization"] != "" {
+				connectionsRejected.Inc("unauthorized")
+			}
+
+			core.Logger.Warn().Msgf("Unauthorized RTSP %s request for %s, session=%s", request.Method, client.cameraPath, client.session)
+			s.sendUnauthorized(client, request)
+			return false
+		}
+
+		if !client.authUser.CanAccess(client.cameraPath) {
+			core.Logger.Warn().Msgf("RTSP user %s is not allowed to access %s", client.authUser.Username, client.cameraPath)
+			connectionsRejected.Inc("forbidden")
+			sendRTSPResponse(client.conn, 403, "Forbidden", map[string]string{"CSeq": strconv.Itoa(request.CSeq)}, "")
+			return true
+		}
+	}
+
+	if client.camera == nil && request.Method != "OPTIONS" && !s.resolveCamera(client, request) {
+		return true
+	}
+
+	switch request.Method {
+	case "DESCRIBE", "SETUP", "PLAY":
+		if err := s.attachStream(client); err != nil {
+			core.Logger.Error().Err(err).Msgf("Failed to create stream for camera %s", client.camera.DeviceName)
+			sendRTSPResponse(client.conn, 500, "Internal Server Error", map[string]string{"CSeq": strconv.Itoa(request.CSeq)}, "")
+			return true
+		}
+	}
+
 	switch request.Method {
 	case "OPTIONS":
 		s.handleOptions(client, request)
@@ -266,6 +319,8 @@ func (s *RTSPServer) handleRTSPMethod(client *RTSPClient, request *RTSPRequest)
 		s.handleSetup(client, request)
 	case "PLAY":
 		s.handlePlay(client, request)
+	case "PAUSE":
+		s.handlePause(client, request)
 	case "TEARDOWN":
 		s.handleTeardown(client, request)
 		close = true
@@ -279,15 +334,24 @@ func (s *RTSPServer) handleRTSPMethod(client *RTSPClient, request *RTSPRequest)
 func (s *RTSPServer) handleOptions(client *RTSPClient, request *RTSPRequest) {
 	headers := map[string]string{
 		"CSeq":   strconv.Itoa(request.CSeq),
-		"Public": "OPTIONS, DESCRIBE, SETUP, PLAY, TEARDOWN",
+		"Public": "OPTIONS, DESCRIBE, SETUP, PLAY, PAUSE, TEARDOWN",
 	}
 
 	sendRTSPResponse(client.conn, 200, "OK", headers, "")
 }
 
 func (s *RTSPServer) handleDescribe(client *RTSPClient, request *RTSPRequest) {
+	// Build the SDP from the live stream, fall back to the skill if it doesn't show up in time
+	info := client.stream.WaitMediaInfo(s.config.DescribeTimeout)
+	if info == nil {
+		core.Logger.Warn().Msgf("No video from camera %s within %v, describing stream from skill",
+			client.stream.camera.DeviceName, s.config.DescribeTimeout)
+	} else if !info.HasParameterSets() {
+		core.Logger.Warn().Msgf("No parameter sets from camera %s, describing stream without sprop", client.stream.camera.DeviceName)
+	}
+
 	// Generate SDP for the camera stream
-	sdp := s.generateSDP(client.stream.camera, request.URL)
+	sdp := s.generateSDP(client.stream.camera, client.resolution, request.URL, info)
 
 	headers := map[string]string{
 		"CSeq":          strconv.Itoa(request.CSeq),
@@ -351,17 +415,15 @@ func (s *RTSPServer) handleSetup(client *RTSPClient, request *RTSPRequest) {
 			}
 		}
 
+		channels := &interleavedChannels{rtp: rtpChannel, rtcp: rtcpChannel}
 		if isVideoTrack {
-			client.videoRTPChannel = rtpChannel
-			client.videoRTCPChannel = rtcpChannel
+			client.videoChannels = channels
 			core.Logger.Trace().Msgf("Setup video track - RTP channel: %d, RTCP channel: %d", rtpChannel, rtcpChannel)
 		} else if isAudioTrack {
-			client.audioRTPChannel = rtpChannel
-			client.audioRTCPChannel = rtcpChannel
+			client.audioChannels = channels
 			core.Logger.Trace().Msgf("Setup audio track - RTP channel: %d, RTCP channel: %d", rtpChannel, rtcpChannel)
 		} else if isBackchannel {
-			client.backAudioRTPChannel = rtpChannel
-			client.backAudioRTCPChannel = rtcpChannel
+			client.backAudioChannels = channels
 			core.Logger.Trace().Msgf("Setup backchannel track - RTP channel: %d, RTCP channel: %d", rtpChannel, rtcpChannel)
 		}
 
@@ -369,8 +431,7 @@ func (s *RTSPServer) handleSetup(client *RTSPClient, request *RTSPRequest) {
 			rtpChannel, rtcpChannel)
 
 		// For TCP, add/update client after each setup
-		err := client.stream.webrtcBridge.rtpForwarder.AddTCPClient(client.session, client.conn,
-			client.videoRTPChannel, client.audioRTPChannel, client.backAudioRTPChannel)
+		err := client.stream.forwarder.AddTCPClient(client.session, client.conn, client.videoChannels, client.audioChannels)
 		if err != nil {
 			core.Logger.Error().Err(err).Msg("Error adding TCP RTP client")
 			sendRTSPResponse(client.conn, 500, "Internal Server Error", nil,
@@ -402,56 +463,71 @@ func (s *RTSPServer) handleSetup(client *RTSPClient, request *RTSPRequest) {
 			return
 		}
 
-		// Store client ports based on track type
-		if isVideoTrack {
-			client.videoRTPPort = clientRTPPort
-			client.videoRTCPPort = clientRTCPPort
-			core.Logger.Trace().Msgf("Setup video track - Client RTP port: %d, RTCP port: %d", clientRTPPort, clientRTCPPort)
-		} else if isAudioTrack {
-			client.audioRTPPort = clientRTPPort
-			client.audioRTCPPort = clientRTCPPort
-			core.Logger.Trace().Msgf("Setup audio track - Client RTP port: %d, RTCP port: %d", clientRTPPort, clientRTCPPort)
-		} else if isBackchannel {
-			// For backchannel, setup the server listener and get actual server port
-			if client.stream != nil && client.stream.webrtcBridge != nil {
-				port, err := client.stream.webrtcBridge.rtpForwarder.SetupUDPBackchannel(
-					client.session, clientRTPPort)
-				if err != nil {
-					core.Logger.Error().Err(err).Msg("Failed to setup UDP backchannel")
-					sendRTSPResponse(client.conn, 500, "Internal Server Error", nil,
-						"Failed to setup backchannel")
-					return
-				}
-				client.backAudioRTPPort = port
-				client.backAudioRTCPPort = port + 1
-			}
-			core.Logger.Trace().Msgf("Setup backchannel track - Client RTP port: %d, Server RTP port: %d", clientRTPPort, client.backAudioRTPPort)
+		if clientRTCPPort == 0 {
+			clientRTCPPort = clientRTPPort + 1
 		}
 
-		// Build response transport
-		if isBackchannel && client.backAudioRTPPort > 0 {
-			// Include server ports for backchannel
-			responseTransport = fmt.Sprintf("RTP/AVP;unicast;client_port=%d-%d;server_port=%d-%d",
-				clientRTPPort, clientRTCPPort, client.backAudioRTPPort, client.backAudioRTCPPort)
-		} else {
-			// No server ports for video/audio (we're only sending to client)
-			responseTransport = fmt.Sprintf("RTP/AVP;unicast;client_port=%d-%d",
-				clientRTPPort, clientRTCPPort)
+		// Always send to the address the request came from. Other destinations
+		// would let anyone direct the streams at a third host.
+		host, _, _ := net.SplitHostPort(client.conn.RemoteAddr().String())
+		if destination := transportParam(transport, "destination"); destination != "" {
+			ip := net.ParseIP(strings.Trim(destination, "[]"))
+			if ip == nil || !ip.Equal(net.ParseIP(host)) {
+				core.Logger.Warn().Msgf("Client %s requested destination %s, ignored", client.session, destination)
+			}
 		}
 
-		core.Logger.Trace().Msgf("UDP setup - Track type: video=%v audio=%v backchannel=%v, Video port: %d, Audio port: %d, Server port: %d",
-			isVideoTrack, isAudioTrack, isBackchannel, client.videoRTPPort, client.audioRTPPort, client.backAudioRTPPort)
+		var serverRTPPort, serverRTCPPort int
 
-		// Add/update UDP client with current ports after video and audio setup
+		// Store client ports based on track type
 		if isVideoTrack || isAudioTrack {
-			err := client.stream.webrtcBridge.rtpForwarder.AddUDPClient(client.session,
-				client.videoRTPPort, client.audioRTPPort)
+			kind := utils.KindVideo
+			if isAudioTrack {
+				kind = utils.KindAudio
+			}
+
+			var err error
+			serverRTPPort, serverRTCPPort, err = client.stream.forwarder.AddUDPClient(
+				client.session, kind, host, clientRTPPort, clientRTCPPort)
 			if err != nil {
 				core.Logger.Error().Err(err).Msg("Error adding UDP RTP client")
 				sendRTSPResponse(client.conn, 500, "Internal Server Error", nil,
 					"Failed to setup RTP forwarding")
 				return
 			}
+
+			if isVideoTrack {
+				client.videoRTPPort = clientRTPPort
+				client.videoRTCPPort = clientRTCPPort
+			} else {
+				client.audioRTPPort = clientRTPPort
+				client.audioRTCPPort = clientRTCPPort
+			}
+			core.Logger.Trace().Msgf("Setup %s track - Client %s RTP port: %d, RTCP port: %d", kind, host, clientRTPPort, clientRTCPPort)
+		} else if isBackchannel {
+			// For backchannel, setup the server listener and get actual server port
+			port, err := client.stream.forwarder.SetupUDPBackchannel(
+				client.session, clientRTPPort)
+			if err != nil {
+				core.Logger.Error().Err(err).Msg("Failed to setup UDP backchannel")
+				sendRTSPResponse(client.conn, 500, "Internal Server Error", nil,
+					"Failed to setup backchannel")
+				return
+			}
+			client.backAudioRTPPort = port
+			client.backAudioRTCPPort = port + 1
+			serverRTPPort, serverRTCPPort = client.backAudioRTPPort, client.backAudioRTCPPort
+			core.Logger.Trace().Msgf("Setup backchannel track - Client RTP port: %d, Server RTP port: %d", clientRTPPort, client.backAudioRTPPort)
+		}
+
+		// Build response transport
+		responseTransport = fmt.Sprintf("RTP/AVP;unicast;client_port=%d-%d",
+			clientRTPPort, clientRTCPPort)
+		if serverRTPPort > 0 {
+			responseTransport += fmt.Sprintf(";server_port=%d-%d", serverRTPPort, serverRTCPPort)
+		}
+		if isVideoTrack || isAudioTrack {
+			responseTransport += ";destination=" + host
 		}
 
 	} else {
@@ -474,6 +550,16 @@ func (s *RTSPServer) handleSetup(client *RTSPClient, request *RTSPRequest) {
 	sendRTSPResponse(client.conn, 200, "OK", headers, "")
 }
 
+// transportParam returns the value of a key=value parameter of a Transport header
+func transportParam(transport, key string) string {
+	for _, param := range strings.Split(transport, ";") {
+		if k, v, ok := strings.Cut(strings.TrimSpace(param), "="); ok && strings.EqualFold(k, key) {
+			return v
+		}
+	}
+	return ""
+}
+
 func (s *RTSPServer) handlePlay(client *RTSPClient, request *RTSPRequest) {
 	// Validate session
 	sessionHeader := request.Headers["Session"]
@@ -482,18 +568,51 @@ func (s *RTSPServer) handlePlay(client *RTSPClient, request *RTSPRequest) {
 		return
 	}
 
+	// Every client gets its own sequence starting at seq 1 / rtptime 0 per track
+	baseURL := strings.TrimSuffix(request.URL, "/")
 	headers := map[string]string{
 		"CSeq":     strconv.Itoa(request.CSeq),
 		"Session":  client.session,
 		"Range":    "npt=0.000-",
-		"RTP-Info": fmt.Sprintf("url=%s;seq=1;rtptime=0", request.URL),
+		"RTP-Info": fmt.Sprintf("url=%s/video;seq=1;rtptime=0,url=%s/audio;seq=1;rtptime=0", baseURL, baseURL),
+	}
+
+	// A playback seeks to the requested range and reports its wall clock position
+	if client.stream != nil && !client.playbackStart.IsZero() {
+		client.stream.PlayFrom(parseRange(request.Headers["Range"], client.playbackStart))
+		headers["Range"] = "clock=" + formatClock(client.stream.PlaybackPosition()) + "-"
 	}
 
 	sendRTSPResponse(client.conn, 200, "OK", headers, "")
 
+	// Start sending after the response, beginning with the cached GOP
+	if client.stream != nil {
+		client.stream.forwarder.StartPlayback(client.session)
+	}
+
 	core.Logger.Info().Msgf("Starting RTSP stream for client %s", client.session)
 }
 
+func (s *RTSPServer) handlePause(client *RTSPClient, request *RTSPRequest) {
+	sessionHeader := request.Headers["Session"]
+	if sessionHeader == "" || !strings.Contains(sessionHeader, client.session) {
+		sendRTSPResponse(client.conn, 454, "Session Not Found", nil, "")
+		return
+	}
+
+	// Live streams can't be paused, the client just stops rendering
+	if client.stream != nil {
+		client.stream.Pause()
+	}
+
+	headers := map[string]string{
+		"CSeq":    strconv.Itoa(request.CSeq),
+		"Session": client.session,
+	}
+
+	sendRTSPResponse(client.conn, 200, "OK", headers, "")
+}
+
 func (s *RTSPServer) handleTeardown(client *RTSPClient, request *RTSPRequest) {
 	headers := map[string]string{
 		"CSeq":    strconv.Itoa(request.CSeq),
@@ -513,7 +632,7 @@ func (s *RTSPServer) handleUnsupportedMethod(client *RTSPClient, request *RTSPRe
 	sendRTSPResponse(client.conn, 501, "Not Implemented", headers, "")
 }
 
-func (s *RTSPServer) generateSDP(camera *storage.CameraInfo, baseURL string) string {
+func (s *RTSPServer) generateSDP(camera *storage.CameraInfo, resolution string, baseURL string, info *MediaInfo) string {
 	sdp := "v=0\r\n"
 	sdp += fmt.Sprintf("o=- %d %d IN IP4 0.0.0.0\r\n", time.Now().Unix(), time.Now().Unix())
 	sdp += "s=Tuya Camera Stream\r\n"
@@ -523,73 +642,98 @@ func (s *RTSPServer) generateSDP(camera *storage.CameraInfo, baseURL string) str
 	sdp += "a=range:npt=0-\r\n"
 
 	var skill *tuya.Skill
-	err := json.Unmarshal([]byte(camera.Skill), &skill)
-	if err != nil {
+	if err := json.Unmarshal([]byte(camera.Skill), &skill); err != nil {
 		core.Logger.Error().Err(err).Msg("Error unmarshalling skill")
-		return ""
+		if info == nil {
+			return ""
+		}
 	}
 
-	audioSdp := ""
-	videoSdp := ""
+	if resolution == "" {
+		resolution = "hd"
+	}
 
-	// Video media description based on skill
+	// Same stream the bridge requested for this resolution
+	var videoInfo *tuya.VideoSkill
 	if skill != nil && len(skill.Videos) > 0 {
-		// HD (main) as default
-		streamType := tuya.GetStreamType(skill, "hd")
-		isHEVC := tuya.IsHEVC(skill, streamType)
-
-		var videoInfo *tuya.VideoSkill
-		for _, video := range skill.Videos {
-			if video.StreamType == streamType {
-				videoInfo = &video
+		streamType := tuya.GetStreamType(skill, resolution)
+		for i := range skill.Videos {
+			if skill.Videos[i].StreamType == streamType {
+				videoInfo = &skill.Videos[i]
 				break
 			}
 		}
+	}
 
-		if videoInfo != nil {
-			if isHEVC {
-				// H.265/HEVC
-				videoSdp += "m=video 0 RTP/AVP 96\r\n"
-				videoSdp += "a=rtpmap:96 H265/90000\r\n"
-				videoSdp += "a=fmtp:96 profile-id=1\r\n"
-			} else {
-				// H.264
-				videoSdp += "m=video 0 RTP/AVP 96\r\n"
-				videoSdp += "a=rtpmap:96 H264/90000\r\n"
-				videoSdp += "a=fmtp:96 packetization-mode=1;profile-level-id=42001e\r\n"
+	videoSdp := ""
+	audioSdp := ""
+
+	// Video media description, from the live stream if available
+	var payloadType uint8 = 96
+	isHEVC := videoInfo != nil && videoInfo.CodecType == 4
+	if info != nil {
+		isHEVC = info.HEVC
+		if info.VideoPayloadType >= 96 {
+			payloadType = info.VideoPayloadType
+		}
+	}
+
+	videoSdp += fmt.Sprintf("m=video 0 RTP/AVP %d\r\n", payloadType)
+
+	if isHEVC {
+		// H.265/HEVC
+		videoSdp += fmt.Sprintf("a=rtpmap:%d H265/90000\r\n", payloadType)
+
+		fmtp := "profile-id=1"
+		if info != nil && info.HasParameterSets() {
+			if profileID := h265.ProfileID(info.SPS); profileID != 0 {
+				fmtp = fmt.Sprintf("profile-id=%d", profileID)
 			}
+			fmtp += fmt.Sprintf(";sprop-vps=%s;sprop-sps=%s;sprop-pps=%s",
+				base64.StdEncoding.EncodeToString(info.VPS),
+				base64.StdEncoding.EncodeToString(info.SPS),
+				base64.StdEncoding.EncodeToString(info.PPS))
 		}
+		videoSdp += fmt.Sprintf("a=fmtp:%d %s\r\n", payloadType, fmtp)
 	} else {
-		// Fallback in case no video stream is found
-		videoSdp += "m=video 0 RTP/AVP 96\r\n"
-		videoSdp += "a=rtpmap:96 H264/90000\r\n"
-		videoSdp += "a=fmtp:96 packetization-mode=1;profile-level-id=42001e\r\n"
+		// H.264
+		videoSdp += fmt.Sprintf("a=rtpmap:%d H264/90000\r\n", payloadType)
+
+		fmtp := "packetization-mode=1;profile-level-id=42001e"
+		if info != nil && info.HasParameterSets() {
+			fmtp = fmt.Sprintf("packetization-mode=1;profile-level-id=%s;sprop-parameter-sets=%s",
+				h264.ProfileLevelID(info.SPS), h264.SpropParameterSets(info.SPS, info.PPS))
+		} else if videoInfo != nil && profileLevelIDPattern.MatchString(videoInfo.ProfileId) {
+			fmtp = "packetization-mode=1;profile-level-id=" + videoInfo.ProfileId
+		}
+		videoSdp += fmt.Sprintf("a=fmtp:%d %s\r\n", payloadType, fmtp)
 	}
 
 	videoSdp += fmt.Sprintf("a=control:%s/video\r\n", baseURL)
 	videoSdp += "a=recvonly\r\n"
 
-	// Audio media description based on skill
+	// Audio media description, payload type seen on the wire wins over skill
+	audioCodec := 105
 	if skill != nil && len(skill.Audios) > 0 {
-		audioInfo := skill.Audios[0] // Nehme ersten audio stream
-
-		switch audioInfo.CodecType {
-		// case 101: // PCML
-		// 	audioSdp += "m=audio 0 RTP/AVP 97\r\n"
-		// 	audioSdp += "a=rtpmap:97 L16/8000\r\n"
-		case 101, 105: // PCML and PCMU
-			audioSdp += "m=audio 0 RTP/AVP 0\r\n"
-			audioSdp += "a=rtpmap:0 PCMU/8000\r\n"
-		case 106: // PCMA
-			audioSdp += "m=audio 0 RTP/AVP 8\r\n"
-			audioSdp += "a=rtpmap:8 PCMA/8000\r\n"
-		default:
-			// Fallback
-			audioSdp += "m=audio 0 RTP/AVP 0\r\n"
-			audioSdp += "a=rtpmap:0 PCMU/8000\r\n"
+		audioCodec = skill.Audios[0].CodecType
+	}
+	if info != nil && info.HasAudio {
+		switch info.AudioPayloadType {
+		case 0:
+			audioCodec = 105
+		case 8:
+			audioCodec = 106
 		}
-	} else {
-		// Fallback in case no audio stream is found
+	}
+
+	switch audioCodec {
+	// case 101: // PCML
+	// 	audioSdp += "m=audio 0 RTP/AVP 97\r\n"
+	// 	audioSdp += "a=rtpmap:97 L16/8000\r\n"
+	case 106: // PCMA
+		audioSdp += "m=audio 0 RTP/AVP 8\r\n"
+		audioSdp += "a=rtpmap:8 PCMA/8000\r\n"
+	default: // PCML and PCMU
 		audioSdp += "m=audio 0 RTP/AVP 0\r\n"
 		audioSdp += "a=rtpmap:0 PCMU/8000\r\n"
 	}
@@ -605,3 +749,5 @@ func (s *RTSPServer) generateSDP(camera *storage.CameraInfo, baseURL string) str
 
 	return finalSdp
 }
+
+var profileLevelIDPattern = regexp.MustCompile(`^[0-9A-Fa-f]{6}$`)
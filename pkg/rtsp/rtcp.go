@@ -0,0 +1,263 @@
+package rtsp
+
+import (
+	"sync"
+	"time"
+	"tuya-ipc-terminal/pkg/core"
+
+	"github.com/pion/rtcp"
+)
+
+const (
+	rtcpInterval   = 5 * time.Second
+	rtcpCNAME      = "tuya-ipc-terminal"
+	videoClockRate = 90000
+	audioClockRate = 8000 // PCMU/PCMA
+)
+
+// ReceiverReport is the reception quality a client reported for one track
+type ReceiverReport struct {
+	FractionLost float64   `json:"fraction_lost"` // 0..1, since the previous report
+	PacketsLost  uint32    `json:"packets_lost"`  // Cumulative
+	Jitter       float64   `json:"jitter_ms"`
+	LastReport   time.Time `json:"last_report"`
+}
+
+type ClientRTCPStats struct {
+	Video *ReceiverReport `json:"video,omitempty"`
+	Audio *ReceiverReport `json:"audio,omitempty"`
+}
+
+// rtcpTrack keeps what was sent to one client on one track, which is what
+// the Sender Reports are built from, and the client's last Receiver Report
+type rtcpTrack struct {
+	clockRate uint32
+
+	ssrc        uint32
+	packetCount uint32
+	octetCount  uint32
+	rtpTime     uint32    // Timestamp of the last frame sent
+	wallclock   time.Time // When that frame was sent
+
+	report *ReceiverReport
+	mutex  sync.Mutex
+}
+
+func newRTCPTrack(clockRate uint32) *rtcpTrack {
+	return &rtcpTrack{clockRate: clockRate}
+}
+
+// onSent records a packet as sent to the client, with the timestamp it was sent with
+func (t *rtcpTrack) onSent(ssrc, timestamp uint32, payloadLen int) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	// New source (e.g. camera reconnected), start counting again
+	if t.packetCount > 0 && ssrc != t.ssrc {
+		t.packetCount, t.octetCount = 0, 0
+		t.report = nil
+	}
+
+	if t.packetCount == 0 || timestamp != t.rtpTime {
+		t.rtpTime = timestamp
+		t.wallclock = time.Now()
+	}
+
+	t.ssrc = ssrc
+	t.packetCount++
+	t.octetCount += uint32(payloadLen)
+}
+
+// senderReport returns the marshaled SR+SDES compound packet, or nil if nothing was sent yet
+func (t *rtcpTrack) senderReport(now time.Time) []byte {
+	t.mutex.Lock()
+	if t.packetCount == 0 {
+		t.mutex.Unlock()
+		return nil
+	}
+
+	sr := &rtcp.SenderReport{
+		SSRC:        t.ssrc,
+		NTPTime:     toNTPTime(now),
+		RTPTime:     t.rtpTime + uint32(now.Sub(t.wallclock).Seconds()*float64(t.clockRate)),
+		PacketCount: t.packetCount,
+		OctetCount:  t.octetCount,
+	}
+	t.mutex.Unlock()
+
+	sdes := &rtcp.SourceDescription{
+		Chunks: []rtcp.SourceDescriptionChunk{{
+			Source: sr.SSRC,
+			Items:  []rtcp.SourceDescriptionItem{{Type: rtcp.SDESCNAME, Text: rtcpCNAME}},
+		}},
+	}
+
+	data, err := rtcp.Marshal([]rtcp.Packet{sr, sdes})
+	if err != nil {
+		core.Logger.Error().Err(err).Msg("Error marshaling RTCP sender report")
+		return nil
+	}
+
+	return data
+}
+
+// applyReport stores a reception report if it is about the SSRC we send
+func (t *rtcpTrack) applyReport(report rtcp.ReceptionReport) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.packetCount == 0 || report.SSRC != t.ssrc {
+		return false
+	}
+
+	t.report = &ReceiverReport{
+		FractionLost: float64(report.FractionLost) / 256,
+		PacketsLost:  report.TotalLost,
+		Jitter:       float64(report.Jitter) / float64(t.clockRate) * 1000,
+		LastReport:   time.Now(),
+	}
+
+	return true
+}
+
+func (t *rtcpTrack) lastReport() *ReceiverReport {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.report == nil {
+		return nil
+	}
+
+	report := *t.report
+	return &report
+}
+
+// startRTCP starts the sender report loop, must be called with rf.mutex held
+func (rf *RTPForwarder) startRTCP() {
+	if rf.rtcpDone != nil {
+		return
+	}
+
+	rf.rtcpDone = make(chan struct{})
+	go rf.rtcpLoop(rf.rtcpDone)
+}
+
+func (rf *RTPForwarder) rtcpLoop(done chan struct{}) {
+	ticker := time.NewTicker(rtcpInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			rf.sendSenderReports()
+		}
+	}
+}
+
+func (rf *RTPForwarder) sendSenderReports() {
+	rf.mutex.RLock()
+	defer rf.mutex.RUnlock()
+
+	now := time.Now()
+
+	for sessionID, client := range rf.clients {
+		if data := client.videoRTCP.senderReport(now); data != nil {
+			if err := rf.writeRTCP(client, client.videoUDP, client.videoChannels, data); err != nil {
+				core.Logger.Debug().Err(err).Msgf("Error sending video RTCP to client %s", sessionID)
+			}
+		}
+
+		if data := client.audioRTCP.senderReport(now); data != nil {
+			if err := rf.writeRTCP(client, client.audioUDP, client.audioChannels, data); err != nil {
+				core.Logger.Debug().Err(err).Msgf("Error sending audio RTCP to client %s", sessionID)
+			}
+		}
+	}
+}
+
+func (rf *RTPForwarder) writeRTCP(client *RTPClient, track *udpTrack, channels *interleavedChannels, data []byte) error {
+	switch client.transportMode {
+	case TransportUDP:
+		if track != nil {
+			return track.writeRTCP(data)
+		}
+	case TransportTCP:
+		if client.tcpConn != nil && channels != nil {
+			return rf.sendInterleavedRTP(client.tcpConn, channels.rtcp, data)
+		}
+	}
+
+	return nil
+}
+
+// handleRTCP processes RTCP received from a client and keeps its reception reports
+func (rf *RTPForwarder) handleRTCP(sessionID string, data []byte) {
+	packets, err := rtcp.Unmarshal(data)
+	if err != nil {
+		core.Logger.Trace().Err(err).Msgf("Invalid RTCP from client %s", sessionID)
+		return
+	}
+
+	rf.mutex.RLock()
+	client, exists := rf.clients[sessionID]
+	rf.mutex.RUnlock()
+
+	if !exists {
+		return
+	}
+
+	client.touch()
+
+	for _, packet := range packets {
+		var reports []rtcp.ReceptionReport
+
+		switch p := packet.(type) {
+		case *rtcp.ReceiverReport:
+			reports = p.Reports
+		case *rtcp.SenderReport:
+			reports = p.Reports
+		}
+
+		for _, report := range reports {
+			kind := "video"
+			if !client.videoRTCP.applyReport(report) {
+				if !client.audioRTCP.applyReport(report) {
+					continue
+				}
+				kind = "audio"
+			}
+
+			core.Logger.Trace().Msgf("RTCP RR from client %s (%s): lost %.1f%% (total %d), jitter %d",
+				sessionID, kind, float64(report.FractionLost)/2.56, report.TotalLost, report.Jitter)
+		}
+	}
+}
+
+// GetClientRTCPStats returns the last Receiver Reports of a client
+func (rf *RTPForwarder) GetClientRTCPStats(sessionID string) (ClientRTCPStats, bool) {
+	rf.mutex.RLock()
+	client, exists := rf.clients[sessionID]
+	rf.mutex.RUnlock()
+
+	if !exists {
+		return ClientRTCPStats{}, false
+	}
+
+	return ClientRTCPStats{
+		Video: client.videoRTCP.lastReport(),
+		Audio: client.audioRTCP.lastReport(),
+	}, true
+}
+
+// toNTPTime converts to the 64 bit NTP format (seconds since 1900 in the upper 32 bits)
+func toNTPTime(t time.Time) uint64 {
+	const ntpEpochOffset = 2208988800
+
+	nanos := uint64(t.UnixNano())
+	seconds := nanos/1e9 + ntpEpochOffset
+	fraction := ((nanos % 1e9) << 32) / 1e9
+
+	return seconds<<32 | fraction
+}
@@ -7,16 +7,22 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"tuya-ipc-terminal/pkg/core"
 	"tuya-ipc-terminal/pkg/storage"
 	"tuya-ipc-terminal/pkg/tuya"
+
+	"github.com/pion/rtp"
 )
 
 type RTSPServer struct {
-	port           int
+	config         ServerConfig
 	listener       net.Listener
 	storageManager *storage.StorageManager
 	clients        map[string]*RTSPClient
@@ -25,40 +31,61 @@ type RTSPServer struct {
 	ctx            context.Context
 	cancel         context.CancelFunc
 	running        bool
+	reconnects     atomic.Int64
 }
 
 type RTSPClient struct {
-	conn                 net.Conn
-	session              string
-	cameraPath           string
-	stream               *CameraStream
-	reader               *bufio.Reader
-	transportMode        TransportMode
-	videoRTPPort         int
-	videoRTCPPort        int
-	audioRTPPort         int
-	audioRTCPPort        int
-	backAudioRTPPort     int // server-side port for back audio
-	backAudioRTCPPort    int // server-side port for back audio RTCP
-	videoRTPChannel      byte
-	videoRTCPChannel     byte
-	audioRTPChannel      byte
-	audioRTCPChannel     byte
-	backAudioRTPChannel  byte
-	backAudioRTCPChannel byte
-	setupCount           int
+	conn              net.Conn
+	session           string
+	cameraPath        string
+	resolution        string
+	camera            *storage.CameraInfo
+	user              *storage.UserSession
+	stream            *CameraStream
+	authNonce         string
+	authUser          *storage.RTSPUser
+	reader            *bufio.Reader
+	transportMode     TransportMode
+	videoRTPPort      int
+	videoRTCPPort     int
+	audioRTPPort      int
+	audioRTCPPort     int
+	backAudioRTPPort  int                  // server-side port for back audio
+	backAudioRTCPPort int                  // server-side port for back audio RTCP
+	videoChannels     *interleavedChannels // TCP channels, nil until the track is set up
+	audioChannels     *interleavedChannels
+	backAudioChannels *interleavedChannels
+	setupCount        int
+	connectedAt       time.Time
+	playbackStart     time.Time // SD-card playback from this time, zero for live
 }
 
 type CameraStream struct {
-	camera       *storage.CameraInfo
-	resolution   string
-	user         *storage.UserSession
-	webrtcBridge *WebRTCBridge
-	clients      map[string]*RTSPClient
-	mutex        sync.RWMutex
-	connecting   bool
-	active       bool
-	lastActivity time.Time
+	camera         *storage.CameraInfo
+	resolution     string
+	user           *storage.UserSession
+	storageManager *storage.StorageManager
+	webrtcBridge   *WebRTCBridge // Current upstream, replaced on reconnect
+	forwarder      *RTPForwarder // Outlives the bridges so clients survive reconnects
+	clients        map[string]*RTSPClient
+	viewers        map[string]struct{} // Attached in-process viewers, see AddViewer
+	mutex          sync.RWMutex
+	connecting     bool
+	active         bool
+	lastActivity   time.Time
+
+	// Upstream supervision
+	supervising bool
+	stopped     bool
+	done        chan struct{}
+	reconnects  int
+
+	// Started through the API, kept running without clients until stopped
+	pinned bool
+
+	// SD-card playback of a single client, nil for live streams
+	playback *playbackState
+	restart  chan struct{}
 
 	// Delayed shutdown
 	shutdownTimer *time.Timer
@@ -70,18 +97,46 @@ type CameraStream struct {
 }
 
 type ServerConfig struct {
+	Host                 string // Listen address, empty for all
 	Port                 int
 	MaxClients           int
 	StreamTimeout        time.Duration
 	ConnectionTimeout    time.Duration
 	EnableAuthentication bool
+	AllowBasicAuth       bool
+
+	// How long DESCRIBE waits for the first keyframe to build the SDP from
+	DescribeTimeout time.Duration
+
+	// GOP cache memory cap per stream in bytes (0 disables), with per camera
+	// overrides keyed by RTSP path, device ID or name
+	GOPCacheSize       int
+	CameraGOPCacheSize map[string]int
 }
 
-func NewRTSPServer(port int, storageManager *storage.StorageManager) *RTSPServer {
+// gopCacheSize returns the GOP cache cap for a camera
+func (c *ServerConfig) gopCacheSize(camera *storage.CameraInfo) int {
+	for _, key := range []string{camera.RTSPPath, strings.TrimPrefix(camera.RTSPPath, "/"), camera.DeviceID, camera.DeviceName} {
+		if size, ok := c.CameraGOPCacheSize[key]; ok {
+			return size
+		}
+	}
+	return c.GOPCacheSize
+}
+
+const DefaultDescribeTimeout = 10 * time.Second
+
+var ErrCameraNotFound = errors.New("camera not found")
+
+func NewRTSPServer(config ServerConfig, storageManager *storage.StorageManager) *RTSPServer {
 	ctx, cancel := context.WithCancel(context.Background())
 
+	if config.DescribeTimeout <= 0 {
+		config.DescribeTimeout = DefaultDescribeTimeout
+	}
+
 	return &RTSPServer{
-		port:           port,
+		config:         config,
 		storageManager: storageManager,
 		clients:        make(map[string]*RTSPClient),
 		streams:        make(map[string]*CameraStream),
@@ -99,15 +154,26 @@ func (s *RTSPServer) Start() error {
 		return errors.New("server is already running")
 	}
 
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", s.port))
+	listener, err := net.Listen("tcp", net.JoinHostPort(s.config.Host, strconv.Itoa(s.config.Port)))
 	if err != nil {
-		return fmt.Errorf("failed to listen on port %d: %v", s.port, err)
+		return fmt.Errorf("failed to listen on port %d: %v", s.config.Port, err)
 	}
 
 	s.listener = listener
 	s.running = true
 
-	core.Logger.Info().Msgf("RTSP Server started on port %d", s.port)
+	core.Logger.Info().Msgf("RTSP Server started on port %d", s.config.Port)
+
+	if s.config.EnableAuthentication {
+		users, err := s.storageManager.GetRTSPUsers()
+		if err != nil {
+			core.Logger.Warn().Msgf("Could not load RTSP users: %v", err)
+		} else if len(users) == 0 {
+			core.Logger.Warn().Msg("RTSP authentication is enabled but no users exist, all requests will be rejected")
+			core.Logger.Warn().Msg("  tuya-ipc-terminal rtsp users add [username]")
+		}
+	}
+
 	core.Logger.Info().Msgf("Available endpoints:")
 
 	// List available camera endpoints
@@ -163,7 +229,7 @@ func (s *RTSPServer) IsRunning() bool {
 }
 
 func (s *RTSPServer) GetPort() int {
-	return s.port
+	return s.config.Port
 }
 
 func (s *RTSPServer) GetStats() ServerStats {
@@ -172,26 +238,254 @@ func (s *RTSPServer) GetStats() ServerStats {
 
 	activeStreams := 0
 	for _, stream := range s.streams {
-		if stream.active {
+		if stream.isActive() {
 			activeStreams++
 		}
 	}
 
 	return ServerStats{
-		Port:         s.port,
+		Port:         s.config.Port,
 		Running:      s.running,
 		ClientCount:  len(s.clients),
 		StreamCount:  activeStreams,
 		TotalStreams: len(s.streams),
+		Reconnects:   s.reconnects.Load(),
+	}
+}
+
+// StreamInfo describes a camera stream for status output
+type StreamInfo struct {
+	ID           string     `json:"id"`
+	CameraName   string     `json:"cameraName"`
+	DeviceID     string     `json:"deviceId"`
+	Path         string     `json:"path"`
+	Resolution   string     `json:"resolution"`
+	Active       bool       `json:"active"`
+	Connecting   bool       `json:"connecting"`
+	Pinned       bool       `json:"pinned"`
+	ClientCount  int        `json:"clientCount"`
+	ViewerCount  int        `json:"viewerCount"`
+	Reconnects   int        `json:"reconnects"`
+	LastActivity time.Time  `json:"lastActivity"`
+	Playback     *time.Time `json:"playback,omitempty"` // Position of an SD-card playback
+}
+
+// ClientInfo describes a connected RTSP session for status output
+type ClientInfo struct {
+	Session     string           `json:"session"`
+	RemoteAddr  string           `json:"remoteAddr"`
+	Path        string           `json:"path"`
+	Resolution  string           `json:"resolution"`
+	CameraName  string           `json:"cameraName"`
+	Transport   string           `json:"transport"`
+	User        string           `json:"user,omitempty"`
+	Playing     bool             `json:"playing"`
+	ConnectedAt time.Time        `json:"connectedAt"`
+	PacketsSent uint64           `json:"packetsSent"`
+	BytesSent   uint64           `json:"bytesSent"`
+	RTCP        *ClientRTCPStats `json:"rtcp,omitempty"`
+}
+
+func (s *RTSPServer) GetStreams() []StreamInfo {
+	s.mutex.RLock()
+	streams := make([]*CameraStream, 0, len(s.streams))
+	for _, stream := range s.streams {
+		streams = append(streams, stream)
+	}
+	s.mutex.RUnlock()
+
+	infos := make([]StreamInfo, 0, len(streams))
+	for _, stream := range streams {
+		stream.mutex.RLock()
+		infos = append(infos, StreamInfo{
+			ID:           stream.streamId,
+			CameraName:   stream.camera.DeviceName,
+			DeviceID:     stream.camera.DeviceID,
+			Path:         stream.camera.RTSPPath,
+			Resolution:   stream.resolution,
+			Active:       stream.active,
+			Connecting:   stream.connecting,
+			Pinned:       stream.pinned,
+			ClientCount:  len(stream.clients),
+			ViewerCount:  len(stream.viewers),
+			Reconnects:   stream.reconnects,
+			LastActivity: stream.lastActivity,
+		})
+		if stream.playback != nil {
+			position := stream.playback.position()
+			infos[len(infos)-1].Playback = &position
+		}
+		stream.mutex.RUnlock()
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+
+	return infos
+}
+
+func (s *RTSPServer) GetClients() []ClientInfo {
+	s.mutex.RLock()
+	clients := make([]*RTSPClient, 0, len(s.clients))
+	for _, client := range s.clients {
+		clients = append(clients, client)
+	}
+	s.mutex.RUnlock()
+
+	infos := make([]ClientInfo, 0, len(clients))
+	for _, client := range clients {
+		info := ClientInfo{
+			Session:     client.session,
+			RemoteAddr:  client.conn.RemoteAddr().String(),
+			Path:        client.cameraPath,
+			Resolution:  client.resolution,
+			CameraName:  client.camera.DeviceName,
+			Transport:   "UDP",
+			ConnectedAt: client.connectedAt,
+		}
+
+		if client.transportMode == TransportTCP {
+			info.Transport = "TCP"
+		}
+
+		if client.authUser != nil {
+			info.User = client.authUser.Username
+		}
+
+		if client.stream != nil {
+			info.Playing = client.stream.forwarder.IsPlaying(client.session)
+			info.PacketsSent, info.BytesSent = client.stream.forwarder.ClientCounters(client.session)
+			if stats, ok := client.stream.forwarder.GetClientRTCPStats(client.session); ok {
+				info.RTCP = &stats
+			}
+		}
+
+		infos = append(infos, info)
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ConnectedAt.Before(infos[j].ConnectedAt) })
+
+	return infos
+}
+
+// KickClient closes the connection of an RTSP session
+func (s *RTSPServer) KickClient(sessionID string) bool {
+	s.mutex.RLock()
+	_, exists := s.clients[sessionID]
+	s.mutex.RUnlock()
+
+	if !exists {
+		return false
+	}
+
+	core.Logger.Info().Msgf("Disconnecting RTSP client %s", sessionID)
+	s.removeClient(sessionID)
+
+	return true
+}
+
+// StartStream connects a camera without waiting for a client and keeps the
+// stream running until StopStream. The camera is given by RTSP path or device ID.
+func (s *RTSPServer) StartStream(cameraID, resolution string) (*StreamInfo, error) {
+	if resolution != "" && resolution != "hd" && resolution != "sd" {
+		return nil, fmt.Errorf("invalid resolution: %s", resolution)
+	}
+
+	camera, user, err := s.lookupCamera(cameraID)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := s.getOrCreateStream(camera, resolution, user)
+	if err != nil {
+		return nil, err
+	}
+
+	stream.mutex.Lock()
+	stream.pinned = true
+	if stream.shutdownTimer != nil {
+		stream.shutdownTimer.Stop()
+		stream.shutdownTimer = nil
+	}
+	stream.startSupervisor()
+	stream.mutex.Unlock()
+
+	core.Logger.Info().Msgf("Started stream %s on request", stream.streamId)
+
+	return s.streamInfo(stream.streamId), nil
+}
+
+// StopStream disconnects the clients of a stream and closes its upstream
+func (s *RTSPServer) StopStream(streamID string) bool {
+	s.mutex.RLock()
+	stream, exists := s.streams[streamID]
+	s.mutex.RUnlock()
+
+	if !exists {
+		return false
+	}
+
+	stream.mutex.RLock()
+	sessions := make([]string, 0, len(stream.clients))
+	for sessionID := range stream.clients {
+		sessions = append(sessions, sessionID)
+	}
+	stream.mutex.RUnlock()
+
+	for _, sessionID := range sessions {
+		s.removeClient(sessionID)
+	}
+
+	core.Logger.Info().Msgf("Stopping stream %s on request", streamID)
+	stream.stopStream()
+
+	return true
+}
+
+func (s *RTSPServer) streamInfo(streamID string) *StreamInfo {
+	for _, info := range s.GetStreams() {
+		if info.ID == streamID {
+			return &info
+		}
 	}
+	return nil
+}
+
+// lookupCamera finds a camera by RTSP path (with or without leading slash) or device ID
+func (s *RTSPServer) lookupCamera(cameraID string) (*storage.CameraInfo, *storage.UserSession, error) {
+	cameras, err := s.storageManager.GetAllCameras()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, camera := range cameras {
+		if camera.DeviceID == cameraID || camera.RTSPPath == cameraID || camera.RTSPPath == "/"+cameraID {
+			camera, user, err := s.findCamera(camera.RTSPPath)
+			if err != nil {
+				return nil, nil, err
+			}
+			if camera == nil {
+				break
+			}
+			return camera, user, nil
+		}
+	}
+
+	return nil, nil, fmt.Errorf("%w: %s", ErrCameraNotFound, cameraID)
+}
+
+// LookupCamera finds a camera by RTSP path (with or without "/") or device ID
+func (s *RTSPServer) LookupCamera(cameraID string) (*storage.CameraInfo, error) {
+	camera, _, err := s.lookupCamera(cameraID)
+	return camera, err
 }
 
 type ServerStats struct {
-	Port         int  `json:"port"`
-	Running      bool `json:"running"`
-	ClientCount  int  `json:"clientCount"`
-	StreamCount  int  `json:"activeStreamCount"`
-	TotalStreams int  `json:"totalStreams"`
+	Port         int   `json:"port"`
+	Running      bool  `json:"running"`
+	ClientCount  int   `json:"clientCount"`
+	StreamCount  int   `json:"activeStreamCount"`
+	TotalStreams int   `json:"totalStreams"`
+	Reconnects   int64 `json:"reconnects"`
 }
 
 func (s *RTSPServer) acceptConnections() {
@@ -226,67 +520,108 @@ func (s *RTSPServer) handleConnection(conn net.Conn) {
 	request, err := s.parseRTSPRequestFromReader(reader)
 	if err != nil {
 		core.Logger.Error().Err(err).Msg("Error parsing initial RTSP request")
+		connectionsRejected.Inc("bad_request")
+		return
+	}
+
+	// Extract camera path from URL, a playback URL has a suffix and start time
+	urlPath, playbackStart, err := parsePlaybackURL(request.URL)
+	if err != nil {
+		core.Logger.Error().Err(err).Msgf("Invalid RTSP URL %s", request.URL)
+		connectionsRejected.Inc("bad_request")
+		sendRTSPResponse(conn, 400, "Bad Request", nil, "")
 		return
 	}
 
-	// Extract camera path from URL
-	cameraPath, streamResolution := extractCameraPath(request.URL)
+	cameraPath, streamResolution := ParseCameraPath(urlPath)
 	if cameraPath == "" {
 		core.Logger.Error().Msg("Invalid RTSP URL")
+		connectionsRejected.Inc("bad_request")
 		sendRTSPResponse(conn, 400, "Bad Request", nil, "")
 		return
 	}
 
-	// Find camera
-	camera, user, err := s.findCamera(cameraPath)
-	if err != nil {
-		core.Logger.Error().Msgf("Error finding camera for path %s: %v", cameraPath, err)
-		sendRTSPResponse(conn, 500, "Internal Server Error", nil, "")
+	// Create RTSP client, the camera is looked up once the client is authorized
+	// so that unauthorized clients can't probe camera paths
+	client := &RTSPClient{
+		conn:             conn,
+		reader:           reader,
+		session:          session,
+		cameraPath:       cameraPath,
+		resolution:       streamResolution,
+		transportMode:    TransportUDP, // Default to UDP
+		videoRTPPort:     0,
+		audioRTPPort:     0,
+		backAudioRTPPort: 0,
+		setupCount:       0,
+		connectedAt:      time.Now(),
+		playbackStart:    playbackStart,
+	}
+
+	// Handle initial request
+	if close := s.handleRTSPMethod(client, request); close {
+		s.removeClient(client.session)
 		return
 	}
 
+	// Handle further requests
+	s.handleRTSPProtocol(client)
+}
+
+// resolveCamera looks up the camera of an authorized client and adds the
+// client to the server. Unknown cameras are answered with 404.
+func (s *RTSPServer) resolveCamera(client *RTSPClient, request *RTSPRequest) bool {
+	headers := map[string]string{"CSeq": strconv.Itoa(request.CSeq)}
+
+	camera, user, err := s.findCamera(client.cameraPath)
+	if err != nil {
+		core.Logger.Error().Msgf("Error finding camera for path %s: %v", client.cameraPath, err)
+		connectionsRejected.Inc("error")
+		sendRTSPResponse(client.conn, 500, "Internal Server Error", headers, "")
+		return false
+	}
+
 	if camera == nil {
-		core.Logger.Error().Msgf("Camera not found for path %s", cameraPath)
-		sendRTSPResponse(conn, 404, "Not Found", nil, "")
-		return
+		core.Logger.Error().Msgf("Camera not found for path %s", client.cameraPath)
+		connectionsRejected.Inc("not_found")
+		sendRTSPResponse(client.conn, 404, "Not Found", headers, "")
+		return false
 	}
 
+	client.camera = camera
+	client.user = user
+	client.resolution = cameraResolution(camera, client.resolution)
+
 	core.Logger.Info().Msgf("New RTSP connection for camera: %s (%s)", camera.DeviceName, camera.DeviceID)
 
+	s.addClient(client)
+	connectionsAccepted.Inc()
+
+	return true
+}
+
+func (s *RTSPServer) attachStream(client *RTSPClient) error {
+	if client.stream != nil {
+		return nil
+	}
+
+	// Playbacks aren't shared, every client seeks on its own
+	if !client.playbackStart.IsZero() {
+		client.stream = s.createPlaybackStream(client)
+		client.stream.AddClient(client)
+		return nil
+	}
+
 	// Create or get existing stream
-	stream, err := s.getOrCreateStream(camera, streamResolution, user)
+	stream, err := s.getOrCreateStream(client.camera, client.resolution, client.user)
 	if err != nil {
-		core.Logger.Error().Err(err).Msgf("Failed to create stream for camera %s", camera.DeviceName)
-		sendRTSPResponse(conn, 500, "Internal Server Error", nil, "Failed to create stream")
-		return
+		return err
 	}
 
-	// Create RTSP client
-	client := &RTSPClient{
-		conn:                conn,
-		reader:              reader,
-		session:             session,
-		cameraPath:          cameraPath,
-		stream:              stream,
-		transportMode:       TransportUDP, // Default to UDP
-		videoRTPPort:        0,
-		audioRTPPort:        0,
-		backAudioRTPPort:    0,
-		videoRTPChannel:     0,
-		audioRTPChannel:     2,
-		backAudioRTPChannel: 4,
-		setupCount:          0,
-	}
-
-	// Add client to server and stream
-	s.addClient(client)
+	client.stream = stream
 	stream.AddClient(client)
 
-	// Handle initial request
-	s.handleRTSPMethod(client, request)
-
-	// Handle further requests
-	s.handleRTSPProtocol(client)
+	return nil
 }
 
 func (s *RTSPServer) findCamera(path string) (*storage.CameraInfo, *storage.UserSession, error) {
@@ -319,12 +654,16 @@ func (s *RTSPServer) getOrCreateStream(camera *storage.CameraInfo, streamResolut
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
+	streamResolution = cameraResolution(camera, streamResolution)
+
 	// Check if stream already exists
 	streamId := fmt.Sprintf("%s-%s", camera.DeviceID, streamResolution)
 	if stream, exists := s.streams[streamId]; exists {
-		if stream.active || stream.connecting {
+		if active, connecting := stream.state(); active || connecting {
 			core.Logger.Trace().Msgf("Reusing existing stream for camera: %s", camera.DeviceName)
+			stream.mutex.Lock()
 			stream.lastActivity = time.Now()
+			stream.mutex.Unlock()
 			return stream, nil
 		}
 	}
@@ -332,21 +671,7 @@ func (s *RTSPServer) getOrCreateStream(camera *storage.CameraInfo, streamResolut
 	// Create new stream
 	stream := NewCameraStream(camera, streamResolution, user, s.storageManager, s)
 	stream.connecting = true
-
-	stream.webrtcBridge.OnError = func(err error) {
-		if stream.active || stream.connecting {
-			core.Logger.Error().Err(err).Msgf("WebRTC error for camera %s", camera.DeviceName)
-
-			// Only stop if no clients are connected
-			stream.mutex.Lock()
-			clientCount := len(stream.clients)
-			stream.mutex.Unlock()
-
-			if clientCount == 0 {
-				stream.stopStreamInternal()
-			}
-		}
-	}
+	stream.forwarder.SetGOPCacheSize(s.config.gopCacheSize(camera))
 
 	s.streams[streamId] = stream
 
@@ -401,7 +726,7 @@ func (s *RTSPServer) printAvailableEndpoints() error {
 		json.Unmarshal([]byte(camera.Skill), &skill)
 
 		supportClarity := skill != nil && (skill.WebRTC&(1<<5)) != 0
-		baseUrl := fmt.Sprintf("rtsp://localhost:%d%s", s.port, camera.RTSPPath)
+		baseUrl := fmt.Sprintf("rtsp://localhost:%d%s", s.config.Port, camera.RTSPPath)
 
 		if supportClarity {
 			core.Logger.Info().Msgf("  %s/hd (%s)", baseUrl, camera.DeviceName)
@@ -435,7 +760,11 @@ func (s *RTSPServer) cleanupInactiveStreams() {
 	now := time.Now()
 	for deviceID, stream := range s.streams {
 		// Remove streams inactive for more than 5 minutes
-		if now.Sub(stream.lastActivity) > 5*time.Minute && len(stream.clients) == 0 {
+		stream.mutex.RLock()
+		idle := now.Sub(stream.lastActivity) > 5*time.Minute && stream.watchers() == 0 && !stream.pinned
+		stream.mutex.RUnlock()
+
+		if idle {
 			core.Logger.Trace().Msgf("Cleaning up inactive stream for camera: %s", stream.camera.DeviceName)
 			stream.Stop()
 			delete(s.streams, deviceID)
@@ -445,22 +774,37 @@ func (s *RTSPServer) cleanupInactiveStreams() {
 
 func NewCameraStream(camera *storage.CameraInfo, resolution string, user *storage.UserSession, storageManager *storage.StorageManager, server *RTSPServer) *CameraStream {
 	stream := &CameraStream{
-		camera:        camera,
-		resolution:    resolution,
-		user:          user,
-		clients:       make(map[string]*RTSPClient),
-		active:        false,
-		lastActivity:  time.Now(),
-		shutdownDelay: 5 * time.Second,
-		server:        server,
-		streamId:      fmt.Sprintf("%s-%s", camera.DeviceID, resolution),
+		camera:         camera,
+		resolution:     resolution,
+		user:           user,
+		storageManager: storageManager,
+		forwarder:      NewRTPForwarder(),
+		clients:        make(map[string]*RTSPClient),
+		viewers:        make(map[string]struct{}),
+		active:         false,
+		lastActivity:   time.Now(),
+		done:           make(chan struct{}),
+		restart:        make(chan struct{}, 1),
+		shutdownDelay:  5 * time.Second,
+		server:         server,
+		streamId:       fmt.Sprintf("%s-%s", camera.DeviceID, resolution),
 	}
 
-	stream.webrtcBridge = NewWebRTCBridge(camera, resolution, user, storageManager)
+	stream.forwarder.OnBackchannelAudio = func(packet *rtp.Packet) {
+		stream.bridge().ForwardBackchannelAudioPacket(packet)
+	}
+
+	stream.webrtcBridge = NewWebRTCBridge(camera, resolution, user, storageManager, stream.forwarder)
 
 	return stream
 }
 
+func (cs *CameraStream) bridge() *WebRTCBridge {
+	cs.mutex.RLock()
+	defer cs.mutex.RUnlock()
+	return cs.webrtcBridge
+}
+
 func (cs *CameraStream) AddClient(client *RTSPClient) {
 	cs.mutex.Lock()
 	defer cs.mutex.Unlock()
@@ -472,12 +816,22 @@ func (cs *CameraStream) AddClient(client *RTSPClient) {
 		core.Logger.Trace().Msgf("Cancelled pending shutdown for camera %s - new client connected", cs.camera.DeviceName)
 	}
 
+	if _, exists := cs.clients[client.session]; !exists {
+		activeClients.Inc(cs.camera.RTSPPath)
+	}
+
 	cs.clients[client.session] = client
 	cs.lastActivity = time.Now()
 
-	// Start stream if not active
-	if !cs.active {
-		go cs.startStream()
+	// Start stream if not running
+	cs.startSupervisor()
+}
+
+// startSupervisor must be called with the mutex held
+func (cs *CameraStream) startSupervisor() {
+	if !cs.supervising && !cs.stopped {
+		cs.supervising = true
+		go cs.run()
 	}
 }
 
@@ -486,19 +840,40 @@ func (cs *CameraStream) RemoveClient(sessionID string) {
 	defer cs.mutex.Unlock()
 
 	// Remove from RTP forwarder
-	if cs.webrtcBridge != nil && cs.webrtcBridge.rtpForwarder != nil {
-		cs.webrtcBridge.rtpForwarder.RemoveClient(sessionID)
+	cs.forwarder.RemoveClient(sessionID)
+
+	if _, exists := cs.clients[sessionID]; exists {
+		activeClients.Dec(cs.camera.RTSPPath)
 	}
 
 	delete(cs.clients, sessionID)
 	cs.lastActivity = time.Now()
 
+	// A playback ends with its client
+	if cs.playback != nil && cs.watchers() == 0 {
+		cs.stopStreamInternal()
+		return
+	}
+
 	// Schedule stream shutdown if no clients and stream is active
-	if len(cs.clients) == 0 && cs.active {
+	if cs.watchers() == 0 && cs.active {
 		cs.scheduleShutdown()
 	}
 }
 
+// WaitMediaInfo waits until the bridge has seen the parameter sets of the stream,
+// returns nil if no video arrived within the timeout
+func (cs *CameraStream) WaitMediaInfo(timeout time.Duration) *MediaInfo {
+	return cs.bridge().media.wait(timeout)
+}
+
+// Reconnects returns how often the upstream connection was re-established
+func (cs *CameraStream) Reconnects() int {
+	cs.mutex.RLock()
+	defer cs.mutex.RUnlock()
+	return cs.reconnects
+}
+
 func (cs *CameraStream) SetShutdownDelay(delay time.Duration) {
 	cs.mutex.Lock()
 	defer cs.mutex.Unlock()
@@ -515,24 +890,140 @@ func (cs *CameraStream) Stop() {
 	cs.stopStream()
 }
 
-func (cs *CameraStream) startStream() {
-	cs.mutex.Lock()
-	defer cs.mutex.Unlock()
+const (
+	reconnectMinDelay = 1 * time.Second
+	reconnectMaxDelay = 30 * time.Second
+)
 
-	if cs.active {
-		return
-	}
+// run keeps the upstream connection alive. When the bridge fails while clients
+// are connected a new one is started with exponential backoff, the forwarder
+// keeps the clients' RTP sequence continuous across bridges.
+func (cs *CameraStream) run() {
+	delay := reconnectMinDelay
 
-	core.Logger.Info().Msgf("Starting stream for camera: %s", cs.camera.DeviceName)
+	for attempt := 0; ; attempt++ {
+		cs.mutex.Lock()
+		if cs.stopped {
+			cs.mutex.Unlock()
+			return
+		}
 
-	if err := cs.webrtcBridge.Start(); err != nil {
-		core.Logger.Error().Err(err).Msg("Failed to start WebRTC bridge")
-		cs.stopStreamInternal()
-		return
-	}
+		bridge := cs.webrtcBridge
+		if attempt > 0 {
+			// A stopped bridge can't be restarted, fetch a fresh WebRTC config and MQTT session
+			bridge = NewWebRTCBridge(cs.camera, cs.resolution, cs.user, cs.storageManager, cs.forwarder)
+			cs.webrtcBridge = bridge
+		}
 
-	cs.connecting = false
-	cs.active = true
+		paused := false
+		if cs.playback != nil {
+			bridge.replayStart = cs.playback.start
+			paused = cs.playback.paused
+		}
+		cs.mutex.Unlock()
+
+		// A paused playback has no upstream until it's played again
+		if paused {
+			select {
+			case <-cs.restart:
+				continue
+			case <-cs.done:
+				return
+			}
+		}
+
+		failed := make(chan error, 1)
+		bridge.OnError = func(err error) {
+			select {
+			case failed <- err:
+			default:
+			}
+		}
+
+		core.Logger.Info().Msgf("Starting stream for camera: %s", cs.camera.DeviceName)
+
+		err := bridge.Start()
+		if err == nil {
+			cs.mutex.Lock()
+			cs.connecting = false
+			cs.active = true
+			if cs.playback != nil {
+				cs.playback.since = time.Now()
+			}
+			if cs.watchers() == 0 {
+				cs.scheduleShutdown()
+			}
+			cs.mutex.Unlock()
+
+			delay = reconnectMinDelay
+
+			select {
+			case err = <-failed:
+				core.Logger.Warn().Err(err).Msgf("Lost connection to camera %s", cs.camera.DeviceName)
+			case <-cs.restart:
+				// Seek or pause of a playback, the position is already set
+				bridge.Stop()
+				cs.mutex.Lock()
+				cs.active = false
+				cs.connecting = true
+				cs.mutex.Unlock()
+				cs.forwarder.Discontinuity()
+				continue
+			case <-cs.done:
+				bridge.Stop()
+				return
+			}
+		} else {
+			core.Logger.Error().Err(err).Msgf("Failed to start WebRTC bridge for camera %s", cs.camera.DeviceName)
+		}
+
+		bridge.Stop()
+
+		cs.mutex.Lock()
+		cs.active = false
+		cs.connecting = true
+		if cs.playback != nil {
+			cs.playback.hold()
+		}
+		clientCount := cs.watchers()
+		pinned := cs.pinned
+		cs.mutex.Unlock()
+
+		// Nobody is watching, no need to reconnect
+		if clientCount == 0 && !pinned {
+			cs.stopStream()
+			return
+		}
+
+		// Packets of the next bridge continue the clients' sequences
+		cs.forwarder.Discontinuity()
+
+		core.Logger.Warn().Msgf("Reconnecting camera %s in %v (attempt %d, %d client(s) waiting)",
+			cs.camera.DeviceName, delay, attempt+1, clientCount)
+
+		select {
+		case <-time.After(delay):
+		case <-cs.done:
+			return
+		}
+
+		cs.mutex.Lock()
+		clientCount = cs.watchers()
+		pinned = cs.pinned
+		cs.reconnects++
+		cs.mutex.Unlock()
+
+		if clientCount == 0 && !pinned {
+			cs.stopStream()
+			return
+		}
+
+		if cs.server != nil {
+			cs.server.reconnects.Add(1)
+		}
+
+		delay = min(delay*2, reconnectMaxDelay)
+	}
 }
 
 func (cs *CameraStream) stopStream() {
@@ -543,11 +1034,12 @@ func (cs *CameraStream) stopStream() {
 
 func (cs *CameraStream) stopStreamInternal() {
 	// Check if we should actually stop
-	if !cs.active && !cs.connecting {
+	if cs.stopped {
 		return
 	}
 
 	wasActive := cs.active
+	cs.stopped = true
 	cs.active = false
 	cs.connecting = false
 
@@ -562,11 +1054,15 @@ func (cs *CameraStream) stopStreamInternal() {
 		core.Logger.Info().Msgf("Stopping stream for camera: %s", cs.camera.DeviceName)
 	}
 
-	// Stop WebRTC bridge
-	if cs.webrtcBridge != nil {
-		cs.webrtcBridge.Stop()
+	// The supervisor stops the bridge it runs, otherwise it was never started
+	close(cs.done)
+	if !cs.supervising {
+		go cs.webrtcBridge.Stop()
 	}
 
+	// Stop RTP forwarder
+	cs.forwarder.Stop()
+
 	// Remove from server map in a separate goroutine to avoid potential deadlock
 	go func() {
 		if cs.server != nil {
@@ -575,9 +1071,22 @@ func (cs *CameraStream) stopStreamInternal() {
 	}()
 }
 
+// state returns whether the upstream is connected and whether it is being
+// connected, the supervisor changes both under the stream's mutex
+func (cs *CameraStream) state() (active, connecting bool) {
+	cs.mutex.RLock()
+	defer cs.mutex.RUnlock()
+	return cs.active, cs.connecting
+}
+
+func (cs *CameraStream) isActive() bool {
+	active, _ := cs.state()
+	return active
+}
+
 func (cs *CameraStream) scheduleShutdown() {
-	// Don't schedule if we're not active
-	if !cs.active {
+	// Don't schedule if we're not active or kept running on request
+	if !cs.active || cs.pinned {
 		return
 	}
 
@@ -593,7 +1102,7 @@ func (cs *CameraStream) scheduleShutdown() {
 		defer cs.mutex.Unlock()
 
 		// Double-check no clients connected during the delay and stream is still active
-		if len(cs.clients) == 0 && cs.active {
+		if cs.watchers() == 0 && cs.active {
 			core.Logger.Info().Msgf("Executing delayed shutdown for camera %s", cs.camera.DeviceName)
 			cs.stopStreamInternal()
 		}
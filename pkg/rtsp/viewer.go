@@ -0,0 +1,152 @@
+package rtsp
+
+import (
+	"errors"
+	"time"
+
+	"github.com/pion/rtp"
+
+	"tuya-ipc-terminal/pkg/core"
+	"tuya-ipc-terminal/pkg/storage"
+)
+
+// Viewer receives the camera's RTP in process, e.g. for HLS or WebRTC output.
+// WriteRTP is called with the forwarder locked, it must not block and must not
+// modify or keep the packet.
+type Viewer interface {
+	WriteRTP(packet *rtp.Packet, video bool)
+
+	// Close is called when the stream stops while the viewer is attached
+	Close()
+}
+
+var ErrStreamStopped = errors.New("stream stopped")
+
+// AddViewer attaches a viewer, starting with the cached GOP
+func (rf *RTPForwarder) AddViewer(id string, viewer Viewer) {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+
+	// Holding the write lock keeps live packets out until the replay is done
+	for _, p := range rf.gop.snapshot() {
+		var packet rtp.Packet
+		if err := packet.Unmarshal(p.data); err != nil {
+			continue
+		}
+
+		// The cached header may carry another client's values
+		packet.SSRC, packet.SequenceNumber, packet.Timestamp = p.ssrc, p.sequence, p.timestamp
+		viewer.WriteRTP(&packet, p.video)
+	}
+
+	rf.viewers[id] = viewer
+}
+
+func (rf *RTPForwarder) RemoveViewer(id string) {
+	rf.mutex.Lock()
+	delete(rf.viewers, id)
+	rf.mutex.Unlock()
+}
+
+// closeViewers detaches and closes all viewers
+func (rf *RTPForwarder) closeViewers() {
+	rf.mutex.Lock()
+	viewers := rf.viewers
+	rf.viewers = make(map[string]Viewer)
+	rf.mutex.Unlock()
+
+	for _, viewer := range viewers {
+		viewer.Close()
+	}
+}
+
+// AddViewer attaches an in-process viewer, which keeps the stream running like an RTSP client
+func (cs *CameraStream) AddViewer(id string, viewer Viewer) error {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	if cs.stopped {
+		return ErrStreamStopped
+	}
+
+	if cs.shutdownTimer != nil {
+		cs.shutdownTimer.Stop()
+		cs.shutdownTimer = nil
+	}
+
+	cs.forwarder.AddViewer(id, viewer)
+	cs.viewers[id] = struct{}{}
+	cs.lastActivity = time.Now()
+
+	core.Logger.Trace().Msgf("Viewer %s attached to camera %s", id, cs.camera.DeviceName)
+
+	cs.startSupervisor()
+
+	return nil
+}
+
+func (cs *CameraStream) RemoveViewer(id string) {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	if _, exists := cs.viewers[id]; !exists {
+		return
+	}
+
+	cs.forwarder.RemoveViewer(id)
+	delete(cs.viewers, id)
+
+	core.Logger.Trace().Msgf("Viewer %s detached from camera %s", id, cs.camera.DeviceName)
+
+	if cs.watchers() == 0 && cs.active {
+		cs.scheduleShutdown()
+	}
+}
+
+// watchers returns the number of RTSP clients and viewers, must be called with the mutex held
+func (cs *CameraStream) watchers() int {
+	return len(cs.clients) + len(cs.viewers)
+}
+
+// OpenStream returns the running stream of a camera or starts a new one. The
+// stream is stopped again when no client or viewer attaches.
+func (s *RTSPServer) OpenStream(cameraPath, resolution string) (*CameraStream, error) {
+	camera, user, err := s.findCamera(cameraPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if camera == nil {
+		return nil, ErrCameraNotFound
+	}
+
+	return s.getOrCreateStream(camera, resolution, user)
+}
+
+// ID returns the stream ID, device ID and resolution
+func (cs *CameraStream) ID() string {
+	return cs.streamId
+}
+
+func (cs *CameraStream) Camera() *storage.CameraInfo {
+	return cs.camera
+}
+
+// AttachViewer opens the camera's stream and attaches the viewer to it. A
+// stream that is just shutting down is replaced by a fresh one.
+func (s *RTSPServer) AttachViewer(cameraPath, resolution, id string, viewer Viewer) (*CameraStream, error) {
+	for attempt := 0; ; attempt++ {
+		stream, err := s.OpenStream(cameraPath, resolution)
+		if err != nil {
+			return nil, err
+		}
+
+		err = stream.AddViewer(id, viewer)
+		if err == nil {
+			return stream, nil
+		}
+		if !errors.Is(err, ErrStreamStopped) || attempt > 0 {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,348 @@
+// Package session keeps the Tuya sessions of a running server alive and
+// reports the ones that need a new login
+package session
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"tuya-ipc-terminal/pkg/core"
+	"tuya-ipc-terminal/pkg/discovery"
+	"tuya-ipc-terminal/pkg/events"
+	"tuya-ipc-terminal/pkg/metrics"
+	"tuya-ipc-terminal/pkg/storage"
+	"tuya-ipc-terminal/pkg/tuya"
+)
+
+const (
+	DefaultCheckInterval = 30 * time.Minute
+	DefaultWarnBefore    = 24 * time.Hour
+)
+
+var (
+	sessionValid = metrics.NewGauge("tuya_ipc_session_valid",
+		"Whether the Tuya session of an account works, 0 needs a new login", "user")
+	sessionExpiry = metrics.NewGauge("tuya_ipc_session_expiry_timestamp_seconds",
+		"When the cookies of an account's session expire", "user")
+	sessionChecks = metrics.NewCounter("tuya_ipc_session_checks_total",
+		"Session checks by result", "result")
+	sessionRelogins = metrics.NewCounter("tuya_ipc_session_relogins_total",
+		"Logins with stored passwords after a session expired, by result", "result")
+)
+
+var errNoCredentials = errors.New("no stored password")
+
+type State string
+
+const (
+	StateValid    State = "valid"
+	StateExpiring State = "expiring" // Expires within WarnBefore
+	StateExpired  State = "expired"  // Needs a new login
+	StateUnknown  State = "unknown"  // Not checked yet or the API wasn't reachable
+)
+
+type Config struct {
+	CheckInterval time.Duration
+	WarnBefore    time.Duration
+}
+
+type Status struct {
+	UserKey   string     `json:"userKey"`
+	Email     string     `json:"email"`
+	Region    string     `json:"region"`
+	State     State      `json:"state"`
+	Expires   *time.Time `json:"expires,omitempty"` // Nil if the cookies don't tell
+	LastCheck *time.Time `json:"lastCheck,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// Keeper calls the API with every session regularly, which keeps it warm,
+// and saves cookies the server rotates. Rejected sessions with a stored
+// password are replaced by a new login, others and sessions that expire soon
+// are logged and published on the bus.
+type Keeper struct {
+	storageManager *storage.StorageManager
+	bus            *events.Bus
+	config         Config
+
+	statuses map[string]*Status // User key -> status
+	mutex    sync.Mutex
+
+	rejected map[string]string // User key -> stored password the API rejected
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewKeeper creates a keeper, bus may be nil
+func NewKeeper(storageManager *storage.StorageManager, bus *events.Bus, config Config) *Keeper {
+	if config.CheckInterval <= 0 {
+		config.CheckInterval = DefaultCheckInterval
+	}
+	if config.WarnBefore <= 0 {
+		config.WarnBefore = DefaultWarnBefore
+	}
+
+	return &Keeper{
+		storageManager: storageManager,
+		bus:            bus,
+		config:         config,
+		statuses:       make(map[string]*Status),
+		rejected:       make(map[string]string),
+		stop:           make(chan struct{}),
+		done:           make(chan struct{}),
+	}
+}
+
+func (k *Keeper) Start() {
+	go k.run()
+}
+
+func (k *Keeper) Close() {
+	close(k.stop)
+	<-k.done
+}
+
+// Status returns the sessions as of their last check
+func (k *Keeper) Status() []Status {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	statuses := make([]Status, 0, len(k.statuses))
+	for _, status := range k.statuses {
+		statuses = append(statuses, *status)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].UserKey < statuses[j].UserKey })
+	return statuses
+}
+
+func (k *Keeper) run() {
+	defer close(k.done)
+
+	ticker := time.NewTicker(k.config.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		k.checkAll()
+
+		select {
+		case <-k.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (k *Keeper) checkAll() {
+	// Sessions are read again, 'auth refresh' may have replaced them
+	users, err := k.storageManager.ListUsers()
+	if err != nil {
+		core.Logger.Error().Err(err).Msg("Session keeper failed to list users")
+		return
+	}
+
+	for _, user := range users {
+		select {
+		case <-k.stop:
+			return
+		default:
+		}
+
+		k.update(&user, k.check(&user))
+	}
+}
+
+// check calls the API with the session and saves rotated cookies
+func (k *Keeper) check(user *storage.UserSession) Status {
+	now := time.Now()
+	status := Status{
+		UserKey:   user.UserKey,
+		Email:     user.Email,
+		Region:    user.Region,
+		State:     StateUnknown,
+		LastCheck: &now,
+	}
+
+	session := user.SessionData
+	if session == nil {
+		status.State = StateExpired
+		status.Error = "no session data"
+		return status
+	}
+
+	httpClient := discovery.NewHTTPClient(session)
+	if httpClient == nil {
+		status.Error = "failed to create HTTP client"
+		return status
+	}
+
+	if _, err := tuya.GetAppInfo(httpClient, session.ServerHost); err != nil {
+		status.Error = err.Error()
+		status.Expires = expiry(session)
+		if !errors.Is(err, tuya.ErrSessionInvalid) {
+			return status
+		}
+
+		status.State = StateExpired
+		newSession, err := k.relogin(user)
+		if err != nil {
+			if !errors.Is(err, errNoCredentials) {
+				status.Error = err.Error()
+			}
+			return status
+		}
+
+		status.Error = ""
+		return k.sessionState(status, newSession)
+	}
+
+	if jar, ok := httpClient.Jar.(*tuya.CookieJar); ok && jar.Changed() {
+		session.Cookies = jar.SessionCookies()
+		session.LastValidated = time.Now()
+
+		if err := k.storageManager.SaveUser(user.Region, user.Email, session); err != nil {
+			core.Logger.Error().Err(err).Msgf("Failed to save the rotated session of %s", user.UserKey)
+		} else {
+			core.Logger.Debug().Msgf("Saved rotated session cookies of %s", user.UserKey)
+		}
+	}
+
+	return k.sessionState(status, session)
+}
+
+// sessionState returns the status of a working session
+func (k *Keeper) sessionState(status Status, session *tuya.SessionData) Status {
+	status.Expires = expiry(session)
+	status.State = StateValid
+	if status.Expires != nil && time.Until(*status.Expires) < k.config.WarnBefore {
+		status.State = StateExpiring
+	}
+
+	return status
+}
+
+// expiry returns when the session expires, nil if unknown
+func expiry(session *tuya.SessionData) *time.Time {
+	expires := session.Expires()
+	if expires.IsZero() {
+		return nil
+	}
+	return &expires
+}
+
+// relogin logs in with the stored password of the user and saves the new
+// session. A rejected password isn't tried again until it was changed.
+func (k *Keeper) relogin(user *storage.UserSession) (*tuya.SessionData, error) {
+	credentials := user.Credentials
+	if credentials == nil {
+		return nil, errNoCredentials
+	}
+
+	if k.rejected[user.UserKey] == credentials.Password {
+		return nil, errors.New("stored password was rejected, log in again")
+	}
+
+	serverHost := user.SessionData.ServerHost
+	httpClient := discovery.NewHTTPClient(nil)
+	if httpClient == nil {
+		return nil, fmt.Errorf("failed to create HTTP client")
+	}
+
+	loginResult, err := tuya.PasswordLogin(httpClient, serverHost, user.Email, credentials.Password, credentials.CountryCode)
+	if err != nil {
+		sessionRelogins.Inc("failed")
+		if errors.Is(err, tuya.ErrLoginRejected) {
+			k.rejected[user.UserKey] = credentials.Password
+		}
+		return nil, fmt.Errorf("failed to log in with the stored password: %v", err)
+	}
+
+	session := &tuya.SessionData{
+		LoginResult:   loginResult,
+		LastValidated: time.Now(),
+		ServerHost:    serverHost,
+		Region:        user.SessionData.Region,
+		UserEmail:     loginResult.Email,
+	}
+	if jar, ok := httpClient.Jar.(*tuya.CookieJar); ok {
+		session.Cookies = jar.SessionCookies()
+	}
+
+	if err := k.storageManager.SaveUser(user.Region, user.Email, session); err != nil {
+		sessionRelogins.Inc("failed")
+		return nil, fmt.Errorf("failed to save the new session: %v", err)
+	}
+
+	sessionRelogins.Inc("success")
+	delete(k.rejected, user.UserKey)
+	core.Logger.Info().Msgf("Logged in again as %s with the stored password", user.UserKey)
+
+	return session, nil
+}
+
+// update stores the status and reports state changes
+func (k *Keeper) update(user *storage.UserSession, status Status) {
+	sessionChecks.Inc(string(status.State))
+
+	k.mutex.Lock()
+	previous := k.statuses[user.UserKey]
+	if status.State == StateUnknown && previous != nil {
+		// A failed check doesn't change what's known about the session
+		status.State = previous.State
+		status.Expires = previous.Expires
+	}
+	k.statuses[user.UserKey] = &status
+	k.mutex.Unlock()
+
+	if status.Error != "" && status.State != StateExpired {
+		core.Logger.Warn().Msgf("Failed to check the session of %s: %s", user.UserKey, status.Error)
+	}
+
+	if status.Expires != nil {
+		sessionExpiry.Set(float64(status.Expires.Unix()), user.UserKey)
+	}
+
+	switch status.State {
+	case StateValid, StateExpiring:
+		sessionValid.Set(1, user.UserKey)
+	case StateExpired:
+		sessionValid.Set(0, user.UserKey)
+	case StateUnknown:
+		return
+	}
+
+	if previous != nil && previous.State == status.State {
+		return
+	}
+
+	switch status.State {
+	case StateExpired:
+		core.Logger.Error().Msgf("The session of %s is no longer valid (%s), log in again with: tuya-ipc-terminal auth refresh %s %s",
+			user.UserKey, status.Error, user.Region, user.Email)
+		k.publish(tuya.EventSessionExpired, user)
+	case StateExpiring:
+		core.Logger.Warn().Msgf("The session of %s expires at %s, log in again with: tuya-ipc-terminal auth refresh %s %s",
+			user.UserKey, status.Expires.Local().Format(time.DateTime), user.Region, user.Email)
+		k.publish(tuya.EventSessionExpiring, user)
+	case StateValid:
+		if previous != nil && previous.State != StateUnknown {
+			core.Logger.Info().Msgf("The session of %s is valid again", user.UserKey)
+		}
+	}
+}
+
+func (k *Keeper) publish(eventType tuya.EventType, user *storage.UserSession) {
+	if k.bus == nil {
+		return
+	}
+
+	k.bus.Publish(events.Event{
+		Type: eventType,
+		User: user.UserKey,
+		Time: time.Now(),
+	})
+}
@@ -0,0 +1,130 @@
+// Package snapshot makes JPEG images of camera streams. There's no decoder in
+// process, the first keyframe is passed to ffmpeg.
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/pion/rtp"
+
+	"tuya-ipc-terminal/pkg/mp4"
+	"tuya-ipc-terminal/pkg/rtsp"
+	"tuya-ipc-terminal/pkg/utils"
+)
+
+const (
+	DefaultFFmpeg  = "ffmpeg"
+	DefaultTimeout = 20 * time.Second
+
+	packetQueueSize = 512
+)
+
+type Config struct {
+	FFmpeg  string        // Command, default "ffmpeg"
+	Timeout time.Duration // For the camera to send a keyframe and ffmpeg to decode it
+}
+
+// Grab attaches to the camera's stream, which connects it if nobody watches,
+// and returns its next keyframe as JPEG
+func Grab(ctx context.Context, rtspServer *rtsp.RTSPServer, cameraPath, resolution string, config Config) ([]byte, error) {
+	if config.FFmpeg == "" {
+		config.FFmpeg = DefaultFFmpeg
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, config.Timeout)
+	defer cancel()
+
+	id := "snapshot-" + utils.RandString(8, 16)
+	v := &viewer{packets: make(chan *rtp.Packet, packetQueueSize), detached: make(chan struct{})}
+
+	stream, err := rtspServer.AttachViewer(cameraPath, resolution, id, v)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.RemoveViewer(id)
+
+	info := stream.WaitMediaInfo(config.Timeout)
+	if info == nil {
+		return nil, errors.New("no video from camera")
+	}
+
+	var keyframe []byte
+	sampler := mp4.NewVideoSampler(info.HEVC, 1, info.VPS, info.SPS, info.PPS, func(track *mp4.Track, sample mp4.Sample) {
+		if keyframe == nil && sample.Keyframe {
+			fragment := mp4.Fragment(1, mp4.TrackFragment{TrackID: track.ID, Samples: []mp4.Sample{sample}})
+			keyframe = append(mp4.InitSegment(track), fragment...)
+		}
+	})
+
+	for keyframe == nil {
+		select {
+		case packet := <-v.packets:
+			sampler.Push(packet)
+		case <-v.detached:
+			return nil, errors.New("stream stopped")
+		case <-ctx.Done():
+			return nil, errors.New("no keyframe from camera")
+		}
+	}
+
+	// The camera isn't needed for decoding
+	stream.RemoveViewer(id)
+
+	return decode(ctx, config.FFmpeg, keyframe)
+}
+
+// decode converts the first frame of an MP4 to JPEG
+func decode(ctx context.Context, ffmpeg string, data []byte) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, ffmpeg, "-hide_banner", "-loglevel", "error",
+		"-f", "mp4", "-i", "pipe:0", "-frames:v", "1", "-c:v", "mjpeg", "-f", "image2", "pipe:1")
+	cmd.Stdin = bytes.NewReader(data)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	image, err := cmd.Output()
+	if err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, fmt.Errorf("%s failed: %v: %s", ffmpeg, err, message)
+		}
+		return nil, fmt.Errorf("%s failed: %v", ffmpeg, err)
+	}
+	if len(image) == 0 {
+		return nil, fmt.Errorf("%s returned no image", ffmpeg)
+	}
+
+	return image, nil
+}
+
+// viewer queues copies of the video packets, it never blocks the stream
+type viewer struct {
+	packets  chan *rtp.Packet
+	detached chan struct{}
+}
+
+func (v *viewer) WriteRTP(packet *rtp.Packet, video bool) {
+	if !video {
+		return
+	}
+
+	clone := *packet
+	clone.Payload = append([]byte(nil), packet.Payload...)
+
+	select {
+	case v.packets <- &clone:
+	default:
+	}
+}
+
+func (v *viewer) Close() {
+	close(v.detached)
+}
@@ -0,0 +1,196 @@
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	encryptionAlgorithm = "aes-256-gcm"
+	encryptionKDF       = "scrypt"
+
+	// Known text sealed with the key to detect wrong keys
+	encryptionCheck = "tuya-ipc-terminal"
+)
+
+var (
+	ErrEncrypted    = errors.New("sessions are encrypted, set the storage key with --storage-key-file, --storage-passphrase or $TUYA_IPC_STORAGE_KEY")
+	ErrWrongKey     = errors.New("wrong storage key")
+	ErrNotEncrypted = errors.New("storage encryption isn't enabled")
+)
+
+// encryptionParams derive the key from the secret, the same for all files
+type encryptionParams struct {
+	KDF   string `json:"kdf"`
+	Salt  []byte `json:"salt"`
+	N     int    `json:"n"`
+	R     int    `json:"r"`
+	P     int    `json:"p"`
+	Check []byte `json:"check"` // encryptionCheck sealed with the key
+}
+
+// encryptedFile replaces the JSON of an encrypted file
+type encryptedFile struct {
+	Encrypted string `json:"encrypted"` // Algorithm
+	Nonce     []byte `json:"nonce"`
+	Data      []byte `json:"data"`
+}
+
+func (sm *StorageManager) getEncryptionPath() string {
+	return filepath.Join(sm.dataDir, "encryption.json")
+}
+
+// EnableEncryption derives the key from a passphrase or key file content,
+// user sessions are encrypted from then on. The first call creates the salt,
+// later calls fail with ErrWrongKey for other secrets.
+func (sm *StorageManager) EnableEncryption(secret []byte) error {
+	if len(secret) == 0 {
+		return errors.New("empty storage key")
+	}
+
+	// Two processes must not create different salts
+	unlock, err := sm.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	params, err := sm.getEncryptionParams()
+	if err != nil {
+		return err
+	}
+
+	key, err := scrypt.Key(secret, params.Salt, params.N, params.R, params.P, 32)
+	if err != nil {
+		return fmt.Errorf("failed to derive storage key: %v", err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+
+	if params.Check == nil {
+		params.Check, err = seal(aead, []byte(encryptionCheck))
+		if err != nil {
+			return err
+		}
+		if err := sm.saveEncryptionParams(params); err != nil {
+			return err
+		}
+	} else if check, err := open(aead, params.Check); err != nil || string(check) != encryptionCheck {
+		return ErrWrongKey
+	}
+
+	sm.aead = aead
+	return nil
+}
+
+// Encrypted reports whether sessions are saved encrypted
+func (sm *StorageManager) Encrypted() bool {
+	return sm.aead != nil
+}
+
+func (sm *StorageManager) getEncryptionParams() (*encryptionParams, error) {
+	data, err := os.ReadFile(sm.getEncryptionPath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		return &encryptionParams{KDF: encryptionKDF, Salt: salt, N: 1 << 15, R: 8, P: 1}, nil
+	}
+
+	var params encryptionParams
+	if err := json.Unmarshal(data, &params); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", sm.getEncryptionPath(), err)
+	}
+
+	if params.KDF != encryptionKDF {
+		return nil, fmt.Errorf("unsupported key derivation: %s", params.KDF)
+	}
+
+	return &params, nil
+}
+
+func (sm *StorageManager) saveEncryptionParams(params *encryptionParams) error {
+	data, err := json.MarshalIndent(params, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFile(sm.getEncryptionPath(), data, 0600)
+}
+
+// encrypt returns data sealed in an encryptedFile
+func (sm *StorageManager) encrypt(data []byte) ([]byte, error) {
+	sealed, err := seal(sm.aead, data)
+	if err != nil {
+		return nil, err
+	}
+
+	nonceSize := sm.aead.NonceSize()
+	return json.MarshalIndent(encryptedFile{
+		Encrypted: encryptionAlgorithm,
+		Nonce:     sealed[:nonceSize],
+		Data:      sealed[nonceSize:],
+	}, "", "  ")
+}
+
+// decrypt opens an encryptedFile, other data is returned as is
+func (sm *StorageManager) decrypt(data []byte) ([]byte, bool, error) {
+	var file encryptedFile
+	if err := json.Unmarshal(data, &file); err != nil || file.Encrypted == "" {
+		return data, false, nil
+	}
+
+	if file.Encrypted != encryptionAlgorithm {
+		return nil, true, fmt.Errorf("unsupported encryption: %s", file.Encrypted)
+	}
+
+	if sm.aead == nil {
+		return nil, true, ErrEncrypted
+	}
+
+	plaintext, err := open(sm.aead, append(file.Nonce, file.Data...))
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to decrypt: %v", err)
+	}
+
+	return plaintext, true, nil
+}
+
+// seal returns the random nonce followed by the ciphertext
+func seal(aead cipher.AEAD, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(aead cipher.AEAD, sealed []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+
+	nonceSize := aead.NonceSize()
+	return aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+}
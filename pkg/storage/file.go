@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Locked while files of the data directory are read and written back
+const lockFileName = "storage.lock"
+
+// writeFile replaces the file atomically, readers see the old or the new
+// content but never a partial write
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := file.Name()
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpPath, perm)
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// lock serializes changes of the data directory between goroutines and
+// processes, the returned function unlocks it
+func (sm *StorageManager) lock() (func(), error) {
+	sm.mutex.Lock()
+
+	file, err := os.OpenFile(filepath.Join(sm.dataDir, lockFileName), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		sm.mutex.Unlock()
+		return nil, fmt.Errorf("failed to open lock file: %v", err)
+	}
+
+	if err := lockFile(file); err != nil {
+		file.Close()
+		sm.mutex.Unlock()
+		return nil, fmt.Errorf("failed to lock %s: %v", sm.dataDir, err)
+	}
+
+	return func() {
+		unlockFile(file)
+		file.Close()
+		sm.mutex.Unlock()
+	}, nil
+}
@@ -1,11 +1,14 @@
 package storage
 
 import (
+	"crypto/cipher"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 	"tuya-ipc-terminal/pkg/tuya"
 )
@@ -16,6 +19,13 @@ type UserSession struct {
 	SessionData *tuya.SessionData `json:"sessionData"`
 	LastRefresh time.Time         `json:"lastRefresh"`
 	UserKey     string            `json:"userKey"`
+	Credentials *Credentials      `json:"credentials,omitempty"` // Only in encrypted files
+}
+
+// Credentials of a password login, kept to log in again when the session dies
+type Credentials struct {
+	Password    string `json:"password"`
+	CountryCode string `json:"countryCode"`
 }
 
 type CameraInfo struct {
@@ -27,24 +37,44 @@ type CameraInfo struct {
 	ProductID  string `json:"productId"`
 	UUID       string `json:"uuid"`
 	Skill      string `json:"skill"`
+	Home       string `json:"home,omitempty"`
+	Room       string `json:"room,omitempty"`
+	Alias      string `json:"alias,omitempty"` // Path set with 'cameras alias', kept by refreshes
+
+	// Earlier paths, newest first, recordings under them are moved
+	PreviousPaths []string `json:"previousPaths,omitempty"`
+
+	// Set by overrides, not stored
+	Resolution string `json:"resolution,omitempty"` // Stream of the path without /hd or /sd
+	NoAudio    bool   `json:"noAudio,omitempty"`
 }
 
+// CameraOverride changes how a camera is served without changing the
+// registry, keyed by device ID, RTSP path or device name
+type CameraOverride struct {
+	Path       string // RTSP path alias
+	Resolution string
+	Disabled   bool
+	NoAudio    bool
+}
+
+const maxPreviousPaths = 5
+
 type CameraRegistry struct {
 	Cameras     []CameraInfo `json:"cameras"`
 	LastUpdated time.Time    `json:"lastUpdated"`
 }
 
 type StorageManager struct {
-	dataDir string
+	dataDir      string
+	aead         cipher.AEAD // Encrypts user sessions if set
+	overrides    map[string]CameraOverride
+	pathTemplate string
+	cache        *registryCache // Set while the registry is watched
+	mutex        sync.Mutex     // Held with the lock file
 }
 
-func NewStorageManager() (*StorageManager, error) {
-	cwd, err := os.Getwd()
-	if err != nil {
-		return nil, err
-	}
-
-	dataDir := filepath.Join(cwd, ".tuya-data")
+func NewStorageManager(dataDir string) (*StorageManager, error) {
 	if err := os.MkdirAll(dataDir, 0700); err != nil {
 		return nil, err
 	}
@@ -80,31 +110,152 @@ func (sm *StorageManager) ListUsers() ([]UserSession, error) {
 	}
 
 	var users []UserSession
+	for _, file := range files {
+		user, err := sm.readUserFile(file)
+		if errors.Is(err, ErrEncrypted) {
+			return nil, err
+		}
+		if err != nil {
+			continue // Skip files that can't be read or parsed
+		}
+
+		users = append(users, *user)
+	}
+
+	return users, nil
+}
+
+func (sm *StorageManager) GetUser(region, email string) (*UserSession, error) {
+	user, err := sm.readUserFile(sm.getUserFilePath(region, email))
+	if os.IsNotExist(err) {
+		return nil, nil // User not found
+	}
+	return user, err
+}
+
+// SaveUser saves a new session of the user, stored credentials are kept
+func (sm *StorageManager) SaveUser(region, email string, sessionData *tuya.SessionData) error {
+	unlock, err := sm.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	existing, err := sm.GetUser(region, email)
+	if errors.Is(err, ErrEncrypted) {
+		return err
+	}
+
+	user := UserSession{
+		Region:      region,
+		Email:       email,
+		SessionData: sessionData,
+		LastRefresh: time.Now(),
+		UserKey:     userKey(region, email),
+	}
+	if existing != nil {
+		user.Credentials = existing.Credentials
+	}
+
+	return sm.writeUserFile(sm.getUserFilePath(region, email), &user, sm.Encrypted())
+}
+
+// SetUserCredentials stores the password of the user in its encrypted
+// session file, nil removes it
+func (sm *StorageManager) SetUserCredentials(region, email string, credentials *Credentials) error {
+	if credentials != nil && !sm.Encrypted() {
+		return ErrNotEncrypted
+	}
+
+	unlock, err := sm.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	user, err := sm.GetUser(region, email)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return fmt.Errorf("user %s in region %s not found", email, region)
+	}
+
+	user.Credentials = credentials
+	return sm.writeUserFile(sm.getUserFilePath(region, email), user, sm.Encrypted())
+}
+
+// MigrateUsers encrypts or decrypts the session files in place and returns
+// how many were changed. Decrypting removes stored credentials and the
+// passwords kept for RTSP users.
+func (sm *StorageManager) MigrateUsers(encrypt bool) (int, error) {
+	if !sm.Encrypted() {
+		return 0, ErrNotEncrypted
+	}
+
+	unlock, err := sm.lock()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	files, err := filepath.Glob(filepath.Join(sm.dataDir, "user_*.json"))
+	if err != nil {
+		return 0, err
+	}
+
+	migrated := 0
 	for _, file := range files {
 		data, err := os.ReadFile(file)
 		if err != nil {
-			continue // Skip files that can't be read
+			return migrated, err
+		}
+
+		data, encrypted, err := sm.decrypt(data)
+		if err != nil {
+			return migrated, fmt.Errorf("%s: %v", filepath.Base(file), err)
+		}
+		if encrypted == encrypt {
+			continue
 		}
 
 		var user UserSession
 		if err := json.Unmarshal(data, &user); err != nil {
-			continue // Skip files that can't be parsed
+			return migrated, fmt.Errorf("%s: %v", filepath.Base(file), err)
+		}
+
+		if !encrypt {
+			user.Credentials = nil
 		}
 
-		users = append(users, user)
+		if err := sm.writeUserFile(file, &user, encrypt); err != nil {
+			return migrated, err
+		}
+		migrated++
 	}
 
-	return users, nil
-}
+	if !encrypt {
+		if err := sm.clearRTSPPasswords(); err != nil {
+			return migrated, err
+		}
 
-func (sm *StorageManager) GetUser(region, email string) (*UserSession, error) {
-	filePath := sm.getUserFilePath(region, email)
+		// A new salt is created when encryption is enabled again
+		if err := os.Remove(sm.getEncryptionPath()); err != nil && !os.IsNotExist(err) {
+			return migrated, err
+		}
+	}
 
+	return migrated, nil
+}
+
+func (sm *StorageManager) readUserFile(filePath string) (*UserSession, error) {
 	data, err := os.ReadFile(filePath)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, nil // User not found
-		}
+		return nil, err
+	}
+
+	data, _, err = sm.decrypt(data)
+	if err != nil {
 		return nil, err
 	}
 
@@ -116,13 +267,9 @@ func (sm *StorageManager) GetUser(region, email string) (*UserSession, error) {
 	return &user, nil
 }
 
-func (sm *StorageManager) SaveUser(region, email string, sessionData *tuya.SessionData) error {
-	user := UserSession{
-		Region:      region,
-		Email:       email,
-		SessionData: sessionData,
-		LastRefresh: time.Now(),
-		UserKey:     userKey(region, email),
+func (sm *StorageManager) writeUserFile(filePath string, user *UserSession, encrypt bool) error {
+	if user.Credentials != nil && !encrypt {
+		return ErrNotEncrypted
 	}
 
 	data, err := json.MarshalIndent(user, "", "  ")
@@ -130,11 +277,22 @@ func (sm *StorageManager) SaveUser(region, email string, sessionData *tuya.Sessi
 		return err
 	}
 
-	filePath := sm.getUserFilePath(region, email)
-	return os.WriteFile(filePath, data, 0600)
+	if encrypt {
+		if data, err = sm.encrypt(data); err != nil {
+			return err
+		}
+	}
+
+	return writeFile(filePath, data, 0600)
 }
 
 func (sm *StorageManager) RemoveUser(region, email string) error {
+	unlock, err := sm.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	filePath := sm.getUserFilePath(region, email)
 
 	if _, err := os.Stat(filePath); err == nil {
@@ -146,7 +304,16 @@ func (sm *StorageManager) RemoveUser(region, email string) error {
 	return sm.removeCamerasForUser(userKey(region, email))
 }
 
+// GetCameraRegistry reads the registry, or returns a copy of the cached one
+// while it is watched
 func (sm *StorageManager) GetCameraRegistry() (*CameraRegistry, error) {
+	if sm.cache != nil {
+		return sm.cache.get(sm.readCameraRegistry)
+	}
+	return sm.readCameraRegistry()
+}
+
+func (sm *StorageManager) readCameraRegistry() (*CameraRegistry, error) {
 	filePath := sm.getCameraRegistryPath()
 
 	data, err := os.ReadFile(filePath)
@@ -169,40 +336,208 @@ func (sm *StorageManager) GetCameraRegistry() (*CameraRegistry, error) {
 }
 
 func (sm *StorageManager) SaveCameraRegistry(registry *CameraRegistry) error {
+	unlock, err := sm.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return sm.saveCameraRegistry(registry)
+}
+
+// saveCameraRegistry must be called with the lock held
+func (sm *StorageManager) saveCameraRegistry(registry *CameraRegistry) error {
 	registry.LastUpdated = time.Now()
 
+	renamed := make(map[string]string)
+	if previous, err := sm.readCameraRegistry(); err == nil {
+		renamed = trackPathChanges(previous.Cameras, registry.Cameras)
+	}
+
 	data, err := json.MarshalIndent(registry, "", "  ")
 	if err != nil {
 		return err
 	}
 
-	filePath := sm.getCameraRegistryPath()
-	return os.WriteFile(filePath, data, 0600)
+	if err := writeFile(sm.getCameraRegistryPath(), data, 0600); err != nil {
+		return err
+	}
+
+	// Don't wait for the notification to read it again
+	sm.cache.invalidate()
+
+	if len(renamed) > 0 {
+		return sm.renameRecordedCameras(renamed)
+	}
+	return nil
+}
+
+// trackPathChanges adds the old path to the previous paths of every camera
+// whose path changed and returns the changes
+func trackPathChanges(previous, cameras []CameraInfo) map[string]string {
+	paths := make(map[string]string)
+	for _, camera := range previous {
+		paths[camera.UserKey+"/"+camera.DeviceID] = camera.RTSPPath
+	}
+
+	renamed := make(map[string]string)
+	for i := range cameras {
+		camera := &cameras[i]
+		old := paths[camera.UserKey+"/"+camera.DeviceID]
+		if old == "" || old == camera.RTSPPath {
+			continue
+		}
+
+		previousPaths := []string{old}
+		for _, path := range camera.PreviousPaths {
+			if path != old && path != camera.RTSPPath && len(previousPaths) < maxPreviousPaths {
+				previousPaths = append(previousPaths, path)
+			}
+		}
+		camera.PreviousPaths = previousPaths
+		renamed[old] = camera.RTSPPath
+	}
+
+	return renamed
 }
 
+// UpdateCamerasForUser replaces the cameras of the user. Known cameras keep
+// their path and alias, new ones get a path from the template.
 func (sm *StorageManager) UpdateCamerasForUser(userKey string, cameras []CameraInfo) error {
-	registry, err := sm.GetCameraRegistry()
+	unlock, err := sm.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	registry, err := sm.readCameraRegistry()
 	if err != nil {
 		return err
 	}
 
 	// Remove existing cameras for this user
+	previous := make(map[string]CameraInfo)
 	var newCameras []CameraInfo
 	for _, cam := range registry.Cameras {
 		if cam.UserKey != userKey {
 			newCameras = append(newCameras, cam)
+		} else {
+			previous[cam.DeviceID] = cam
 		}
 	}
 
 	// Add new cameras
-	newCameras = append(newCameras, cameras...)
+	for _, cam := range cameras {
+		cam.RTSPPath, cam.Alias = "", ""
+		if known, ok := previous[cam.DeviceID]; ok {
+			cam.RTSPPath, cam.Alias, cam.PreviousPaths = known.RTSPPath, known.Alias, known.PreviousPaths
+		}
+		newCameras = append(newCameras, cam)
+	}
+
+	sm.assignPaths(newCameras)
 	registry.Cameras = newCameras
 
-	return sm.SaveCameraRegistry(registry)
+	return sm.saveCameraRegistry(registry)
 }
 
+// ResetCameraPaths gives all cameras without alias a new path from the
+// template and returns how many changed
+func (sm *StorageManager) ResetCameraPaths() (int, error) {
+	unlock, err := sm.lock()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	registry, err := sm.readCameraRegistry()
+	if err != nil {
+		return 0, err
+	}
+
+	paths := make([]string, len(registry.Cameras))
+	for i := range registry.Cameras {
+		paths[i] = registry.Cameras[i].RTSPPath
+		if registry.Cameras[i].Alias == "" {
+			registry.Cameras[i].RTSPPath = ""
+		}
+	}
+
+	sm.assignPaths(registry.Cameras)
+
+	changed := 0
+	for i, camera := range registry.Cameras {
+		if camera.RTSPPath != paths[i] {
+			changed++
+		}
+	}
+	if changed == 0 {
+		return 0, nil
+	}
+
+	return changed, sm.saveCameraRegistry(registry)
+}
+
+// SetCameraAlias sets the path of a camera given by device ID, RTSP path or
+// name. An empty alias removes it, the camera gets a path from the template.
+func (sm *StorageManager) SetCameraAlias(camera, alias string) (*CameraInfo, error) {
+	if alias != "" {
+		if err := ValidatePath(alias); err != nil {
+			return nil, err
+		}
+		alias = CleanPath(alias)
+	}
+
+	unlock, err := sm.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	registry, err := sm.readCameraRegistry()
+	if err != nil {
+		return nil, err
+	}
+
+	var target *CameraInfo
+	for i := range registry.Cameras {
+		cam := &registry.Cameras[i]
+		if cam.DeviceID != camera && cam.RTSPPath != CleanPath(camera) && cam.DeviceName != camera {
+			continue
+		}
+		if target != nil {
+			return nil, fmt.Errorf("%s matches several cameras, use the device ID", camera)
+		}
+		target = cam
+	}
+	if target == nil {
+		return nil, fmt.Errorf("camera not found: %s", camera)
+	}
+
+	if alias != "" {
+		for _, other := range registry.Cameras {
+			if other.RTSPPath == alias && other.DeviceID != target.DeviceID {
+				return nil, fmt.Errorf("%s is already the path of %s", alias, other.DeviceName)
+			}
+		}
+		for key, override := range sm.overrides {
+			if override.Path != "" && CleanPath(override.Path) == alias {
+				return nil, fmt.Errorf("%s is the path of %s in the config", alias, key)
+			}
+		}
+	}
+
+	target.Alias = alias
+	target.RTSPPath = ""
+	sm.assignPaths(registry.Cameras)
+
+	updated := *target
+	return &updated, sm.saveCameraRegistry(registry)
+}
+
+// removeCamerasForUser must be called with the lock held
 func (sm *StorageManager) removeCamerasForUser(userKey string) error {
-	registry, err := sm.GetCameraRegistry()
+	registry, err := sm.readCameraRegistry()
 	if err != nil {
 		return err
 	}
@@ -215,17 +550,17 @@ func (sm *StorageManager) removeCamerasForUser(userKey string) error {
 	}
 
 	registry.Cameras = newCameras
-	return sm.SaveCameraRegistry(registry)
+	return sm.saveCameraRegistry(registry)
 }
 
 func (sm *StorageManager) GetCamerasForUser(userKey string) ([]CameraInfo, error) {
-	registry, err := sm.GetCameraRegistry()
+	cameras, err := sm.GetAllCameras()
 	if err != nil {
 		return nil, err
 	}
 
 	var userCameras []CameraInfo
-	for _, cam := range registry.Cameras {
+	for _, cam := range cameras {
 		if cam.UserKey == userKey {
 			userCameras = append(userCameras, cam)
 		}
@@ -234,27 +569,52 @@ func (sm *StorageManager) GetCamerasForUser(userKey string) ([]CameraInfo, error
 	return userCameras, nil
 }
 
+// GetAllCameras returns the enabled cameras with their overrides
 func (sm *StorageManager) GetAllCameras() ([]CameraInfo, error) {
 	registry, err := sm.GetCameraRegistry()
 	if err != nil {
 		return nil, err
 	}
 
-	return registry.Cameras, nil
-}
+	if len(sm.overrides) == 0 {
+		return registry.Cameras, nil
+	}
 
-func (sm *StorageManager) GenerateRTSPPath(deviceName, deviceID string) string {
-	// Clean device name for URL safety
-	safeName := strings.ReplaceAll(deviceName, " ", "_")
-	safeName = strings.ReplaceAll(safeName, "/", "_")
-	safeName = strings.ReplaceAll(safeName, "\\", "_")
+	cameras := make([]CameraInfo, 0, len(registry.Cameras))
+	for _, camera := range registry.Cameras {
+		override, ok := sm.cameraOverride(&camera)
+		if !ok {
+			cameras = append(cameras, camera)
+			continue
+		}
+
+		if override.Disabled {
+			continue
+		}
+		if override.Path != "" {
+			camera.RTSPPath = CleanPath(override.Path)
+		}
+		camera.Resolution = override.Resolution
+		camera.NoAudio = override.NoAudio
 
-	// If name is empty or too generic, use device ID
-	if safeName == "" || safeName == "_" {
-		safeName = deviceID
+		cameras = append(cameras, camera)
 	}
 
-	return fmt.Sprintf("/%s", safeName)
+	return cameras, nil
+}
+
+// SetCameraOverrides applies the overrides to the cameras returned from now on
+func (sm *StorageManager) SetCameraOverrides(overrides map[string]CameraOverride) {
+	sm.overrides = overrides
+}
+
+func (sm *StorageManager) cameraOverride(camera *CameraInfo) (CameraOverride, bool) {
+	for _, key := range []string{camera.DeviceID, camera.RTSPPath, strings.TrimPrefix(camera.RTSPPath, "/"), camera.DeviceName} {
+		if override, ok := sm.overrides[key]; ok {
+			return override, true
+		}
+	}
+	return CameraOverride{}, false
 }
 
 func (sm *StorageManager) ValidateUserSession(region, email string) (bool, error) {
@@ -267,8 +627,15 @@ func (sm *StorageManager) ValidateUserSession(region, email string) (bool, error
 		return false, nil
 	}
 
-	// Check if session is older than 4 days
-	// It seems the cookie expires after 4 days
+	if user.SessionData == nil {
+		return false, nil
+	}
+
+	if expires := user.SessionData.Expires(); !expires.IsZero() {
+		return time.Now().Before(expires), nil
+	}
+
+	// Sessions saved without cookie expiry seem to expire after 4 days
 	if time.Since(user.LastRefresh) > 4*24*time.Hour {
 		return false, nil
 	}
@@ -0,0 +1,239 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// DefaultPathTemplate names paths like older versions, e.g. /Front_Door
+const DefaultPathTemplate = "/{name}"
+
+var (
+	pathPlaceholder  = regexp.MustCompile(`\{[^}]*\}`)
+	pathPlaceholders = map[string]bool{"{name}": true, "{home}": true, "{room}": true, "{id}": true}
+
+	// Letters NFKD doesn't decompose
+	pathReplacer = strings.NewReplacer("ß", "ss", "Æ", "AE", "æ", "ae", "Ø", "O", "ø", "o", "Œ", "OE", "œ", "oe",
+		"Ł", "L", "ł", "l", "Đ", "D", "đ", "d", "Þ", "TH", "þ", "th")
+)
+
+// Last path elements that select a stream instead of a camera
+var reservedPathElements = map[string]bool{"hd": true, "sd": true, "playback": true}
+
+// ValidatePathTemplate checks that a template like /{home}/{room}/{name} only
+// uses known placeholders and URL-safe characters
+func ValidatePathTemplate(template string) error {
+	for _, placeholder := range pathPlaceholder.FindAllString(template, -1) {
+		if !pathPlaceholders[placeholder] {
+			return fmt.Errorf("unknown placeholder %s, use {name}, {home}, {room} or {id}", placeholder)
+		}
+	}
+
+	if !strings.Contains(template, "{name}") && !strings.Contains(template, "{id}") {
+		return errors.New("template needs {name} or {id}")
+	}
+
+	literal := pathPlaceholder.ReplaceAllString(template, "")
+	for _, r := range literal {
+		if r != '/' && !isPathChar(r) {
+			return fmt.Errorf("%q can't be used in paths", r)
+		}
+	}
+
+	return nil
+}
+
+// SetPathTemplate sets the template of the paths of new cameras, empty for
+// DefaultPathTemplate
+func (sm *StorageManager) SetPathTemplate(template string) {
+	sm.pathTemplate = template
+}
+
+// NormalizePathElement makes a name URL-safe, accents are removed and other
+// characters replaced by _, e.g. "Küche Süd" becomes "Kuche_Sud"
+func NormalizePathElement(name string) string {
+	name = pathReplacer.Replace(name)
+	name, _, _ = transform.String(transform.Chain(norm.NFKD, runes.Remove(runes.In(unicode.Mn))), name)
+
+	var builder strings.Builder
+	separator := false
+	for _, r := range name {
+		if isPathChar(r) {
+			builder.WriteRune(r)
+			separator = false
+		} else if !separator {
+			builder.WriteRune('_')
+			separator = true
+		}
+	}
+
+	return strings.Trim(builder.String(), "_.-")
+}
+
+func isPathChar(r rune) bool {
+	return r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_' || r == '.')
+}
+
+// CleanPath returns the path with a leading slash and without a trailing one
+func CleanPath(path string) string {
+	return "/" + strings.Trim(path, "/")
+}
+
+// ValidatePath checks a user-defined path, the last element must not select
+// a stream
+func ValidatePath(path string) error {
+	path = CleanPath(path)
+	if path == "/" {
+		return errors.New("empty path")
+	}
+
+	elements := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	for _, element := range elements {
+		if element == "" || element == "." || element == ".." {
+			return fmt.Errorf("invalid path %s", path)
+		}
+		for _, r := range element {
+			if !isPathChar(r) {
+				return fmt.Errorf("%q can't be used in paths", r)
+			}
+		}
+	}
+
+	if reservedPathElements[elements[len(elements)-1]] {
+		return fmt.Errorf("path %s would select a stream, /hd, /sd and /playback are reserved", path)
+	}
+
+	return nil
+}
+
+// templatePath returns the path of the camera from the template, elements of
+// empty values are left out
+func (sm *StorageManager) templatePath(camera *CameraInfo) string {
+	template := sm.pathTemplate
+	if template == "" {
+		template = DefaultPathTemplate
+	}
+
+	name := NormalizePathElement(camera.DeviceName)
+	if name == "" {
+		name = camera.DeviceID
+	}
+	values := map[string]string{
+		"{name}": name,
+		"{home}": NormalizePathElement(camera.Home),
+		"{room}": NormalizePathElement(camera.Room),
+		"{id}":   camera.DeviceID,
+	}
+
+	var elements []string
+	for _, element := range strings.Split(strings.Trim(template, "/"), "/") {
+		element = pathPlaceholder.ReplaceAllStringFunc(element, func(placeholder string) string {
+			return values[placeholder]
+		})
+		if element = strings.Trim(element, "_.-"); element != "" {
+			elements = append(elements, element)
+		}
+	}
+
+	if len(elements) == 0 {
+		elements = []string{camera.DeviceID}
+	}
+	if last := len(elements) - 1; reservedPathElements[elements[last]] {
+		elements[last] += "_" + shortDeviceID(camera.DeviceID)
+	}
+
+	return "/" + strings.Join(elements, "/")
+}
+
+func shortDeviceID(deviceID string) string {
+	if len(deviceID) > 4 {
+		deviceID = deviceID[len(deviceID)-4:]
+	}
+	return strings.ToLower(deviceID)
+}
+
+// assignPaths gives every camera a unique path. Aliases come first, then
+// stored paths, then new ones from the template. Each group is handled in
+// device ID order so the result doesn't depend on the order of discovery, and
+// colliding paths get the end of the device ID as suffix.
+func (sm *StorageManager) assignPaths(cameras []CameraInfo) {
+	order := make([]int, len(cameras))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := &cameras[order[i]], &cameras[order[j]]
+		if a.DeviceID != b.DeviceID {
+			return a.DeviceID < b.DeviceID
+		}
+		return a.UserKey < b.UserKey
+	})
+
+	taken := make(map[string]*CameraInfo)
+	// Paths of the config belong to their cameras
+	for _, override := range sm.overrides {
+		if override.Path != "" {
+			taken[CleanPath(override.Path)] = nil
+		}
+	}
+
+	free := func(path string, camera *CameraInfo) bool {
+		owner, exists := taken[path]
+		if !exists {
+			return true
+		}
+		if owner != nil {
+			return false
+		}
+		override, ok := sm.cameraOverride(camera)
+		return ok && CleanPath(override.Path) == path
+	}
+
+	// Stored paths aren't validated, older versions allowed any character
+	claim := func(camera *CameraInfo, path string) bool {
+		if !free(path, camera) {
+			return false
+		}
+		camera.RTSPPath = path
+		taken[path] = camera
+		return true
+	}
+
+	var unassigned []*CameraInfo
+	for _, aliases := range []bool{true, false} {
+		for _, i := range order {
+			camera := &cameras[i]
+			if (camera.Alias != "") != aliases {
+				continue
+			}
+
+			path := camera.RTSPPath
+			if aliases {
+				path = CleanPath(camera.Alias)
+			}
+			if path == "" || !claim(camera, path) {
+				unassigned = append(unassigned, camera)
+			}
+		}
+	}
+
+	for _, camera := range unassigned {
+		path := sm.templatePath(camera)
+		if claim(camera, path) {
+			continue
+		}
+
+		path += "_" + shortDeviceID(camera.DeviceID)
+		for n := 2; !claim(camera, path); n++ {
+			path = fmt.Sprintf("%s_%s_%d", sm.templatePath(camera), shortDeviceID(camera.DeviceID), n)
+		}
+	}
+}
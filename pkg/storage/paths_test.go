@@ -0,0 +1,259 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNormalizePathElement(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"FrontDoor", "FrontDoor"},
+		{"Front Door", "Front_Door"},
+		{"Küche Süd", "Kuche_Sud"},
+		{"Straße", "Strasse"},
+		{"Łódź", "Lodz"},
+		{"  Garage / Tor  ", "Garage_Tor"},
+		{"Cam #1 (Hof)", "Cam_1_Hof"},
+		{"-_.Garden._-", "Garden"},
+		{"café-2.0", "cafe-2.0"},
+		{"客厅", ""},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		if got := NormalizePathElement(test.name); got != test.want {
+			t.Errorf("NormalizePathElement(%q) = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestValidatePathTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		valid    bool
+	}{
+		{"/{name}", true},
+		{"/{id}", true},
+		{"/{home}/{room}/{name}", true},
+		{"/cams/{room}-{name}", true},
+		{"{name}", true},
+		{"/{home}/{room}", false},
+		{"/{name}/{floor}", false},
+		{"/{Name}", false},
+		{"/{name} cam", false},
+		{"/{name}?x", false},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		if err := ValidatePathTemplate(test.template); (err == nil) != test.valid {
+			t.Errorf("ValidatePathTemplate(%q) = %v, want valid %v", test.template, err, test.valid)
+		}
+	}
+}
+
+func TestValidatePath(t *testing.T) {
+	tests := []struct {
+		path  string
+		valid bool
+	}{
+		{"/front", true},
+		{"front/", true},
+		{"/Home/Garden", true},
+		{"/", false},
+		{"/Home//Garden", false},
+		{"/Home/../Garden", false},
+		{"/front door", false},
+		{"/front/hd", false},
+		{"/sd", false},
+		{"/playback", false},
+		{"/hd/front", true},
+	}
+
+	for _, test := range tests {
+		if err := ValidatePath(test.path); (err == nil) != test.valid {
+			t.Errorf("ValidatePath(%q) = %v, want valid %v", test.path, err, test.valid)
+		}
+	}
+}
+
+func TestAssignPaths(t *testing.T) {
+	tests := []struct {
+		name      string
+		template  string
+		overrides map[string]CameraOverride
+		cameras   []CameraInfo
+		want      []string
+	}{
+		{
+			name: "name template",
+			cameras: []CameraInfo{
+				{DeviceID: "aaa1111", DeviceName: "Front Door"},
+				{DeviceID: "bbb2222", DeviceName: "Küche"},
+			},
+			want: []string{"/Front_Door", "/Kuche"},
+		},
+		{
+			name: "collisions get the end of the device ID",
+			cameras: []CameraInfo{
+				{DeviceID: "bbb2222", DeviceName: "Garden"},
+				{DeviceID: "aaa1111", DeviceName: "Garden"},
+				{DeviceID: "ccc3333", DeviceName: "Garden"},
+			},
+			want: []string{"/Garden_2222", "/Garden", "/Garden_3333"},
+		},
+		{
+			name: "same device of two accounts",
+			cameras: []CameraInfo{
+				{UserKey: "eu_b", DeviceID: "AAA1111", DeviceName: "Garden"},
+				{UserKey: "eu_a", DeviceID: "AAA1111", DeviceName: "Garden"},
+				{UserKey: "eu_c", DeviceID: "AAA1111", DeviceName: "Garden"},
+			},
+			want: []string{"/Garden_1111", "/Garden", "/Garden_1111_2"},
+		},
+		{
+			name: "stored paths are kept",
+			cameras: []CameraInfo{
+				{DeviceID: "aaa1111", DeviceName: "Garden", RTSPPath: "/Garden_1111"},
+				{DeviceID: "bbb2222", DeviceName: "Garden", RTSPPath: "/Garden"},
+				{DeviceID: "ccc3333", DeviceName: "Renamed", RTSPPath: "/Old Name"},
+			},
+			want: []string{"/Garden_1111", "/Garden", "/Old Name"},
+		},
+		{
+			name: "aliases take precedence over stored paths",
+			cameras: []CameraInfo{
+				{DeviceID: "aaa1111", DeviceName: "Garden", RTSPPath: "/front"},
+				{DeviceID: "bbb2222", DeviceName: "Door", RTSPPath: "/Door", Alias: "front"},
+			},
+			want: []string{"/Garden", "/front"},
+		},
+		{
+			name: "colliding aliases",
+			cameras: []CameraInfo{
+				{DeviceID: "bbb2222", DeviceName: "Door", Alias: "/front"},
+				{DeviceID: "aaa1111", DeviceName: "Garden", Alias: "/front"},
+			},
+			want: []string{"/Door", "/front"},
+		},
+		{
+			name: "config paths are reserved",
+			overrides: map[string]CameraOverride{
+				"ccc3333": {Path: "/Garden"},
+			},
+			cameras: []CameraInfo{
+				{DeviceID: "aaa1111", DeviceName: "Garden"},
+				{DeviceID: "ccc3333", DeviceName: "Yard", RTSPPath: "/Garden"},
+			},
+			want: []string{"/Garden_1111", "/Garden"},
+		},
+		{
+			name:     "home and room template",
+			template: "/{home}/{room}/{name}",
+			cameras: []CameraInfo{
+				{DeviceID: "aaa1111", DeviceName: "Camera", Home: "My Home", Room: "Living Room"},
+				{DeviceID: "bbb2222", DeviceName: "Camera", Home: "My Home", Room: "Living Room"},
+				{DeviceID: "ccc3333", DeviceName: "Shared"},
+			},
+			want: []string{"/My_Home/Living_Room/Camera", "/My_Home/Living_Room/Camera_2222", "/Shared"},
+		},
+		{
+			name:     "reserved last element",
+			template: "/{room}/{name}",
+			cameras: []CameraInfo{
+				{DeviceID: "aaa1111", DeviceName: "HD", Room: "Hall"},
+				{DeviceID: "bbb2222", DeviceName: "sd"},
+			},
+			want: []string{"/Hall/HD", "/sd_2222"},
+		},
+		{
+			name:     "names without latin letters",
+			template: "/{name}",
+			cameras: []CameraInfo{
+				{DeviceID: "aaa1111", DeviceName: "客厅"},
+			},
+			want: []string{"/aaa1111"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sm := &StorageManager{pathTemplate: test.template, overrides: test.overrides}
+			sm.assignPaths(test.cameras)
+
+			for i, camera := range test.cameras {
+				if camera.RTSPPath != test.want[i] {
+					t.Errorf("camera %s got %s, want %s", camera.DeviceID, camera.RTSPPath, test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestTrackPathChanges(t *testing.T) {
+	previous := []CameraInfo{
+		{UserKey: "eu_a", DeviceID: "aaa1111", RTSPPath: "/Garden"},
+		{UserKey: "eu_a", DeviceID: "bbb2222", RTSPPath: "/Door"},
+	}
+	cameras := []CameraInfo{
+		{UserKey: "eu_a", DeviceID: "aaa1111", RTSPPath: "/Home/Garden", PreviousPaths: []string{"/Home/Garden", "/Yard"}},
+		{UserKey: "eu_a", DeviceID: "bbb2222", RTSPPath: "/Door"},
+		{UserKey: "eu_a", DeviceID: "ccc3333", RTSPPath: "/New"},
+	}
+
+	renamed := trackPathChanges(previous, cameras)
+
+	if len(renamed) != 1 || renamed["/Garden"] != "/Home/Garden" {
+		t.Errorf("got %v, want /Garden renamed to /Home/Garden", renamed)
+	}
+	if got := cameras[0].PreviousPaths; len(got) != 2 || got[0] != "/Garden" || got[1] != "/Yard" {
+		t.Errorf("got previous paths %v, want [/Garden /Yard]", got)
+	}
+	if cameras[1].PreviousPaths != nil || cameras[2].PreviousPaths != nil {
+		t.Errorf("unchanged cameras got previous paths")
+	}
+}
+
+func TestSaveCameraRegistryRenamesRecordings(t *testing.T) {
+	sm, err := NewStorageManager(filepath.Join(t.TempDir(), "data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	camera := CameraInfo{UserKey: "eu_a", DeviceID: "aaa1111", DeviceName: "Garden", RTSPPath: "/Garden"}
+	if err := sm.SaveCameraRegistry(&CameraRegistry{Cameras: []CameraInfo{camera}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sm.AddRecordedCamera("/Garden", "hd"); err != nil {
+		t.Fatal(err)
+	}
+
+	camera.RTSPPath = "/Home/Garden"
+	if err := sm.SaveCameraRegistry(&CameraRegistry{Cameras: []CameraInfo{camera}}); err != nil {
+		t.Fatal(err)
+	}
+
+	recorded, err := sm.GetRecordedCameras()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recorded) != 1 || recorded[0].Path != "/Home/Garden" {
+		t.Errorf("got recorded cameras %+v, want /Home/Garden", recorded)
+	}
+
+	registry, err := sm.GetCameraRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := registry.Cameras[0].PreviousPaths; len(got) != 1 || got[0] != "/Garden" {
+		t.Errorf("got previous paths %v, want [/Garden]", got)
+	}
+
+	if _, err := os.Stat(filepath.Join(sm.dataDir, "recordings.json")); err != nil {
+		t.Error(err)
+	}
+}
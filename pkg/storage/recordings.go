@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// RecordedCamera is a camera stream recorded continuously by the RTSP server
+type RecordedCamera struct {
+	Path       string    `json:"path"`       // RTSP path, e.g. "/FrontDoor"
+	Resolution string    `json:"resolution"` // "hd" or "sd"
+	AddedAt    time.Time `json:"addedAt"`
+}
+
+type RecordingRegistry struct {
+	Cameras []RecordedCamera `json:"cameras"`
+}
+
+func (sm *StorageManager) getRecordingsPath() string {
+	return filepath.Join(sm.dataDir, "recordings.json")
+}
+
+// GetRecordingsDir returns the default directory recordings are written to
+func (sm *StorageManager) GetRecordingsDir() string {
+	return filepath.Join(sm.dataDir, "recordings")
+}
+
+// GetClipsDir returns the default directory event clips are written to
+func (sm *StorageManager) GetClipsDir() string {
+	return filepath.Join(sm.dataDir, "clips")
+}
+
+func (sm *StorageManager) GetRecordedCameras() ([]RecordedCamera, error) {
+	data, err := os.ReadFile(sm.getRecordingsPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []RecordedCamera{}, nil
+		}
+		return nil, err
+	}
+
+	var registry RecordingRegistry
+	if err := json.Unmarshal(data, &registry); err != nil {
+		return nil, err
+	}
+
+	return registry.Cameras, nil
+}
+
+// AddRecordedCamera returns false if the camera is already recorded
+func (sm *StorageManager) AddRecordedCamera(path, resolution string) (bool, error) {
+	unlock, err := sm.lock()
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	cameras, err := sm.GetRecordedCameras()
+	if err != nil {
+		return false, err
+	}
+
+	for _, camera := range cameras {
+		if camera.Path == path && camera.Resolution == resolution {
+			return false, nil
+		}
+	}
+
+	cameras = append(cameras, RecordedCamera{Path: path, Resolution: resolution, AddedAt: time.Now()})
+
+	return true, sm.saveRecordedCameras(cameras)
+}
+
+// RemoveRecordedCamera returns false if the camera wasn't recorded
+func (sm *StorageManager) RemoveRecordedCamera(path, resolution string) (bool, error) {
+	unlock, err := sm.lock()
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	cameras, err := sm.GetRecordedCameras()
+	if err != nil {
+		return false, err
+	}
+
+	removed := false
+	var newCameras []RecordedCamera
+	for _, camera := range cameras {
+		if camera.Path == path && camera.Resolution == resolution {
+			removed = true
+			continue
+		}
+		newCameras = append(newCameras, camera)
+	}
+
+	if !removed {
+		return false, nil
+	}
+
+	return true, sm.saveRecordedCameras(newCameras)
+}
+
+// renameRecordedCameras follows path changes of the registry, it must be
+// called with the lock held
+func (sm *StorageManager) renameRecordedCameras(renamed map[string]string) error {
+	cameras, err := sm.GetRecordedCameras()
+	if err != nil {
+		return err
+	}
+
+	changed := false
+	for i := range cameras {
+		if path, ok := renamed[cameras[i].Path]; ok {
+			cameras[i].Path = path
+			changed = true
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+	return sm.saveRecordedCameras(cameras)
+}
+
+func (sm *StorageManager) saveRecordedCameras(cameras []RecordedCamera) error {
+	if cameras == nil {
+		cameras = []RecordedCamera{}
+	}
+
+	data, err := json.MarshalIndent(RecordingRegistry{Cameras: cameras}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFile(sm.getRecordingsPath(), data, 0600)
+}
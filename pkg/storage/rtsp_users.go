@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+type RTSPUser struct {
+	Username  string    `json:"username"`
+	HA1       string    `json:"ha1"`   // MD5(username:realm:password)
+	Paths     []string  `json:"paths"` // Allowed RTSP paths, e.g. "/FrontDoor", "/Garden*" or "*"
+	CreatedAt time.Time `json:"createdAt"`
+
+	// Sealed with the storage key if requested, for WS-Security password
+	// digests which can't be checked with HA1
+	Password []byte `json:"password,omitempty"`
+}
+
+type RTSPUserRegistry struct {
+	Users []RTSPUser `json:"users"`
+}
+
+// SealPassword encrypts a password for RTSPUser.Password
+func (sm *StorageManager) SealPassword(password string) ([]byte, error) {
+	if !sm.Encrypted() {
+		return nil, ErrNotEncrypted
+	}
+	return seal(sm.aead, []byte(password))
+}
+
+// RTSPUserPassword decrypts the kept password of the user, empty if it
+// wasn't kept
+func (sm *StorageManager) RTSPUserPassword(user *RTSPUser) (string, error) {
+	if len(user.Password) == 0 {
+		return "", nil
+	}
+	if sm.aead == nil {
+		return "", ErrEncrypted
+	}
+
+	password, err := open(sm.aead, user.Password)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt the password of %s: %v", user.Username, err)
+	}
+	return string(password), nil
+}
+
+// clearRTSPPasswords removes the kept passwords, which can't be opened once
+// the key is gone. Must be called with the lock held.
+func (sm *StorageManager) clearRTSPPasswords() error {
+	users, err := sm.GetRTSPUsers()
+	if err != nil {
+		return err
+	}
+
+	cleared := false
+	for i := range users {
+		if len(users[i].Password) > 0 {
+			users[i].Password = nil
+			cleared = true
+		}
+	}
+	if !cleared {
+		return nil
+	}
+
+	return sm.saveRTSPUsers(users)
+}
+
+// CanAccess reports whether the user may open the given camera path
+func (u *RTSPUser) CanAccess(cameraPath string) bool {
+	for _, pattern := range u.Paths {
+		if pattern == "*" || pattern == cameraPath {
+			return true
+		}
+
+		if matched, err := path.Match(pattern, cameraPath); err == nil && matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (sm *StorageManager) getRTSPUsersPath() string {
+	return filepath.Join(sm.dataDir, "rtsp_users.json")
+}
+
+func (sm *StorageManager) GetRTSPUsers() ([]RTSPUser, error) {
+	data, err := os.ReadFile(sm.getRTSPUsersPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []RTSPUser{}, nil
+		}
+		return nil, err
+	}
+
+	var registry RTSPUserRegistry
+	if err := json.Unmarshal(data, &registry); err != nil {
+		return nil, err
+	}
+
+	return registry.Users, nil
+}
+
+func (sm *StorageManager) GetRTSPUser(username string) (*RTSPUser, error) {
+	users, err := sm.GetRTSPUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, user := range users {
+		if user.Username == username {
+			return &user, nil
+		}
+	}
+
+	return nil, nil // User not found
+}
+
+func (sm *StorageManager) SaveRTSPUser(user RTSPUser) error {
+	unlock, err := sm.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	users, err := sm.GetRTSPUsers()
+	if err != nil {
+		return err
+	}
+
+	// Replace existing user with the same name
+	var newUsers []RTSPUser
+	for _, existing := range users {
+		if existing.Username != user.Username {
+			newUsers = append(newUsers, existing)
+		}
+	}
+
+	newUsers = append(newUsers, user)
+
+	return sm.saveRTSPUsers(newUsers)
+}
+
+func (sm *StorageManager) RemoveRTSPUser(username string) (bool, error) {
+	unlock, err := sm.lock()
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	users, err := sm.GetRTSPUsers()
+	if err != nil {
+		return false, err
+	}
+
+	removed := false
+	var newUsers []RTSPUser
+	for _, user := range users {
+		if user.Username == username {
+			removed = true
+			continue
+		}
+		newUsers = append(newUsers, user)
+	}
+
+	if !removed {
+		return false, nil
+	}
+
+	return true, sm.saveRTSPUsers(newUsers)
+}
+
+func (sm *StorageManager) saveRTSPUsers(users []RTSPUser) error {
+	if users == nil {
+		users = []RTSPUser{}
+	}
+
+	data, err := json.MarshalIndent(RTSPUserRegistry{Users: users}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFile(sm.getRTSPUsersPath(), data, 0600)
+}
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// registryCache keeps the parsed camera registry while the data directory is
+// watched, changes by any process drop it
+type registryCache struct {
+	watcher    *fsnotify.Watcher
+	registry   *CameraRegistry
+	generation uint64 // Incremented by every invalidation
+	closed     bool
+	mutex      sync.Mutex
+}
+
+// WatchCameraRegistry caches the camera registry until its file changes, for
+// long running processes that look up cameras often. Close stops it.
+func (sm *StorageManager) WatchCameraRegistry() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to watch %s: %v", sm.dataDir, err)
+	}
+
+	// The directory is watched, atomic writes replace the file
+	if err := watcher.Add(sm.dataDir); err != nil {
+		watcher.Close()
+		return fmt.Errorf("failed to watch %s: %v", sm.dataDir, err)
+	}
+
+	sm.cache = &registryCache{watcher: watcher}
+	go sm.cache.run(filepath.Base(sm.getCameraRegistryPath()))
+
+	return nil
+}
+
+// Close stops watching the data directory
+func (sm *StorageManager) Close() error {
+	if sm.cache == nil {
+		return nil
+	}
+	return sm.cache.watcher.Close()
+}
+
+func (c *registryCache) run(name string) {
+	defer func() {
+		c.mutex.Lock()
+		c.closed = true
+		c.registry = nil
+		c.mutex.Unlock()
+	}()
+
+	for {
+		select {
+		case event, ok := <-c.watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Base(event.Name) == name {
+				c.invalidate()
+			}
+		case _, ok := <-c.watcher.Errors:
+			if !ok {
+				return
+			}
+			// Events may have been lost
+			c.invalidate()
+		}
+	}
+}
+
+func (c *registryCache) invalidate() {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	c.registry = nil
+	c.generation++
+	c.mutex.Unlock()
+}
+
+// get returns a copy of the cached registry, read loads it if there is none
+func (c *registryCache) get(read func() (*CameraRegistry, error)) (*CameraRegistry, error) {
+	c.mutex.Lock()
+	registry, generation, closed := c.registry, c.generation, c.closed
+	c.mutex.Unlock()
+
+	if registry == nil || closed {
+		var err error
+		if registry, err = read(); err != nil {
+			return nil, err
+		}
+
+		// Not cached if the file changed while it was read
+		c.mutex.Lock()
+		if c.generation == generation && !c.closed {
+			c.registry = registry
+		}
+		c.mutex.Unlock()
+	}
+
+	return &CameraRegistry{
+		Cameras:     slices.Clone(registry.Cameras),
+		LastUpdated: registry.LastUpdated,
+	}, nil
+}
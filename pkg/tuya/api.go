@@ -337,13 +337,15 @@ func PasswordLogin(client *http.Client, serverHost, email, password, countryCode
 	}
 
 	if !loginResp.Success {
-		return nil, errors.New(loginResp.ErrorMsg)
+		return nil, fmt.Errorf("%w: %s", ErrLoginRejected, loginResp.ErrorMsg)
 	}
 
 	return &loginResp.Result, nil
 }
 
-func GetLoginToken(client *http.Client, serverHost, username, countryCode string) (*LoginTokenResponse, error) {
+func GetLoginToken(client *http.Client, serverHost, username, countryCode string) (_ *LoginTokenResponse, err error) {
+	defer observeAPICall("GetLoginToken", time.Now(), &err)
+
 	url := fmt.Sprintf("https://%s/api/login/token", serverHost)
 
 	tokenReq := LoginTokenRequest{
@@ -380,13 +382,15 @@ func GetLoginToken(client *http.Client, serverHost, username, countryCode string
 	}
 
 	if !tokenResp.Success {
-		return nil, err
+		return nil, fmt.Errorf("%w: %s", ErrLoginRejected, tokenResp.Msg)
 	}
 
 	return &tokenResp, nil
 }
 
-func GenerateQRCode(client *http.Client, serverHost string) (string, error) {
+func GenerateQRCode(client *http.Client, serverHost string) (_ string, err error) {
+	defer observeAPICall("GenerateQRCode", time.Now(), &err)
+
 	url := fmt.Sprintf("https://%s/api/login/security/QCtoken", serverHost)
 
 	req, err := http.NewRequest("POST", url, nil)
@@ -427,7 +431,9 @@ func GenerateQRCode(client *http.Client, serverHost string) (string, error) {
 	return qrResponse.Result, nil
 }
 
-func PollForLogin(client *http.Client, serverHost string, token string) (*LoginResult, error) {
+// PollForLogin waits until the QR code of the token was scanned and confirmed
+// in the app, ErrLoginTimeout after the timeout
+func PollForLogin(client *http.Client, serverHost string, token string, timeout time.Duration) (*LoginResult, error) {
 	url := fmt.Sprintf("https://%s/api/login/poll", serverHost)
 
 	data := map[string]string{
@@ -439,8 +445,8 @@ func PollForLogin(client *http.Client, serverHost string, token string) (*LoginR
 		return nil, err
 	}
 
-	maxRetries := 60
-	for i := 0; i < maxRetries; i++ {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
 		req, err := http.NewRequest("POST", url, bytes.NewReader(jsonData))
 		if err != nil {
 			return nil, err
@@ -494,10 +500,12 @@ func PollForLogin(client *http.Client, serverHost string, token string) (*LoginR
 		time.Sleep(1 * time.Second)
 	}
 
-	return nil, errors.New("timeout waiting for QR code scan")
+	return nil, ErrLoginTimeout
 }
 
-func GetAppInfo(client *http.Client, serverHost string) (*AppInfoResponse, error) {
+func GetAppInfo(client *http.Client, serverHost string) (_ *AppInfoResponse, err error) {
+	defer observeAPICall("GetAppInfo", time.Now(), &err)
+
 	url := fmt.Sprintf("https://%s/api/customized/web/app/info", serverHost)
 
 	req, err := http.NewRequest("POST", url, nil)
@@ -532,13 +540,15 @@ func GetAppInfo(client *http.Client, serverHost string) (*AppInfoResponse, error
 	}
 
 	if !appInfoResponse.Success {
-		return nil, errors.New(appInfoResponse.Msg)
+		return nil, fmt.Errorf("%w: %s", ErrSessionInvalid, appInfoResponse.Msg)
 	}
 
 	return &appInfoResponse, nil
 }
 
-func GetMQTTConfig(client *http.Client, serverHost string) (*MQTTConfigResponse, error) {
+func GetMQTTConfig(client *http.Client, serverHost string) (_ *MQTTConfigResponse, err error) {
+	defer observeAPICall("GetMQTTConfig", time.Now(), &err)
+
 	url := fmt.Sprintf("https://%s/api/jarvis/mqtt", serverHost)
 
 	req, err := http.NewRequest("POST", url, strings.NewReader("{}"))
@@ -579,7 +589,9 @@ func GetMQTTConfig(client *http.Client, serverHost string) (*MQTTConfigResponse,
 	return &mqttConfigResponse, nil
 }
 
-func GetWebRTCConfig(client *http.Client, serverHost string, deviceId string) (*WebRTCConfigResponse, error) {
+func GetWebRTCConfig(client *http.Client, serverHost string, deviceId string) (_ *WebRTCConfigResponse, err error) {
+	defer observeAPICall("GetWebRTCConfig", time.Now(), &err)
+
 	url := fmt.Sprintf("https://%s/api/jarvis/config", serverHost)
 
 	data := map[string]string{
@@ -630,7 +642,9 @@ func GetWebRTCConfig(client *http.Client, serverHost string, deviceId string) (*
 	return &webRTCConfigResponse, nil
 }
 
-func GetHomeList(client *http.Client, serverHost string) (*HomeListResponse, error) {
+func GetHomeList(client *http.Client, serverHost string) (_ *HomeListResponse, err error) {
+	defer observeAPICall("GetHomeList", time.Now(), &err)
+
 	url := fmt.Sprintf("https://%s/api/new/common/homeList", serverHost)
 
 	req, err := http.NewRequest("POST", url, nil)
@@ -671,7 +685,9 @@ func GetHomeList(client *http.Client, serverHost string) (*HomeListResponse, err
 	return &homeListResponse, nil
 }
 
-func GetSharedHomeList(client *http.Client, serverHost string) (*SharedHomeListResponse, error) {
+func GetSharedHomeList(client *http.Client, serverHost string) (_ *SharedHomeListResponse, err error) {
+	defer observeAPICall("GetSharedHomeList", time.Now(), &err)
+
 	url := fmt.Sprintf("https://%s/api/new/playback/shareList", serverHost)
 
 	req, err := http.NewRequest("POST", url, nil)
@@ -712,7 +728,9 @@ func GetSharedHomeList(client *http.Client, serverHost string) (*SharedHomeListR
 	return &sharedHomeListResponse, nil
 }
 
-func GetRoomList(client *http.Client, serverHost string, homeId string) (*RoomListResponse, error) {
+func GetRoomList(client *http.Client, serverHost string, homeId string) (_ *RoomListResponse, err error) {
+	defer observeAPICall("GetRoomList", time.Now(), &err)
+
 	url := fmt.Sprintf("https://%s/api/new/common/roomList", serverHost)
 
 	data := map[string]string{
@@ -762,7 +780,9 @@ func GetRoomList(client *http.Client, serverHost string, homeId string) (*RoomLi
 	return &roomListResponse, nil
 }
 
-func performLogin(client *http.Client, url string, loginReq PasswordLoginRequest, serverHost string) (*PasswordLoginResponse, error) {
+func performLogin(client *http.Client, url string, loginReq PasswordLoginRequest, serverHost string) (_ *PasswordLoginResponse, err error) {
+	defer observeAPICall("PasswordLogin", time.Now(), &err)
+
 	jsonData, err := json.Marshal(loginReq)
 	if err != nil {
 		return nil, err
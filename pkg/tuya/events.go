@@ -0,0 +1,203 @@
+package tuya
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"tuya-ipc-terminal/pkg/core"
+)
+
+type EventType string
+
+const (
+	EventMotion   EventType = "motion"
+	EventPerson   EventType = "person"
+	EventDoorbell EventType = "doorbell"
+	EventTamper   EventType = "tamper"
+)
+
+// Account events, a session that needs a new login
+const (
+	EventSessionExpiring EventType = "session_expiring"
+	EventSessionExpired  EventType = "session_expired"
+)
+
+var EventTypes = []EventType{EventMotion, EventPerson, EventDoorbell, EventTamper, EventSessionExpiring, EventSessionExpired}
+
+// Topics of device reports and of the account's alarm messages
+const (
+	deviceEventTopic = "smart/mb/out/%s"
+	alarmEventTopic  = "smart/mb/alarm/%s"
+)
+
+// Data points reporting events, the values are base64 encoded JSON
+const (
+	dpMotionPicture   = "115" // movement_detect_pic
+	dpDoorbellActive  = "136" // doorbell_active
+	dpDoorbellPicture = "154" // doorbell_pic
+	dpAlarmMessage    = "185" // alarm_message
+)
+
+// Alarm commands of alarm_message and alarm topic messages
+var alarmEventTypes = map[string]EventType{
+	"ipc_motion":         EventMotion,
+	"ipc_move":           EventMotion,
+	"ipc_human":          EventPerson,
+	"ipc_person":         EventPerson,
+	"ipc_doorbell":       EventDoorbell,
+	"ipc_bang":           EventDoorbell,
+	"ipc_tamper":         EventTamper,
+	"ipc_antibreak":      EventTamper,
+	"ipc_anti_dismantle": EventTamper,
+}
+
+// DeviceEvent is a motion, person, doorbell or tamper notification of a camera
+type DeviceEvent struct {
+	Type     EventType
+	DeviceID string
+	Time     time.Time
+	DP       string // Data point that reported it, empty for alarm messages
+}
+
+type eventMessage struct {
+	Protocol int             `json:"protocol"`
+	T        int64           `json:"t"`
+	Data     json.RawMessage `json:"data"`
+}
+
+type alarmData struct {
+	DevID string         `json:"devId"`
+	Cmd   string         `json:"cmd"`
+	Type  string         `json:"etype"`
+	DPs   map[string]any `json:"dps"`
+}
+
+func ParseEventType(value string) (EventType, error) {
+	for _, eventType := range EventTypes {
+		if string(eventType) == value {
+			return eventType, nil
+		}
+	}
+	return "", fmt.Errorf("unknown event type: %s", value)
+}
+
+// ParseEvents decodes the events of a device report (protocol 4) or an alarm
+// message, other messages have none
+func ParseEvents(payload []byte) []DeviceEvent {
+	var message eventMessage
+	if err := json.Unmarshal(payload, &message); err != nil || message.Data == nil {
+		return nil
+	}
+
+	var data alarmData
+	if err := json.Unmarshal(message.Data, &data); err != nil || data.DevID == "" {
+		return nil
+	}
+
+	eventTime := time.Now()
+	if message.T > 0 {
+		eventTime = time.Unix(message.T, 0)
+	}
+
+	var events []DeviceEvent
+	add := func(eventType EventType, dp string) {
+		for _, event := range events {
+			if event.Type == eventType {
+				return
+			}
+		}
+		events = append(events, DeviceEvent{Type: eventType, DeviceID: data.DevID, Time: eventTime, DP: dp})
+	}
+
+	if message.Protocol == 4 {
+		for dp, value := range data.DPs {
+			switch dp {
+			case dpMotionPicture:
+				add(EventMotion, dp)
+			case dpDoorbellActive, dpDoorbellPicture:
+				add(EventDoorbell, dp)
+			case dpAlarmMessage:
+				if s, ok := value.(string); ok {
+					if eventType, ok := alarmEventType(s); ok {
+						add(eventType, dp)
+					}
+				}
+			}
+		}
+		return events
+	}
+
+	for _, value := range []string{data.Cmd, data.Type} {
+		if eventType, ok := alarmEventTypes[value]; ok {
+			add(eventType, "")
+		}
+	}
+	return events
+}
+
+// alarmEventType finds the alarm command in an alarm_message value, plain or
+// base64 encoded JSON
+func alarmEventType(value string) (EventType, bool) {
+	if decoded, err := base64.StdEncoding.DecodeString(value); err == nil {
+		value = string(decoded)
+	}
+
+	var alarm struct {
+		Cmd  string `json:"cmd"`
+		Type string `json:"type"`
+	}
+	if json.Unmarshal([]byte(value), &alarm) == nil {
+		for _, cmd := range []string{alarm.Cmd, alarm.Type} {
+			if eventType, ok := alarmEventTypes[cmd]; ok {
+				return eventType, true
+			}
+		}
+	}
+
+	for cmd, eventType := range alarmEventTypes {
+		if strings.Contains(value, `"`+cmd+`"`) {
+			return eventType, true
+		}
+	}
+	return "", false
+}
+
+// SubscribeEvents subscribes to the reports of the devices and the alarm
+// messages of the account. The subscriptions are renewed on reconnects.
+func (c *MQTTClient) SubscribeEvents(deviceIds []string, handler func(event DeviceEvent)) error {
+	topics := map[string]byte{fmt.Sprintf(alarmEventTopic, c.uid): 1}
+	for _, deviceId := range deviceIds {
+		topics[fmt.Sprintf(deviceEventTopic, deviceId)] = 1
+	}
+
+	c.eventTopics = topics
+	c.onEvent = handler
+
+	return c.subscribeEvents(c.mqtt)
+}
+
+func (c *MQTTClient) subscribeEvents(client mqtt.Client) error {
+	if len(c.eventTopics) == 0 {
+		return nil
+	}
+
+	core.Logger.Trace().Msgf("Subscribing to %d event topics", len(c.eventTopics))
+
+	if token := client.SubscribeMultiple(c.eventTopics, c.consumeEvent); token.Wait() && token.Error() != nil {
+		return fmt.Errorf("failed to subscribe to events: %v", token.Error())
+	}
+	return nil
+}
+
+func (c *MQTTClient) consumeEvent(client mqtt.Client, msg mqtt.Message) {
+	core.Logger.Trace().Msgf("Event message on %s: %s", msg.Topic(), msg.Payload())
+
+	for _, event := range ParseEvents(msg.Payload()) {
+		c.onEvent(event)
+	}
+}
@@ -0,0 +1,24 @@
+package tuya
+
+import (
+	"time"
+
+	"tuya-ipc-terminal/pkg/metrics"
+)
+
+var (
+	apiRequestDuration = metrics.NewHistogram("tuya_ipc_api_request_duration_seconds",
+		"Latency of Tuya API calls", metrics.DefaultBuckets, "endpoint")
+	apiErrors = metrics.NewCounter("tuya_ipc_api_errors_total",
+		"Failed Tuya API calls", "endpoint")
+	mqttReconnects = metrics.NewCounter("tuya_ipc_mqtt_reconnects_total",
+		"Reconnects of MQTT signaling connections")
+)
+
+// observeAPICall is deferred by the API functions with their named error result
+func observeAPICall(endpoint string, start time.Time, err *error) {
+	apiRequestDuration.Observe(time.Since(start).Seconds(), endpoint)
+	if *err != nil {
+		apiErrors.Inc(endpoint)
+	}
+}
@@ -1,9 +1,14 @@
 package tuya
 
 import (
+	"bytes"
+	"crypto/aes"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/crc32"
+	"net/http"
 	"time"
 	"tuya-ipc-terminal/pkg/core"
 	"tuya-ipc-terminal/pkg/utils"
@@ -16,6 +21,8 @@ type MQTTClient struct {
 	uid            string
 	subscribeTopic string
 	cameras        map[string]*MQTTCameraClient // sessionId -> camera
+	eventTopics    map[string]byte
+	onEvent        func(event DeviceEvent)
 	closed         bool
 	Connected      utils.Waiter
 }
@@ -37,6 +44,18 @@ type MqttFrame struct {
 	Message json.RawMessage `json:"msg"`
 }
 
+// DPMessage sets data points of a device, protocol 5
+type DPMessage struct {
+	Protocol int    `json:"protocol"`
+	T        int64  `json:"t"`
+	Data     DPData `json:"data"`
+}
+
+type DPData struct {
+	DevID string         `json:"devId"`
+	DPs   map[string]any `json:"dps"`
+}
+
 type MqttMessage struct {
 	Protocol int       `json:"protocol"`
 	Pv       string    `json:"pv"`
@@ -63,6 +82,7 @@ func NewMqttClient(clientId, mobileMqttsUrl string, mqttConfig *MQTConfig) (*MQT
 	// opts.SetDefaultPublishHandler(messageHandler)
 	opts.SetOnConnectHandler(client.onConnect)
 	opts.SetConnectionLostHandler(client.onDisconnect)
+	opts.SetReconnectingHandler(func(mqtt.Client, *mqtt.ClientOptions) { mqttReconnects.Inc() })
 	opts.SetAutoReconnect(true)
 	opts.SetConnectTimeout(10 * time.Second)
 	opts.SetKeepAlive(60 * time.Second)
@@ -76,6 +96,32 @@ func NewMqttClient(clientId, mobileMqttsUrl string, mqttConfig *MQTConfig) (*MQT
 	return client, nil
 }
 
+// ConnectMQTT connects to the MQTT broker of a session and waits for the
+// subscription
+func ConnectMQTT(client *http.Client, session *SessionData) (*MQTTClient, error) {
+	appInfo, err := GetAppInfo(client, session.ServerHost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get app info: %v", err)
+	}
+
+	mqttConfig, err := GetMQTTConfig(client, session.ServerHost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get MQTT config: %v", err)
+	}
+
+	mqttClient, err := NewMqttClient(appInfo.Result.ClientId, session.LoginResult.Domain.MobileMqttsUrl, &mqttConfig.Result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to MQTT: %v", err)
+	}
+
+	if err := mqttClient.Connected.Wait(); err != nil {
+		mqttClient.Stop()
+		return nil, fmt.Errorf("MQTT connection failed: %v", err)
+	}
+
+	return mqttClient, nil
+}
+
 func (c *MQTTClient) Stop() {
 	if c.mqtt != nil {
 		c.mqtt.Disconnect(250)
@@ -83,6 +129,65 @@ func (c *MQTTClient) Stop() {
 	}
 }
 
+func (c *MQTTClient) IsConnected() bool {
+	return !c.closed && c.mqtt.IsConnectionOpen()
+}
+
+// PublishDPs sets data points of a device like the app does, the message is
+// encrypted with the device's local key
+func (c *MQTTClient) PublishDPs(device DPDevice, dps map[string]any) error {
+	if c.closed {
+		return errors.New("mqtt client is closed, publish dps fail")
+	}
+
+	message, err := json.Marshal(DPMessage{
+		Protocol: 5,
+		T:        time.Now().Unix(),
+		Data:     DPData{DevID: device.ID, DPs: dps},
+	})
+	if err != nil {
+		return err
+	}
+
+	core.Logger.Trace().Msgf("Publishing dps to %s: %s", device.ID, message)
+
+	payload, err := encryptDPMessage(device.LocalKey, message, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to encrypt dps: %v", err)
+	}
+
+	token := c.mqtt.Publish(fmt.Sprintf("smart/mb/in/%s", device.ID), 1, false, payload)
+	if token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+
+	return nil
+}
+
+// encryptDPMessage frames a message as protocol version 2.2: "2.2", the
+// CRC32 of the rest, an 8 digit timestamp in 10 ms and the message encrypted
+// with AES-ECB, see mq_pub_15.py of tuya-convert
+func encryptDPMessage(localKey string, message []byte, now time.Time) ([]byte, error) {
+	block, err := aes.NewCipher([]byte(localKey))
+	if err != nil {
+		return nil, err
+	}
+
+	padding := aes.BlockSize - len(message)%aes.BlockSize
+	plain := append(append([]byte(nil), message...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+
+	body := []byte(fmt.Sprintf("%08d", now.UnixMilli()/10%100000000))
+	for i := 0; i < len(plain); i += aes.BlockSize {
+		encrypted := make([]byte, aes.BlockSize)
+		block.Encrypt(encrypted, plain[i:i+aes.BlockSize])
+		body = append(body, encrypted...)
+	}
+
+	payload := []byte("2.2")
+	payload = binary.BigEndian.AppendUint32(payload, crc32.ChecksumIEEE(body))
+	return append(payload, body...), nil
+}
+
 func (c *MQTTClient) AddCameraClient(sessionId string, cameraClient *MQTTCameraClient) {
 	if c.cameras == nil {
 		c.cameras = make(map[string]*MQTTCameraClient)
@@ -106,6 +211,12 @@ func (c *MQTTClient) onConnect(client mqtt.Client) {
 		return
 	}
 
+	// The session is clean after a reconnect
+	c.closed = false
+	if err := c.subscribeEvents(client); err != nil {
+		core.Logger.Error().Err(err).Msg("Failed to renew event subscriptions")
+	}
+
 	core.Logger.Trace().Msgf("Subscribed")
 	c.Connected.Done(nil)
 }
@@ -19,6 +19,7 @@ type MQTTCameraClient struct {
 	deviceId     string
 	SessionId    string
 	publishTopic string
+	replay       Replay
 
 	HandleAnswer     func(answer AnswerFrame)
 	HandleCandidate  func(candidate CandidateFrame)
@@ -26,8 +27,12 @@ type MQTTCameraClient struct {
 	HandleError      func(err error)
 }
 
+// Replay selects live view or SD-card playback. The time range fields aren't
+// confirmed by a device response yet.
 type Replay struct {
-	IsReplay int `json:"is_replay"`
+	IsReplay  int   `json:"is_replay"`
+	StartTime int64 `json:"start_time,omitempty"` // Unix seconds
+	EndTime   int64 `json:"end_time,omitempty"`
 }
 
 type OfferFrame struct {
@@ -76,6 +81,19 @@ func NewMqttCameraClient(mqttClient *MQTTClient, device *Device, webrtcConfig *W
 	}
 }
 
+// SetReplay makes the next offer play the SD-card recording from start until
+// the end of that day instead of the live stream
+func (c *MQTTCameraClient) SetReplay(start time.Time) {
+	year, month, day := start.Date()
+	end := time.Date(year, month, day+1, 0, 0, 0, 0, start.Location())
+
+	c.replay = Replay{
+		IsReplay:  1,
+		StartTime: start.Unix(),
+		EndTime:   end.Unix() - 1,
+	}
+}
+
 func (c *MQTTCameraClient) SendOffer(sdp string, streamResolution string, streamType int, isHEVC bool) error {
 	if isHEVC {
 		// On HEVC we use streamType 0 for main stream (hd) and 1 for sub stream (sd)
@@ -92,7 +110,7 @@ func (c *MQTTCameraClient) SendOffer(sdp string, streamResolution string, stream
 		StreamType:        streamType,
 		Auth:              c.auth,
 		Token:             c.webrtcConfig.P2PConfig.Ices,
-		Replay:            Replay{IsReplay: 0},
+		Replay:            c.replay,
 		DatachannelEnable: isHEVC,
 	})
 }
@@ -0,0 +1,11 @@
+package tuya
+
+// SetPrivacyMode turns the privacy mode of a camera on or off
+func (c *MQTTClient) SetPrivacyMode(device DPDevice, enabled bool) error {
+	dps, err := device.dps(map[string]any{codePrivacy: enabled})
+	if err != nil {
+		return err
+	}
+
+	return c.PublishDPs(device, dps)
+}
@@ -0,0 +1,137 @@
+package tuya
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	presetSave = 1
+	presetGoto = 3
+)
+
+type PTZAction string
+
+const (
+	PTZUp         PTZAction = "up"
+	PTZUpRight    PTZAction = "up-right"
+	PTZRight      PTZAction = "right"
+	PTZDownRight  PTZAction = "down-right"
+	PTZDown       PTZAction = "down"
+	PTZDownLeft   PTZAction = "down-left"
+	PTZLeft       PTZAction = "left"
+	PTZUpLeft     PTZAction = "up-left"
+	PTZZoomIn     PTZAction = "zoom-in"
+	PTZZoomOut    PTZAction = "zoom-out"
+	PTZStop       PTZAction = "stop"
+	PTZPreset     PTZAction = "preset"
+	PTZSavePreset PTZAction = "save-preset"
+)
+
+// Values of codePTZControl
+var ptzDirections = map[PTZAction]string{
+	PTZUp:        "0",
+	PTZUpRight:   "1",
+	PTZRight:     "2",
+	PTZDownRight: "3",
+	PTZDown:      "4",
+	PTZDownLeft:  "5",
+	PTZLeft:      "6",
+	PTZUpLeft:    "7",
+}
+
+// PTZCommand moves a camera until the stop command or goes to a preset
+type PTZCommand struct {
+	Action PTZAction `json:"action"`
+	Preset int       `json:"preset,omitempty"` // 1-based, for preset and save-preset
+}
+
+// ParsePTZCommand parses arguments like "left" or "preset 2"
+func ParsePTZCommand(args []string) (PTZCommand, error) {
+	if len(args) == 0 {
+		return PTZCommand{}, fmt.Errorf("missing PTZ action")
+	}
+
+	command := PTZCommand{Action: PTZAction(strings.ToLower(args[0]))}
+	if command.Action == PTZPreset || command.Action == PTZSavePreset {
+		if len(args) != 2 {
+			return PTZCommand{}, fmt.Errorf("%s needs a preset number", command.Action)
+		}
+
+		preset, err := strconv.Atoi(args[1])
+		if err != nil {
+			return PTZCommand{}, fmt.Errorf("invalid preset: %s", args[1])
+		}
+		command.Preset = preset
+	} else if len(args) != 1 {
+		return PTZCommand{}, fmt.Errorf("too many arguments for %s", command.Action)
+	}
+
+	return command, command.Validate()
+}
+
+func (c PTZCommand) Validate() error {
+	switch c.Action {
+	case PTZZoomIn, PTZZoomOut, PTZStop:
+		return nil
+	case PTZPreset, PTZSavePreset:
+		if c.Preset < 1 {
+			return fmt.Errorf("invalid preset: %d", c.Preset)
+		}
+		return nil
+	}
+
+	if _, ok := ptzDirections[c.Action]; !ok {
+		return fmt.Errorf("unknown PTZ action: %s", c.Action)
+	}
+	return nil
+}
+
+// values returns the function codes to publish for the command
+func (c PTZCommand) values() (map[string]any, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	switch c.Action {
+	case PTZZoomIn:
+		return map[string]any{codeZoomControl: "1"}, nil
+	case PTZZoomOut:
+		return map[string]any{codeZoomControl: "0"}, nil
+	case PTZStop:
+		return map[string]any{codePTZStop: true, codeZoomStop: true}, nil
+	case PTZPreset, PTZSavePreset:
+		action := presetGoto
+		if c.Action == PTZSavePreset {
+			action = presetSave
+		}
+
+		value, err := json.Marshal(map[string]any{
+			"type": action,
+			"data": map[string]int{"num": c.Preset},
+		})
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{codePreset: string(value)}, nil
+	}
+
+	return map[string]any{codePTZControl: ptzDirections[c.Action]}, nil
+}
+
+// SendPTZ publishes a PTZ command to the camera
+func (c *MQTTClient) SendPTZ(device DPDevice, command PTZCommand) error {
+	values, err := command.values()
+	if err != nil {
+		return err
+	}
+
+	dps, err := device.dps(values)
+	if err != nil {
+		return err
+	}
+
+	return c.PublishDPs(device, dps)
+}
@@ -0,0 +1,61 @@
+package tuya
+
+import "fmt"
+
+// DPDevice is the target of data point commands
+type DPDevice struct {
+	ID       string
+	Category string // Selects the data point schema
+	LocalKey string // Encrypts the commands, from the WebRTC config
+}
+
+// dpSchema maps the standard function codes of a category to data point IDs
+type dpSchema map[string]string
+
+// Function codes used by the bridge
+const (
+	codePTZStop     = "ptz_stop"         // bool
+	codePTZControl  = "ptz_control"      // enum, direction "0" (up) to "7" clockwise
+	codeZoomControl = "zoom_control"     // enum, "0" zoom out, "1" zoom in
+	codeZoomStop    = "zoom_stop"        // bool
+	codePreset      = "memory_point_set" // json, {"type":1|3,"data":{"num":N}}
+	codePrivacy     = "basic_private"    // bool, the camera stops streaming and turns the lens away
+)
+
+// Data points of Tuya's standard instruction set per category, categories
+// without one can't be controlled
+var categorySchemas = map[string]dpSchema{
+	"sp": {
+		codePrivacy:     "105",
+		codePTZStop:     "116",
+		codePTZControl:  "119",
+		codeZoomControl: "163",
+		codeZoomStop:    "164",
+		codePreset:      "178",
+	},
+}
+
+// HasDPSchema reports whether the data points of the category are known
+func HasDPSchema(category string) bool {
+	_, ok := categorySchemas[category]
+	return ok
+}
+
+// dps resolves the function codes of values to the data points of the device
+func (d DPDevice) dps(values map[string]any) (map[string]any, error) {
+	schema, ok := categorySchemas[d.Category]
+	if !ok {
+		return nil, fmt.Errorf("no data point schema for category %q", d.Category)
+	}
+
+	dps := make(map[string]any, len(values))
+	for code, value := range values {
+		id, ok := schema[code]
+		if !ok {
+			return nil, fmt.Errorf("category %s has no %s data point", d.Category, code)
+		}
+		dps[id] = value
+	}
+
+	return dps, nil
+}
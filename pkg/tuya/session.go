@@ -0,0 +1,133 @@
+package tuya
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+var (
+	// ErrSessionInvalid is returned when the API rejects the session cookies
+	ErrSessionInvalid = errors.New("not logged in")
+	// ErrLoginRejected is returned when the API rejects the account or password
+	ErrLoginRejected = errors.New("login rejected")
+	// ErrLoginTimeout is returned when the QR code wasn't scanned in time
+	ErrLoginTimeout = errors.New("timeout waiting for QR code scan")
+)
+
+// CookieJar remembers the attributes of the cookies set by the server, the
+// standard jar only returns names and values
+type CookieJar struct {
+	jar     *cookiejar.Jar
+	cookies map[string]*Cookie // Name -> cookie
+	changed bool
+	mutex   sync.Mutex
+}
+
+// NewCookieJar returns a jar with the cookies of the session, session may be nil
+func NewCookieJar(session *SessionData) (*CookieJar, error) {
+	jar, err := cookiejar.New(&cookiejar.Options{
+		PublicSuffixList: publicsuffix.List,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	j := &CookieJar{jar: jar, cookies: make(map[string]*Cookie)}
+
+	if session != nil && len(session.Cookies) > 0 {
+		serverURL, _ := url.Parse(fmt.Sprintf("https://%s", session.ServerHost))
+
+		var httpCookies []*http.Cookie
+		for _, cookie := range session.Cookies {
+			httpCookies = append(httpCookies, &http.Cookie{
+				Name:     cookie.Name,
+				Value:    cookie.Value,
+				Domain:   cookie.Domain,
+				Path:     cookie.Path,
+				Expires:  cookie.Expires,
+				Secure:   cookie.Secure,
+				HttpOnly: cookie.HttpOnly,
+			})
+
+			c := *cookie
+			j.cookies[cookie.Name] = &c
+		}
+
+		jar.SetCookies(serverURL, httpCookies)
+	}
+
+	return j, nil
+}
+
+func (j *CookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.jar.SetCookies(u, cookies)
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	now := time.Now()
+	for _, cookie := range cookies {
+		expires := cookie.Expires
+		if cookie.MaxAge > 0 {
+			expires = now.Add(time.Duration(cookie.MaxAge) * time.Second)
+		}
+
+		if cookie.MaxAge < 0 || (!expires.IsZero() && expires.Before(now)) {
+			delete(j.cookies, cookie.Name)
+		} else {
+			j.cookies[cookie.Name] = &Cookie{
+				Name:     cookie.Name,
+				Value:    cookie.Value,
+				Domain:   cookie.Domain,
+				Path:     cookie.Path,
+				Expires:  expires,
+				Secure:   cookie.Secure,
+				HttpOnly: cookie.HttpOnly,
+			}
+		}
+		j.changed = true
+	}
+}
+
+func (j *CookieJar) Cookies(u *url.URL) []*http.Cookie {
+	return j.jar.Cookies(u)
+}
+
+// SessionCookies returns the current cookies with their expiry
+func (j *CookieJar) SessionCookies() []*Cookie {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	cookies := make([]*Cookie, 0, len(j.cookies))
+	for _, cookie := range j.cookies {
+		c := *cookie
+		cookies = append(cookies, &c)
+	}
+	return cookies
+}
+
+// Changed reports whether the server set cookies since the jar was created
+func (j *CookieJar) Changed() bool {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.changed
+}
+
+// Expires returns when the first cookie of the session expires, zero if the
+// cookies don't say
+func (s *SessionData) Expires() time.Time {
+	var expires time.Time
+	for _, cookie := range s.Cookies {
+		if !cookie.Expires.IsZero() && (expires.IsZero() || cookie.Expires.Before(expires)) {
+			expires = cookie.Expires
+		}
+	}
+	return expires
+}
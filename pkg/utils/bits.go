@@ -0,0 +1,94 @@
+package utils
+
+import "errors"
+
+var ErrBitstreamEnd = errors.New("unexpected end of bitstream")
+
+// BitReader reads MSB first from a byte slice, as used by H.264/H.265 parameter sets.
+// Reading past the end sets Err and returns zeros.
+type BitReader struct {
+	data []byte
+	pos  int // In bits
+	Err  error
+}
+
+func NewBitReader(data []byte) *BitReader {
+	return &BitReader{data: data}
+}
+
+func (r *BitReader) ReadBit() uint32 {
+	if r.pos >= len(r.data)*8 {
+		r.Err = ErrBitstreamEnd
+		return 0
+	}
+
+	bit := r.data[r.pos/8] >> (7 - r.pos%8) & 1
+	r.pos++
+
+	return uint32(bit)
+}
+
+func (r *BitReader) ReadBits(n int) uint32 {
+	var v uint32
+	for i := 0; i < n; i++ {
+		v = v<<1 | r.ReadBit()
+	}
+	return v
+}
+
+func (r *BitReader) ReadFlag() bool {
+	return r.ReadBit() == 1
+}
+
+func (r *BitReader) Skip(n int) {
+	r.pos += n
+	if r.pos > len(r.data)*8 {
+		r.Err = ErrBitstreamEnd
+	}
+}
+
+// ReadUE reads an unsigned Exp-Golomb code
+func (r *BitReader) ReadUE() uint32 {
+	zeros := 0
+	for r.ReadBit() == 0 {
+		if r.Err != nil || zeros == 31 {
+			r.Err = ErrBitstreamEnd
+			return 0
+		}
+		zeros++
+	}
+	return 1<<zeros - 1 + r.ReadBits(zeros)
+}
+
+// ReadSE reads a signed Exp-Golomb code
+func (r *BitReader) ReadSE() int32 {
+	v := r.ReadUE()
+	if v&1 == 1 {
+		return int32(v+1) / 2
+	}
+	return -int32(v / 2)
+}
+
+// RemoveEmulationPrevention converts a NAL unit to its RBSP by dropping the
+// 0x03 bytes inserted after two zero bytes
+func RemoveEmulationPrevention(nalu []byte) []byte {
+	rbsp := make([]byte, 0, len(nalu))
+	zeros := 0
+
+	for _, b := range nalu {
+		if zeros == 2 && b == 3 {
+			zeros = 0
+			continue
+		}
+
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+
+		rbsp = append(rbsp, b)
+	}
+
+	return rbsp
+}
@@ -0,0 +1,70 @@
+package webrtc
+
+import (
+	"strings"
+
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v4"
+)
+
+// AddCandidates announces extra host candidates (e.g. a public
+// address with port forwarding) in the answer
+func AddCandidates(answer string, addresses []string) string {
+	if len(addresses) == 0 {
+		return answer
+	}
+
+	sd := &sdp.SessionDescription{}
+	if err := sd.Unmarshal([]byte(answer)); err != nil {
+		return answer
+	}
+
+	var candidates []string
+	for _, address := range addresses {
+		address, err := LookupIP(address)
+		if err != nil {
+			continue
+		}
+
+		for _, network := range []string{"udp", "tcp"} {
+			if candidate, err := NewCandidate(network, address); err == nil {
+				candidates = append(candidates, strings.TrimPrefix(candidate, "candidate:"))
+			}
+		}
+	}
+
+	// Before a=end-of-candidates if the media has one
+	for _, md := range sd.MediaDescriptions {
+		var attributes []sdp.Attribute
+		for _, attribute := range md.Attributes {
+			if attribute.Key == sdp.AttrKeyEndOfCandidates {
+				break
+			}
+			attributes = append(attributes, attribute)
+		}
+
+		tail := md.Attributes[len(attributes):]
+		for _, candidate := range candidates {
+			attributes = append(attributes, sdp.NewAttribute("candidate", candidate))
+		}
+		md.Attributes = append(attributes, tail...)
+	}
+
+	b, err := sd.Marshal()
+	if err != nil {
+		return answer
+	}
+
+	return string(b)
+}
+
+// DiscardRTCP reads the RTCP of a sender until it's closed, the interceptors
+// (NACK, reports) only work when it's read
+func DiscardRTCP(sender *webrtc.RTPSender) {
+	buf := make([]byte, 1500)
+	for {
+		if _, _, err := sender.Read(buf); err != nil {
+			return
+		}
+	}
+}
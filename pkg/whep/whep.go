@@ -0,0 +1,329 @@
+package whep
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	pion "github.com/pion/webrtc/v4"
+
+	"tuya-ipc-terminal/pkg/core"
+	"tuya-ipc-terminal/pkg/h264"
+	"tuya-ipc-terminal/pkg/rtsp"
+	"tuya-ipc-terminal/pkg/utils"
+	"tuya-ipc-terminal/pkg/webrtc"
+)
+
+type Config struct {
+	// API with the ICE ports and filters of the server
+	API *pion.API
+
+	// Candidates are announced in addition to the gathered ones
+	Candidates []string
+}
+
+const (
+	mediaInfoTimeout = 15 * time.Second
+	gatherTimeout    = 5 * time.Second
+	connectTimeout   = 30 * time.Second
+
+	maxOfferSize = 64 << 10
+)
+
+// Server implements WHEP playback: POST /whep/<camera path>[/sd] with an SDP
+// offer, DELETE /whep/session/<id> to hang up
+type Server struct {
+	rtspServer *rtsp.RTSPServer
+	config     Config
+	sessions   map[string]*session
+	mutex      sync.Mutex
+}
+
+func NewServer(rtspServer *rtsp.RTSPServer, config Config) *Server {
+	return &Server{
+		rtspServer: rtspServer,
+		config:     config,
+		sessions:   make(map[string]*session),
+	}
+}
+
+// Close hangs up all sessions
+func (s *Server) Close() {
+	s.mutex.Lock()
+	sessions := s.sessions
+	s.sessions = make(map[string]*session)
+	s.mutex.Unlock()
+
+	for _, sess := range sessions {
+		sess.close()
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Expose-Headers", "Location")
+
+	path := strings.TrimPrefix(r.URL.Path, "/whep")
+
+	switch r.Method {
+	case http.MethodOptions:
+		w.Header().Set("Access-Control-Allow-Methods", "POST, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodPost:
+		s.handleOffer(w, r, path)
+	case http.MethodDelete:
+		s.handleDelete(w, r, path)
+	case http.MethodPatch:
+		// Candidates are sent with the answer, trickle ICE is not supported
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleOffer(w http.ResponseWriter, r *http.Request, path string) {
+	cameraPath, resolution := rtsp.ParseCameraPath(path)
+	if cameraPath == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if !s.rtspServer.AuthorizeHTTP(w, r, cameraPath) {
+		return
+	}
+
+	if contentType := r.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "application/sdp") {
+		http.Error(w, "Content-Type must be application/sdp", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	offer, err := io.ReadAll(io.LimitReader(r.Body, maxOfferSize))
+	if err != nil {
+		http.Error(w, "failed to read offer", http.StatusBadRequest)
+		return
+	}
+
+	sess := &session{id: utils.RandString(16, 16), server: s}
+
+	// Attaching starts the camera, the codecs are known once it sends video
+	sess.stream, err = s.rtspServer.AttachViewer(cameraPath, resolution, sess.viewerID(), &pendingViewer{session: sess})
+	if err != nil {
+		if errors.Is(err, rtsp.ErrCameraNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	answer, status, err := sess.negotiate(string(offer))
+	if err != nil {
+		core.Logger.Warn().Err(err).Msgf("WHEP: negotiation for %s failed", cameraPath)
+		sess.close()
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	s.mutex.Lock()
+	s.sessions[sess.id] = sess
+	s.mutex.Unlock()
+
+	core.Logger.Info().Msgf("WHEP session %s started for camera %s (%s)", sess.id, sess.stream.Camera().DeviceName, resolution)
+
+	w.Header().Set("Content-Type", "application/sdp")
+	w.Header().Set("Location", "/whep/session/"+sess.id)
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte(answer))
+}
+
+func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request, path string) {
+	id, found := strings.CutPrefix(path, "/session/")
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.mutex.Lock()
+	sess := s.sessions[id]
+	s.mutex.Unlock()
+
+	if sess == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if !s.rtspServer.AuthorizeHTTP(w, r, sess.stream.Camera().RTSPPath) {
+		return
+	}
+
+	sess.close()
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) removeSession(id string) {
+	s.mutex.Lock()
+	delete(s.sessions, id)
+	s.mutex.Unlock()
+}
+
+type session struct {
+	id     string
+	server *Server
+	stream *rtsp.CameraStream
+	pc     *pion.PeerConnection
+
+	videoTrack *pion.TrackLocalStaticRTP
+	audioTrack *pion.TrackLocalStaticRTP
+
+	once sync.Once
+}
+
+func (sess *session) viewerID() string {
+	return "whep-" + sess.id
+}
+
+// negotiate answers the offer with the camera's tracks, returns the HTTP status on failure
+func (sess *session) negotiate(offer string) (string, int, error) {
+	info := sess.stream.WaitMediaInfo(mediaInfoTimeout)
+	if info == nil {
+		return "", http.StatusServiceUnavailable, errors.New("no video from camera")
+	}
+
+	videoCodec := pion.RTPCodecCapability{MimeType: pion.MimeTypeH264, ClockRate: 90000}
+	if info.HEVC {
+		if !strings.Contains(strings.ToUpper(offer), "H265/90000") {
+			return "", http.StatusNotAcceptable, errors.New("camera streams H.265, which the browser doesn't support")
+		}
+		videoCodec.MimeType = pion.MimeTypeH265
+	} else if info.SPS != nil {
+		videoCodec.SDPFmtpLine = "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=" + strings.ToLower(h264.ProfileLevelID(info.SPS))
+	}
+
+	pc, err := sess.server.config.API.NewPeerConnection(pion.Configuration{})
+	if err != nil {
+		return "", http.StatusInternalServerError, fmt.Errorf("failed to create peer connection: %v", err)
+	}
+	sess.pc = pc
+
+	if err := pc.SetRemoteDescription(pion.SessionDescription{Type: pion.SDPTypeOffer, SDP: offer}); err != nil {
+		return "", http.StatusBadRequest, fmt.Errorf("invalid offer: %v", err)
+	}
+
+	if sess.videoTrack, err = pion.NewTrackLocalStaticRTP(videoCodec, "video", "tuya"); err != nil {
+		return "", http.StatusInternalServerError, err
+	}
+	if err := sess.addTrack(sess.videoTrack); err != nil {
+		return "", http.StatusNotAcceptable, err
+	}
+
+	if info.HasAudio && (info.AudioPayloadType == 0 || info.AudioPayloadType == 8) {
+		audioCodec := pion.RTPCodecCapability{MimeType: pion.MimeTypePCMU, ClockRate: 8000}
+		if info.AudioPayloadType == 8 {
+			audioCodec.MimeType = pion.MimeTypePCMA
+		}
+
+		if sess.audioTrack, err = pion.NewTrackLocalStaticRTP(audioCodec, "audio", "tuya"); err != nil {
+			return "", http.StatusInternalServerError, err
+		}
+		if err := sess.addTrack(sess.audioTrack); err != nil {
+			// Video alone is still worth showing
+			core.Logger.Debug().Err(err).Msg("WHEP: audio not accepted")
+			sess.audioTrack = nil
+		}
+	}
+
+	pc.OnConnectionStateChange(sess.onConnectionStateChange)
+
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		return "", http.StatusNotAcceptable, fmt.Errorf("failed to create answer: %v", err)
+	}
+
+	gathered := pion.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(answer); err != nil {
+		return "", http.StatusInternalServerError, fmt.Errorf("failed to set answer: %v", err)
+	}
+
+	select {
+	case <-gathered:
+	case <-time.After(gatherTimeout):
+	}
+
+	// Hang up if the browser never connects
+	time.AfterFunc(connectTimeout, func() {
+		if pc.ConnectionState() != pion.PeerConnectionStateConnected {
+			sess.close()
+		}
+	})
+
+	return webrtc.AddCandidates(pc.LocalDescription().SDP, sess.server.config.Candidates), 0, nil
+}
+
+func (sess *session) addTrack(track *pion.TrackLocalStaticRTP) error {
+	sender, err := sess.pc.AddTrack(track)
+	if err != nil {
+		return fmt.Errorf("failed to add %s track: %v", track.Kind(), err)
+	}
+
+	go webrtc.DiscardRTCP(sender)
+
+	return nil
+}
+
+func (sess *session) onConnectionStateChange(state pion.PeerConnectionState) {
+	core.Logger.Debug().Msgf("WHEP session %s: %s", sess.id, state)
+
+	switch state {
+	case pion.PeerConnectionStateConnected:
+		// Replaces the pending viewer, the cached GOP gives an instant picture
+		if err := sess.stream.AddViewer(sess.viewerID(), sess); err != nil {
+			go sess.close()
+		}
+	case pion.PeerConnectionStateFailed, pion.PeerConnectionStateClosed:
+		go sess.close()
+	}
+}
+
+// WriteRTP implements rtsp.Viewer
+func (sess *session) WriteRTP(packet *rtp.Packet, video bool) {
+	if video {
+		sess.videoTrack.WriteRTP(packet)
+	} else if sess.audioTrack != nil {
+		sess.audioTrack.WriteRTP(packet)
+	}
+}
+
+// Close implements rtsp.Viewer, it's called with the stream locked
+func (sess *session) Close() {
+	go sess.close()
+}
+
+func (sess *session) close() {
+	sess.once.Do(func() {
+		sess.server.removeSession(sess.id)
+		sess.stream.RemoveViewer(sess.viewerID())
+
+		if sess.pc != nil {
+			sess.pc.Close()
+		}
+
+		core.Logger.Info().Msgf("WHEP session %s closed", sess.id)
+	})
+}
+
+// pendingViewer keeps the stream running while the peer connection is set up
+type pendingViewer struct {
+	session *session
+}
+
+func (v *pendingViewer) WriteRTP(*rtp.Packet, bool) {}
+
+func (v *pendingViewer) Close() {
+	go v.session.close()
+}